@@ -0,0 +1,147 @@
+// Package apperror maps the domain errors to stable codes and http status codes,
+// which the http api renders as RFC 7807 problem details and the realtime api as error payloads.
+package apperror
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// Code is a stable identifier of an error, clients can rely on it.
+type Code string
+
+const (
+	CodeInternal         Code = "internal"
+	CodeBadRequest       Code = "bad_request"
+	CodeValidation       Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeNotFound         Code = "not_found"
+	CodeConflict         Code = "conflict"
+	CodeRateLimited      Code = "rate_limited"
+	CodeUnavailable      Code = "unavailable"
+	CodeShopNotFound     Code = "shop_not_found"
+	CodePlanNotFound     Code = "plan_not_found"
+	CodeShopifyAPI       Code = "shopify_api_error"
+	CodeInvalidSignature Code = "invalid_signature"
+)
+
+// FieldError describes an invalid field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error which is safe to show to the clients.
+// Message is public, the wrapped error is only logged.
+type Error struct {
+	Code    Code
+	Status  int
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+// New returns an error with a public message.
+func New(code Code, status int, message string) *Error {
+	return &Error{
+		Code:    code,
+		Status:  status,
+		Message: message,
+	}
+}
+
+// Wrap returns an error with a public message, err is kept as the cause.
+func Wrap(err error, code Code, status int, message string) *Error {
+	return &Error{
+		Code:    code,
+		Status:  status,
+		Message: message,
+		Err:     err,
+	}
+}
+
+// Validation returns an error listing the invalid fields.
+func Validation(fields ...FieldError) *Error {
+	return &Error{
+		Code:    CodeValidation,
+		Status:  http.StatusUnprocessableEntity,
+		Message: "the request is invalid",
+		Fields:  fields,
+	}
+}
+
+func Unauthorized(message string) *Error {
+	return New(CodeUnauthorized, http.StatusUnauthorized, message)
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Provider is implemented by the errors of other packages which know their public form,
+// such as shopifysvc.GraphQLError.
+type Provider interface {
+	AppError() *Error
+}
+
+// From returns the public form of err. Unknown errors become an internal error without details.
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	var provider Provider
+	if errors.As(err, &provider) {
+		return provider.AppError()
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return New(codeFromStatus(fiberErr.Code), fiberErr.Code, fiberErr.Message)
+	}
+
+	return Wrap(err, CodeInternal, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+func codeFromStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnprocessableEntity:
+		return CodeValidation
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+
+	if status < http.StatusInternalServerError {
+		return CodeBadRequest
+	}
+
+	return CodeInternal
+}
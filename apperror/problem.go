@@ -0,0 +1,34 @@
+package apperror
+
+import (
+	"net/http"
+)
+
+// ContentTypeProblem is the media type of RFC 7807.
+const ContentTypeProblem = "application/problem+json"
+
+// Problem is the RFC 7807 problem details of an error.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      Code         `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// NewProblem returns the problem details of err for the request instance.
+func NewProblem(err *Error, instance, requestID string) *Problem {
+	return &Problem{
+		Type:      "/problems/" + string(err.Code),
+		Title:     http.StatusText(err.Status),
+		Status:    err.Status,
+		Detail:    err.Message,
+		Instance:  instance,
+		Code:      err.Code,
+		RequestID: requestID,
+		Errors:    err.Fields,
+	}
+}
@@ -0,0 +1,82 @@
+package authsvc
+
+import (
+	"crypto/subtle"
+	"os"
+	"strings"
+
+	"github.com/aiocean/wireset/apperror"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+)
+
+// HeaderAPIKey carries the api key of the service to service requests.
+const HeaderAPIKey = "X-API-Key"
+
+var APIKeyWireset = wire.NewSet(
+	NewAPIKeyVerifierFromEnv,
+)
+
+// APIKeyVerifier verifies the X-API-Key header, the routes declare it with fiberapp.AuthAPIKey.
+type APIKeyVerifier struct {
+	// Keys maps the name of the client to its key, the name is the subject.
+	Keys map[string]string
+}
+
+// NewAPIKeyVerifierFromEnv reads the keys from API_KEYS, a comma separated list of name:key.
+func NewAPIKeyVerifierFromEnv() (*APIKeyVerifier, error) {
+	verifier := &APIKeyVerifier{
+		Keys: map[string]string{},
+	}
+
+	value, ok := os.LookupEnv("API_KEYS")
+	if !ok {
+		return nil, errors.New("API_KEYS is required")
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		name, key, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || name == "" || key == "" {
+			return nil, errors.New("API_KEYS entries must be name:key")
+		}
+		verifier.Keys[name] = key
+	}
+
+	return verifier, nil
+}
+
+func (v *APIKeyVerifier) AuthKind() fiberapp.AuthKind {
+	return fiberapp.AuthKindAPIKey
+}
+
+func (v *APIKeyVerifier) SecurityScheme() *fiberapp.SecurityScheme {
+	return &fiberapp.SecurityScheme{
+		Type: "apiKey",
+		In:   "header",
+		Name: HeaderAPIKey,
+	}
+}
+
+func (v *APIKeyVerifier) Verify(c *fiber.Ctx, policy fiberapp.AuthPolicy) error {
+	key := c.Get(HeaderAPIKey)
+	if key == "" {
+		return apperror.Unauthorized("missing api key")
+	}
+
+	// compare every key in constant time, so the timing does not reveal a valid prefix
+	subject := ""
+	for name, validKey := range v.Keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(validKey)) == 1 {
+			subject = name
+		}
+	}
+
+	if subject == "" {
+		return apperror.Unauthorized("the api key is invalid")
+	}
+
+	fiberapp.SetAuthSubject(c, subject)
+	return nil
+}
@@ -0,0 +1,5 @@
+// Package authsvc provides the fiberapp.AuthVerifier of the firebase users, the api keys and the casbin permissions.
+// Add the verifiers which the routes require to the registry, in the Init of a feature:
+//
+//	f.HttpRegistry.AddAuthVerifiers(f.FirebaseUserVerifier, f.CasbinVerifier)
+package authsvc
@@ -0,0 +1,74 @@
+package authsvc
+
+import (
+	"net/http"
+
+	"github.com/aiocean/wireset/apperror"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/casbin/casbin/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/wire"
+	"go.uber.org/zap"
+)
+
+// CasbinWireset checks the permissions of the firebase users.
+var CasbinWireset = wire.NewSet(
+	NewCasbinVerifier,
+)
+
+// CasbinVerifier authenticates the request with Authenticator, then enforces the permission of the policy
+// for the subject. The routes declare it with fiberapp.AuthPermission.
+type CasbinVerifier struct {
+	Enforcer      *casbin.Enforcer
+	Authenticator fiberapp.AuthVerifier
+	Logger        *zap.Logger
+}
+
+func NewCasbinVerifier(enforcer *casbin.Enforcer, authenticator *FirebaseUserVerifier, logger *zap.Logger) *CasbinVerifier {
+	return &CasbinVerifier{
+		Enforcer:      enforcer,
+		Authenticator: authenticator,
+		Logger:        logger.Named("casbinVerifier"),
+	}
+}
+
+func (v *CasbinVerifier) AuthKind() fiberapp.AuthKind {
+	return fiberapp.AuthKindCasbin
+}
+
+func (v *CasbinVerifier) SecurityScheme() *fiberapp.SecurityScheme {
+	if provider, ok := v.Authenticator.(fiberapp.SecuritySchemeProvider); ok {
+		return provider.SecurityScheme()
+	}
+
+	return nil
+}
+
+func (v *CasbinVerifier) Verify(c *fiber.Ctx, policy fiberapp.AuthPolicy) error {
+	if err := v.Authenticator.Verify(c, policy); err != nil {
+		return err
+	}
+
+	object := policy.Object
+	if object == "" {
+		object = c.Route().Path
+	}
+
+	action := policy.Action
+	if action == "" {
+		action = c.Method()
+	}
+
+	subject := fiberapp.AuthSubject(c)
+	allowed, err := v.Enforcer.Enforce(subject, object, action)
+	if err != nil {
+		return apperror.Wrap(err, apperror.CodeInternal, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+
+	if !allowed {
+		v.Logger.Info("permission denied", zap.String("subject", subject), zap.String("object", object), zap.String("action", action))
+		return apperror.New(apperror.CodeForbidden, http.StatusForbidden, "permission denied")
+	}
+
+	return nil
+}
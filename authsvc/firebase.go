@@ -0,0 +1,64 @@
+package authsvc
+
+import (
+	"net/http"
+	"strings"
+
+	"firebase.google.com/go/auth"
+	"github.com/aiocean/wireset/apperror"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/wire"
+	"go.uber.org/zap"
+)
+
+var FirebaseWireset = wire.NewSet(
+	NewFirebaseUserVerifier,
+)
+
+// FirebaseUserVerifier verifies the firebase id token of the bearer authorization header,
+// the routes declare it with fiberapp.AuthFirebaseUser.
+type FirebaseUserVerifier struct {
+	client *auth.Client
+	logger *zap.Logger
+}
+
+func NewFirebaseUserVerifier(client *auth.Client, logger *zap.Logger) *FirebaseUserVerifier {
+	return &FirebaseUserVerifier{
+		client: client,
+		logger: logger.Named("firebaseUserVerifier"),
+	}
+}
+
+func (v *FirebaseUserVerifier) AuthKind() fiberapp.AuthKind {
+	return fiberapp.AuthKindFirebaseUser
+}
+
+func (v *FirebaseUserVerifier) SecurityScheme() *fiberapp.SecurityScheme {
+	return &fiberapp.SecurityScheme{
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+		Description:  "The firebase id token of the user",
+	}
+}
+
+// Verify stores the verified token in the "firebaseToken" local, the subject is the user id.
+func (v *FirebaseUserVerifier) Verify(c *fiber.Ctx, policy fiberapp.AuthPolicy) error {
+	idToken := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if idToken == "" {
+		return apperror.Unauthorized("missing authentication header")
+	}
+
+	token, err := v.client.VerifyIDToken(c.UserContext(), idToken)
+	if err != nil {
+		v.logger.Info("invalid id token", zap.Error(err))
+		return apperror.Wrap(err, apperror.CodeUnauthorized, http.StatusUnauthorized, "the id token is invalid")
+	}
+
+	c.Locals("firebaseToken", token)
+	c.Locals("uid", token.UID)
+	fiberapp.SetAuthSubject(c, token.UID)
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package cachesvc
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/ristretto"
@@ -29,6 +30,24 @@ func ProvideCacheConfig() *CacheConfig {
 type CacheService struct {
 	cache  *ristretto.Cache
 	config *CacheConfig
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// CacheStats counts the lookups since the start.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// HitRatio returns the part of the lookups which found the key, 0 before the first lookup.
+func (s CacheStats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(total)
 }
 
 // NewCacheService creates a new CacheService with the given configuration
@@ -57,7 +76,22 @@ func NewCacheService(config *CacheConfig) (*CacheService, func(), error) {
 
 // Get retrieves a value from the cache using a key
 func (s *CacheService) Get(key string) (interface{}, bool) {
-	return s.cache.Get(key)
+	value, ok := s.cache.Get(key)
+	if ok {
+		s.hits.Add(1)
+	} else {
+		s.misses.Add(1)
+	}
+
+	return value, ok
+}
+
+// Stats returns the number of hits and misses of Get
+func (s *CacheService) Stats() CacheStats {
+	return CacheStats{
+		Hits:   s.hits.Load(),
+		Misses: s.misses.Load(),
+	}
 }
 
 // Set adds a value to the cache with a specified key, using the default TTL
@@ -1,18 +1,17 @@
 package configsvc
 
 import (
-	"errors"
-	"os"
-
 	"github.com/google/wire"
 )
 
 type ConfigService struct {
-	ServiceName string
-	ServiceUrl  string
-	Address     string
-	Port        string
-	Environment string
+	ServiceName string `env:"SERVICE_NAME" required:"true"`
+	ServiceUrl  string `env:"SERVICE_URL" required:"true"`
+	Address     string `env:"ADDRESS"`
+	Port        string `env:"PORT" default:"8080"`
+	Environment string `env:"ENVIRONMENT" required:"true"`
+	// DisabledFeatures are the names of the features which the server skips, comma separated.
+	DisabledFeatures []string `env:"DISABLED_FEATURES"`
 }
 
 type DatabaseConfig struct {
@@ -21,46 +20,26 @@ type DatabaseConfig struct {
 
 var EnvWireset = wire.NewSet(NewConfigFromEnv)
 
-// NewConfigFromEnv creates a new ConfigService from environment variables.
-// It returns an error if any of the required environment variables are missing.
+// NewConfigFromEnv creates a new ConfigService from the layered sources, see Loader.
+// It returns an error listing every required key which is missing.
 func NewConfigFromEnv() (*ConfigService, error) {
-	configService := &ConfigService{
-		ServiceName: os.Getenv("SERVICE_NAME"),
-		ServiceUrl:  os.Getenv("SERVICE_URL"),
-		Environment: os.Getenv("ENVIRONMENT"),
-	}
-
-	if value, ok := os.LookupEnv("ENVIRONMENT"); ok {
-		configService.Environment = value
-	} else {
-		return nil, errors.New("ENVIRONMENT is required")
+	configService := &ConfigService{}
+	if err := Load(configService); err != nil {
+		return nil, err
 	}
 
-	if value, ok := os.LookupEnv("SERVICE_NAME"); ok {
-		configService.ServiceName = value
-	} else {
-		return nil, errors.New("SERVICE_NAME is required")
-	}
-
-	if value, ok := os.LookupEnv("SERVICE_URL"); ok {
-		configService.ServiceUrl = value
-	} else {
-		return nil, errors.New("SERVICE_URL is required")
-	}
-
-	if value, ok := os.LookupEnv("ADDRESS"); ok {
-		configService.Address = value
-	} else {
-		configService.Address = ""
-	}
+	return configService, nil
+}
 
-	if value, ok := os.LookupEnv("PORT"); ok {
-		configService.Port = value
-	} else {
-		configService.Port = "8080"
+// IsFeatureEnabled reports if the feature is not listed in DISABLED_FEATURES.
+func (c *ConfigService) IsFeatureEnabled(name string) bool {
+	for _, disabled := range c.DisabledFeatures {
+		if disabled == name {
+			return false
+		}
 	}
 
-	return configService, nil
+	return true
 }
 
 // IsProduction
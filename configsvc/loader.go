@@ -0,0 +1,383 @@
+package configsvc
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultSecretsDir is where docker and kubernetes mount the secrets.
+const DefaultSecretsDir = "/run/secrets"
+
+const redacted = "******"
+
+// Loader fills a config struct from layered sources, each one overriding the previous:
+// the defaults, the config file, the environment, the secret files and the overrides.
+//
+// The fields are bound by their tags:
+//
+//	Port   string `env:"PORT" default:"8080"`
+//	Secret string `env:"SHOPIFY_CLIENT_SECRET" required:"true" secret:"true"`
+//
+// The file is YAML or TOML, by its extension. Its keys are the env names, nested keys are
+// joined with an underscore, so shopify: {client_id: x} sets SHOPIFY_CLIENT_ID.
+// A secret file is named after the key, such as /run/secrets/SHOPIFY_CLIENT_SECRET.
+type Loader struct {
+	// File is the path of the config file, optional.
+	File string
+	// SecretsDir holds the secret files, optional.
+	SecretsDir string
+	// LookupEnv reads the environment, os.LookupEnv when nil.
+	LookupEnv func(key string) (string, bool)
+	// Overrides are the values of a runtime Source, optional.
+	Overrides map[string]string
+}
+
+// NewLoader returns a loader which reads the file from CONFIG_FILE and the secrets
+// from CONFIG_SECRETS_DIR, DefaultSecretsDir when it is not set.
+func NewLoader() *Loader {
+	secretsDir := DefaultSecretsDir
+	if value, ok := os.LookupEnv("CONFIG_SECRETS_DIR"); ok {
+		secretsDir = value
+	}
+
+	return &Loader{
+		File:       os.Getenv("CONFIG_FILE"),
+		SecretsDir: secretsDir,
+		LookupEnv:  os.LookupEnv,
+	}
+}
+
+// Load fills the target with the default loader.
+func Load(target any) error {
+	return NewLoader().Load(target)
+}
+
+// InvalidKey is a value which cannot be converted to the type of its field.
+type InvalidKey struct {
+	Key string
+	Err error
+}
+
+// Validator is implemented by the configs which check more than the types and the required keys.
+type Validator interface {
+	Validate() error
+}
+
+// ValidationError reports every missing and invalid key of a config.
+type ValidationError struct {
+	Missing []string
+	Invalid []InvalidKey
+}
+
+func (e *ValidationError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing "+strings.Join(e.Missing, ", "))
+	}
+	for _, invalid := range e.Invalid {
+		parts = append(parts, fmt.Sprintf("invalid %s: %v", invalid.Key, invalid.Err))
+	}
+
+	return "config: " + strings.Join(parts, "; ")
+}
+
+// field is a bound field of the config struct.
+type field struct {
+	key      string
+	value    reflect.Value
+	def      string
+	required bool
+	secret   bool
+}
+
+// Load fills the target, a pointer to a struct, and validates it.
+func (l *Loader) Load(target any) error {
+	fields, err := bind(target)
+	if err != nil {
+		return err
+	}
+
+	fileValues := map[string]string{}
+	if l.File != "" {
+		if fileValues, err = readFile(l.File); err != nil {
+			return err
+		}
+	}
+
+	lookupEnv := l.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+
+	validationErr := &ValidationError{}
+	for _, f := range fields {
+		raw, found := f.def, f.def != ""
+
+		if value, ok := fileValues[f.key]; ok {
+			raw, found = value, true
+		}
+
+		if value, ok := lookupEnv(f.key); ok {
+			raw, found = value, true
+		}
+
+		if value, ok, err := l.readSecret(f.key); err != nil {
+			return err
+		} else if ok {
+			raw, found = value, true
+		}
+
+		if value, ok := l.Overrides[f.key]; ok {
+			raw, found = value, true
+		}
+
+		if !found || raw == "" {
+			if f.required {
+				validationErr.Missing = append(validationErr.Missing, f.key)
+			}
+			continue
+		}
+
+		if err := setValue(f.value, raw); err != nil {
+			validationErr.Invalid = append(validationErr.Invalid, InvalidKey{Key: f.key, Err: err})
+		}
+	}
+
+	if len(validationErr.Missing) > 0 || len(validationErr.Invalid) > 0 {
+		return validationErr
+	}
+
+	if validator, ok := target.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return errors.Wrap(err, "config")
+		}
+	}
+
+	return nil
+}
+
+// readFile returns the values of the config file by key.
+func readFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config file")
+	}
+
+	var document map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &document)
+	case ".toml":
+		err = toml.Unmarshal(content, &document)
+	default:
+		return nil, errors.Errorf("config file %s is neither yaml nor toml", path)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse config file %s", path)
+	}
+
+	values := map[string]string{}
+	flatten("", document, values)
+
+	return values, nil
+}
+
+// flatten joins the nested keys with an underscore, the lists are comma separated.
+func flatten(prefix string, document map[string]any, values map[string]string) {
+	for key, value := range document {
+		key = strings.ToUpper(key)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+
+		switch value := value.(type) {
+		case map[string]any:
+			flatten(key, value, values)
+		case []any:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+}
+
+// readSecret reads the secret file of the key, named as the key or in lower case.
+func (l *Loader) readSecret(key string) (string, bool, error) {
+	if l.SecretsDir == "" {
+		return "", false, nil
+	}
+
+	for _, name := range []string{key, strings.ToLower(key)} {
+		content, err := os.ReadFile(filepath.Join(l.SecretsDir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", false, errors.Wrapf(err, "failed to read secret %s", key)
+		}
+
+		return strings.TrimRight(string(content), "\r\n"), true, nil
+	}
+
+	return "", false, nil
+}
+
+// bind returns the tagged fields of the target, nested structs included.
+func bind(target any) ([]field, error) {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return nil, errors.Errorf("config target must be a pointer to a struct, got %T", target)
+	}
+
+	return bindStruct(value.Elem()), nil
+}
+
+func bindStruct(value reflect.Value) []field {
+	var fields []field
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		if !structField.IsExported() {
+			continue
+		}
+
+		key, ok := structField.Tag.Lookup("env")
+		if !ok {
+			if structField.Type.Kind() == reflect.Struct {
+				fields = append(fields, bindStruct(value.Field(i))...)
+			}
+			continue
+		}
+
+		fields = append(fields, field{
+			key:      key,
+			value:    value.Field(i),
+			def:      structField.Tag.Get("default"),
+			required: structField.Tag.Get("required") == "true",
+			secret:   structField.Tag.Get("secret") == "true",
+		})
+	}
+
+	return fields
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setValue converts the raw value to the type of the field.
+func setValue(value reflect.Value, raw string) error {
+	if unmarshaler, ok := value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(raw))
+	}
+
+	if value.Type() == durationType {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(parsed)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return errors.Errorf("unsupported type %s", value.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items).Convert(value.Type()))
+	default:
+		return errors.Errorf("unsupported type %s", value.Type())
+	}
+
+	return nil
+}
+
+// Dump returns the values of a loaded config by key, the secrets are redacted.
+func Dump(target any) map[string]string {
+	fields, err := bind(target)
+	if err != nil {
+		return nil
+	}
+
+	dump := make(map[string]string, len(fields))
+	for _, f := range fields {
+		value := fmt.Sprint(f.value.Interface())
+		if f.value.Kind() == reflect.Slice {
+			items := make([]string, 0, f.value.Len())
+			for i := 0; i < f.value.Len(); i++ {
+				items = append(items, fmt.Sprint(f.value.Index(i).Interface()))
+			}
+			value = strings.Join(items, ",")
+		}
+
+		if f.secret && value != "" {
+			value = redacted
+		}
+		dump[f.key] = value
+	}
+
+	return dump
+}
+
+// DumpString formats the dump as sorted KEY=value lines, for debugging.
+func DumpString(target any) string {
+	dump := Dump(target)
+	keys := make([]string, 0, len(dump))
+	for key := range dump {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	for _, key := range keys {
+		builder.WriteString(key + "=" + dump[key] + "\n")
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,283 @@
+package configsvc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type loaderConfig struct {
+	Name     string        `env:"APP_NAME" default:"default"`
+	Port     int           `env:"APP_PORT" required:"true"`
+	Timeout  time.Duration `env:"APP_TIMEOUT" default:"5s"`
+	Hosts    []string      `env:"APP_HOSTS"`
+	Debug    bool          `env:"APP_DEBUG"`
+	Password string        `env:"APP_PASSWORD" secret:"true"`
+	Database struct {
+		Url string `env:"DATABASE_URL"`
+	}
+}
+
+// writeFile writes the file in a temporary directory and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func lookupEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestLoaderPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", "app_name: file\napp_port: 1\n")
+	secrets := t.TempDir()
+	if err := os.WriteFile(filepath.Join(secrets, "APP_NAME"), []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		loader Loader
+		want   string
+	}{
+		{name: "default", loader: Loader{LookupEnv: lookupEnv(map[string]string{"APP_PORT": "1"})}, want: "default"},
+		{name: "file over default", loader: Loader{File: file, LookupEnv: lookupEnv(nil)}, want: "file"},
+		{
+			name:   "env over file",
+			loader: Loader{File: file, LookupEnv: lookupEnv(map[string]string{"APP_NAME": "env"})},
+			want:   "env",
+		},
+		{
+			name:   "secret over env",
+			loader: Loader{File: file, SecretsDir: secrets, LookupEnv: lookupEnv(map[string]string{"APP_NAME": "env"})},
+			want:   "secret",
+		},
+		{
+			name: "override over secret",
+			loader: Loader{
+				File:       file,
+				SecretsDir: secrets,
+				LookupEnv:  lookupEnv(map[string]string{"APP_NAME": "env"}),
+				Overrides:  map[string]string{"APP_NAME": "override"},
+			},
+			want: "override",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &loaderConfig{}
+			if err := test.loader.Load(config); err != nil {
+				t.Fatal(err)
+			}
+
+			if config.Name != test.want {
+				t.Fatalf("name is %q, want %q", config.Name, test.want)
+			}
+		})
+	}
+}
+
+func TestLoaderReadsTheSecretFileInLowerCase(t *testing.T) {
+	secrets := t.TempDir()
+	if err := os.WriteFile(filepath.Join(secrets, "app_password"), []byte("hunter2\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &loaderConfig{}
+	loader := Loader{SecretsDir: secrets, LookupEnv: lookupEnv(map[string]string{"APP_PORT": "1"})}
+	if err := loader.Load(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Password != "hunter2" {
+		t.Fatalf("password is %q, want the secret file without its line break", config.Password)
+	}
+}
+
+func TestLoaderReportsEveryMissingAndInvalidKey(t *testing.T) {
+	type config struct {
+		Id      string        `env:"ID" required:"true"`
+		Secret  string        `env:"SECRET" required:"true"`
+		Port    int           `env:"PORT"`
+		Timeout time.Duration `env:"TIMEOUT"`
+		Empty   string        `env:"EMPTY" required:"true"`
+	}
+
+	loader := Loader{LookupEnv: lookupEnv(map[string]string{
+		"PORT":    "http",
+		"TIMEOUT": "5",
+		"EMPTY":   "",
+	})}
+	err := loader.Load(&config{})
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("error is %v, want a ValidationError", err)
+	}
+	if want := []string{"ID", "SECRET", "EMPTY"}; !reflect.DeepEqual(validationErr.Missing, want) {
+		t.Fatalf("missing keys are %v, want %v", validationErr.Missing, want)
+	}
+
+	var invalid []string
+	for _, key := range validationErr.Invalid {
+		invalid = append(invalid, key.Key)
+	}
+	if want := []string{"PORT", "TIMEOUT"}; !reflect.DeepEqual(invalid, want) {
+		t.Fatalf("invalid keys are %v, want %v", invalid, want)
+	}
+
+	for _, key := range []string{"ID", "SECRET", "EMPTY", "PORT", "TIMEOUT"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("the error %q does not name %s", err, key)
+		}
+	}
+}
+
+type validatedConfig struct {
+	Port int `env:"PORT"`
+}
+
+func (c *validatedConfig) Validate() error {
+	if c.Port == 0 {
+		return errors.New("port must be set")
+	}
+	return nil
+}
+
+func TestLoaderValidatesTheConfig(t *testing.T) {
+	loader := Loader{LookupEnv: lookupEnv(nil)}
+	if err := loader.Load(&validatedConfig{}); err == nil || !strings.Contains(err.Error(), "port must be set") {
+		t.Fatalf("error is %v, want the error of Validate", err)
+	}
+
+	loader.LookupEnv = lookupEnv(map[string]string{"PORT": "80"})
+	if err := loader.Load(&validatedConfig{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoaderParsesTheValues(t *testing.T) {
+	loader := Loader{LookupEnv: lookupEnv(map[string]string{
+		"APP_PORT":    "8080",
+		"APP_TIMEOUT": "1m30s",
+		"APP_HOSTS":   " a.example.com, ,b.example.com ",
+		"APP_DEBUG":   "true",
+	})}
+
+	config := &loaderConfig{}
+	if err := loader.Load(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Port != 8080 {
+		t.Fatalf("port is %d, want 8080", config.Port)
+	}
+	if config.Timeout != 90*time.Second {
+		t.Fatalf("timeout is %s, want 1m30s", config.Timeout)
+	}
+	if want := []string{"a.example.com", "b.example.com"}; !reflect.DeepEqual(config.Hosts, want) {
+		t.Fatalf("hosts are %q, want %q", config.Hosts, want)
+	}
+	if !config.Debug {
+		t.Fatal("debug is not set")
+	}
+}
+
+func TestLoaderFlattensTheNestedKeysOfTheFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name:    "yaml",
+			file:    "config.yaml",
+			content: "app:\n  port: 8080\n  hosts: [a, b]\n  timeout: 10s\ndatabase:\n  url: postgres://db\n",
+		},
+		{
+			name:    "toml",
+			file:    "config.toml",
+			content: "[app]\nport = 8080\nhosts = [\"a\", \"b\"]\ntimeout = \"10s\"\n\n[database]\nurl = \"postgres://db\"\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loader := Loader{File: writeFile(t, test.file, test.content), LookupEnv: lookupEnv(nil)}
+
+			config := &loaderConfig{}
+			if err := loader.Load(config); err != nil {
+				t.Fatal(err)
+			}
+
+			if config.Port != 8080 || config.Timeout != 10*time.Second || config.Database.Url != "postgres://db" {
+				t.Fatalf("config is %+v", config)
+			}
+			if want := []string{"a", "b"}; !reflect.DeepEqual(config.Hosts, want) {
+				t.Fatalf("hosts are %q, want %q", config.Hosts, want)
+			}
+		})
+	}
+}
+
+func TestLoaderRejectsAnUnknownFileFormat(t *testing.T) {
+	loader := Loader{File: writeFile(t, "config.json", "{}"), LookupEnv: lookupEnv(nil)}
+	if err := loader.Load(&loaderConfig{}); err == nil {
+		t.Fatal("a json file is loaded")
+	}
+}
+
+func TestLoaderRejectsATargetWhichIsNotAStructPointer(t *testing.T) {
+	if err := (&Loader{}).Load(loaderConfig{}); err == nil {
+		t.Fatal("a struct value is loaded")
+	}
+}
+
+func TestDumpRedactsTheSecrets(t *testing.T) {
+	config := &loaderConfig{Name: "app", Port: 80, Timeout: time.Second, Hosts: []string{"a", "b"}, Password: "hunter2"}
+	config.Database.Url = "postgres://db"
+
+	dump := Dump(config)
+	want := map[string]string{
+		"APP_NAME":     "app",
+		"APP_PORT":     "80",
+		"APP_TIMEOUT":  "1s",
+		"APP_HOSTS":    "a,b",
+		"APP_DEBUG":    "false",
+		"APP_PASSWORD": redacted,
+		"DATABASE_URL": "postgres://db",
+	}
+	if !reflect.DeepEqual(dump, want) {
+		t.Fatalf("dump is %v, want %v", dump, want)
+	}
+
+	dumpString := DumpString(config)
+	if strings.Contains(dumpString, "hunter2") {
+		t.Fatal("the secret is in the dump")
+	}
+	wantString := "APP_DEBUG=false\nAPP_HOSTS=a,b\nAPP_NAME=app\nAPP_PASSWORD=******\nAPP_PORT=80\nAPP_TIMEOUT=1s\nDATABASE_URL=postgres://db\n"
+	if dumpString != wantString {
+		t.Fatalf("dump string is %q, want %q", dumpString, wantString)
+	}
+}
+
+func TestDumpKeepsAnEmptySecretEmpty(t *testing.T) {
+	if dump := Dump(&loaderConfig{}); dump["APP_PASSWORD"] != "" {
+		t.Fatalf("the empty secret is dumped as %q", dump["APP_PASSWORD"])
+	}
+}
@@ -0,0 +1,189 @@
+package configsvc
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// FileWatcherWireset watches the file of CONFIG_WATCH_FILE, or CONFIG_FILE.
+var FileWatcherWireset = wire.NewSet(
+	NewFileSourceFromEnv,
+	wire.Bind(new(Source), new(*FileSource)),
+	NewWatcher,
+)
+
+// WatcherWireset requires a Source, such as redissvc.ConfigSourceWireset.
+var WatcherWireset = wire.NewSet(
+	NewWatcher,
+)
+
+// Source provides the values which change at runtime, by key.
+// They override the other layers of the Loader.
+type Source interface {
+	Values(ctx context.Context) (map[string]string, error)
+}
+
+// FileSource reads the values from a YAML or TOML file, like the config file of the Loader.
+type FileSource struct {
+	Path string
+}
+
+func NewFileSourceFromEnv() *FileSource {
+	path := os.Getenv("CONFIG_WATCH_FILE")
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+
+	return &FileSource{Path: path}
+}
+
+// Values returns no value when there is no file yet, so it can be created later.
+func (s *FileSource) Values(_ context.Context) (map[string]string, error) {
+	if s.Path == "" {
+		return map[string]string{}, nil
+	}
+
+	info, err := os.Stat(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+
+	// the file is being written, the values would fall back to the defaults
+	if err == nil && info.Size() == 0 {
+		return nil, errors.Errorf("config file %s is empty", s.Path)
+	}
+
+	return readFile(s.Path)
+}
+
+type watcherConfig struct {
+	Interval time.Duration `env:"CONFIG_WATCH_INTERVAL" default:"10s"`
+}
+
+// Watcher polls a Source and reloads the subscribed configs when its values change.
+type Watcher struct {
+	Source   Source
+	Interval time.Duration
+	Logger   *zap.Logger
+
+	mu       sync.Mutex
+	values   map[string]string
+	reloads  []func(values map[string]string)
+	stopChan chan struct{}
+}
+
+// NewWatcher reads the source once, then polls it until the cleanup.
+func NewWatcher(source Source, logger *zap.Logger) (*Watcher, func(), error) {
+	config := &watcherConfig{}
+	if err := Load(config); err != nil {
+		return nil, nil, err
+	}
+
+	values, err := source.Values(context.Background())
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed to read the config source")
+	}
+
+	watcher := &Watcher{
+		Source:   source,
+		Interval: config.Interval,
+		Logger:   logger.Named("configWatcher"),
+		values:   values,
+		stopChan: make(chan struct{}),
+	}
+
+	go watcher.run()
+
+	cleanup := func() {
+		close(watcher.stopChan)
+	}
+
+	return watcher, cleanup, nil
+}
+
+func (w *Watcher) run() {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopChan:
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+func (w *Watcher) poll() {
+	ctx, cancel := context.WithTimeout(context.Background(), w.Interval)
+	defer cancel()
+
+	values, err := w.Source.Values(ctx)
+	if err != nil {
+		w.Logger.Error("failed to read the config source", zap.Error(err))
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if reflect.DeepEqual(values, w.values) {
+		return
+	}
+
+	w.values = values
+	for _, reload := range w.reloads {
+		reload(values)
+	}
+}
+
+// Subscribe loads the config T and calls fn with it, then again every time the
+// source changes it. A reload which fails the validation is rejected, fn keeps the
+// previous config. It returns the error of the first load.
+func Subscribe[T any](w *Watcher, fn func(config *T)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	current, err := loadWith[T](w.values)
+	if err != nil {
+		return err
+	}
+	fn(current)
+
+	w.reloads = append(w.reloads, func(values map[string]string) {
+		next, err := loadWith[T](values)
+		if err != nil {
+			w.Logger.Error("rejected the config reload", zap.String("config", reflect.TypeOf(current).Elem().String()), zap.Error(err))
+			return
+		}
+
+		if reflect.DeepEqual(current, next) {
+			return
+		}
+
+		current = next
+		fn(current)
+	})
+
+	return nil
+}
+
+func loadWith[T any](values map[string]string) (*T, error) {
+	loader := NewLoader()
+	loader.Overrides = values
+
+	config := new(T)
+	if err := loader.Load(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
@@ -0,0 +1,227 @@
+package configsvc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// fakeSource returns the values it is given.
+type fakeSource struct {
+	mu     sync.Mutex
+	values map[string]string
+	err    error
+	reads  int
+}
+
+func (s *fakeSource) Values(_ context.Context) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reads++
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	values := make(map[string]string, len(s.values))
+	for key, value := range s.values {
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func (s *fakeSource) set(values map[string]string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values, s.err = values, err
+}
+
+type reloadedConfig struct {
+	Limit int `env:"WATCHED_LIMIT" required:"true"`
+}
+
+func (c *reloadedConfig) Validate() error {
+	if c.Limit <= 0 {
+		return errors.New("limit must be positive")
+	}
+	return nil
+}
+
+// isolateLoader keeps the environment of the machine out of the loads of the watcher.
+func isolateLoader(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("CONFIG_SECRETS_DIR", t.TempDir())
+}
+
+// newTestWatcher returns a watcher which polls when the test calls poll.
+func newTestWatcher(t *testing.T, source *fakeSource) *Watcher {
+	t.Helper()
+
+	values, err := source.Values(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Watcher{
+		Source:   source,
+		Interval: time.Second,
+		Logger:   zap.NewNop(),
+		values:   values,
+	}
+}
+
+// subscribe records the configs the subscriber gets.
+func subscribe(t *testing.T, watcher *Watcher) *[]int {
+	t.Helper()
+
+	var limits []int
+	if err := Subscribe(watcher, func(config *reloadedConfig) {
+		limits = append(limits, config.Limit)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return &limits
+}
+
+func TestSubscribeGetsTheReloadedConfig(t *testing.T) {
+	isolateLoader(t)
+	source := &fakeSource{values: map[string]string{"WATCHED_LIMIT": "1"}}
+	watcher := newTestWatcher(t, source)
+
+	first, second := subscribe(t, watcher), subscribe(t, watcher)
+
+	source.set(map[string]string{"WATCHED_LIMIT": "2"}, nil)
+	watcher.poll()
+
+	for _, limits := range []*[]int{first, second} {
+		if len(*limits) != 2 || (*limits)[0] != 1 || (*limits)[1] != 2 {
+			t.Fatalf("the subscriber got %v, want [1 2]", *limits)
+		}
+	}
+}
+
+func TestSubscribeIgnoresAnUnchangedConfig(t *testing.T) {
+	isolateLoader(t)
+	source := &fakeSource{values: map[string]string{"WATCHED_LIMIT": "1"}}
+	watcher := newTestWatcher(t, source)
+	limits := subscribe(t, watcher)
+
+	// the same values, then a key the config does not bind
+	watcher.poll()
+	source.set(map[string]string{"WATCHED_LIMIT": "1", "OTHER": "x"}, nil)
+	watcher.poll()
+
+	if len(*limits) != 1 {
+		t.Fatalf("the subscriber got %v, want only the first load", *limits)
+	}
+}
+
+func TestSubscribeRejectsAReloadWhichFailsTheValidation(t *testing.T) {
+	isolateLoader(t)
+	source := &fakeSource{values: map[string]string{"WATCHED_LIMIT": "1"}}
+	watcher := newTestWatcher(t, source)
+	limits := subscribe(t, watcher)
+
+	for _, values := range []map[string]string{
+		{"WATCHED_LIMIT": "0"},
+		{"WATCHED_LIMIT": "many"},
+		{},
+	} {
+		source.set(values, nil)
+		watcher.poll()
+	}
+
+	if len(*limits) != 1 || (*limits)[0] != 1 {
+		t.Fatalf("the subscriber got %v, want to keep the previous config", *limits)
+	}
+
+	source.set(map[string]string{"WATCHED_LIMIT": "3"}, nil)
+	watcher.poll()
+
+	if len(*limits) != 2 || (*limits)[1] != 3 {
+		t.Fatalf("the subscriber got %v, want the valid reload after the rejected ones", *limits)
+	}
+}
+
+func TestSubscribeKeepsTheConfigWhenTheSourceFails(t *testing.T) {
+	isolateLoader(t)
+	source := &fakeSource{values: map[string]string{"WATCHED_LIMIT": "1"}}
+	watcher := newTestWatcher(t, source)
+	limits := subscribe(t, watcher)
+
+	source.set(nil, errors.New("source is unavailable"))
+	watcher.poll()
+
+	// the values are unchanged once the source is back
+	source.set(map[string]string{"WATCHED_LIMIT": "1"}, nil)
+	watcher.poll()
+
+	if len(*limits) != 1 {
+		t.Fatalf("the subscriber got %v, want only the first load", *limits)
+	}
+}
+
+func TestSubscribeReturnsTheErrorOfTheFirstLoad(t *testing.T) {
+	isolateLoader(t)
+	watcher := newTestWatcher(t, &fakeSource{values: map[string]string{"WATCHED_LIMIT": "0"}})
+
+	called := false
+	err := Subscribe(watcher, func(config *reloadedConfig) {
+		called = true
+	})
+	if err == nil || called {
+		t.Fatal("an invalid config is given to the subscriber")
+	}
+	if len(watcher.reloads) != 0 {
+		t.Fatal("the subscriber is reloaded after its first load failed")
+	}
+}
+
+func TestWatcherPollsTheSourceUntilTheCleanup(t *testing.T) {
+	isolateLoader(t)
+	t.Setenv("CONFIG_WATCH_INTERVAL", "10ms")
+
+	source := &fakeSource{values: map[string]string{"WATCHED_LIMIT": "1"}}
+	watcher, cleanup, err := NewWatcher(source, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan int, 10)
+	if err := Subscribe(watcher, func(config *reloadedConfig) {
+		reloaded <- config.Limit
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-reloaded
+
+	source.set(map[string]string{"WATCHED_LIMIT": "2"}, nil)
+	select {
+	case limit := <-reloaded:
+		if limit != 2 {
+			t.Fatalf("the subscriber got %d, want 2", limit)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the change of the source is not polled")
+	}
+
+	cleanup()
+	time.Sleep(30 * time.Millisecond)
+	source.mu.Lock()
+	reads := source.reads
+	source.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	if source.reads != reads {
+		t.Fatal("the source is polled after the cleanup")
+	}
+}
@@ -0,0 +1,235 @@
+package cryptosvc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+)
+
+var DefaultWireset = wire.NewSet(
+	ConfigFromEnv,
+	NewEnvelope,
+)
+
+// sealedPrefix marks a sealed value, a value without it is stored in plain text.
+const sealedPrefix = "enc:v1:"
+
+const dataKeySize = 32
+
+var (
+	ErrUnknownKey = errors.New("the key of the sealed value is unknown")
+	ErrMalformed  = errors.New("the sealed value is malformed")
+)
+
+// Config is bound by configsvc.Loader.
+type Config struct {
+	// Keys are the key-encryption keys, "id:base64 key" each. A key is 16, 24 or 32 bytes, see GenerateKey.
+	Keys []string `env:"TOKEN_ENCRYPTION_KEYS" required:"true" secret:"true"`
+	// KeyID is the key which seals, the first key when empty. The other keys only open the values
+	// sealed before the rotation, until they are sealed again.
+	KeyID string `env:"TOKEN_ENCRYPTION_KEY_ID"`
+}
+
+func (c *Config) Validate() error {
+	_, err := NewEnvelope(c)
+	return err
+}
+
+// ConfigFromEnv loads the config from the layered sources of configsvc.Loader.
+func ConfigFromEnv() (*Config, error) {
+	config := &Config{}
+	if err := configsvc.Load(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// GenerateKey returns a new 32 bytes key, in the format of Config.Keys.
+func GenerateKey(id string) (string, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", errors.Wrap(err, "generate key")
+	}
+
+	return id + ":" + base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Envelope seals the values with AES-GCM: each value has a data key of its own, which is sealed
+// by the key-encryption key. The id of that key is stored with the value, so the keys can rotate.
+type Envelope struct {
+	keyID string
+	keys  map[string]cipher.AEAD
+}
+
+func NewEnvelope(config *Config) (*Envelope, error) {
+	envelope := &Envelope{
+		keyID: config.KeyID,
+		keys:  map[string]cipher.AEAD{},
+	}
+
+	for _, entry := range config.Keys {
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, errors.New("a key is not formatted as id:base64 key")
+		}
+		if _, exists := envelope.keys[id]; exists {
+			return nil, errors.Errorf("the key %s is duplicated", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode the key %s", id)
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "the key %s", id)
+		}
+
+		envelope.keys[id] = aead
+		if envelope.keyID == "" {
+			envelope.keyID = id
+		}
+	}
+
+	if len(envelope.keys) == 0 {
+		return nil, errors.New("no key-encryption key")
+	}
+	if _, ok := envelope.keys[envelope.keyID]; !ok {
+		return nil, errors.Errorf("the active key %s is not one of the keys", envelope.keyID)
+	}
+
+	return envelope, nil
+}
+
+// KeyID is the id of the key which seals.
+func (e *Envelope) KeyID() string {
+	return e.keyID
+}
+
+// Seal returns the sealed value, associated is authenticated with it, such as the id of its owner.
+func (e *Envelope) Seal(plaintext, associated string) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", errors.Wrap(err, "generate data key")
+	}
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealedKey, err := seal(e.keys[e.keyID], dataKey, []byte(e.keyID))
+	if err != nil {
+		return "", err
+	}
+
+	sealedValue, err := seal(dataAEAD, []byte(plaintext), []byte(associated))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedPrefix + e.keyID + ":" +
+		base64.RawURLEncoding.EncodeToString(sealedKey) + ":" +
+		base64.RawURLEncoding.EncodeToString(sealedValue), nil
+}
+
+// Open returns the plain text of a sealed value, with the associated data it was sealed with.
+// A value which is not sealed is returned as it is, it was stored before the encryption.
+func (e *Envelope) Open(value, associated string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+
+	keyAEAD, ok := e.keys[parts[0]]
+	if !ok {
+		return "", errors.WithMessage(ErrUnknownKey, parts[0])
+	}
+
+	sealedKey, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	sealedValue, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	dataKey, err := open(keyAEAD, sealedKey, []byte(parts[0]))
+	if err != nil {
+		return "", errors.WithMessage(err, "open data key")
+	}
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(dataAEAD, sealedValue, []byte(associated))
+	if err != nil {
+		return "", errors.WithMessage(err, "open value")
+	}
+
+	return string(plaintext), nil
+}
+
+// NeedsRotation is true for a value in plain text, or sealed by another key than the active one.
+func (e *Envelope) NeedsRotation(value string) bool {
+	return !strings.HasPrefix(value, sealedPrefix+e.keyID+":")
+}
+
+// IsSealed is true for a value returned by Seal.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "new cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "new gcm")
+	}
+
+	return aead, nil
+}
+
+// seal prefixes the sealed data with its random nonce.
+func seal(aead cipher.AEAD, plaintext, associated []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce")
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, associated), nil
+}
+
+func open(aead cipher.AEAD, sealed, associated []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, associated)
+	if err != nil {
+		return nil, errors.Wrap(err, "authenticate")
+	}
+
+	return plaintext, nil
+}
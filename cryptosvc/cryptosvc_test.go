@@ -0,0 +1,181 @@
+package cryptosvc
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	testShopID  = "gid://shopify/Shop/1"
+	testToken   = "shpat_secret"
+	testKeySize = 32
+)
+
+func newTestEnvelope(t *testing.T, ids ...string) *Envelope {
+	t.Helper()
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		key, err := GenerateKey(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	envelope, err := NewEnvelope(&Config{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return envelope
+}
+
+func TestEnvelopeOpensTheSealedValue(t *testing.T) {
+	envelope := newTestEnvelope(t, "k1")
+
+	sealed, err := envelope.Seal(testToken, testShopID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, testToken) {
+		t.Fatalf("the value is not sealed: %s", sealed)
+	}
+
+	opened, err := envelope.Open(sealed, testShopID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != testToken {
+		t.Fatalf("opened %q, want %q", opened, testToken)
+	}
+}
+
+func TestEnvelopeRejectsTheValueOfAnotherShop(t *testing.T) {
+	envelope := newTestEnvelope(t, "k1")
+
+	sealed, err := envelope.Seal(testToken, testShopID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opened, err := envelope.Open(sealed, "gid://shopify/Shop/2"); err == nil {
+		t.Fatalf("the value of another shop was opened as %q", opened)
+	}
+}
+
+// flipByte flips a byte of a base64 part of the sealed value.
+func flipByte(t *testing.T, sealed string, part int) string {
+	t.Helper()
+
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	data, err := base64.RawURLEncoding.DecodeString(parts[part])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0x01
+	parts[part] = base64.RawURLEncoding.EncodeToString(data)
+
+	return sealedPrefix + strings.Join(parts, ":")
+}
+
+func TestEnvelopeRejectsATamperedValue(t *testing.T) {
+	envelope := newTestEnvelope(t, "k1")
+
+	sealed, err := envelope.Seal(testToken, testShopID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"sealed data key": flipByte(t, sealed, 1),
+		"sealed value":    flipByte(t, sealed, 2),
+	}
+
+	for name, tampered := range tests {
+		t.Run(name, func(t *testing.T) {
+			if opened, err := envelope.Open(tampered, testShopID); err == nil {
+				t.Fatalf("the tampered value was opened as %q", opened)
+			}
+		})
+	}
+}
+
+func TestEnvelopeRejectsAMalformedValue(t *testing.T) {
+	envelope := newTestEnvelope(t, "k1")
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr error
+	}{
+		{name: "prefix only", value: "enc:v1:", wantErr: ErrMalformed},
+		{name: "missing parts", value: "enc:v1:k1:abc", wantErr: ErrMalformed},
+		{name: "extra parts", value: "enc:v1:k1:abc:def:ghi", wantErr: ErrMalformed},
+		{name: "unknown key", value: "enc:v1:k2:abc:def", wantErr: ErrUnknownKey},
+		{name: "data key is not base64", value: "enc:v1:k1:%%%:def", wantErr: ErrMalformed},
+		{name: "value is not base64", value: "enc:v1:k1:abc:%%%", wantErr: ErrMalformed},
+		{name: "empty data key", value: "enc:v1:k1::def", wantErr: ErrMalformed},
+		{name: "data key shorter than a nonce", value: "enc:v1:k1:AAAA:def", wantErr: ErrMalformed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opened, err := envelope.Open(test.value, testShopID)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("opened %q with the error %v, want %v", opened, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnvelopeReturnsALegacyValueAsItIs(t *testing.T) {
+	envelope := newTestEnvelope(t, "k1")
+
+	opened, err := envelope.Open(testToken, testShopID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != testToken {
+		t.Fatalf("opened %q, want the plain text value %q", opened, testToken)
+	}
+	if !envelope.NeedsRotation(testToken) {
+		t.Fatal("a plain text value does not need to be sealed")
+	}
+}
+
+func TestNewEnvelopeRejectsAnInvalidConfig(t *testing.T) {
+	key, err := GenerateKey("k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := GenerateKey("k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shortKey := "short:" + base64.StdEncoding.EncodeToString(make([]byte, 15))
+
+	tests := []struct {
+		name   string
+		config *Config
+	}{
+		{name: "no key", config: &Config{}},
+		{name: "wrong key length", config: &Config{Keys: []string{shortKey}}},
+		{name: "key is not base64", config: &Config{Keys: []string{"k1:%%%"}}},
+		{name: "key without id", config: &Config{Keys: []string{":" + base64.StdEncoding.EncodeToString(make([]byte, testKeySize))}}},
+		{name: "key without separator", config: &Config{Keys: []string{base64.StdEncoding.EncodeToString(make([]byte, testKeySize))}}},
+		{name: "duplicated key id", config: &Config{Keys: []string{key, otherKey}}},
+		{name: "active key is not a key", config: &Config{Keys: []string{key}, KeyID: "k2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewEnvelope(test.config); err == nil {
+				t.Fatal("the envelope was created")
+			}
+		})
+	}
+}
@@ -5,14 +5,16 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
-	"os"
 	"time"
 
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp"
 	"github.com/dgraph-io/dgo/v2"
 	"github.com/dgraph-io/dgo/v2/protos/api"
 	"github.com/google/wire"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 )
 
@@ -22,24 +24,19 @@ var DefaultWireSet = wire.NewSet(
 )
 
 type Config struct {
-	Address     string
-	PoolSize    int
-	DialTimeout time.Duration
-	RetryCount  int
+	Address     string        `env:"DGRAPH_ADDRESS" required:"true"`
+	PoolSize    int           `env:"DGRAPH_POOL_SIZE" default:"10"`
+	DialTimeout time.Duration `env:"DGRAPH_DIAL_TIMEOUT" default:"30s"`
+	RetryCount  int           `env:"DGRAPH_RETRY_COUNT" default:"3"`
 }
 
 func ProvideConfig() (*Config, error) {
-	address := os.Getenv("DGRAPH_ADDRESS")
-	if address == "" {
-		return nil, fmt.Errorf("DGRAPH_ADDRESS environment variable is not provided or is empty")
+	config := &Config{}
+	if err := configsvc.Load(config); err != nil {
+		return nil, err
 	}
 
-	return &Config{
-		Address:     address,
-		PoolSize:    10,
-		DialTimeout: 30 * time.Second,
-		RetryCount:  3,
-	}, nil
+	return config, nil
 }
 
 func createDialOptions(cfg *Config) ([]grpc.DialOption, error) {
@@ -79,7 +76,7 @@ func dialDgraphWithRetry(ctx context.Context, cfg *Config, opts []grpc.DialOptio
 	return nil, fmt.Errorf("failed to connect to Dgraph after %d attempts: %w", cfg.RetryCount, err)
 }
 
-func NewDgraphSvc(cfg *Config, logger *zap.Logger) (*dgo.Dgraph, func(), error) {
+func NewDgraphSvc(cfg *Config, logger *zap.Logger, healthRegistry *fiberapp.HealthRegistry) (*dgo.Dgraph, func(), error) {
 	opts, err := createDialOptions(cfg)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create dial options: %w", err)
@@ -93,6 +90,17 @@ func NewDgraphSvc(cfg *Config, logger *zap.Logger) (*dgo.Dgraph, func(), error)
 
 	dgraphClient := dgo.NewDgraphClient(api.NewDgraphClient(conn))
 
+	healthRegistry.AddHealthChecks(&fiberapp.HealthCheck{
+		Name:     "dgraph",
+		Critical: true,
+		Check: func(ctx context.Context) error {
+			if state := conn.GetState(); state == connectivity.TransientFailure || state == connectivity.Shutdown {
+				return fmt.Errorf("connection is %s", state)
+			}
+			return nil
+		},
+	})
+
 	cleanup := func() {
 		if err := conn.Close(); err != nil {
 			logger.Error("Failed to close connection", zap.Error(err))
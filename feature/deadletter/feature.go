@@ -0,0 +1,154 @@
+package deadletter
+
+import (
+	"net/http"
+
+	"github.com/aiocean/wireset/apperror"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+)
+
+// DeadLettersPath lists and purges the dead letters, DeadLetterPath reads, deletes and replays one.
+const (
+	DeadLettersPath = "/api/v1/admin/dead-letters"
+	DeadLetterPath  = DeadLettersPath + "/:id"
+)
+
+// defaultListLimit is the number of dead letters listed when the request has no limit.
+const defaultListLimit = 50
+
+var ErrDeadLetterNotFound = apperror.New(apperror.CodeNotFound, http.StatusNotFound, "dead letter not found")
+
+// DefaultWireset requires an api key verifier, such as authsvc.APIKeyWireset, the routes are declared with fiberapp.AuthAPIKey.
+var DefaultWireset = wire.NewSet(
+	wire.Struct(new(FeatureDeadLetter), "*"),
+)
+
+// FeatureDeadLetter serves the admin api of the dead letter queue.
+type FeatureDeadLetter struct {
+	HttpRegistry    *fiberapp.Registry
+	DeadLetterQueue *pubsub.DeadLetterQueue
+}
+
+type ListDeadLettersResponse struct {
+	DeadLetters []*pubsub.DeadLetter `json:"deadLetters"`
+}
+
+func (f *FeatureDeadLetter) Name() string {
+	return "deadletter"
+}
+
+func (f *FeatureDeadLetter) Init() error {
+	tags := []string{"admin"}
+
+	f.HttpRegistry.AddHttpHandlers(
+		&fiberapp.HttpHandler{
+			Method:      fiber.MethodGet,
+			Path:        DeadLettersPath,
+			Handlers:    []fiber.Handler{f.List},
+			Summary:     "List the dead letters",
+			Description: "Returns the most recent dead letters first, the limit query parameter defaults to 50.",
+			Tags:        tags,
+			Response:    ListDeadLettersResponse{},
+			Auth:        fiberapp.AuthAPIKey,
+		},
+		&fiberapp.HttpHandler{
+			Method:         fiber.MethodDelete,
+			Path:           DeadLettersPath,
+			Handlers:       []fiber.Handler{f.Purge},
+			Summary:        "Purge the dead letters",
+			Tags:           tags,
+			Auth:           fiberapp.AuthAPIKey,
+			ResponseStatus: fiber.StatusNoContent,
+		},
+		&fiberapp.HttpHandler{
+			Method:   fiber.MethodGet,
+			Path:     DeadLetterPath,
+			Handlers: []fiber.Handler{f.Get},
+			Summary:  "Get a dead letter",
+			Tags:     tags,
+			Response: pubsub.DeadLetter{},
+			Auth:     fiberapp.AuthAPIKey,
+		},
+		&fiberapp.HttpHandler{
+			Method:         fiber.MethodDelete,
+			Path:           DeadLetterPath,
+			Handlers:       []fiber.Handler{f.Delete},
+			Summary:        "Delete a dead letter without replaying it",
+			Tags:           tags,
+			Auth:           fiberapp.AuthAPIKey,
+			ResponseStatus: fiber.StatusNoContent,
+		},
+		&fiberapp.HttpHandler{
+			Method:         fiber.MethodPost,
+			Path:           DeadLetterPath + "/replay",
+			Handlers:       []fiber.Handler{f.Replay},
+			Summary:        "Replay a dead letter",
+			Description:    "Publishes the message again to its topic, only the handler which failed it handles it. The dead letter is deleted once published.",
+			Tags:           tags,
+			Auth:           fiberapp.AuthAPIKey,
+			ResponseStatus: fiber.StatusNoContent,
+		},
+	)
+
+	return nil
+}
+
+func (f *FeatureDeadLetter) List(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", defaultListLimit)
+	if limit <= 0 {
+		return apperror.Validation(apperror.FieldError{Field: "limit", Message: "must be a positive number"})
+	}
+
+	deadLetters, err := f.DeadLetterQueue.List(c.UserContext(), limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(ListDeadLettersResponse{DeadLetters: deadLetters})
+}
+
+func (f *FeatureDeadLetter) Get(c *fiber.Ctx) error {
+	deadLetter, err := f.DeadLetterQueue.Get(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return notFound(err)
+	}
+
+	return c.JSON(deadLetter)
+}
+
+func (f *FeatureDeadLetter) Replay(c *fiber.Ctx) error {
+	if err := f.DeadLetterQueue.Replay(c.UserContext(), c.Params("id")); err != nil {
+		return notFound(err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (f *FeatureDeadLetter) Delete(c *fiber.Ctx) error {
+	if err := f.DeadLetterQueue.Delete(c.UserContext(), c.Params("id")); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (f *FeatureDeadLetter) Purge(c *fiber.Ctx) error {
+	if err := f.DeadLetterQueue.Purge(c.UserContext()); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// notFound maps pubsub.ErrDeadLetterNotFound to its http error.
+func notFound(err error) error {
+	if errors.Is(err, pubsub.ErrDeadLetterNotFound) {
+		return ErrDeadLetterNotFound
+	}
+
+	return err
+}
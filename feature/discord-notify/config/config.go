@@ -1,22 +1,23 @@
 package config
 
 import (
+	"github.com/aiocean/wireset/configsvc"
 	"github.com/pkg/errors"
-	"os"
 )
 
 type Config struct {
-	NewInstallWebhook string
+	NewInstallWebhook string `env:"DISCORD_WEBHOOK_URL" secret:"true"`
 }
 
 var ErrMissingNewInstallWebhook = errors.New("DISCORD_WEBHOOK_URL is missing")
 
 func NewNotifyConfigFromEnv() (*Config, error) {
 	conf := &Config{}
+	if err := configsvc.Load(conf); err != nil {
+		return nil, err
+	}
 
-	if newInstallWebhook, ok := os.LookupEnv("DISCORD_WEBHOOK_URL"); ok {
-		conf.NewInstallWebhook = newInstallWebhook
-	} else {
+	if conf.NewInstallWebhook == "" {
 		return nil, ErrMissingNewInstallWebhook
 	}
 
@@ -0,0 +1,51 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/pkg/errors"
+)
+
+// discordRetryPolicy gives discord time to recover from rate limits and outages,
+// a notification is not urgent. The deduplicator leases a message for this retry window too.
+func discordRetryPolicy() pubsub.RetryPolicy {
+	return pubsub.RetryPolicy{
+		MaxAttempts:         5,
+		InitialInterval:     2 * time.Second,
+		MaxInterval:         time.Minute,
+		Multiplier:          2,
+		MaxElapsedTime:      5 * time.Minute,
+		RandomizationFactor: 0.5,
+	}
+}
+
+// sendDiscordMessage posts the content to the discord webhook.
+func sendDiscordMessage(ctx context.Context, webhookUrl, content string) error {
+	payload, err := json.Marshal(map[string]string{"content": content})
+	if err != nil {
+		return errors.WithMessage(err, "marshal discord message")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookUrl, bytes.NewReader(payload))
+	if err != nil {
+		return errors.WithMessage(err, "create discord request")
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.WithMessage(err, "send discord message")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("send discord message, status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
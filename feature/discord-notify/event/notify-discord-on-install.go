@@ -5,9 +5,8 @@ import (
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/aiocean/wireset/feature/discord-notify/config"
 	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
 	"go.uber.org/zap"
-	"net/http"
-	"strings"
 )
 
 type NotifyDiscordOnInstallHandler struct {
@@ -44,13 +43,11 @@ func (h *NotifyDiscordOnInstallHandler) RegisterBus(commandBus *cqrs.CommandBus,
 	h.commandBus = commandBus
 }
 
+func (h *NotifyDiscordOnInstallHandler) RetryPolicy() pubsub.RetryPolicy {
+	return discordRetryPolicy()
+}
+
 func (h *NotifyDiscordOnInstallHandler) Handle(ctx context.Context, event interface{}) error {
 	cmd := event.(*model.ShopInstalledEvt)
-	payload := strings.NewReader(`{"content": "New shop installed: ` + cmd.MyshopifyDomain + `"}`)
-	req, _ := http.NewRequest("POST", h.config.NewInstallWebhook, payload)
-	req.Header.Add("Content-Type", "application/json")
-	res, _ := http.DefaultClient.Do(req)
-	defer res.Body.Close()
-
-	return nil
+	return sendDiscordMessage(ctx, h.config.NewInstallWebhook, "New shop installed: "+cmd.MyshopifyDomain)
 }
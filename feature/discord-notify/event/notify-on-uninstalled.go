@@ -5,9 +5,8 @@ import (
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/aiocean/wireset/feature/discord-notify/config"
 	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
 	"go.uber.org/zap"
-	"net/http"
-	"strings"
 )
 
 type NotifyDiscordOnUninstallHandler struct {
@@ -42,13 +41,11 @@ func (h *NotifyDiscordOnUninstallHandler) RegisterBus(commandBus *cqrs.CommandBu
 	h.commandBus = commandBus
 }
 
+func (h *NotifyDiscordOnUninstallHandler) RetryPolicy() pubsub.RetryPolicy {
+	return discordRetryPolicy()
+}
+
 func (h *NotifyDiscordOnUninstallHandler) Handle(ctx context.Context, event interface{}) error {
 	cmd := event.(*model.ShopUninstalledEvt)
-	payload := strings.NewReader(`{"content": "Shop uninstalled: ` + cmd.MyshopifyDomain + `"}`)
-	req, _ := http.NewRequest("POST", h.config.NewInstallWebhook, payload)
-	req.Header.Add("Content-Type", "application/json")
-	res, _ := http.DefaultClient.Do(req)
-	defer res.Body.Close()
-
-	return nil
+	return sendDiscordMessage(ctx, h.config.NewInstallWebhook, "Shop uninstalled: "+cmd.MyshopifyDomain)
 }
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aiocean/wireset/apperror"
+	"github.com/aiocean/wireset/cachesvc"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/prometheussvc"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/google/wire"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsPath serves the metrics in the prometheus format.
+const MetricsPath = "/metrics"
+
+// DefaultWireset requires prometheussvc.DefaultWireset.
+var DefaultWireset = wire.NewSet(
+	wire.Struct(new(FeatureMetrics), "*"),
+)
+
+// FeatureMetrics mounts /metrics and exports the built-in instrumentation:
+// the http requests, the message handlers, the websocket connections, the cache and the Shopify api calls.
+type FeatureMetrics struct {
+	FiberApp     *fiber.App
+	HttpRegistry *fiberapp.Registry
+	Registerer   prometheus.Registerer
+	Gatherer     prometheus.Gatherer
+	CacheSvc     *cachesvc.CacheService
+	Deduplicator *pubsub.Deduplicator
+}
+
+func (f *FeatureMetrics) Name() string {
+	return "metrics"
+}
+
+func (f *FeatureMetrics) Init() error {
+	collectors := append(prometheussvc.Collectors(), f.statsCollectors()...)
+	if err := prometheussvc.Register(f.Registerer, collectors...); err != nil {
+		return err
+	}
+
+	f.FiberApp.Use(observeRequest)
+
+	f.HttpRegistry.AddHttpHandlers(&fiberapp.HttpHandler{
+		Method:   fiber.MethodGet,
+		Path:     MetricsPath,
+		Handlers: []fiber.Handler{adaptor.HTTPHandler(promhttp.HandlerFor(f.Gatherer, promhttp.HandlerOpts{}))},
+		Auth:     fiberapp.AuthPublic,
+		Hidden:   true,
+	})
+
+	return nil
+}
+
+// statsCollectors reads the stats which the cache and the deduplicator keep.
+func (f *FeatureMetrics) statsCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "cache_hits_total",
+			Help: "Lookups of the in-memory cache which found the key.",
+		}, func() float64 {
+			return float64(f.CacheSvc.Stats().Hits)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "cache_misses_total",
+			Help: "Lookups of the in-memory cache which did not find the key.",
+		}, func() float64 {
+			return float64(f.CacheSvc.Stats().Misses)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "cache_hit_ratio",
+			Help: "Part of the lookups of the in-memory cache which found the key, since the start.",
+		}, func() float64 {
+			return f.CacheSvc.Stats().HitRatio()
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "messages_duplicated_total",
+			Help: "Messages skipped because the handler already processed them.",
+		}, func() float64 {
+			return float64(f.Deduplicator.Stats().Hits)
+		}),
+	}
+}
+
+// observeRequest records the duration of the request by the template of the route which handled it,
+// so the path parameters do not multiply the series.
+func observeRequest(c *fiber.Ctx) error {
+	startedAt := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		// the error handler writes the status after the middlewares returned
+		status = apperror.From(err).Status
+	}
+
+	prometheussvc.HttpRequestDuration.
+		WithLabelValues(c.Method(), c.Route().Path, strconv.Itoa(status)).
+		Observe(time.Since(startedAt).Seconds())
+
+	return err
+}
@@ -2,12 +2,14 @@ package api
 
 import (
 	"context"
+	"github.com/aiocean/wireset/apperror"
 	"github.com/aiocean/wireset/feature/realtime/models"
 	"github.com/aiocean/wireset/feature/realtime/room"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/pkg/errors"
 	"github.com/tidwall/gjson"
 	"go.uber.org/zap"
+	"net/http"
 )
 
 func (h *WebsocketHandler) Handle(conn *websocket.Conn) {
@@ -33,13 +35,13 @@ func (h *WebsocketHandler) Handle(conn *websocket.Conn) {
 	username := conn.Locals(usernameKey).(string)
 
 	if currentRoom.IsMemberExists(username) {
-		h.handleError(conn, logger, errors.New("username already exists"), "username already exists")
+		h.handleError(conn, logger, apperror.New(apperror.CodeConflict, http.StatusConflict, "username already exists"), "username already exists")
 		return
 	}
 
 	logger.Info("New user want to join group", zap.String(usernameKey, username))
 
-	if err := currentRoom.AddMember(username, conn); err != nil {
+	if err := h.RoomManager.JoinMember(context.Background(), currentRoom, username, conn); err != nil {
 		h.handleError(conn, logger, err, "failed to add user to room")
 		return
 	}
@@ -54,6 +56,16 @@ func (h *WebsocketHandler) Handle(conn *websocket.Conn) {
 	// do some clean up
 	defer h.OnDisconnect(conn, currentRoom, username)
 
+	// the connect handlers stop before the member leaves
+	member, err := currentRoom.GetMember(username)
+	if err != nil {
+		h.handleError(conn, logger, err, "failed to get member")
+		return
+	}
+	connCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.Registry.Connected(connCtx, conn, member.Send)
+
 	// reuse variable for avoiding memory allocation, but it's not a good practice, use it carefully to avoid memory leak, race condition, etc.
 	var buf []byte
 	for {
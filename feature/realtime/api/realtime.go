@@ -1,7 +1,9 @@
 package api
 
 import (
+	"context"
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/aiocean/wireset/feature/realtime/models"
 	"github.com/aiocean/wireset/feature/realtime/registry"
 	"github.com/aiocean/wireset/feature/realtime/resolver"
 	"github.com/aiocean/wireset/feature/realtime/room"
@@ -15,8 +17,6 @@ import (
 const roomIDKey = "roomID"
 const usernameKey = "username"
 
-const errorKey = "error"
-
 type WebsocketHandler struct {
 	RoomManager      *room.Manager
 	Logger           *zap.Logger
@@ -79,8 +79,8 @@ func (h *WebsocketHandler) Upgrade(ctx *fiber.Ctx) error {
 // OnDisconnect is called when a client disconnects from the server.
 func (h *WebsocketHandler) OnDisconnect(ctx *websocket.Conn, room *room.Room, username string) {
 	// delete member from room
-	if err := room.DeleteMember(username); err != nil {
-		h.Logger.Error("delete member", zap.String(usernameKey, username), zap.String(roomIDKey, room.ID))
+	if err := h.RoomManager.LeaveMember(context.Background(), room, username); err != nil {
+		h.Logger.Error("delete member", zap.String(usernameKey, username), zap.String(roomIDKey, room.ID), zap.Error(err))
 		return
 	}
 
@@ -100,9 +100,9 @@ func (h *WebsocketHandler) OnDisconnect(ctx *websocket.Conn, room *room.Room, us
 
 func (h *WebsocketHandler) handleError(conn *websocket.Conn, logger *zap.Logger, err error, message string) {
 	logger.Error(message, zap.Error(err))
-	conn.WriteJSON(map[string]string{
-		errorKey: message + ": " + err.Error(),
-	})
+	if err := conn.WriteJSON(models.NewErrorMessage(err)); err != nil {
+		logger.Error("failed to write error", zap.Error(err))
+	}
 }
 
 // getTopic returns the topic from the message.
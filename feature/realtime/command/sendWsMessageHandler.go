@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/aiocean/wireset/feature/realtime/relay"
 	"github.com/aiocean/wireset/feature/realtime/room"
+	"github.com/aiocean/wireset/logsvc"
 	"go.uber.org/zap"
 )
 
@@ -12,6 +14,7 @@ type SendWsMessageHandler struct {
 	EventBus    *cqrs.EventBus
 	CommandBus  *cqrs.CommandBus
 	RoomManager *room.Manager
+	Relay       *relay.Relay
 	Logger      *zap.Logger
 }
 
@@ -28,24 +31,44 @@ func (h *SendWsMessageHandler) HandlerName() string {
 func (h *SendWsMessageHandler) NewCommand() interface{} {
 	return &SendWsMessageCmd{}
 }
+
+// Handle sends the message directly when the member is connected to this pod,
+// otherwise it forwards the message to the pod which owns the connection.
 func (h *SendWsMessageHandler) Handle(ctx context.Context, raw any) error {
+	logger := logsvc.WithTrace(ctx, h.Logger)
 	cmd, ok := raw.(*SendWsMessageCmd)
 	if !ok {
-		h.Logger.Error("Failed to cast raw to SendWsMessageCmd")
+		logger.Error("Failed to cast raw to SendWsMessageCmd")
 		return fmt.Errorf("failed to cast raw to SendWsMessageCmd")
 	}
 
-	toRoom, err := h.RoomManager.GetRoom(cmd.RoomID)
+	podID, err := h.RoomManager.LocateMember(ctx, cmd.RoomID, cmd.Username)
+	if err != nil {
+		logger.Error("Failed to locate member", zap.String("roomID", cmd.RoomID), zap.String("username", cmd.Username), zap.Error(err))
+		return fmt.Errorf("failed to locate member: %w", err)
+	}
+
+	if podID != h.RoomManager.PodID {
+		if err := h.Relay.Forward(ctx, podID, cmd.RoomID, cmd.Username, cmd.Payload); err != nil {
+			logger.Error("Failed to forward message", zap.String("podID", string(podID)), zap.Error(err))
+			return fmt.Errorf("failed to forward message: %w", err)
+		}
+
+		logger.Info("Message forwarded", zap.String("username", cmd.Username), zap.String("roomID", cmd.RoomID), zap.String("podID", string(podID)))
+		return nil
+	}
+
+	member, err := h.RoomManager.GetLocalMember(cmd.RoomID, cmd.Username)
 	if err != nil {
-		h.Logger.Error("Failed to get room", zap.String("roomID", cmd.RoomID), zap.Error(err))
-		return fmt.Errorf("failed to get room: %w", err)
+		logger.Error("Failed to get member", zap.String("roomID", cmd.RoomID), zap.String("username", cmd.Username), zap.Error(err))
+		return fmt.Errorf("failed to get member: %w", err)
 	}
 
-	if err := toRoom.SendMessageTo(cmd.Username, cmd.Payload); err != nil {
-		h.Logger.Error("Failed to send message", zap.String("username", cmd.Username), zap.Error(err))
+	if err := member.Send(cmd.Payload); err != nil {
+		logger.Error("Failed to send message", zap.String("username", cmd.Username), zap.Error(err))
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 
-	h.Logger.Info("Message sent successfully", zap.String("username", cmd.Username), zap.String("roomID", cmd.RoomID))
+	logger.Info("Message sent successfully", zap.String("username", cmd.Username), zap.String("roomID", cmd.RoomID))
 	return nil
 }
@@ -0,0 +1,179 @@
+package command
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/aiocean/wireset/feature/realtime/relay"
+	"github.com/aiocean/wireset/feature/realtime/room"
+	"github.com/alicebob/miniredis/v2"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const roomID = "room"
+
+// pod serves its members over websocket and sends the commands with its own handler, like a pod of the app.
+type pod struct {
+	manager *room.Manager
+	handler *SendWsMessageHandler
+	addr    string
+}
+
+func newPod(t *testing.T, podID room.PodID, client *redis.Client, pubSub *gochannel.GoChannel, router *message.Router) *pod {
+	t.Helper()
+
+	presence, cleanup, err := room.NewRedisPresence(client, podID, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	manager, err := room.NewRoomManager(zap.NewNop(), podID, presence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.AddNewRoom(roomID); err != nil {
+		t.Fatal(err)
+	}
+
+	podRelay := relay.NewRelay(manager, pubSub, pubSub, zap.NewNop())
+	podRelay.AddHandler(router)
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws/:username", websocket.New(func(conn *websocket.Conn) {
+		currentRoom, _ := manager.GetRoom(roomID)
+		username := conn.Params("username")
+		if err := manager.JoinMember(context.Background(), currentRoom, username, conn); err != nil {
+			return
+		}
+		defer manager.LeaveMember(context.Background(), currentRoom, username)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	return &pod{
+		manager: manager,
+		handler: &SendWsMessageHandler{RoomManager: manager, Relay: podRelay, Logger: zap.NewNop()},
+		addr:    listener.Addr().String(),
+	}
+}
+
+// connect joins the member to the pod and waits until the presence knows it.
+func (p *pod) connect(t *testing.T, username string) *fastws.Conn {
+	t.Helper()
+
+	conn, _, err := fastws.DefaultDialer.Dial("ws://"+p.addr+"/ws/"+username, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// the member is added to the room before its owner is stored
+		if _, err := p.manager.Presence.GetOwner(context.Background(), roomID, username); err == nil {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s did not join", username)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func read(t *testing.T, conn *fastws.Conn) string {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestSendWsMessageHandlerRoutesTheMessageToThePodOfTheMember(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+	router, err := message.NewRouter(message.RouterConfig{}, watermill.NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podA := newPod(t, "pod-a", client, pubSub, router)
+	podB := newPod(t, "pod-b", client, pubSub, router)
+
+	go func() { _ = router.Run(ctx) }()
+	<-router.Running()
+	defer router.Close()
+
+	alice := podA.connect(t, "alice")
+	bob := podB.connect(t, "bob")
+
+	tests := []struct {
+		name     string
+		sender   *pod
+		username string
+		conn     *fastws.Conn
+	}{
+		{name: "to a member of another pod", sender: podA, username: "bob", conn: bob},
+		{name: "to a member of the same pod", sender: podA, username: "alice", conn: alice},
+		{name: "back to the first pod", sender: podB, username: "alice", conn: alice},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := `{"to":"` + test.username + `"}`
+			cmd := &SendWsMessageCmd{RoomID: roomID, Username: test.username, Payload: payload}
+			if err := test.sender.handler.Handle(ctx, cmd); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := read(t, test.conn); got != payload {
+				t.Fatalf("%s got %q, want %q", test.username, got, payload)
+			}
+		})
+	}
+
+	err = podA.handler.Handle(ctx, &SendWsMessageCmd{RoomID: roomID, Username: "carol", Payload: "{}"})
+	if !errors.Is(err, room.ErrMemberNotFound) {
+		t.Fatalf("error is %v, want %v for a member nobody holds", err, room.ErrMemberNotFound)
+	}
+
+	// dave was connected to a pod which crashed, the message is not forwarded to a topic nobody reads
+	if err := client.HSet(ctx, "realtime:presence:"+roomID, "dave", "pod-c").Err(); err != nil {
+		t.Fatal(err)
+	}
+	err = podA.handler.Handle(ctx, &SendWsMessageCmd{RoomID: roomID, Username: "dave", Payload: "{}"})
+	if !errors.Is(err, room.ErrMemberNotFound) {
+		t.Fatalf("error is %v, want %v for a member of a crashed pod", err, room.ErrMemberNotFound)
+	}
+}
@@ -1,13 +1,15 @@
-// Known issues:
-// - Service này có thể scale lên nhiều pod, nếu user connect ws vào pod A, nhưng nếu pod B nhận được command SendMessage, pod B sẽ thực hiện send message về cho user bằng ws, nhưng pod B không có kết nối. sẽ dẫn tới lỗi.
-
 package realtime
 
 import (
+	"context"
+
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/aiocean/wireset/feature/realtime/api"
 	"github.com/aiocean/wireset/feature/realtime/command"
 	"github.com/aiocean/wireset/feature/realtime/registry"
+	"github.com/aiocean/wireset/feature/realtime/relay"
+	"github.com/aiocean/wireset/feature/realtime/resolver"
 	"github.com/aiocean/wireset/feature/realtime/room"
 	"github.com/aiocean/wireset/fiberapp"
 	"github.com/gofiber/contrib/websocket"
@@ -16,10 +18,14 @@ import (
 	"github.com/pkg/errors"
 )
 
+// DefaultWireset requires a room.Presence, use room.MemoryPresenceWireset for a single pod
+// or room.RedisPresenceWireset when the service runs several replicas.
 var DefaultWireset = wire.NewSet(
 	wire.Struct(new(FeatureRealtime), "*"),
 	api.NewWebsocketHandler,
 	room.NewRoomManager,
+	room.NewPodIDFromEnv,
+	relay.NewRelay,
 	wire.Struct(new(command.SendWsMessageHandler), "*"),
 	registry.NewWsHandlerRegistry,
 )
@@ -31,7 +37,11 @@ type FeatureRealtime struct {
 	CommandProcessor *cqrs.CommandProcessor
 	EventProcessor   *cqrs.EventProcessor
 
-	EventBus *cqrs.EventBus
+	EventBus  *cqrs.EventBus
+	MsgRouter *message.Router
+	Relay     *relay.Relay
+
+	RoomManager *room.Manager
 
 	SendWsMessageHandler *command.SendWsMessageHandler
 }
@@ -46,6 +56,12 @@ func (f *FeatureRealtime) Init() error {
 		return errors.Wrap(err, "add command api")
 	}
 
+	// receive the messages which other pods forward to the members connected here
+	f.Relay.AddHandler(f.MsgRouter)
+
+	// the identity resolver reads the identity of the verified request
+	policy := resolver.AuthPolicyOf(f.WebsocketHandler.IdentityResolver)
+	f.HttpRegistry.AddAuthPolicy("/api/v1/ws", policy)
 	f.HttpRegistry.AddHttpMiddleware("/api/v1/ws", f.WebsocketHandler.Upgrade)
 	f.HttpRegistry.AddHttpHandlers(
 		&fiberapp.HttpHandler{
@@ -54,7 +70,17 @@ func (f *FeatureRealtime) Init() error {
 			Handlers: []fiber.Handler{
 				websocket.New(f.WebsocketHandler.Handle),
 			},
+			Summary:        "Connect to the realtime websocket",
+			Description:    "Upgrades the connection to a websocket, the messages are {topic, payload} json objects.",
+			Tags:           []string{"realtime"},
+			ResponseStatus: fiber.StatusSwitchingProtocols,
+			Auth:           policy,
 		},
 	)
 	return nil
 }
+
+// Stop closes the websocket connections, the clients reconnect to another pod.
+func (f *FeatureRealtime) Stop(ctx context.Context) error {
+	return f.RoomManager.CloseAll(ctx)
+}
@@ -1,5 +1,7 @@
 package models
 
+import "github.com/aiocean/wireset/apperror"
+
 type WebsocketTopic string
 
 // Stringer interface
@@ -14,6 +16,23 @@ type WebsocketMessage struct {
 
 const TopicError WebsocketTopic = "error"
 
+// ErrorPayload uses the same codes as the problem details of the http api.
 type ErrorPayload struct {
-	Message string `json:"message"`
+	Code    apperror.Code         `json:"code"`
+	Message string                `json:"message"`
+	Errors  []apperror.FieldError `json:"errors,omitempty"`
+}
+
+// NewErrorMessage returns the error message of err, unknown errors are sent without details.
+func NewErrorMessage(err error) WebsocketMessage {
+	appErr := apperror.From(err)
+
+	return WebsocketMessage{
+		Topic: TopicError,
+		Payload: ErrorPayload{
+			Code:    appErr.Code,
+			Message: appErr.Message,
+			Errors:  appErr.Fields,
+		},
+	}
 }
@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"context"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/hashicorp/go-multierror"
 	"github.com/tidwall/gjson"
@@ -8,8 +9,9 @@ import (
 )
 
 type HandlerRegistry struct {
-	handlers map[string][]HandlerFunc
-	mu       sync.RWMutex
+	handlers        map[string][]HandlerFunc
+	connectHandlers []ConnectHandlerFunc
+	mu              sync.RWMutex
 }
 
 func NewWsHandlerRegistry() *HandlerRegistry {
@@ -20,6 +22,10 @@ func NewWsHandlerRegistry() *HandlerRegistry {
 
 type HandlerFunc func(conn *websocket.Conn, payload *gjson.Result) error
 
+// ConnectHandlerFunc runs while a connection is open, ctx is done once it is closed.
+// send writes to the connection, it can be called concurrently with the other writers.
+type ConnectHandlerFunc func(ctx context.Context, conn *websocket.Conn, send func(message any) error)
+
 type WebsocketHandler struct {
 	Topic   string
 	Handler HandlerFunc
@@ -34,6 +40,25 @@ func (r *HandlerRegistry) AddWebsocketHandler(handlers ...*WebsocketHandler) {
 	}
 }
 
+// AddConnectHandler adds handlers which run in their own goroutine for each new connection.
+func (r *HandlerRegistry) AddConnectHandler(handlers ...ConnectHandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.connectHandlers = append(r.connectHandlers, handlers...)
+}
+
+// Connected starts the connect handlers of the connection.
+func (r *HandlerRegistry) Connected(ctx context.Context, conn *websocket.Conn, send func(message any) error) {
+	r.mu.RLock()
+	handlers := r.connectHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		go handler(ctx, conn, send)
+	}
+}
+
 func (r *HandlerRegistry) Handle(topic string, conn *websocket.Conn, payload *gjson.Result) error {
 	r.mu.RLock()
 	handlers, ok := r.handlers[topic]
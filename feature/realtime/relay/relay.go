@@ -0,0 +1,118 @@
+// Package relay delivers websocket messages to members whose connection is held by another pod.
+// Every pod subscribes to its own topic, the sender looks up the owner pod through room.Presence
+// and publishes the message to the topic of that pod.
+package relay
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/feature/realtime/room"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const topicPrefix = "realtime.deliver."
+
+// Envelope is the message sent between pods.
+type Envelope struct {
+	RoomID   string `json:"room_id"`
+	Username string `json:"username"`
+	// Payload is written to the member as it is, see room.EncodeMessage.
+	Payload []byte `json:"payload"`
+}
+
+type Relay struct {
+	RoomManager *room.Manager
+	Publisher   message.Publisher
+	Subscriber  message.Subscriber
+	Logger      *zap.Logger
+}
+
+func NewRelay(
+	roomManager *room.Manager,
+	publisher message.Publisher,
+	subscriber message.Subscriber,
+	logger *zap.Logger,
+) *Relay {
+	return &Relay{
+		RoomManager: roomManager,
+		Publisher:   publisher,
+		Subscriber:  subscriber,
+		Logger:      logger.Named("relay"),
+	}
+}
+
+// TopicForPod returns the topic which the given pod consumes.
+func TopicForPod(podID room.PodID) string {
+	return topicPrefix + string(podID)
+}
+
+// HandlerName returns the router handler name of the given pod.
+func HandlerName(podID room.PodID) string {
+	return "realtime.RelayHandler." + string(podID)
+}
+
+// AddHandler subscribes the current pod to its delivery topic.
+func (r *Relay) AddHandler(router *message.Router) {
+	podID := r.RoomManager.PodID
+	router.AddNoPublisherHandler(
+		HandlerName(podID),
+		TopicForPod(podID),
+		r.Subscriber,
+		r.handle,
+	)
+}
+
+// Forward publishes the payload to the pod which owns the member, the member receives the bytes a local Send writes.
+func (r *Relay) Forward(ctx context.Context, podID room.PodID, roomID, username string, payload any) error {
+	rawPayload, err := room.EncodeMessage(payload)
+	if err != nil {
+		return errors.WithMessage(err, "encode payload")
+	}
+
+	rawEnvelope, err := json.Marshal(&Envelope{
+		RoomID:   roomID,
+		Username: username,
+		Payload:  rawPayload,
+	})
+	if err != nil {
+		return errors.WithMessage(err, "marshal envelope")
+	}
+
+	msg := message.NewMessage(watermill.NewUUID(), rawEnvelope)
+	msg.SetContext(ctx)
+	msg.Metadata.Set("from_pod", string(r.RoomManager.PodID))
+
+	if err := r.Publisher.Publish(TopicForPod(podID), msg); err != nil {
+		return errors.WithMessage(err, "publish to pod "+string(podID))
+	}
+
+	return nil
+}
+
+func (r *Relay) handle(msg *message.Message) error {
+	var envelope Envelope
+	if err := json.Unmarshal(msg.Payload, &envelope); err != nil {
+		r.Logger.Error("invalid envelope, dropped", zap.Error(err))
+		return nil
+	}
+
+	logger := r.Logger.With(zap.String("roomID", envelope.RoomID), zap.String("username", envelope.Username))
+
+	member, err := r.RoomManager.GetLocalMember(envelope.RoomID, envelope.Username)
+	if err != nil {
+		// the member has left between the lookup and the delivery, nobody is waiting for this message
+		logger.Info("member is not connected to this pod, dropped", zap.Error(err))
+		return nil
+	}
+
+	if err := member.Send(envelope.Payload); err != nil {
+		return errors.WithMessage(err, "send message")
+	}
+
+	logger.Info("Relayed message sent", zap.String("fromPod", msg.Metadata.Get("from_pod")))
+	return nil
+}
@@ -0,0 +1,164 @@
+package relay
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/aiocean/wireset/feature/realtime/room"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const roomID = "room"
+
+// pod is a room manager which serves its members over websocket, like a pod of the app.
+type pod struct {
+	manager *room.Manager
+	relay   *Relay
+	addr    string
+}
+
+func newPod(t *testing.T, podID room.PodID, presence room.Presence, pubSub *gochannel.GoChannel, router *message.Router) *pod {
+	t.Helper()
+
+	manager, err := room.NewRoomManager(zap.NewNop(), podID, presence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.AddNewRoom(roomID); err != nil {
+		t.Fatal(err)
+	}
+
+	relay := NewRelay(manager, pubSub, pubSub, zap.NewNop())
+	relay.AddHandler(router)
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws/:username", websocket.New(func(conn *websocket.Conn) {
+		currentRoom, _ := manager.GetRoom(roomID)
+		username := conn.Params("username")
+		if err := manager.JoinMember(context.Background(), currentRoom, username, conn); err != nil {
+			return
+		}
+		defer manager.LeaveMember(context.Background(), currentRoom, username)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	return &pod{manager: manager, relay: relay, addr: listener.Addr().String()}
+}
+
+// connect joins the member to the pod and waits until the presence knows it.
+func (p *pod) connect(t *testing.T, username string) *fastws.Conn {
+	t.Helper()
+
+	conn, _, err := fastws.DefaultDialer.Dial("ws://"+p.addr+"/ws/"+username, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// the member is added to the room before its owner is stored
+		if _, err := p.manager.Presence.GetOwner(context.Background(), roomID, username); err == nil {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s did not join", username)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func read(t *testing.T, conn *fastws.Conn) []byte {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType != fastws.TextMessage {
+		t.Fatalf("message type is %d, want a text message", messageType)
+	}
+
+	return data
+}
+
+func TestRelayWritesTheBytesOfALocalSend(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := watermill.NopLogger{}
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, logger)
+	router, err := message.NewRouter(message.RouterConfig{}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	presence := room.NewMemoryPresence()
+	podA := newPod(t, "pod-a", presence, pubSub, router)
+	podB := newPod(t, "pod-b", presence, pubSub, router)
+
+	go func() { _ = router.Run(ctx) }()
+	<-router.Running()
+	defer router.Close()
+
+	remote := podB.connect(t, "remote")
+	local := podB.connect(t, "local")
+
+	owner, err := podA.manager.LocateMember(ctx, roomID, "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != "pod-b" {
+		t.Fatalf("owner is %s, want pod-b", owner)
+	}
+
+	localMember, err := podB.manager.GetLocalMember(roomID, "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := map[string]any{
+		"string": `{"type":"greeting"}`,
+		"bytes":  []byte("plain text"),
+		"struct": struct {
+			Type string `json:"type"`
+		}{Type: "greeting"},
+	}
+
+	for name, payload := range payloads {
+		t.Run(name, func(t *testing.T) {
+			if err := podA.relay.Forward(ctx, owner, roomID, "remote", payload); err != nil {
+				t.Fatal(err)
+			}
+			if err := localMember.Send(payload); err != nil {
+				t.Fatal(err)
+			}
+
+			relayed, sent := read(t, remote), read(t, local)
+			if string(relayed) != string(sent) {
+				t.Fatalf("relayed %q, a local send writes %q", relayed, sent)
+			}
+		})
+	}
+}
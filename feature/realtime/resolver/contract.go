@@ -1,6 +1,9 @@
 package resolver
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/gofiber/fiber/v2"
+)
 
 type Identity struct {
 	Username string `json:"username"`
@@ -10,3 +13,18 @@ type Identity struct {
 type IdentityResolver interface {
 	Resolve(ctx *fiber.Ctx) (*Identity, error)
 }
+
+// AuthPolicyProvider is implemented by the resolvers which read an authenticated identity,
+// the websocket endpoint requires their policy.
+type AuthPolicyProvider interface {
+	AuthPolicy() fiberapp.AuthPolicy
+}
+
+// AuthPolicyOf returns the policy of the resolver, the endpoint is public for the other resolvers.
+func AuthPolicyOf(resolver IdentityResolver) fiberapp.AuthPolicy {
+	if provider, ok := resolver.(AuthPolicyProvider); ok {
+		return provider.AuthPolicy()
+	}
+
+	return fiberapp.AuthPublic
+}
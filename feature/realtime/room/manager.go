@@ -1,24 +1,35 @@
 package room
 
 import (
+	"context"
+	"time"
+
+	"github.com/aiocean/wireset/prometheussvc"
+	"github.com/gofiber/contrib/websocket"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"sync"
 )
 
 type Manager struct {
-	Logger *zap.Logger
-	Mu     sync.Mutex
-	Rooms  map[string]*Room
+	Logger   *zap.Logger
+	Mu       sync.Mutex
+	Rooms    map[string]*Room
+	PodID    PodID
+	Presence Presence
 }
 
 func NewRoomManager(
 	logger *zap.Logger,
+	podID PodID,
+	presence Presence,
 ) (*Manager, error) {
 	return &Manager{
-		Logger: logger,
-		Mu:     sync.Mutex{},
-		Rooms:  make(map[string]*Room),
+		Logger:   logger,
+		Mu:       sync.Mutex{},
+		Rooms:    make(map[string]*Room),
+		PodID:    podID,
+		Presence: presence,
 	}, nil
 }
 
@@ -34,6 +45,9 @@ func (h *Manager) IsRoomExists(roomName string) bool {
 func (h *Manager) AddNewRoom(roomName string) (*Room, error) {
 	h.Mu.Lock()
 	defer h.Mu.Unlock()
+	if _, ok := h.Rooms[roomName]; !ok {
+		prometheussvc.WebsocketRooms.Inc()
+	}
 	h.Rooms[roomName] = NewRoom(roomName)
 
 	return h.Rooms[roomName], nil
@@ -58,6 +72,110 @@ func (h *Manager) DeleteRoom(roomName string) error {
 	h.Mu.Lock()
 	defer h.Mu.Unlock()
 
+	if _, ok := h.Rooms[roomName]; ok {
+		prometheussvc.WebsocketRooms.Dec()
+	}
 	delete(h.Rooms, roomName)
 	return nil
 }
+
+// JoinMember adds the member to the room and claims its ownership for this pod.
+func (h *Manager) JoinMember(ctx context.Context, currentRoom *Room, username string, conn *websocket.Conn) error {
+	if err := currentRoom.AddMember(username, conn); err != nil {
+		return err
+	}
+
+	if err := h.Presence.SetOwner(ctx, currentRoom.ID, username, h.PodID); err != nil {
+		_ = currentRoom.DeleteMember(username)
+		return errors.WithMessage(err, "set member owner")
+	}
+
+	prometheussvc.WebsocketConnections.Inc()
+	return nil
+}
+
+// LeaveMember removes the member from the room and releases its ownership.
+func (h *Manager) LeaveMember(ctx context.Context, currentRoom *Room, username string) error {
+	if err := currentRoom.DeleteMember(username); err != nil {
+		return err
+	}
+	prometheussvc.WebsocketConnections.Dec()
+
+	if err := h.Presence.RemoveOwner(ctx, currentRoom.ID, username, h.PodID); err != nil {
+		return errors.WithMessage(err, "remove member owner")
+	}
+
+	return nil
+}
+
+// GetLocalMember returns the member if its connection is held by this pod.
+func (h *Manager) GetLocalMember(roomID, username string) (*Member, error) {
+	currentRoom, err := h.GetRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	return currentRoom.GetMember(username)
+}
+
+// LocateMember returns the pod which owns the member connection.
+func (h *Manager) LocateMember(ctx context.Context, roomID, username string) (PodID, error) {
+	if _, err := h.GetLocalMember(roomID, username); err == nil {
+		return h.PodID, nil
+	}
+
+	return h.Presence.GetOwner(ctx, roomID, username)
+}
+
+// closeGracePeriod is how long the clients have to answer the close frame.
+const closeGracePeriod = 5 * time.Second
+
+// CloseAll sends a close frame to every local member and waits until they left.
+// The connections still open after the grace period, or when ctx is done, are closed.
+func (h *Manager) CloseAll(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, closeGracePeriod)
+	defer cancel()
+
+	members := h.localMembers()
+	for _, member := range members {
+		if err := member.CloseGoingAway(); err != nil {
+			h.Logger.Debug("failed to send close frame", zap.String("member", member.Name), zap.Error(err))
+		}
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for len(h.localMembers()) > 0 {
+		select {
+		case <-ctx.Done():
+			for _, member := range h.localMembers() {
+				_ = member.Close()
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+func (h *Manager) localMembers() []*Member {
+	h.Mu.Lock()
+	rooms := make([]*Room, 0, len(h.Rooms))
+	for _, currentRoom := range h.Rooms {
+		rooms = append(rooms, currentRoom)
+	}
+	h.Mu.Unlock()
+
+	members := make([]*Member, 0)
+	for _, currentRoom := range rooms {
+		currentRoom.membersLock.Lock()
+		for _, member := range currentRoom.Members {
+			members = append(members, member)
+		}
+		currentRoom.membersLock.Unlock()
+	}
+
+	return members
+}
@@ -1,10 +1,18 @@
 package room
 
-import "github.com/gofiber/contrib/websocket"
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
 
 type Member struct {
 	Name       string
 	connection *websocket.Conn
+	// writeMu serializes the writes, the connection supports one writer at a time
+	writeMu sync.Mutex
 }
 
 func NewMember(name string, conn *websocket.Conn) *Member {
@@ -14,27 +22,47 @@ func NewMember(name string, conn *websocket.Conn) *Member {
 	}
 }
 
-func (m *Member) Send(message interface{}) error {
-	if messageBytes, ok := message.([]byte); ok {
-		return m.connection.WriteMessage(websocket.TextMessage, messageBytes)
+// EncodeMessage returns the bytes which Send writes: []byte and string as they are, anything else as json.
+func EncodeMessage(message interface{}) ([]byte, error) {
+	switch message := message.(type) {
+	case []byte:
+		return message, nil
+	case string:
+		return []byte(message), nil
+	default:
+		return json.Marshal(message)
 	}
+}
 
-	// check if it's string
-	if messageString, ok := message.(string); ok {
-		return m.connection.WriteMessage(websocket.TextMessage, []byte(messageString))
+func (m *Member) Send(message interface{}) error {
+	messageBytes, err := EncodeMessage(message)
+	if err != nil {
+		return err
 	}
 
-	return m.connection.WriteJSON(message)
+	return m.WriteMessage(websocket.TextMessage, messageBytes)
 }
 
 func (m *Member) Close() error {
 	return m.connection.Close()
 }
 
+// CloseGoingAway tells the client the server is going away, the client reads the close frame and disconnects.
+func (m *Member) CloseGoingAway() error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	return m.connection.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+}
+
 func (m *Member) ReadMessage() (int, []byte, error) {
 	return m.connection.ReadMessage()
 }
 
 func (m *Member) WriteMessage(messageType int, data []byte) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
 	return m.connection.WriteMessage(messageType, data)
 }
@@ -0,0 +1,90 @@
+package room
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/google/wire"
+)
+
+// PodID identifies the pod that is running this process.
+// Members are owned by the pod which holds their websocket connection.
+type PodID string
+
+// NewPodIDFromEnv resolves the pod id from POD_NAME, then the hostname.
+// A random id is used when neither is available.
+func NewPodIDFromEnv() PodID {
+	if value, ok := os.LookupEnv("POD_NAME"); ok && value != "" {
+		return PodID(value)
+	}
+
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return PodID(hostname)
+	}
+
+	return PodID(watermill.NewShortUUID())
+}
+
+// Presence keeps track of which pod owns the connection of a member.
+type Presence interface {
+	// SetOwner records that the member is connected to the given pod.
+	SetOwner(ctx context.Context, roomID, username string, podID PodID) error
+	// RemoveOwner removes the record, only if it still belongs to the given pod.
+	RemoveOwner(ctx context.Context, roomID, username string, podID PodID) error
+	// GetOwner returns the pod which owns the member, or ErrMemberNotFound.
+	GetOwner(ctx context.Context, roomID, username string) (PodID, error)
+}
+
+var MemoryPresenceWireset = wire.NewSet(
+	NewMemoryPresence,
+	wire.Bind(new(Presence), new(*MemoryPresence)),
+)
+
+// MemoryPresence is an in-process Presence, it is only correct when all pods share the same process.
+type MemoryPresence struct {
+	mu     sync.RWMutex
+	owners map[string]PodID
+}
+
+func NewMemoryPresence() *MemoryPresence {
+	return &MemoryPresence{
+		owners: make(map[string]PodID),
+	}
+}
+
+func presenceKey(roomID, username string) string {
+	return roomID + "/" + username
+}
+
+func (p *MemoryPresence) SetOwner(ctx context.Context, roomID, username string, podID PodID) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.owners[presenceKey(roomID, username)] = podID
+	return nil
+}
+
+func (p *MemoryPresence) RemoveOwner(ctx context.Context, roomID, username string, podID PodID) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := presenceKey(roomID, username)
+	if p.owners[key] == podID {
+		delete(p.owners, key)
+	}
+
+	return nil
+}
+
+func (p *MemoryPresence) GetOwner(ctx context.Context, roomID, username string) (PodID, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if podID, ok := p.owners[presenceKey(roomID, username)]; ok {
+		return podID, nil
+	}
+
+	return "", ErrMemberNotFound
+}
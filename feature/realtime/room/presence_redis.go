@@ -0,0 +1,155 @@
+package room
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+var RedisPresenceWireset = wire.NewSet(
+	NewRedisPresence,
+	wire.Bind(new(Presence), new(*RedisPresence)),
+)
+
+const (
+	// presenceTTL bounds how long the hash of a room survives when nobody joins it anymore.
+	presenceTTL = 24 * time.Hour
+	// podTTL is how long the members of a pod which stopped its heartbeat are still located on it.
+	podTTL = 30 * time.Second
+	// podHeartbeatInterval refreshes the heartbeat of the pod well before podTTL.
+	podHeartbeatInterval = 10 * time.Second
+)
+
+// removeOwnerScript deletes the field only when it is still owned by the calling pod,
+// so a late disconnect on pod A never removes the new connection on pod B.
+var removeOwnerScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// RedisPresence stores member ownership in a redis hash per room.
+// Every pod keeps a heartbeat key alive, the members of a pod whose heartbeat expired,
+// because it crashed, are not located on it anymore.
+type RedisPresence struct {
+	client *redis.Client
+	podID  PodID
+	logger *zap.Logger
+	stop   chan struct{}
+}
+
+// NewRedisPresence starts the heartbeat of the pod, the cleanup stops it and removes the heartbeat.
+func NewRedisPresence(client *redis.Client, podID PodID, logger *zap.Logger) (*RedisPresence, func(), error) {
+	presence := &RedisPresence{
+		client: client,
+		podID:  podID,
+		logger: logger.Named("presence"),
+		stop:   make(chan struct{}),
+	}
+
+	if err := presence.heartbeat(context.Background()); err != nil {
+		return nil, nil, err
+	}
+
+	go presence.run()
+
+	cleanup := func() {
+		close(presence.stop)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Del(ctx, redisPodKey(podID)).Err(); err != nil {
+			presence.logger.Error("failed to remove the pod heartbeat", zap.Error(err))
+		}
+	}
+
+	return presence, cleanup, nil
+}
+
+func redisPresenceKey(roomID string) string {
+	return "realtime:presence:" + roomID
+}
+
+func redisPodKey(podID PodID) string {
+	return "realtime:pod:" + string(podID)
+}
+
+func (p *RedisPresence) run() {
+	ticker := time.NewTicker(podHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), podHeartbeatInterval)
+			if err := p.heartbeat(ctx); err != nil {
+				p.logger.Error("failed to refresh the pod heartbeat", zap.Error(err))
+			}
+			cancel()
+		}
+	}
+}
+
+// heartbeat marks the pod as alive for podTTL.
+func (p *RedisPresence) heartbeat(ctx context.Context) error {
+	if err := p.client.Set(ctx, redisPodKey(p.podID), time.Now().UTC().Format(time.RFC3339), podTTL).Err(); err != nil {
+		return errors.WithMessage(err, "refresh pod heartbeat")
+	}
+
+	return nil
+}
+
+func (p *RedisPresence) SetOwner(ctx context.Context, roomID, username string, podID PodID) error {
+	key := redisPresenceKey(roomID)
+
+	pipe := p.client.TxPipeline()
+	pipe.HSet(ctx, key, username, string(podID))
+	pipe.Expire(ctx, key, presenceTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.WithMessage(err, "set presence owner")
+	}
+
+	return nil
+}
+
+func (p *RedisPresence) RemoveOwner(ctx context.Context, roomID, username string, podID PodID) error {
+	if err := removeOwnerScript.Run(ctx, p.client, []string{redisPresenceKey(roomID)}, username, string(podID)).Err(); err != nil {
+		return errors.WithMessage(err, "remove presence owner")
+	}
+
+	return nil
+}
+
+// GetOwner removes the member when its pod is not alive anymore, unless the member joined another pod meanwhile.
+func (p *RedisPresence) GetOwner(ctx context.Context, roomID, username string) (PodID, error) {
+	podID, err := p.client.HGet(ctx, redisPresenceKey(roomID), username).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", ErrMemberNotFound
+		}
+
+		return "", errors.WithMessage(err, "get presence owner")
+	}
+
+	alive, err := p.client.Exists(ctx, redisPodKey(PodID(podID))).Result()
+	if err != nil {
+		return "", errors.WithMessage(err, "get pod heartbeat")
+	}
+
+	if alive == 0 {
+		if err := p.RemoveOwner(ctx, roomID, username, PodID(podID)); err != nil {
+			return "", err
+		}
+
+		return "", ErrMemberNotFound
+	}
+
+	return PodID(podID), nil
+}
@@ -0,0 +1,174 @@
+package room
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func newTestRedisPresence(t *testing.T, client *redis.Client, podID PodID) (*RedisPresence, func()) {
+	t.Helper()
+
+	presence, cleanup, err := NewRedisPresence(client, podID, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var once sync.Once
+	stop := func() { once.Do(cleanup) }
+	t.Cleanup(stop)
+
+	return presence, stop
+}
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return server, client
+}
+
+func TestRedisPresenceLocatesTheMembersOfEveryPod(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	podA, _ := newTestRedisPresence(t, client, "pod-a")
+	podB, _ := newTestRedisPresence(t, client, "pod-b")
+
+	if err := podB.SetOwner(ctx, "room", "alice", "pod-b"); err != nil {
+		t.Fatal(err)
+	}
+
+	owner, err := podA.GetOwner(ctx, "room", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != "pod-b" {
+		t.Fatalf("owner is %s, want pod-b", owner)
+	}
+
+	if _, err := podA.GetOwner(ctx, "room", "bob"); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("error is %v, want %v", err, ErrMemberNotFound)
+	}
+	if _, err := podA.GetOwner(ctx, "other-room", "alice"); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("error is %v, want %v", err, ErrMemberNotFound)
+	}
+}
+
+func TestRedisPresenceRemovesOnlyTheOwnEntry(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	podA, _ := newTestRedisPresence(t, client, "pod-a")
+	podB, _ := newTestRedisPresence(t, client, "pod-b")
+
+	// alice moved from pod A to pod B, then the late disconnect of pod A arrives
+	if err := podA.SetOwner(ctx, "room", "alice", "pod-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := podB.SetOwner(ctx, "room", "alice", "pod-b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := podA.RemoveOwner(ctx, "room", "alice", "pod-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if owner, err := podA.GetOwner(ctx, "room", "alice"); err != nil || owner != "pod-b" {
+		t.Fatalf("owner is %s, %v, want pod-b", owner, err)
+	}
+
+	if err := podB.RemoveOwner(ctx, "room", "alice", "pod-b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := podA.GetOwner(ctx, "room", "alice"); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("error is %v, want %v", err, ErrMemberNotFound)
+	}
+
+	// removing a member which is not there is not an error
+	if err := podB.RemoveOwner(ctx, "room", "alice", "pod-b"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisPresenceForgetsTheMembersOfACrashedPod(t *testing.T) {
+	ctx := context.Background()
+	server, client := newTestRedis(t)
+	podA, _ := newTestRedisPresence(t, client, "pod-a")
+
+	// pod B crashed: its heartbeat is not refreshed anymore
+	podB := &RedisPresence{client: client, podID: "pod-b", logger: zap.NewNop()}
+	if err := podB.heartbeat(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"alice", "bob"} {
+		if err := podB.SetOwner(ctx, "room", username, "pod-b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if owner, err := podA.GetOwner(ctx, "room", "alice"); err != nil || owner != "pod-b" {
+		t.Fatalf("owner is %s, %v, want pod-b while it is alive", owner, err)
+	}
+
+	server.FastForward(podTTL)
+	// pod A is alive, its heartbeat would have been refreshed
+	if err := podA.heartbeat(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := podA.SetOwner(ctx, "room", "carol", "pod-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := podA.GetOwner(ctx, "room", "alice"); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("error is %v, want the member of the crashed pod to be gone", err)
+	}
+	if server.HGet(redisPresenceKey("room"), "alice") != "" {
+		t.Fatal("the entry of the crashed pod is kept")
+	}
+
+	// bob reconnected to pod A, the stale entry of pod B does not hide him
+	if err := podA.SetOwner(ctx, "room", "bob", "pod-a"); err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := podA.GetOwner(ctx, "room", "bob"); err != nil || owner != "pod-a" {
+		t.Fatalf("owner is %s, %v, want pod-a", owner, err)
+	}
+	if owner, err := podA.GetOwner(ctx, "room", "carol"); err != nil || owner != "pod-a" {
+		t.Fatalf("owner is %s, %v, want pod-a", owner, err)
+	}
+}
+
+func TestRedisPresenceForgetsTheMembersOfAStoppedPod(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	podA, _ := newTestRedisPresence(t, client, "pod-a")
+	podB, stopB := newTestRedisPresence(t, client, "pod-b")
+
+	if err := podB.SetOwner(ctx, "room", "alice", "pod-b"); err != nil {
+		t.Fatal(err)
+	}
+	stopB()
+
+	if _, err := podA.GetOwner(ctx, "room", "alice"); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("error is %v, want the member of the stopped pod to be gone", err)
+	}
+}
+
+func TestRedisPresenceExpiresAnAbandonedRoom(t *testing.T) {
+	ctx := context.Background()
+	server, client := newTestRedis(t)
+	podA, _ := newTestRedisPresence(t, client, "pod-a")
+
+	if err := podA.SetOwner(ctx, "room", "alice", "pod-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl := server.TTL(redisPresenceKey("room")); ttl != presenceTTL {
+		t.Fatalf("the room expires in %s, want %s", ttl, presenceTTL)
+	}
+}
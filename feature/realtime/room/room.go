@@ -59,12 +59,26 @@ func (r *Room) IsEmpty() bool {
 
 var ErrMemberNotFound = errors.New("member not found")
 
-func (r *Room) SendMessageTo(username string, message interface{}) error {
+// GetMember returns a member by its username.
+// It acquires a lock on the room's mutex to ensure thread safety.
+func (r *Room) GetMember(username string) (*Member, error) {
+	r.membersLock.Lock()
+	defer r.membersLock.Unlock()
+
 	if member := r.Members[username]; member != nil {
-		return member.Send(message)
+		return member, nil
+	}
+
+	return nil, ErrMemberNotFound
+}
+
+func (r *Room) SendMessageTo(username string, message interface{}) error {
+	member, err := r.GetMember(username)
+	if err != nil {
+		return err
 	}
 
-	return ErrMemberNotFound
+	return member.Send(message)
 }
 
 // SendSystemMessage sends a system message to a member.
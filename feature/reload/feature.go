@@ -0,0 +1,52 @@
+package reload
+
+import (
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/geminisvc"
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/google/wire"
+)
+
+// DefaultWireset requires a watcher, configsvc.FileWatcherWireset or redissvc.ConfigSourceWireset.
+var DefaultWireset = wire.NewSet(
+	wire.Struct(new(FeatureReload), "*"),
+)
+
+// GeminiWireset reloads the models of the gemini service, next to DefaultWireset.
+var GeminiWireset = wire.NewSet(
+	wire.Struct(new(FeatureReloadGemini), "*"),
+)
+
+// FeatureReload applies the runtime config to the log level and the global rate limit.
+type FeatureReload struct {
+	Watcher   *configsvc.Watcher
+	LogConfig *logsvc.Config
+	Limiter   *fiberapp.Limiter
+}
+
+func (f *FeatureReload) Name() string {
+	return "reload"
+}
+
+func (f *FeatureReload) Init() error {
+	if err := logsvc.WatchLevel(f.Watcher, f.LogConfig); err != nil {
+		return err
+	}
+
+	return f.Limiter.Watch(f.Watcher)
+}
+
+// FeatureReloadGemini applies the runtime config to the models of the gemini service.
+type FeatureReloadGemini struct {
+	Watcher   *configsvc.Watcher
+	GeminiSvc *geminisvc.GeminiSvc
+}
+
+func (f *FeatureReloadGemini) Name() string {
+	return "reload-gemini"
+}
+
+func (f *FeatureReloadGemini) Init() error {
+	return f.GeminiSvc.Watch(f.Watcher)
+}
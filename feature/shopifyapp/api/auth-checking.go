@@ -1,6 +1,7 @@
 package api
 
 import (
+	"github.com/aiocean/wireset/apperror"
 	"github.com/aiocean/wireset/shopifysvc"
 	goshopify "github.com/bold-commerce/go-shopify/v3"
 	"github.com/golang-jwt/jwt/v5"
@@ -82,9 +83,7 @@ func (s *AuthHandler) handleAuth(ctx *fiber.Ctx, authentication string) error {
 	sessionToken, err := jwt.ParseWithClaims(authentication, &sessionClaim, s.sessionTokenKeyFunc)
 	if err != nil {
 		s.LogSvc.Error("error parsing jwt sessionToken", zap.Error(err))
-		return ctx.Status(http.StatusUnauthorized).JSON(model.AuthResponse{
-			Message: "Unauthorized",
-		})
+		return apperror.Wrap(err, apperror.CodeUnauthorized, http.StatusUnauthorized, "the session token is invalid")
 	}
 
 	if !sessionToken.Valid {
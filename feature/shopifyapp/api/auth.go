@@ -11,12 +11,12 @@ import (
 )
 
 type AuthHandler struct {
-	ShopRepo       *repository.ShopRepository
+	ShopRepo       repository.ShopRepository
 	ShopifyService *shopifysvc.ShopifyService
 	ConfigSvc      *configsvc.ConfigService
 	ShopifyConfig  *shopifysvc.Config
 	ShopifyApp     *goshopify.App
-	TokenRepo      *repository.TokenRepository
+	TokenRepo      repository.TokenRepository
 	EventBus       *cqrs.EventBus
 	CommandBus     *cqrs.CommandBus
 	LogSvc         *zap.Logger
@@ -1,19 +1,89 @@
 package api
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"encoding/json"
+	"strconv"
 
+	"github.com/aiocean/wireset/feature/shopifyapp/webhook"
+	"github.com/aiocean/wireset/model"
+	"github.com/pkg/errors"
+)
+
+// GdprHandler parses the GDPR mandatory webhooks into events.
+// The webhooks are verified by the webhook registry before reaching the handler.
 type GdprHandler struct {
 }
 
-func (g *GdprHandler) CustomerDataRequest(ctx *fiber.Ctx) error {
+type gdprPayload struct {
+	ShopID          int64              `json:"shop_id"`
+	ShopDomain      string             `json:"shop_domain"`
+	Customer        model.GdprCustomer `json:"customer"`
+	OrdersRequested []int64            `json:"orders_requested"`
+	OrdersToRedact  []int64            `json:"orders_to_redact"`
+	DataRequest     struct {
+		ID int64 `json:"id"`
+	} `json:"data_request"`
+}
+
+func parseGdprPayload(req *webhook.Request) (*gdprPayload, error) {
+	var payload gdprPayload
+	if err := json.Unmarshal(req.Body, &payload); err != nil {
+		return nil, errors.WithMessage(err, "unmarshal gdpr payload")
+	}
+
+	if payload.ShopID == 0 {
+		return nil, errors.New("shop_id is required")
+	}
+
+	if payload.ShopDomain == "" {
+		payload.ShopDomain = req.ShopDomain
+	}
+
+	return &payload, nil
+}
+
+// shopGid converts the numeric shop id of the webhook into the GraphQL id used as shop id everywhere else.
+func shopGid(shopID int64) string {
+	return "gid://shopify/Shop/" + strconv.FormatInt(shopID, 10)
+}
+
+func (g *GdprHandler) CustomerDataRequest(req *webhook.Request) (any, error) {
+	payload, err := parseGdprPayload(req)
+	if err != nil {
+		return nil, err
+	}
 
-	return ctx.SendStatus(200)
+	return &model.CustomerDataRequestedEvt{
+		ShopID:          shopGid(payload.ShopID),
+		MyshopifyDomain: payload.ShopDomain,
+		Customer:        payload.Customer,
+		OrdersRequested: payload.OrdersRequested,
+		DataRequestID:   payload.DataRequest.ID,
+	}, nil
 }
 
-func (g *GdprHandler) CustomerRedact(ctx *fiber.Ctx) error {
-	return ctx.SendStatus(200)
+func (g *GdprHandler) CustomerRedact(req *webhook.Request) (any, error) {
+	payload, err := parseGdprPayload(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.CustomerRedactRequestedEvt{
+		ShopID:          shopGid(payload.ShopID),
+		MyshopifyDomain: payload.ShopDomain,
+		Customer:        payload.Customer,
+		OrdersToRedact:  payload.OrdersToRedact,
+	}, nil
 }
 
-func (g *GdprHandler) ShopRedact(ctx *fiber.Ctx) error {
-	return ctx.SendStatus(200)
+func (g *GdprHandler) ShopRedact(req *webhook.Request) (any, error) {
+	payload, err := parseGdprPayload(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.ShopRedactRequestedEvt{
+		ShopID:          shopGid(payload.ShopID),
+		MyshopifyDomain: payload.ShopDomain,
+	}, nil
 }
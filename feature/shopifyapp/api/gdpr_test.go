@@ -0,0 +1,125 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aiocean/wireset/feature/shopifyapp/webhook"
+	"github.com/aiocean/wireset/model"
+)
+
+func TestParseGdprPayload(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		shopDomain string
+		wantErr    bool
+		wantShopID string
+		wantDomain string
+	}{
+		{
+			name:       "shop id is converted to a gid",
+			body:       `{"shop_id":954889,"shop_domain":"snowdevil.myshopify.com"}`,
+			wantShopID: "gid://shopify/Shop/954889",
+			wantDomain: "snowdevil.myshopify.com",
+		},
+		{
+			name:       "domain defaults to the webhook header",
+			body:       `{"shop_id":954889}`,
+			shopDomain: "header.myshopify.com",
+			wantShopID: "gid://shopify/Shop/954889",
+			wantDomain: "header.myshopify.com",
+		},
+		{name: "missing shop id", body: `{"shop_domain":"snowdevil.myshopify.com"}`, wantErr: true},
+		{name: "zero shop id", body: `{"shop_id":0,"shop_domain":"snowdevil.myshopify.com"}`, wantErr: true},
+		{name: "shop id is not a number", body: `{"shop_id":"954889"}`, wantErr: true},
+		{name: "invalid json", body: `{`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := parseGdprPayload(&webhook.Request{Body: []byte(test.body), ShopDomain: test.shopDomain})
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %+v, want an error", payload)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := shopGid(payload.ShopID); got != test.wantShopID {
+				t.Fatalf("shop id is %s, want %s", got, test.wantShopID)
+			}
+			if payload.ShopDomain != test.wantDomain {
+				t.Fatalf("shop domain is %s, want %s", payload.ShopDomain, test.wantDomain)
+			}
+		})
+	}
+}
+
+func TestGdprHandlerBuildsTheEvents(t *testing.T) {
+	handler := &GdprHandler{}
+	customer := model.GdprCustomer{ID: 191167, Email: "john@example.com", Phone: "555-625-1199"}
+	body := []byte(`{
+		"shop_id": 954889,
+		"shop_domain": "snowdevil.myshopify.com",
+		"customer": {"id": 191167, "email": "john@example.com", "phone": "555-625-1199"},
+		"orders_requested": [299938, 280263],
+		"orders_to_redact": [299938],
+		"data_request": {"id": 9999}
+	}`)
+
+	tests := []struct {
+		name     string
+		newEvent func(req *webhook.Request) (any, error)
+		want     any
+	}{
+		{
+			name:     "customers data request",
+			newEvent: handler.CustomerDataRequest,
+			want: &model.CustomerDataRequestedEvt{
+				ShopID:          "gid://shopify/Shop/954889",
+				MyshopifyDomain: "snowdevil.myshopify.com",
+				Customer:        customer,
+				OrdersRequested: []int64{299938, 280263},
+				DataRequestID:   9999,
+			},
+		},
+		{
+			name:     "customers redact",
+			newEvent: handler.CustomerRedact,
+			want: &model.CustomerRedactRequestedEvt{
+				ShopID:          "gid://shopify/Shop/954889",
+				MyshopifyDomain: "snowdevil.myshopify.com",
+				Customer:        customer,
+				OrdersToRedact:  []int64{299938},
+			},
+		},
+		{
+			name:     "shop redact",
+			newEvent: handler.ShopRedact,
+			want: &model.ShopRedactRequestedEvt{
+				ShopID:          "gid://shopify/Shop/954889",
+				MyshopifyDomain: "snowdevil.myshopify.com",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := test.newEvent(&webhook.Request{Body: body})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(event, test.want) {
+				t.Fatalf("event is %+v, want %+v", event, test.want)
+			}
+
+			if _, err := test.newEvent(&webhook.Request{Body: []byte(`{"shop_domain":"snowdevil.myshopify.com"}`)}); err == nil {
+				t.Fatal("an event was built without shop id")
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"github.com/aiocean/wireset/apperror"
+	"github.com/aiocean/wireset/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ShopStatePath is the state of the shop of the session token.
+const ShopStatePath = "/api/v1/shop/state"
+
+// ShopStateHandler serves the state of the shop, the changes are pushed to its socket.
+type ShopStateHandler struct {
+	ShopRepo  repository.ShopRepository
+	StateRepo repository.StateRepository
+}
+
+type ShopStateResponse struct {
+	State map[string]interface{} `json:"state"`
+}
+
+type ShopStateKeyResponse struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+type SetShopStateKeyRequest struct {
+	Value interface{} `json:"value"`
+}
+
+func currentShopID(c *fiber.Ctx) (string, error) {
+	shopID, ok := c.Locals("shopID").(string)
+	if !ok || shopID == "" {
+		return "", apperror.Unauthorized("shop is not authenticated")
+	}
+
+	return shopID, nil
+}
+
+func (h *ShopStateHandler) GetState(c *fiber.Ctx) error {
+	shopID, err := currentShopID(c)
+	if err != nil {
+		return err
+	}
+
+	state, err := h.StateRepo.GetShopState(c.UserContext(), shopID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(ShopStateResponse{State: state})
+}
+
+func (h *ShopStateHandler) GetKey(c *fiber.Ctx) error {
+	shopID, err := currentShopID(c)
+	if err != nil {
+		return err
+	}
+
+	key := c.Params("key")
+	value, err := h.StateRepo.GetShopStateKey(c.UserContext(), shopID, key)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(ShopStateKeyResponse{Key: key, Value: value})
+}
+
+func (h *ShopStateHandler) SetKey(c *fiber.Ctx) error {
+	shopID, err := currentShopID(c)
+	if err != nil {
+		return err
+	}
+
+	var req SetShopStateKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperror.Validation(apperror.FieldError{Field: "value", Message: "must be json"})
+	}
+
+	key := c.Params("key")
+	if err := h.ShopRepo.UpdateStoreState(c.UserContext(), shopID, key, req.Value); err != nil {
+		return err
+	}
+
+	return c.JSON(ShopStateKeyResponse{Key: key, Value: req.Value})
+}
+
+func (h *ShopStateHandler) DeleteKey(c *fiber.Ctx) error {
+	shopID, err := currentShopID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.StateRepo.DeleteShopStateKey(c.UserContext(), shopID, c.Params("key")); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
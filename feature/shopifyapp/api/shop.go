@@ -1,9 +1,9 @@
 package api
 
 import (
+	"github.com/aiocean/wireset/apperror"
 	"github.com/aiocean/wireset/repository"
 	"github.com/gofiber/fiber/v2"
-	"net/http"
 
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/google/wire"
@@ -12,12 +12,12 @@ import (
 var ShopHandlerWireset = wire.NewSet(NewShopHandler)
 
 type ShopHandler struct {
-	shopRepo *repository.ShopRepository
+	shopRepo repository.ShopRepository
 	cqrsSvc  *cqrs.Facade
 }
 
 func NewShopHandler(
-	shopRepo *repository.ShopRepository,
+	shopRepo repository.ShopRepository,
 	cqrsSvc *cqrs.Facade,
 	fiberApp *fiber.App,
 ) *ShopHandler {
@@ -42,7 +42,7 @@ func (ctrl *ShopHandler) GetDetails(ctx *fiber.Ctx) error {
 
 	currentShop := ctx.Query("shop")
 	if currentShop == "" {
-		return fiber.NewError(http.StatusInternalServerError, "shop not found")
+		return apperror.Validation(apperror.FieldError{Field: "shop", Message: "is required"})
 	}
 
 	return ctx.JSON(map[string]interface{}{
@@ -0,0 +1,45 @@
+package command
+
+import (
+	"context"
+
+	"firebase.google.com/go/auth"
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/repository"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// DeleteShopUserHandler deletes the firebase user of a shop, a user which does not exist is already deleted.
+type DeleteShopUserHandler struct {
+	Logger     *zap.Logger
+	AuthClient *auth.Client
+}
+
+func (h *DeleteShopUserHandler) HandlerName() string {
+	return "DeleteShopUserHandler"
+}
+
+func (h *DeleteShopUserHandler) NewCommand() interface{} {
+	return &model.DeleteShopUserCmd{}
+}
+
+func (h *DeleteShopUserHandler) Handle(ctx context.Context, raw interface{}) error {
+	logger := logsvc.WithTrace(ctx, h.Logger)
+	cmd := raw.(*model.DeleteShopUserCmd)
+
+	// the uid of the user is the normalized shop id, see CreateUserHandler
+	uid, err := repository.NormalizeShopID(cmd.ShopID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.AuthClient.DeleteUser(ctx, uid); err != nil && !auth.IsUserNotFound(err) {
+		return errors.WithMessage(err, "delete user")
+	}
+
+	logger.Info("shop user deleted", zap.String("shop_id", cmd.ShopID))
+
+	return nil
+}
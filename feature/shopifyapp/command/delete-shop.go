@@ -0,0 +1,38 @@
+package command
+
+import (
+	"context"
+
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/repository"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// DeleteShopHandler deletes a shop and its token, a shop which does not exist is already deleted.
+type DeleteShopHandler struct {
+	Logger   *zap.Logger
+	ShopRepo repository.ShopRepository
+}
+
+func (h *DeleteShopHandler) HandlerName() string {
+	return "DeleteShopHandler"
+}
+
+func (h *DeleteShopHandler) NewCommand() interface{} {
+	return &model.DeleteShopCmd{}
+}
+
+func (h *DeleteShopHandler) Handle(ctx context.Context, raw interface{}) error {
+	logger := logsvc.WithTrace(ctx, h.Logger)
+	cmd := raw.(*model.DeleteShopCmd)
+
+	if err := h.ShopRepo.Delete(ctx, cmd.ShopID); err != nil {
+		return errors.WithMessage(err, "delete shop")
+	}
+
+	logger.Info("shop deleted", zap.String("shop_id", cmd.ShopID))
+
+	return nil
+}
@@ -3,22 +3,31 @@ package command
 import (
 	"context"
 
+	"github.com/aiocean/wireset/feature/shopifyapp/webhook"
 	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/repository"
 	"github.com/aiocean/wireset/shopifysvc"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
 
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 )
 
 type InstallWebhookHandler struct {
-	EventBus   *cqrs.EventBus
-	CommandBus *cqrs.CommandBus
-	ShopifySvc *shopifysvc.ShopifyService
+	EventBus        *cqrs.EventBus
+	CommandBus      *cqrs.CommandBus
+	ShopifySvc      *shopifysvc.ShopifyService
+	TokenRepo       repository.TokenRepository
+	WebhookRegistry *webhook.Registry
 }
 
 // NewInstallWebhookHandler creates a new InstallWebhookHandler.
-func NewInstallWebhookHandler(shopifySvc *shopifysvc.ShopifyService) *InstallWebhookHandler {
+func NewInstallWebhookHandler(eventBus *cqrs.EventBus, shopifySvc *shopifysvc.ShopifyService, tokenRepo repository.TokenRepository, webhookRegistry *webhook.Registry) *InstallWebhookHandler {
 	return &InstallWebhookHandler{
-		ShopifySvc: shopifySvc,
+		EventBus:        eventBus,
+		ShopifySvc:      shopifySvc,
+		TokenRepo:       tokenRepo,
+		WebhookRegistry: webhookRegistry,
 	}
 }
 
@@ -35,14 +44,35 @@ func (h *InstallWebhookHandler) RegisterBus(commandBus *cqrs.CommandBus, eventBu
 	h.CommandBus = commandBus
 }
 
+// Handle subscribes the shop to every webhook declared in the registry.
+// It fails while the token of the shop is not saved yet, the command is retried.
 func (h *InstallWebhookHandler) Handle(ctx context.Context, cmdItf interface{}) error {
 	cmd := cmdItf.(*model.InstallWebhookCmd)
 
-	shopClient := h.ShopifySvc.GetShopifyClient(cmd.MyshopifyDomain, cmd.AccessToken)
+	token, err := h.TokenRepo.GetToken(ctx, cmd.ShopID)
+	if err != nil {
+		return errors.WithMessage(err, "get token")
+	}
+
+	shopClient := h.ShopifySvc.GetShopifyClient(cmd.MyshopifyDomain, token.AccessToken).WithContext(ctx)
+
+	var result *multierror.Error
+	for _, registeredWebhook := range h.WebhookRegistry.Webhooks() {
+		if registeredWebhook.Mandatory {
+			continue
+		}
+
+		if err := shopClient.InstallWebhook(registeredWebhook.Topic, registeredWebhook.CallbackPath()); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
 
-	if err := shopClient.InstallAppUninstalledWebhook(); err != nil {
+	if err := result.ErrorOrNil(); err != nil {
 		return err
 	}
 
-	return nil
+	return h.EventBus.Publish(ctx, &model.WebhooksInstalledEvt{
+		ShopID:          cmd.ShopID,
+		MyshopifyDomain: cmd.MyshopifyDomain,
+	})
 }
@@ -11,11 +11,11 @@ type SetShopStateHandler struct {
 	eventBus   *cqrs.EventBus
 	commandBus *cqrs.CommandBus
 
-	ShopStateRepo *repository.StateRepository
+	ShopStateRepo repository.StateRepository
 }
 
 func NewSetShopStateHandler(
-	ShopStateRepo *repository.StateRepository,
+	ShopStateRepo repository.StateRepository,
 ) *SetShopStateHandler {
 	return &SetShopStateHandler{
 		ShopStateRepo: ShopStateRepo,
@@ -40,13 +40,14 @@ func (h *CreateUserHandler) Handle(ctx context.Context, event interface{}) error
 		return errors.WithMessage(err, "create user failed")
 	}
 
-	installWebhookCmd := &model.InstallWebhookCmd{
+	// the onboarding saga installs the webhooks next
+	userCreatedEvt := &model.ShopUserCreatedEvt{
+		ShopID:          evt.ShopID,
 		MyshopifyDomain: evt.MyshopifyDomain,
-		AccessToken:     evt.AccessToken,
 	}
 
-	if err := h.CommandBus.Send(ctx, installWebhookCmd); err != nil {
-		return errors.WithMessage(err, "install webhook failed")
+	if err := h.EventBus.Publish(ctx, userCreatedEvt); err != nil {
+		return errors.WithMessage(err, "publish user created failed")
 	}
 
 	return nil
@@ -2,11 +2,15 @@ package event
 
 import (
 	"context"
+	"errors"
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/aiocean/wireset/logsvc"
 	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
 	"github.com/aiocean/wireset/repository"
 	"github.com/aiocean/wireset/shopifysvc"
 	"go.uber.org/zap"
+	"time"
 )
 
 type OnCheckedInHandler struct {
@@ -14,9 +18,10 @@ type OnCheckedInHandler struct {
 	EventBus      *cqrs.EventBus
 	CommandBus    *cqrs.CommandBus
 	ShopifyConfig *shopifysvc.Config
-	ShopRepo      *repository.ShopRepository
+	ShopRepo      repository.ShopRepository
 	ShopifySvc    *shopifysvc.ShopifyService
-	TokenRepo     *repository.TokenRepository
+	TokenRepo     repository.TokenRepository
+	Outbox        *pubsub.Outbox
 }
 
 func (h *OnCheckedInHandler) HandlerName() string {
@@ -27,61 +32,126 @@ func (h *OnCheckedInHandler) NewEvent() interface{} {
 	return &model.ShopCheckedInEvt{}
 }
 
+// RetryPolicy retries the token exchange for a short time only, the session token expires after a minute.
+// Shopify rejecting the session token is final.
+func (h *OnCheckedInHandler) RetryPolicy() pubsub.RetryPolicy {
+	return pubsub.RetryPolicy{
+		MaxAttempts:         4,
+		InitialInterval:     500 * time.Millisecond,
+		MaxInterval:         5 * time.Second,
+		Multiplier:          2,
+		MaxElapsedTime:      30 * time.Second,
+		RandomizationFactor: 0.2,
+		Retryable: func(err error) bool {
+			var exchangeErr *shopifysvc.TokenExchangeError
+			if errors.As(err, &exchangeErr) {
+				return exchangeErr.Temporary()
+			}
+			return true
+		},
+	}
+}
+
 func (h *OnCheckedInHandler) Handle(ctx context.Context, event interface{}) error {
+	logger := logsvc.WithTrace(ctx, h.Logger)
 	evt := event.(*model.ShopCheckedInEvt)
 	accessTokenResponse, err := shopifysvc.ExchangeAccessToken(evt.MyshopifyDomain, h.ShopifyConfig.ClientId, h.ShopifyConfig.ClientSecret, evt.SessionToken)
 	if err != nil {
-		h.Logger.Error("failed to exchange access token", zap.Error(err))
+		logger.Error("failed to exchange access token", zap.Error(err))
 		return err
 	}
 
 	// create shopify client
-	shopify := h.ShopifySvc.GetShopifyClient(evt.MyshopifyDomain, accessTokenResponse.AccessToken)
+	shopify := h.ShopifySvc.GetShopifyClient(evt.MyshopifyDomain, accessTokenResponse.AccessToken).WithContext(ctx)
 
 	shopDetails, err := shopify.GetShopDetails()
 	if err != nil {
-		h.Logger.Error("failed to get shop details", zap.Error(err))
+		logger.Error("failed to get shop details", zap.Error(err))
 		return err
 	}
 
 	// check if shop is exist
 	isShopExists, err := h.ShopRepo.IsShopExists(ctx, shopDetails.ID)
 	if err != nil {
-		h.Logger.Error("failed to check if shop exists", zap.Error(err))
+		logger.Error("failed to check if shop exists", zap.Error(err))
 		return err
 	}
 
-	if !isShopExists {
-
-		// create shop
-		if err := h.ShopRepo.Create(ctx, shopDetails); err != nil {
-			h.Logger.Error("failed to create shop", zap.Error(err))
+	if isShopExists {
+		if err := h.reinstallIfUninstalled(ctx, shopDetails); err != nil {
 			return err
 		}
-
+	} else {
 		shopInstalledEvt := &model.ShopInstalledEvt{
 			ShopID:          shopDetails.ID,
 			MyshopifyDomain: shopDetails.Domain,
-			AccessToken:     accessTokenResponse.AccessToken,
 		}
 
-		if err := h.EventBus.Publish(ctx, shopInstalledEvt); err != nil {
-			h.Logger.Error("failed to publish shop installed event", zap.Error(err))
+		record, err := h.Outbox.NewRecord(shopInstalledEvt)
+		if err != nil {
+			logger.Error("failed to create shop installed record", zap.Error(err))
 			return err
 		}
+
+		// the shop and its installed event are written together, so the event is never lost
+		if err := h.ShopRepo.CreateWithEvents(ctx, shopDetails, record); err != nil {
+			if !errors.Is(err, repository.ErrShopExists) {
+				logger.Error("failed to create shop", zap.Error(err))
+				return err
+			}
+		} else {
+			h.Outbox.Notify()
+		}
 	}
 
+	// the token is saved once the shop exists, the handlers of its events retry until they can read it
 	token := &model.ShopifyToken{
 		ShopID:      shopDetails.ID,
 		AccessToken: accessTokenResponse.AccessToken,
 	}
 
 	if err := h.TokenRepo.SaveAccessToken(ctx, token); err != nil {
-		h.Logger.Error("failed to save access token", zap.Error(err))
+		logger.Error("failed to save access token", zap.Error(err))
+		return err
+	}
+
+	logger.Info("shop checked in", zap.String("shop_id", shopDetails.ID))
+
+	return nil
+}
+
+// reinstallIfUninstalled reinstalls a returning shop, the installed shops are left as they are.
+func (h *OnCheckedInHandler) reinstallIfUninstalled(ctx context.Context, shopDetails *shopifysvc.Shop) error {
+	logger := logsvc.WithTrace(ctx, h.Logger)
+	lifecycle, err := h.ShopRepo.GetLifecycle(ctx, shopDetails.ID)
+	if err != nil {
+		logger.Error("failed to get shop lifecycle", zap.Error(err))
+		return err
+	}
+
+	if lifecycle.IsInstalled() {
+		return nil
+	}
+
+	record, err := h.Outbox.NewRecord(&model.ShopReinstalledEvt{
+		ShopID:          shopDetails.ID,
+		MyshopifyDomain: shopDetails.MyshopifyDomain,
+	})
+	if err != nil {
+		logger.Error("failed to create shop reinstalled record", zap.Error(err))
+		return err
+	}
+
+	// the shop and its reinstalled event are written together, a concurrent check in reinstalls it once
+	if err := h.ShopRepo.ReinstallWithEvents(ctx, shopDetails, time.Now(), record); err != nil {
+		if errors.Is(err, repository.ErrShopInstalled) {
+			return nil
+		}
+		logger.Error("failed to reinstall shop", zap.Error(err))
 		return err
 	}
 
-	h.Logger.Info("shop checked in", zap.String("shop_id", shopDetails.ID))
+	h.Outbox.Notify()
 
 	return nil
 }
@@ -0,0 +1,63 @@
+package event
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/aiocean/wireset/shopifysvc"
+	"github.com/pkg/errors"
+)
+
+func TestOnCheckedInHandlerRetriesTheTemporaryTokenExchangeErrors(t *testing.T) {
+	policy := (&OnCheckedInHandler{}).RetryPolicy()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "refused session token", err: &shopifysvc.TokenExchangeError{StatusCode: http.StatusUnauthorized}, want: false},
+		{name: "rate limited", err: &shopifysvc.TokenExchangeError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "shopify outage", err: &shopifysvc.TokenExchangeError{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "wrapped refusal", err: errors.WithMessage(&shopifysvc.TokenExchangeError{StatusCode: http.StatusBadRequest}, "exchange"), want: false},
+		{name: "other error", err: errors.New("failed to get shop details"), want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := policy.Retryable(test.err); got != test.want {
+				t.Fatalf("retryable is %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOnCheckedInHandlerDeadLettersARefusedSessionTokenAtOnce(t *testing.T) {
+	// outside of the router the handler name is empty
+	handlers := pubsub.NewHandlerRegistry()
+	handlers.Add("", &OnCheckedInHandler{})
+
+	calls, failedAttempts := 0, 0
+	retry := pubsub.Retry{
+		Policy:   pubsub.DefaultRetryPolicy(),
+		Handlers: handlers,
+		OnFailed: func(msg *message.Message, err error, attempts int) ([]*message.Message, error) {
+			failedAttempts = attempts
+			return nil, nil
+		},
+	}
+
+	handler := retry.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		calls++
+		return nil, &shopifysvc.TokenExchangeError{StatusCode: http.StatusUnauthorized, Body: "invalid session token"}
+	})
+	if _, err := handler(message.NewMessage("uuid", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 1 || failedAttempts != 1 {
+		t.Fatalf("the handler ran %d times and OnFailed got %d attempts, want 1", calls, failedAttempts)
+	}
+}
@@ -12,7 +12,7 @@ import (
 
 type OnUserConnectedHandler struct {
 	CommandBus *cqrs.CommandBus
-	TokenRepo  *repository.TokenRepository
+	TokenRepo  repository.TokenRepository
 	ShopifySvc *shopifysvc.ShopifyService
 }
 
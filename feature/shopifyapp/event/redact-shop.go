@@ -0,0 +1,59 @@
+package event
+
+import (
+	"context"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/repository"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// RedactShopHandler deletes every data the app stored for a shop once Shopify asks to redact it.
+// The shop is replaced by a tombstone without its details, see repository.ShopRepository.MarkRedacted.
+type RedactShopHandler struct {
+	Logger     *zap.Logger
+	EventBus   *cqrs.EventBus
+	CommandBus *cqrs.CommandBus
+	ShopRepo   repository.ShopRepository
+	TokenRepo  repository.TokenRepository
+	StateRepo  repository.StateRepository
+	PlanRepo   repository.PlanRepository
+}
+
+func (h *RedactShopHandler) HandlerName() string {
+	return "RedactShopHandler"
+}
+
+func (h *RedactShopHandler) NewEvent() interface{} {
+	return &model.ShopRedactRequestedEvt{}
+}
+
+func (h *RedactShopHandler) Handle(ctx context.Context, event interface{}) error {
+	logger := logsvc.WithTrace(ctx, h.Logger)
+	evt := event.(*model.ShopRedactRequestedEvt)
+
+	if err := h.TokenRepo.DeleteToken(ctx, evt.ShopID); err != nil {
+		return errors.WithMessage(err, "delete token")
+	}
+
+	if err := h.StateRepo.DeleteShopState(ctx, evt.ShopID); err != nil {
+		return errors.WithMessage(err, "delete shop state")
+	}
+
+	if err := h.PlanRepo.DeleteShopPlans(ctx, evt.ShopID); err != nil {
+		return errors.WithMessage(err, "delete shop plans")
+	}
+
+	// the tombstone keeps the id, the domain and the lifecycle, so a reinstall is known as such
+	if err := h.ShopRepo.MarkRedacted(ctx, evt.ShopID, time.Now()); err != nil && !errors.Is(err, repository.ErrShopNotFound) {
+		return errors.WithMessage(err, "redact shop")
+	}
+
+	logger.Info("shop redacted", zap.String("shop_id", evt.ShopID), zap.String("shop", evt.MyshopifyDomain))
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aiocean/wireset/cryptosvc"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/aiocean/wireset/repository"
+	"github.com/aiocean/wireset/shopifysvc"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+func TestRedactShopHandlerDeletesTheDataAndKeepsATombstone(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := cryptosvc.GenerateKey("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := cryptosvc.NewEnvelope(&cryptosvc.Config{Keys: []string{key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := repository.NewMemoryStore()
+	shops := &repository.MemoryShopRepository{Store: store, Outbox: pubsub.NewMemoryOutboxStore()}
+	tokens := &repository.MemoryTokenRepository{Store: store, Envelope: envelope}
+	states := &repository.MemoryStateRepository{Store: store}
+	plans := repository.NewMemoryPlanRepository([]*model.Plan{{ID: "basic", Name: "Basic"}})
+
+	shop := &shopifysvc.Shop{
+		ID:              "gid://shopify/Shop/954889",
+		Name:            "Snow Devil",
+		Email:           "owner@example.com",
+		MyshopifyDomain: "snowdevil.myshopify.com",
+	}
+	if err := shops.Create(ctx, shop); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.SaveAccessToken(ctx, &model.ShopifyToken{ShopID: shop.ID, AccessToken: "shpat_secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := states.SetShopState(ctx, shop.ID, map[string]interface{}{"onboarded": true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := plans.AssignPlan(ctx, shop.ID, model.PlanAssignment{PlanID: "basic", AssignedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := shops.MarkUninstalled(ctx, shop.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &RedactShopHandler{
+		Logger:    zap.NewNop(),
+		ShopRepo:  shops,
+		TokenRepo: tokens,
+		StateRepo: states,
+		PlanRepo:  plans,
+	}
+	evt := &model.ShopRedactRequestedEvt{ShopID: shop.ID, MyshopifyDomain: shop.MyshopifyDomain}
+	if err := handler.Handle(ctx, evt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tokens.GetToken(ctx, shop.ID); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Fatalf("get token: want ErrTokenNotFound, got %v", err)
+	}
+	if state, err := states.GetShopState(ctx, shop.ID); err != nil || len(state) != 0 {
+		t.Fatalf("state of the redacted shop is %v, %v", state, err)
+	}
+	if _, err := plans.GetPlansOfShop(ctx, shop.ID); !errors.Is(err, repository.ErrNoPlanFound) {
+		t.Fatalf("plans of the redacted shop: want ErrNoPlanFound, got %v", err)
+	}
+	if history, err := plans.GetPlanHistory(ctx, shop.ID); err != nil || len(history) != 0 {
+		t.Fatalf("plan history of the redacted shop is %v, %v", history, err)
+	}
+
+	tombstone, err := shops.Get(ctx, shop.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tombstone.ID != shop.ID || tombstone.MyshopifyDomain != shop.MyshopifyDomain || tombstone.Name != "" || tombstone.Email != "" {
+		t.Fatalf("the tombstone is %+v, want only the id and the domain", tombstone)
+	}
+	lifecycle, err := shops.GetLifecycle(ctx, shop.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lifecycle.Status != model.ShopStatusRedacted || lifecycle.RedactedAt == nil {
+		t.Fatalf("the lifecycle is %+v, want a redacted shop", lifecycle)
+	}
+
+	// Shopify may send the webhook again, the redaction is idempotent
+	if err := handler.Handle(ctx, evt); err != nil {
+		t.Fatal(err)
+	}
+
+	// a shop which was never stored is redacted too
+	if err := handler.Handle(ctx, &model.ShopRedactRequestedEvt{ShopID: "gid://shopify/Shop/1"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,41 @@
+package event
+
+import (
+	"context"
+
+	"github.com/aiocean/wireset/cryptosvc"
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/repository"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ReencryptTokensHandler seals the tokens again with the active key when the server starts,
+// so a rotated key can be removed from the config once every server restarted with the new one.
+// Several servers can run it at once, a token changed meanwhile is skipped.
+type ReencryptTokensHandler struct {
+	Logger    *zap.Logger
+	TokenRepo repository.TokenRepository
+	Envelope  *cryptosvc.Envelope
+}
+
+func (h *ReencryptTokensHandler) HandlerName() string {
+	return "ReencryptTokensHandler"
+}
+
+func (h *ReencryptTokensHandler) NewEvent() interface{} {
+	return &model.ServerStartedEvt{}
+}
+
+func (h *ReencryptTokensHandler) Handle(ctx context.Context, event interface{}) error {
+	logger := logsvc.WithTrace(ctx, h.Logger)
+	count, err := h.TokenRepo.ReencryptTokens(ctx)
+	if err != nil {
+		return errors.WithMessagef(err, "reencrypt tokens, %d reencrypted", count)
+	}
+
+	logger.Info("tokens reencrypted", zap.Int("count", count), zap.String("key_id", h.Envelope.KeyID()))
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package event
+
+import (
+	"context"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/aiocean/wireset/model"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ReinstallWebhooksHandler installs the webhooks of a reinstalled shop again, Shopify deleted them on the uninstall.
+// The firebase user of the shop is kept, so the onboarding saga does not run again.
+type ReinstallWebhooksHandler struct {
+	Logger     *zap.Logger
+	EventBus   *cqrs.EventBus
+	CommandBus *cqrs.CommandBus
+}
+
+func (h *ReinstallWebhooksHandler) HandlerName() string {
+	return "ReinstallWebhooksHandler"
+}
+
+func (h *ReinstallWebhooksHandler) NewEvent() interface{} {
+	return &model.ShopReinstalledEvt{}
+}
+
+func (h *ReinstallWebhooksHandler) Handle(ctx context.Context, event interface{}) error {
+	logger := logsvc.WithTrace(ctx, h.Logger)
+	evt := event.(*model.ShopReinstalledEvt)
+
+	if err := h.CommandBus.Send(ctx, &model.InstallWebhookCmd{
+		ShopID:          evt.ShopID,
+		MyshopifyDomain: evt.MyshopifyDomain,
+	}); err != nil {
+		return errors.WithMessage(err, "send install webhook")
+	}
+
+	logger.Info("shop reinstalled", zap.String("shop_id", evt.ShopID), zap.String("shop", evt.MyshopifyDomain))
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package event
+
+import (
+	"context"
+	"time"
+
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/repository"
+	"github.com/aiocean/wireset/shopifysvc"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// SyncPlanHandler assigns the plan of the active subscription of the shop when its subscriptions change.
+// The subscription is read from Shopify, the webhook payload is only a notification.
+type SyncPlanHandler struct {
+	Logger     *zap.Logger
+	ShopRepo   repository.ShopRepository
+	TokenRepo  repository.TokenRepository
+	PlanRepo   repository.PlanRepository
+	ShopifySvc *shopifysvc.ShopifyService
+}
+
+func (h *SyncPlanHandler) HandlerName() string {
+	return "SyncPlanHandler"
+}
+
+func (h *SyncPlanHandler) NewEvent() interface{} {
+	return &model.ShopSubscriptionUpdatedEvt{}
+}
+
+func (h *SyncPlanHandler) Handle(ctx context.Context, event interface{}) error {
+	logger := logsvc.WithTrace(ctx, h.Logger)
+	evt := event.(*model.ShopSubscriptionUpdatedEvt)
+
+	shop, err := h.ShopRepo.GetByDomain(ctx, evt.MyshopifyDomain)
+	if err != nil {
+		return errors.WithMessage(err, "get shop")
+	}
+
+	token, err := h.TokenRepo.GetToken(ctx, shop.ID)
+	if err != nil {
+		return errors.WithMessage(err, "get token")
+	}
+
+	client := h.ShopifySvc.GetShopifyClient(evt.MyshopifyDomain, token.AccessToken).WithContext(ctx)
+	subscription, err := client.GetSubscription()
+	if err != nil && !errors.Is(err, shopifysvc.ErrorSubscriptionNotFound) {
+		return errors.WithMessage(err, "get subscription")
+	}
+
+	if err := repository.SyncShopPlan(ctx, h.PlanRepo, shop.ID, subscription, time.Now()); err != nil {
+		return errors.WithMessage(err, "sync plan")
+	}
+
+	logger.Info("shop plan synced", zap.String("shop_id", shop.ID), zap.Bool("subscribed", subscription != nil))
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package event
+
+import (
+	"context"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/repository"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// UninstallShopHandler deletes the token of an uninstalled shop and marks it uninstalled, the shop is kept.
+type UninstallShopHandler struct {
+	Logger     *zap.Logger
+	EventBus   *cqrs.EventBus
+	CommandBus *cqrs.CommandBus
+	ShopRepo   repository.ShopRepository
+	TokenRepo  repository.TokenRepository
+}
+
+func (h *UninstallShopHandler) HandlerName() string {
+	return "UninstallShopHandler"
+}
+
+func (h *UninstallShopHandler) NewEvent() interface{} {
+	return &model.ShopUninstalledEvt{}
+}
+
+func (h *UninstallShopHandler) Handle(ctx context.Context, event interface{}) error {
+	logger := logsvc.WithTrace(ctx, h.Logger)
+	evt := event.(*model.ShopUninstalledEvt)
+
+	shop, err := h.ShopRepo.GetByDomain(ctx, evt.MyshopifyDomain)
+	if err != nil {
+		if errors.Is(err, repository.ErrShopNotFound) {
+			logger.Info("uninstalled shop is not stored", zap.String("shop", evt.MyshopifyDomain))
+			return nil
+		}
+		return errors.WithMessage(err, "get shop")
+	}
+
+	// the token is revoked by the uninstall
+	if err := h.TokenRepo.DeleteToken(ctx, shop.ID); err != nil {
+		return errors.WithMessage(err, "delete token")
+	}
+
+	if err := h.ShopRepo.MarkUninstalled(ctx, shop.ID, time.Now()); err != nil {
+		return errors.WithMessage(err, "mark shop uninstalled")
+	}
+
+	logger.Info("shop uninstalled", zap.String("shop_id", shop.ID), zap.String("shop", evt.MyshopifyDomain))
+
+	return nil
+}
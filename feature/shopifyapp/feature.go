@@ -8,8 +8,12 @@ import (
 	"github.com/aiocean/wireset/feature/shopifyapp/event"
 	"github.com/aiocean/wireset/feature/shopifyapp/middleware"
 	"github.com/aiocean/wireset/feature/shopifyapp/models"
+	"github.com/aiocean/wireset/feature/shopifyapp/webhook"
+	"github.com/aiocean/wireset/feature/shopifyapp/workflow"
 	"github.com/aiocean/wireset/feature/shopifyapp/ws"
 	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/wire"
 )
@@ -19,54 +23,85 @@ var DefaultWireset = wire.NewSet(
 
 	command.NewInstallWebhookHandler,
 	command.NewSetShopStateHandler,
+	wire.Struct(new(command.DeleteShopUserHandler), "*"),
+	wire.Struct(new(command.DeleteShopHandler), "*"),
 	wire.Struct(new(event.CreateUserHandler), "*"),
 	wire.Struct(new(event.WelcomeHandler), "*"),
 	wire.Struct(new(event.OnUserConnectedHandler), "*"),
 	wire.Struct(new(event.OnCheckedInHandler), "*"),
+	wire.Struct(new(event.RedactShopHandler), "*"),
+	wire.Struct(new(event.UninstallShopHandler), "*"),
+	wire.Struct(new(event.ReinstallWebhooksHandler), "*"),
+	wire.Struct(new(event.ReencryptTokensHandler), "*"),
+	wire.Struct(new(event.SyncPlanHandler), "*"),
 
 	wire.Struct(new(ws.FetchActivateSubscriptionHandler), "*"),
 	wire.Struct(new(ws.CreateSubscriptionHandler), "*"),
+	wire.Struct(new(ws.ShopStateWatcher), "*"),
 
 	middleware.NewAuthzController,
+	middleware.NewWebhookVerifier,
+	webhook.NewRegistry,
 
 	wire.Struct(new(api.AuthHandler), "*"),
-	wire.Struct(new(api.WebhookHandler), "*"),
 	wire.Struct(new(api.GdprHandler), "*"),
+	wire.Struct(new(api.ShopStateHandler), "*"),
 )
 
 type FeatureCore struct {
 	InstallWebhookCmdHandler *command.InstallWebhookHandler
 	SetShopStateCmdHandler   *command.SetShopStateHandler
+	DeleteShopUserCmdHandler *command.DeleteShopUserHandler
+	DeleteShopCmdHandler     *command.DeleteShopHandler
 
 	FetchPlanWsHandler        *ws.FetchActivateSubscriptionHandler
 	CreateSubscriptionHandler *ws.CreateSubscriptionHandler
-
-	ShopInstalledEvtHandler *event.CreateUserHandler
-	WelcomeEvtHandler       *event.WelcomeHandler
-	OnUserConnectedHandler  *event.OnUserConnectedHandler
-	OnCheckedInHandler      *event.OnCheckedInHandler
+	ShopStateWatcher          *ws.ShopStateWatcher
+
+	ShopInstalledEvtHandler  *event.CreateUserHandler
+	WelcomeEvtHandler        *event.WelcomeHandler
+	OnUserConnectedHandler   *event.OnUserConnectedHandler
+	OnCheckedInHandler       *event.OnCheckedInHandler
+	RedactShopHandler        *event.RedactShopHandler
+	UninstallShopHandler     *event.UninstallShopHandler
+	ReinstallWebhooksHandler *event.ReinstallWebhooksHandler
+	ReencryptTokensHandler   *event.ReencryptTokensHandler
+	SyncPlanHandler          *event.SyncPlanHandler
 
 	AuthzMiddleware *middleware.ShopifyAuthzMiddleware
 
-	AuthHandler    *api.AuthHandler
-	WebhookHandler *api.WebhookHandler
-	GdprHandler    *api.GdprHandler
+	AuthHandler      *api.AuthHandler
+	GdprHandler      *api.GdprHandler
+	ShopStateHandler *api.ShopStateHandler
+
+	WebhookRegistry *webhook.Registry
 
 	EventProcessor   *cqrs.EventProcessor
 	CommandProcessor *cqrs.CommandProcessor
 	HttpRegistry     *fiberapp.Registry
 	WsRegistry       *registry.HandlerRegistry
+	Outbox           *pubsub.Outbox
+	ProcessManager   *pubsub.ProcessManager
 }
 
 func (f *FeatureCore) Name() string {
 	return "shopifyapp"
 }
 
+// Dependencies makes the realtime feature initialize first, shopifyapp adds websocket handlers to it.
+func (f *FeatureCore) Dependencies() []string {
+	return []string{"realtime"}
+}
+
 func (f *FeatureCore) Init() error {
+	// the records written before a restart are published by the relay
+	f.Outbox.RegisterEvents(&model.ShopInstalledEvt{}, &model.ShopReinstalledEvt{})
 
 	if err := f.CommandProcessor.AddHandlers(
 		f.InstallWebhookCmdHandler,
 		f.SetShopStateCmdHandler,
+		f.DeleteShopUserCmdHandler,
+		f.DeleteShopCmdHandler,
 	); err != nil {
 		return err
 	}
@@ -76,45 +111,121 @@ func (f *FeatureCore) Init() error {
 		f.WelcomeEvtHandler,
 		f.OnUserConnectedHandler,
 		f.OnCheckedInHandler,
+		f.RedactShopHandler,
+		f.UninstallShopHandler,
+		f.ReinstallWebhooksHandler,
+		f.ReencryptTokensHandler,
+		f.SyncPlanHandler,
 	); err != nil {
 		return err
 	}
 
-	f.HttpRegistry.AddHttpMiddleware("/", f.AuthzMiddleware.Handle)
+	if err := f.ProcessManager.Register(workflow.NewOnboardingSaga()); err != nil {
+		return err
+	}
+
+	f.HttpRegistry.AddAuthVerifiers(f.AuthzMiddleware)
 
 	f.HttpRegistry.AddHttpHandlers(
 		&fiberapp.HttpHandler{
-			Method:   fiber.MethodGet,
-			Path:     "/auth/shopify/login-callback",
-			Handlers: []fiber.Handler{f.AuthHandler.LoginCallback},
+			Method:         fiber.MethodGet,
+			Path:           "/auth/shopify/login-callback",
+			Handlers:       []fiber.Handler{f.AuthHandler.LoginCallback},
+			Summary:        "Redirect to the app after the OAuth login",
+			Tags:           []string{"auth"},
+			ResponseStatus: fiber.StatusFound,
+			Auth:           fiberapp.AuthPublic,
 		},
 		&fiberapp.HttpHandler{
-			Method:   fiber.MethodGet,
-			Path:     "/auth/shopify/checkin",
-			Handlers: []fiber.Handler{f.AuthHandler.Checkin},
+			Method:      fiber.MethodGet,
+			Path:        "/auth/shopify/checkin",
+			Handlers:    []fiber.Handler{f.AuthHandler.Checkin},
+			Summary:     "Check in the shop of the session token",
+			Description: "Returns the authentication url when the shop has to authorize the app again.",
+			Tags:        []string{"auth"},
+			Response:    model.AuthResponse{},
+			// the handler verifies the session token, it answers with the authentication url when it is invalid
+			Auth: fiberapp.AuthPublic,
 		},
 		&fiberapp.HttpHandler{
 			Method:   fiber.MethodGet,
-			Path:     "/webhook/shopify/app-uninstalled",
-			Handlers: []fiber.Handler{f.WebhookHandler.Uninstalled},
+			Path:     api.ShopStatePath,
+			Handlers: []fiber.Handler{f.ShopStateHandler.GetState},
+			Summary:  "Get the state of the shop",
+			Tags:     []string{"shop"},
+			Response: api.ShopStateResponse{},
+			Auth:     fiberapp.AuthShopifySession,
 		},
 		&fiberapp.HttpHandler{
-			Method:   fiber.MethodPost,
-			Path:     "/gdpr/customers/data_request",
-			Handlers: []fiber.Handler{f.GdprHandler.CustomerDataRequest},
+			Method:   fiber.MethodGet,
+			Path:     api.ShopStatePath + "/:key",
+			Handlers: []fiber.Handler{f.ShopStateHandler.GetKey},
+			Summary:  "Get a key of the state of the shop",
+			Tags:     []string{"shop"},
+			Response: api.ShopStateKeyResponse{},
+			Auth:     fiberapp.AuthShopifySession,
 		},
 		&fiberapp.HttpHandler{
-			Method:   fiber.MethodPost,
-			Path:     "/gdpr/customers/redact",
-			Handlers: []fiber.Handler{f.GdprHandler.CustomerRedact},
+			Method:      fiber.MethodPut,
+			Path:        api.ShopStatePath + "/:key",
+			Handlers:    []fiber.Handler{f.ShopStateHandler.SetKey},
+			Summary:     "Set a key of the state of the shop",
+			Description: "The new state is pushed to the socket of the shop.",
+			Tags:        []string{"shop"},
+			Request:     api.SetShopStateKeyRequest{},
+			Response:    api.ShopStateKeyResponse{},
+			Auth:        fiberapp.AuthShopifySession,
 		},
 		&fiberapp.HttpHandler{
-			Method:   fiber.MethodPost,
-			Path:     "/gdpr/shop/redact",
-			Handlers: []fiber.Handler{f.GdprHandler.ShopRedact},
+			Method:         fiber.MethodDelete,
+			Path:           api.ShopStatePath + "/:key",
+			Handlers:       []fiber.Handler{f.ShopStateHandler.DeleteKey},
+			Summary:        "Delete a key of the state of the shop",
+			Tags:           []string{"shop"},
+			ResponseStatus: fiber.StatusNoContent,
+			Auth:           fiberapp.AuthShopifySession,
 		},
 	)
 
+	if err := f.WebhookRegistry.Add(
+		&webhook.Webhook{
+			Topic: "APP_UNINSTALLED",
+			NewEvent: func(req *webhook.Request) (any, error) {
+				return &model.ShopUninstalledEvt{
+					MyshopifyDomain: req.ShopDomain,
+				}, nil
+			},
+		},
+		&webhook.Webhook{
+			Topic: "APP_SUBSCRIPTIONS_UPDATE",
+			NewEvent: func(req *webhook.Request) (any, error) {
+				return &model.ShopSubscriptionUpdatedEvt{
+					MyshopifyDomain: req.ShopDomain,
+				}, nil
+			},
+		},
+		&webhook.Webhook{
+			Topic:     "CUSTOMERS_DATA_REQUEST",
+			Path:      "/gdpr/customers/data_request",
+			Mandatory: true,
+			NewEvent:  f.GdprHandler.CustomerDataRequest,
+		},
+		&webhook.Webhook{
+			Topic:     "CUSTOMERS_REDACT",
+			Path:      "/gdpr/customers/redact",
+			Mandatory: true,
+			NewEvent:  f.GdprHandler.CustomerRedact,
+		},
+		&webhook.Webhook{
+			Topic:     "SHOP_REDACT",
+			Path:      "/gdpr/shop/redact",
+			Mandatory: true,
+			NewEvent:  f.GdprHandler.ShopRedact,
+		},
+	); err != nil {
+		return err
+	}
+
 	f.WsRegistry.AddWebsocketHandler(
 		&registry.WebsocketHandler{
 			Topic:   models.TopicFetchActivateSubscription.String(),
@@ -125,5 +236,6 @@ func (f *FeatureCore) Init() error {
 			Handler: f.CreateSubscriptionHandler.Handle,
 		},
 	)
+	f.WsRegistry.AddConnectHandler(f.ShopStateWatcher.Watch)
 	return nil
 }
@@ -1,15 +1,19 @@
 package middleware
 
 import (
+	"github.com/aiocean/wireset/apperror"
 	"github.com/aiocean/wireset/cachesvc"
 	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp"
 	"github.com/aiocean/wireset/model"
 	"github.com/aiocean/wireset/repository"
 	"github.com/aiocean/wireset/shopifysvc"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
 	"github.com/tidwall/gjson"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"net/http"
 	"strings"
 	"time"
@@ -18,8 +22,8 @@ import (
 type ShopifyAuthzMiddleware struct {
 	configService   *configsvc.ConfigService
 	shopifyConfig   *shopifysvc.Config
-	tokenRepository *repository.TokenRepository
-	shopRepository  *repository.ShopRepository
+	tokenRepository repository.TokenRepository
+	shopRepository  repository.ShopRepository
 	cacheSvc        *cachesvc.CacheService
 	logger          *zap.Logger
 	shopifySvc      *shopifysvc.ShopifyService
@@ -28,8 +32,8 @@ type ShopifyAuthzMiddleware struct {
 func NewAuthzController(
 	configSvc *configsvc.ConfigService,
 	shopifyConfig *shopifysvc.Config,
-	tokenRepository *repository.TokenRepository,
-	shopRepository *repository.ShopRepository,
+	tokenRepository repository.TokenRepository,
+	shopRepository repository.ShopRepository,
 	logger *zap.Logger,
 	cacheSvc *cachesvc.CacheService,
 	shopifySvc *shopifysvc.ShopifyService,
@@ -48,24 +52,6 @@ func NewAuthzController(
 	return controller
 }
 
-// IsAuthRequired check if the path is required authentication
-// TODO by hard code this path, it's become not flexible, hard to maintain. Maybe let's feature to register it into the http api registry is better
-func (s *ShopifyAuthzMiddleware) IsAuthRequired(path string) bool {
-	if strings.HasPrefix(path, "/auth") {
-		return false
-	}
-
-	if strings.HasPrefix(path, "/metrics") {
-		return false
-	}
-
-	if strings.HasPrefix(path, "/app") {
-		return false
-	}
-
-	return true
-}
-
 type AuthData struct {
 	AccessToken     string
 	MyshopifyDomain string
@@ -81,12 +67,34 @@ type AuthData struct {
 	Sid             string
 }
 
-// Handle TODO the token which sent from shopify have expired time, we can use this time to cache the authz result, so that we do not need to query database every time
-func (s *ShopifyAuthzMiddleware) Handle(c *fiber.Ctx) error {
-	if !s.IsAuthRequired(c.OriginalURL()) {
-		return c.Next()
+// MarshalLogObject logs the session, the access token is left out.
+func (a *AuthData) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("shopID", a.ShopID)
+	enc.AddString("myshopifyDomain", a.MyshopifyDomain)
+	enc.AddString("dest", a.Dest)
+	enc.AddString("sub", a.Sub)
+	enc.AddString("jti", a.Jti)
+	enc.AddString("sid", a.Sid)
+	enc.AddInt("exp", a.Exp)
+	return nil
+}
+
+func (s *ShopifyAuthzMiddleware) AuthKind() fiberapp.AuthKind {
+	return fiberapp.AuthKindShopifySession
+}
+
+func (s *ShopifyAuthzMiddleware) SecurityScheme() *fiberapp.SecurityScheme {
+	return &fiberapp.SecurityScheme{
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+		Description:  "The session token of the embedded app, issued by App Bridge",
 	}
+}
 
+// Verify authenticates the session token of the embedded app, the routes declare it with fiberapp.AuthShopifySession.
+// TODO the token which sent from shopify have expired time, we can use this time to cache the authz result, so that we do not need to query database every time
+func (s *ShopifyAuthzMiddleware) Verify(c *fiber.Ctx, policy fiberapp.AuthPolicy) error {
 	authHeader := c.Get("authorization")
 	if authHeader == "" {
 		authHeader = c.Params("authorization")
@@ -103,9 +111,7 @@ func (s *ShopifyAuthzMiddleware) Handle(c *fiber.Ctx) error {
 	authentication := strings.TrimPrefix(authHeader, "Bearer ")
 
 	if authentication == "" {
-		return c.Status(http.StatusUnauthorized).JSON(model.AuthResponse{
-			Message: "Unauthorized: missing authentication header",
-		})
+		return apperror.Unauthorized("missing authentication header")
 	}
 
 	var claims model.CustomJwtClaims
@@ -113,22 +119,20 @@ func (s *ShopifyAuthzMiddleware) Handle(c *fiber.Ctx) error {
 		return []byte(s.shopifyConfig.ClientSecret), nil
 	})
 	if err != nil {
-		return c.Status(http.StatusUnauthorized).JSON(model.AuthResponse{
-			Message: "Unauthorized: " + err.Error(),
-		})
+		return apperror.Wrap(err, apperror.CodeUnauthorized, http.StatusUnauthorized, "the session token is invalid")
 	}
 
 	if !token.Valid {
-		return fiber.NewError(http.StatusUnauthorized, "Couldn't handle this token")
+		return apperror.Unauthorized("the session token is invalid")
 	}
 
 	cacheKey := "sessionId:" + claims.Jti
 
 	if authDataCache, ok := s.cacheSvc.Get(cacheKey); ok {
-		s.logger.Info("get auth data from cache", zap.Any("authData", authDataCache))
 		authData := authDataCache.(AuthData)
+		s.logger.Info("get auth data from cache", zap.Object("authData", &authData))
 		setLocal(c, &authData)
-		return c.Next()
+		return nil
 	}
 
 	authData := AuthData{
@@ -147,28 +151,25 @@ func (s *ShopifyAuthzMiddleware) Handle(c *fiber.Ctx) error {
 	// exchange the session token with access token
 	accessTokenResponse, err := shopifysvc.ExchangeAccessToken(authData.MyshopifyDomain, s.shopifyConfig.ClientId, s.shopifyConfig.ClientSecret, authentication)
 	if err != nil {
-		return c.Status(http.StatusUnauthorized).JSON(model.AuthResponse{
-			Message: "Unauthorized: " + err.Error(),
-		})
+		// TokenExchangeError knows its public form
+		return errors.WithMessage(err, "failed to exchange the session token")
 	}
 
 	authData.AccessToken = accessTokenResponse.AccessToken
 
-	shopifyClient := s.shopifySvc.GetShopifyClient(authData.MyshopifyDomain, authData.AccessToken)
+	shopifyClient := s.shopifySvc.GetShopifyClient(authData.MyshopifyDomain, authData.AccessToken).WithContext(c.UserContext())
 	shop, err := shopifyClient.GetShopDetails()
 	if err != nil {
-		return c.Status(http.StatusUnauthorized).JSON(model.AuthResponse{
-			Message: "Unauthorized: " + err.Error(),
-		})
+		return errors.WithMessage(err, "failed to get shop details")
 	}
 
 	authData.ShopID = shop.ID
 
-	s.logger.Info("set auth data to cache", zap.Any("authData", authData))
+	s.logger.Info("set auth data to cache", zap.Object("authData", &authData))
 	s.cacheSvc.SetWithTTL(cacheKey, authData, 3*time.Minute)
 
 	setLocal(c, &authData)
-	return c.Next()
+	return nil
 }
 
 func setLocal(c *fiber.Ctx, authData *AuthData) {
@@ -176,4 +177,5 @@ func setLocal(c *fiber.Ctx, authData *AuthData) {
 	c.Locals("accessToken", authData.AccessToken)
 	c.Locals("shopID", authData.ShopID)
 	c.Locals("sid", authData)
+	fiberapp.SetAuthSubject(c, authData.MyshopifyDomain)
 }
@@ -24,3 +24,10 @@ const TopicNavigateTo models.WebsocketTopic = "navigateTo"
 type NavigateToPayload struct {
 	URL string `json:"url"`
 }
+
+// TopicShopState pushes the state of the shop, when the socket connects and after every change.
+const TopicShopState models.WebsocketTopic = "shopState"
+
+type ShopStatePayload struct {
+	State map[string]interface{} `json:"state"`
+}
@@ -0,0 +1,164 @@
+// Package webhook lets features declare the Shopify webhooks they need.
+// Each webhook is mounted as a POST route behind the HMAC verifier, and the
+// topics are subscribed for every shop by the InstallWebhookCmd.
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/aiocean/wireset/feature/shopifyapp/middleware"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+const pathPrefix = "/webhook/shopify/"
+
+// Request is a verified webhook request.
+type Request struct {
+	Topic      string
+	ShopDomain string
+	WebhookID  string
+	ApiVersion string
+	Body       []byte
+}
+
+// Payload returns the parsed body.
+func (r *Request) Payload() gjson.Result {
+	return gjson.ParseBytes(r.Body)
+}
+
+// Webhook declares a webhook topic and what to do when it is received.
+type Webhook struct {
+	// Topic is the GraphQL webhook subscription topic, such as APP_UNINSTALLED.
+	Topic string
+	// Path overrides the default path, which is derived from the topic.
+	Path string
+	// Mandatory webhooks are configured in the app settings, they are not subscribed through the API.
+	Mandatory bool
+	// Handler is called with the verified request, it is optional.
+	Handler func(ctx context.Context, req *Request) error
+	// NewEvent builds the event which is published on the EventBus, it is optional.
+	NewEvent func(req *Request) (any, error)
+}
+
+// CallbackPath returns the path where Shopify sends the webhook.
+func (w *Webhook) CallbackPath() string {
+	if w.Path != "" {
+		return w.Path
+	}
+
+	return pathPrefix + strings.ReplaceAll(strings.ToLower(w.Topic), "_", "-")
+}
+
+type Registry struct {
+	HttpRegistry *fiberapp.Registry
+	EventBus     *cqrs.EventBus
+	Verifier     *middleware.WebhookVerifier
+	Logger       *zap.Logger
+
+	mu       sync.RWMutex
+	webhooks map[string]*Webhook
+}
+
+func NewRegistry(
+	httpRegistry *fiberapp.Registry,
+	eventBus *cqrs.EventBus,
+	verifier *middleware.WebhookVerifier,
+	logger *zap.Logger,
+) *Registry {
+	// the webhook routes are authenticated by the signature
+	httpRegistry.AddAuthVerifiers(verifier)
+
+	return &Registry{
+		HttpRegistry: httpRegistry,
+		EventBus:     eventBus,
+		Verifier:     verifier,
+		Logger:       logger.Named("webhookRegistry"),
+		webhooks:     map[string]*Webhook{},
+	}
+}
+
+// Add registers the webhooks and mounts their routes.
+func (r *Registry) Add(webhooks ...*Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, webhook := range webhooks {
+		if webhook.Topic == "" {
+			return errors.New("webhook topic is required")
+		}
+
+		if _, ok := r.webhooks[webhook.Topic]; ok {
+			return errors.Errorf("webhook %s is already registered", webhook.Topic)
+		}
+
+		r.webhooks[webhook.Topic] = webhook
+		r.HttpRegistry.AddHttpHandlers(&fiberapp.HttpHandler{
+			Method:   fiber.MethodPost,
+			Path:     webhook.CallbackPath(),
+			Handlers: []fiber.Handler{r.handler(webhook)},
+			Summary:  "Receive the " + webhook.Topic + " webhook",
+			Tags:     []string{"webhooks"},
+			Auth:     fiberapp.AuthShopifyWebhook,
+		})
+	}
+
+	return nil
+}
+
+// Webhooks returns the registered webhooks.
+func (r *Registry) Webhooks() []*Webhook {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	webhooks := make([]*Webhook, 0, len(r.webhooks))
+	for _, webhook := range r.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks
+}
+
+func (r *Registry) handler(webhook *Webhook) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := &Request{
+			Topic:      webhook.Topic,
+			ShopDomain: c.Get("X-Shopify-Shop-Domain"),
+			WebhookID:  c.Get("X-Shopify-Webhook-Id"),
+			ApiVersion: c.Get("X-Shopify-API-Version"),
+			// fiber reuses the body buffer once the handler returns
+			Body: append([]byte(nil), c.Body()...),
+		}
+
+		logger := r.Logger.With(zap.String("topic", req.Topic), zap.String("shop", req.ShopDomain), zap.String("webhookId", req.WebhookID))
+
+		if webhook.Handler != nil {
+			if err := webhook.Handler(c.UserContext(), req); err != nil {
+				logger.Error("failed to handle webhook", zap.Error(err))
+				return fiber.NewError(http.StatusInternalServerError, "failed to handle webhook")
+			}
+		}
+
+		if webhook.NewEvent != nil {
+			event, err := webhook.NewEvent(req)
+			if err != nil {
+				logger.Error("failed to build webhook event", zap.Error(err))
+				return fiber.NewError(http.StatusBadRequest, "invalid webhook payload")
+			}
+
+			if err := r.EventBus.Publish(c.UserContext(), event); err != nil {
+				logger.Error("failed to publish webhook event", zap.Error(err))
+				return fiber.NewError(http.StatusInternalServerError, "failed to publish webhook event")
+			}
+		}
+
+		return c.SendStatus(http.StatusOK)
+	}
+}
@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/feature/shopifyapp/middleware"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/aiocean/wireset/shopifysvc"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	testClientSecret = "client-secret"
+	testShopDomain   = "shop.myshopify.com"
+)
+
+type testUninstalledEvt struct {
+	MyshopifyDomain string
+	ID              int64
+}
+
+type registryFixture struct {
+	registry *Registry
+	app      *fiber.App
+	messages <-chan *message.Message
+}
+
+func newRegistryFixture(t *testing.T, clientSecret string) *registryFixture {
+	t.Helper()
+
+	channel := gochannel.NewGoChannel(gochannel.Config{OutputChannelBuffer: 10}, watermill.NopLogger{})
+	t.Cleanup(func() { _ = channel.Close() })
+
+	messages, err := channel.Subscribe(context.Background(), cqrs.JSONMarshaler{}.Name(&testUninstalledEvt{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventBus, err := pubsub.NewEventBus(channel, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpRegistry := fiberapp.NewRegistry(&configsvc.ConfigService{ServiceName: "webhook-test"}, nil)
+	verifier := middleware.NewWebhookVerifier(&shopifysvc.Config{ClientSecret: clientSecret}, zap.NewNop())
+	registry := NewRegistry(httpRegistry, eventBus, verifier, zap.NewNop())
+
+	if err := registry.Add(&Webhook{
+		Topic: "APP_UNINSTALLED",
+		NewEvent: func(req *Request) (any, error) {
+			return &testUninstalledEvt{MyshopifyDomain: req.ShopDomain, ID: req.Payload().Get("id").Int()}, nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: fiberapp.NewErrorHandler(zap.NewNop())})
+	httpRegistry.RegisterHandlers(app)
+
+	return &registryFixture{registry: registry, app: app, messages: messages}
+}
+
+func (f *registryFixture) post(t *testing.T, path string, body []byte, signature string) int {
+	t.Helper()
+
+	req := httptest.NewRequest(fiber.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Shopify-Shop-Domain", testShopDomain)
+	if signature != "" {
+		req.Header.Set(middleware.HeaderShopifyHmac, signature)
+	}
+
+	resp, err := f.app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode
+}
+
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestRegistryMountsTheWebhookBehindTheVerifier(t *testing.T) {
+	f := newRegistryFixture(t, testClientSecret)
+
+	handler := f.registry.HttpRegistry.GetHttpHandler(fiber.MethodPost, "/webhook/shopify/app-uninstalled")
+	if handler == nil {
+		t.Fatal("the webhook is not mounted on its callback path")
+	}
+	if handler.Auth != fiberapp.AuthShopifyWebhook {
+		t.Fatalf("the webhook route has the %s auth policy, want %s", handler.Auth.Kind, fiberapp.AuthShopifyWebhook.Kind)
+	}
+}
+
+func TestRegistryPublishesTheEventOfASignedWebhook(t *testing.T) {
+	f := newRegistryFixture(t, testClientSecret)
+	body := []byte(`{"id":42}`)
+
+	if status := f.post(t, "/webhook/shopify/app-uninstalled", body, sign(body, testClientSecret)); status != fiber.StatusOK {
+		t.Fatalf("status is %d, want %d", status, fiber.StatusOK)
+	}
+
+	select {
+	case msg := <-f.messages:
+		msg.Ack()
+		var evt testUninstalledEvt
+		if err := json.Unmarshal(msg.Payload, &evt); err != nil {
+			t.Fatal(err)
+		}
+		if evt.MyshopifyDomain != testShopDomain || evt.ID != 42 {
+			t.Fatalf("published %+v, want the event built by NewEvent", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the event was not published")
+	}
+}
+
+func TestRegistryRejectsTheWebhooksWithAnInvalidSignature(t *testing.T) {
+	body := []byte(`{"id":42}`)
+
+	tests := []struct {
+		name         string
+		clientSecret string
+		signature    string
+	}{
+		{name: "wrong signature", clientSecret: testClientSecret, signature: sign(body, "another-secret")},
+		{name: "missing signature", clientSecret: testClientSecret, signature: ""},
+		{name: "signature is not base64", clientSecret: testClientSecret, signature: "%%%"},
+		{name: "empty client secret", clientSecret: "", signature: sign(body, "")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newRegistryFixture(t, test.clientSecret)
+
+			if status := f.post(t, "/webhook/shopify/app-uninstalled", body, test.signature); status != fiber.StatusUnauthorized {
+				t.Fatalf("status is %d, want %d", status, fiber.StatusUnauthorized)
+			}
+
+			select {
+			case msg := <-f.messages:
+				t.Fatalf("the event %s of a rejected webhook was published", msg.UUID)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
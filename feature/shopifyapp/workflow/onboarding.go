@@ -0,0 +1,70 @@
+package workflow
+
+import (
+	"context"
+	"time"
+
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
+)
+
+const OnboardingSagaName = "shop-onboarding"
+
+// NewOnboardingSaga models the install flow of a shop, correlated by the shop id:
+// the shop is installed, its firebase user is created, then its webhooks are installed.
+// Each step is retried by its own handler. An onboarding which is not completed in time is undone:
+// the firebase user and the shop are deleted, so the next check in of the shop onboards it again.
+func NewOnboardingSaga() *pubsub.Saga {
+	return &pubsub.Saga{
+		Name:    OnboardingSagaName,
+		Timeout: 15 * time.Minute,
+		Steps: []*pubsub.SagaStep{
+			{
+				Name: "installed",
+				SagaTrigger: pubsub.SagaTrigger{
+					Event: &model.ShopInstalledEvt{},
+					Key: func(event any) string {
+						return event.(*model.ShopInstalledEvt).ShopID
+					},
+				},
+				Then: func(ctx context.Context, state *pubsub.SagaState, event any) ([]any, error) {
+					state.Data["myshopifyDomain"] = event.(*model.ShopInstalledEvt).MyshopifyDomain
+					return nil, nil
+				},
+				Compensate: func(ctx context.Context, state *pubsub.SagaState) ([]any, error) {
+					return []any{&model.DeleteShopCmd{ShopID: state.Key}}, nil
+				},
+			},
+			{
+				Name: "user-created",
+				SagaTrigger: pubsub.SagaTrigger{
+					Event: &model.ShopUserCreatedEvt{},
+					Key: func(event any) string {
+						return event.(*model.ShopUserCreatedEvt).ShopID
+					},
+				},
+				Then: func(ctx context.Context, state *pubsub.SagaState, event any) ([]any, error) {
+					evt := event.(*model.ShopUserCreatedEvt)
+					return []any{
+						&model.InstallWebhookCmd{
+							ShopID:          evt.ShopID,
+							MyshopifyDomain: evt.MyshopifyDomain,
+						},
+					}, nil
+				},
+				Compensate: func(ctx context.Context, state *pubsub.SagaState) ([]any, error) {
+					return []any{&model.DeleteShopUserCmd{ShopID: state.Key}}, nil
+				},
+			},
+			{
+				Name: "webhooks-installed",
+				SagaTrigger: pubsub.SagaTrigger{
+					Event: &model.WebhooksInstalledEvt{},
+					Key: func(event any) string {
+						return event.(*model.WebhooksInstalledEvt).ShopID
+					},
+				},
+			},
+		},
+	}
+}
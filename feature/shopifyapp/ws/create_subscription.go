@@ -1,17 +1,19 @@
 package ws
 
 import (
+	"github.com/aiocean/wireset/apperror"
 	"github.com/aiocean/wireset/feature/realtime/models"
 	models2 "github.com/aiocean/wireset/feature/shopifyapp/models"
 	"github.com/aiocean/wireset/repository"
 	"github.com/aiocean/wireset/shopifysvc"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/tidwall/gjson"
+	"net/http"
 )
 
 type CreateSubscriptionHandler struct {
 	ShopifySvc *shopifysvc.ShopifyService
-	TokenRepo  *repository.TokenRepository
+	TokenRepo  repository.TokenRepository
 }
 
 func (h *CreateSubscriptionHandler) Handle(conn *websocket.Conn, payload *gjson.Result) error {
@@ -22,12 +24,7 @@ func (h *CreateSubscriptionHandler) Handle(conn *websocket.Conn, payload *gjson.
 
 	result, err := client.CreateSubscription(1)
 	if err != nil {
-		return conn.WriteJSON(models.WebsocketMessage{
-			Topic: models.TopicError,
-			Payload: models.ErrorPayload{
-				Message: err.Error(),
-			},
-		})
+		return conn.WriteJSON(models.NewErrorMessage(err))
 	}
 
 	confirmUrl := result.Get("appSubscriptionCreate.confirmationUrl").String()
@@ -40,9 +37,7 @@ func (h *CreateSubscriptionHandler) Handle(conn *websocket.Conn, payload *gjson.
 		})
 	}
 
-	return conn.WriteJSON(models.WebsocketMessage{
-		Topic: models.TopicError,
-		Payload: models.ErrorPayload{
-			Message: "Failed to create subscription",
-		}})
+	return conn.WriteJSON(models.NewErrorMessage(
+		apperror.New(apperror.CodeShopifyAPI, http.StatusBadGateway, "failed to create subscription"),
+	))
 }
@@ -1,15 +1,23 @@
 package ws
 
 import (
+	"context"
+	"time"
+
 	"github.com/aiocean/wireset/feature/realtime/models"
 	models2 "github.com/aiocean/wireset/feature/shopifyapp/models"
+	"github.com/aiocean/wireset/repository"
 	"github.com/aiocean/wireset/shopifysvc"
 	"github.com/gofiber/contrib/websocket"
+	"github.com/pkg/errors"
 	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
 )
 
 type FetchActivateSubscriptionHandler struct {
 	ShopifySvc *shopifysvc.ShopifyService
+	PlanRepo   repository.PlanRepository
+	Logger     *zap.Logger
 }
 
 func (h *FetchActivateSubscriptionHandler) Handle(conn *websocket.Conn, payload *gjson.Result) error {
@@ -20,15 +28,15 @@ func (h *FetchActivateSubscriptionHandler) Handle(conn *websocket.Conn, payload
 	client := h.ShopifySvc.GetShopifyClient(shopifyDomain, accessToken)
 
 	currentSubscription, err := client.GetSubscription()
+	if errors.Is(err, shopifysvc.ErrorSubscriptionNotFound) {
+		h.syncPlan(conn, nil)
+	}
 	if err != nil {
-		return conn.WriteJSON(models.WebsocketMessage{
-			Topic: models.TopicError,
-			Payload: models.ErrorPayload{
-				Message: err.Error(),
-			},
-		})
+		return conn.WriteJSON(models.NewErrorMessage(err))
 	}
 
+	h.syncPlan(conn, currentSubscription)
+
 	return conn.WriteJSON(models.WebsocketMessage{
 		Topic: models2.TopicSetActivateSubscription,
 		Payload: models2.SetActivateSubscriptionPayload{
@@ -37,3 +45,15 @@ func (h *FetchActivateSubscriptionHandler) Handle(conn *websocket.Conn, payload
 		},
 	})
 }
+
+// syncPlan keeps the plan of the shop in line with the subscription it just read, a failure is only logged.
+func (h *FetchActivateSubscriptionHandler) syncPlan(conn *websocket.Conn, subscription *shopifysvc.Subscription) {
+	shopID, ok := conn.Locals("shopID").(string)
+	if !ok || shopID == "" {
+		return
+	}
+
+	if err := repository.SyncShopPlan(context.Background(), h.PlanRepo, shopID, subscription, time.Now()); err != nil {
+		h.Logger.Error("failed to sync the shop plan", zap.String("shopID", shopID), zap.Error(err))
+	}
+}
@@ -0,0 +1,41 @@
+package ws
+
+import (
+	"context"
+
+	"github.com/aiocean/wireset/feature/realtime/models"
+	models2 "github.com/aiocean/wireset/feature/shopifyapp/models"
+	"github.com/aiocean/wireset/repository"
+	"github.com/gofiber/contrib/websocket"
+	"go.uber.org/zap"
+)
+
+// ShopStateWatcher pushes the state of the shop to its socket while it is connected.
+type ShopStateWatcher struct {
+	StateRepo repository.StateRepository
+	Logger    *zap.Logger
+}
+
+func (h *ShopStateWatcher) Watch(ctx context.Context, conn *websocket.Conn, send func(message any) error) {
+	shopID, ok := conn.Locals("shopID").(string)
+	if !ok || shopID == "" {
+		return
+	}
+
+	logger := h.Logger.With(zap.String("shopID", shopID))
+
+	states, err := h.StateRepo.WatchShopState(ctx, shopID)
+	if err != nil {
+		logger.Error("failed to watch the shop state", zap.Error(err))
+		return
+	}
+
+	for state := range states {
+		if err := send(models.WebsocketMessage{
+			Topic:   models2.TopicShopState,
+			Payload: models2.ShopStatePayload{State: state},
+		}); err != nil {
+			logger.Info("failed to push the shop state", zap.Error(err))
+		}
+	}
+}
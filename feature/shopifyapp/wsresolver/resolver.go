@@ -5,6 +5,7 @@ package wsresolver
 import (
 	"errors"
 	"github.com/aiocean/wireset/feature/realtime/resolver"
+	"github.com/aiocean/wireset/fiberapp"
 	"github.com/aiocean/wireset/shopifysvc"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/wire"
@@ -21,6 +22,11 @@ type JwtIdentityResolver struct {
 	ShopifyConfig *shopifysvc.Config
 }
 
+// AuthPolicy makes the websocket endpoint verify the session token, Resolve reads the shop it stores.
+func (j JwtIdentityResolver) AuthPolicy() fiberapp.AuthPolicy {
+	return fiberapp.AuthShopifySession
+}
+
 func (j JwtIdentityResolver) Resolve(c *fiber.Ctx) (*resolver.Identity, error) {
 	myshopifyDomain, ok := c.Locals("myshopifyDomain").(string)
 	if !ok {
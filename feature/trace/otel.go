@@ -0,0 +1,29 @@
+package trace
+
+import (
+	"github.com/aiocean/wireset/otelsvc"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/wire"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// OtelWireset traces with OpenTelemetry instead of Datadog, it requires otelsvc.DefaultWireset.
+var OtelWireset = wire.NewSet(
+	wire.Struct(new(FeatureOtelTrace), "*"),
+)
+
+// FeatureOtelTrace traces the http requests, the message handlers and the Shopify api calls with OpenTelemetry.
+type FeatureOtelTrace struct {
+	TracerProvider *sdktrace.TracerProvider
+	FiberApp       *fiber.App
+}
+
+func (f *FeatureOtelTrace) Name() string {
+	return "trace"
+}
+
+func (f *FeatureOtelTrace) Init() error {
+	// the tracer provider is global once it is created, the messages and the Shopify calls use it
+	f.FiberApp.Use(otelsvc.FiberMiddleware)
+	return nil
+}
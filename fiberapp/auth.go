@@ -0,0 +1,151 @@
+package fiberapp
+
+import (
+	"github.com/aiocean/wireset/apperror"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// AuthKind names how a request is authenticated, the registry picks the verifier by it.
+type AuthKind string
+
+const (
+	AuthKindPublic         AuthKind = "public"
+	AuthKindShopifySession AuthKind = "shopify-session"
+	AuthKindShopifyWebhook AuthKind = "shopify-webhook"
+	AuthKindFirebaseUser   AuthKind = "firebase-user"
+	AuthKindAPIKey         AuthKind = "api-key"
+	AuthKindCasbin         AuthKind = "casbin"
+)
+
+// AuthPolicy is the auth requirement of a route. The zero value is undeclared,
+// such routes are rejected by Validate, so a route is never public by mistake.
+type AuthPolicy struct {
+	Kind AuthKind
+	// Object and Action of the casbin permission, they default to the route path and the method.
+	Object string
+	Action string
+}
+
+var (
+	AuthPublic         = AuthPolicy{Kind: AuthKindPublic}
+	AuthShopifySession = AuthPolicy{Kind: AuthKindShopifySession}
+	AuthShopifyWebhook = AuthPolicy{Kind: AuthKindShopifyWebhook}
+	AuthFirebaseUser   = AuthPolicy{Kind: AuthKindFirebaseUser}
+	AuthAPIKey         = AuthPolicy{Kind: AuthKindAPIKey}
+)
+
+// AuthPermission requires the casbin permission to act on the object.
+func AuthPermission(object, action string) AuthPolicy {
+	return AuthPolicy{
+		Kind:   AuthKindCasbin,
+		Object: object,
+		Action: action,
+	}
+}
+
+func (p AuthPolicy) IsDeclared() bool {
+	return p.Kind != ""
+}
+
+// AuthVerifier authenticates the requests of one kind of policy.
+type AuthVerifier interface {
+	AuthKind() AuthKind
+	// Verify returns an error when the request is not authenticated,
+	// otherwise it stores the identity in the locals, and the subject with SetAuthSubject.
+	Verify(c *fiber.Ctx, policy AuthPolicy) error
+}
+
+// SecuritySchemeProvider is implemented by the verifiers which describe their scheme in the OpenAPI document.
+type SecuritySchemeProvider interface {
+	SecurityScheme() *SecurityScheme
+}
+
+const (
+	authSubjectKey = "authSubject"
+	authPolicyKey  = "authPolicy"
+)
+
+// SetAuthSubject stores who sent the request, such as the shop domain or the user id.
+func SetAuthSubject(c *fiber.Ctx, subject string) {
+	c.Locals(authSubjectKey, subject)
+}
+
+// AuthSubject returns the subject which the verifier of the route stored.
+func AuthSubject(c *fiber.Ctx) string {
+	subject, _ := c.Locals(authSubjectKey).(string)
+	return subject
+}
+
+// AddAuthVerifiers adds the verifiers, one per kind.
+func (r *Registry) AddAuthVerifiers(verifiers ...AuthVerifier) {
+	for _, verifier := range verifiers {
+		r.AuthVerifiers[verifier.AuthKind()] = verifier
+	}
+}
+
+// AddAuthPolicy declares the policy of the requests under path, it runs before the middlewares of path.
+// Use it when a middleware needs the identity, the handlers still declare their own policy.
+func (r *Registry) AddAuthPolicy(path string, policy AuthPolicy) {
+	r.AuthPolicies[path] = policy
+}
+
+// Validate checks that every route declares its policy, and that a verifier handles it.
+func (r *Registry) Validate() error {
+	for _, handler := range r.HttpHandlers {
+		if err := r.validatePolicy(handler.Auth); err != nil {
+			return errors.WithMessagef(err, "%s %s", handler.Method, handler.Path)
+		}
+	}
+
+	for path, policy := range r.AuthPolicies {
+		if err := r.validatePolicy(policy); err != nil {
+			return errors.WithMessagef(err, "middleware %s", path)
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) validatePolicy(policy AuthPolicy) error {
+	if !policy.IsDeclared() {
+		return errors.New("auth policy is not declared")
+	}
+
+	if policy.Kind == AuthKindPublic {
+		return nil
+	}
+
+	if _, ok := r.AuthVerifiers[policy.Kind]; !ok {
+		return errors.Errorf("no verifier for the %s auth policy", policy.Kind)
+	}
+
+	return nil
+}
+
+// authenticate returns the middleware which verifies the requests with the verifier of the policy.
+func (r *Registry) authenticate(policy AuthPolicy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if policy.Kind == AuthKindPublic {
+			return c.Next()
+		}
+
+		// the middleware of the path already verified the same policy
+		if verified, ok := c.Locals(authPolicyKey).(AuthPolicy); ok && verified == policy {
+			return c.Next()
+		}
+
+		verifier, ok := r.AuthVerifiers[policy.Kind]
+		if !ok {
+			// fail closed, Validate reports it at startup
+			return apperror.Unauthorized("the route has no auth verifier")
+		}
+
+		if err := verifier.Verify(c, policy); err != nil {
+			return err
+		}
+
+		c.Locals(authPolicyKey, policy)
+		return c.Next()
+	}
+}
@@ -1,6 +1,43 @@
 package fiberapp
 
-type APIError struct {
-	Message string `json:"message"`
-	Code    string `json:"code"`
+import (
+	"github.com/aiocean/wireset/apperror"
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// NewErrorHandler renders the errors as RFC 7807 problem details.
+// The messages of unknown errors are logged, never sent to the client.
+func NewErrorHandler(logger *zap.Logger) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		appErr := apperror.From(err)
+		requestID := RequestID(c)
+
+		logger := logsvc.WithTrace(c.UserContext(), logger)
+		fields := []zap.Field{
+			zap.String("code", string(appErr.Code)),
+			zap.Int("status", appErr.Status),
+			zap.String("path", c.Path()),
+			zap.String("requestId", requestID),
+			zap.Error(err),
+		}
+		if appErr.Status >= fiber.StatusInternalServerError {
+			logger.Error("request failed", fields...)
+		} else {
+			logger.Info("request rejected", fields...)
+		}
+
+		problem := apperror.NewProblem(appErr, c.OriginalURL(), requestID)
+		return c.Status(appErr.Status).JSON(problem, apperror.ContentTypeProblem)
+	}
+}
+
+// RequestID returns the id which the requestid middleware assigned to the request.
+func RequestID(c *fiber.Ctx) string {
+	if requestID, ok := c.Locals("requestid").(string); ok {
+		return requestID
+	}
+
+	return c.GetRespHeader(fiber.HeaderXRequestID)
 }
@@ -2,15 +2,13 @@ package fiberapp
 
 import (
 	"encoding/json"
-	"errors"
 	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/logsvc"
 	"github.com/gofiber/contrib/fiberzap/v2"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/healthcheck"
 	"github.com/gofiber/fiber/v2/middleware/idempotency"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/proxy"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/google/wire"
@@ -20,8 +18,11 @@ import (
 	"time"
 )
 
+// DefaultWireset requires a fiber.Storage for the rate limits and the idempotency keys,
+// MemoryStorageWireset or redissvc.FiberStorageWireset.
 var DefaultWireset = wire.NewSet(
 	NewFiberApp,
+	NewLimiter,
 	NewRegistry,
 	NewHealthRegistry,
 )
@@ -30,24 +31,22 @@ type FiberAppConfig struct {
 	BodyLimit   int
 	ServiceName string
 	IdleTimeout time.Duration
-	MaxRequests int
-	RateLimit   time.Duration
 	ProxyURL    string
 }
 
 func NewFiberApp(
-	logsvc *zap.Logger,
+	logSvc *zap.Logger,
 	cfg *configsvc.ConfigService,
 	healthRegistry *HealthRegistry,
+	storage fiber.Storage,
+	limiter *Limiter,
 ) (*fiber.App, func(), error) {
-	logger := logsvc.With(zap.Strings("tags", []string{"fiber"}))
+	logger := logSvc.With(zap.Strings("tags", []string{"fiber"}))
 
 	config := FiberAppConfig{
 		BodyLimit:   50 * 1024 * 1024,
 		ServiceName: cfg.ServiceName,
 		IdleTimeout: 10 * time.Second,
-		MaxRequests: 500,
-		RateLimit:   30 * time.Second,
 		ProxyURL:    os.Getenv("PROXY_URL"),
 	}
 
@@ -58,40 +57,33 @@ func NewFiberApp(
 		JSONDecoder:           json.Unmarshal,
 		DisableStartupMessage: true,
 		IdleTimeout:           config.IdleTimeout,
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			code := fiber.StatusInternalServerError
-
-			var e *fiber.Error
-			if errors.As(err, &e) {
-				code = e.Code
-			}
-
-			logger.Error("error", zap.Error(err))
-
-			return c.Status(code).JSON(fiber.Map{
-				"error": err.Error(),
-			})
-		},
+		ErrorHandler:          NewErrorHandler(logger),
 	})
 
-	// enable middlewares
+	// enable middlewares, the request id first so every error carries it
+	app.Use(requestid.New())
 	app.Use(cors.New())
 	app.Use(fiberzap.New(fiberzap.Config{
 		Logger: logger,
-		SkipURIs: []string{"/healthz"},
+		SkipURIs: []string{"/healthz", "/ready"},
+		// the trace of the request, when a tracer is configured
+		FieldsFunc: func(c *fiber.Ctx) []zap.Field {
+			return logsvc.TraceFields(c.UserContext())
+		},
 	}))
 
+	// the probes answer before the rate limits and the idempotency keys, so they never depend on the storage
+	app.Use(healthRegistry.Handler)
+
 	// compress
 	app.Use(compress.New(compress.Config{
 		Level: compress.LevelBestSpeed,
 	}))
-	app.Use(idempotency.New())
-	app.Use(limiter.New(limiter.Config{
-		Max:               config.MaxRequests,
-		Expiration:        config.RateLimit,
-		LimiterMiddleware: limiter.SlidingWindow{},
+	// the storage shares the idempotency keys and the rate limits between the replicas when it is redis
+	app.Use(idempotency.New(idempotency.Config{
+		Storage: storage,
 	}))
-	app.Use(requestid.New())
+	app.Use(limiter.Handle)
 
 	cleanup := func() {
 		if err := app.Shutdown(); err != nil {
@@ -105,7 +97,7 @@ func NewFiberApp(
 	// this is used for local development, to proxy to the real endpoint
 	if config.ProxyURL != "" {
 		app.Use(func(c *fiber.Ctx) error {
-			if c.Path() == "/healthz" {
+			if c.Path() == "/healthz" || c.Path() == "/ready" {
 				return c.Next()
 			}
 			endpointUrl, _ := url.JoinPath(config.ProxyURL, c.Path())
@@ -113,20 +105,5 @@ func NewFiberApp(
 		})
 	}
 
-	app.Use(healthcheck.New(healthcheck.Config{
-		LivenessProbe: func(c *fiber.Ctx) bool {
-			if err := healthRegistry.Check(); err != nil {
-				return false
-			}
-
-			return true
-		},
-		LivenessEndpoint: "/healthz",
-		ReadinessProbe: func(c *fiber.Ctx) bool {
-			return true
-		},
-		ReadinessEndpoint: "/ready",
-	}))
-
 	return app, cleanup, nil
 }
@@ -1,26 +1,235 @@
 package fiberapp
 
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
 type HealthChecker func() error
 
+const (
+	defaultHealthCheckTimeout  = 2 * time.Second
+	defaultHealthCheckCacheTTL = 5 * time.Second
+)
+
+// HealthCheck is a named check of a dependency.
+type HealthCheck struct {
+	Name string
+	// Check returns an error when the dependency is unhealthy. ctx is done after Timeout.
+	Check func(ctx context.Context) error
+	// Timeout of one run of the check, 2 seconds when 0.
+	Timeout time.Duration
+	// Critical checks make the service unhealthy, the others only degrade it.
+	Critical bool
+	// CacheTTL is how long a result is reused, so frequent probes do not flood the dependency. 5 seconds when 0.
+	CacheTTL time.Duration
+}
+
+type HealthStatus string
+
+const (
+	HealthStatusUp       HealthStatus = "up"
+	HealthStatusDegraded HealthStatus = "degraded"
+	HealthStatusDown     HealthStatus = "down"
+)
+
+// HealthCheckResult is the last result of a check.
+type HealthCheckResult struct {
+	Name      string       `json:"name"`
+	Status    HealthStatus `json:"status"`
+	Critical  bool         `json:"critical"`
+	LatencyMs int64        `json:"latencyMs"`
+	Error     string       `json:"error,omitempty"`
+	CheckedAt time.Time    `json:"checkedAt"`
+}
+
+// HealthReport is the body of the health endpoints.
+type HealthReport struct {
+	Status HealthStatus         `json:"status"`
+	Ready  bool                 `json:"ready"`
+	Checks []*HealthCheckResult `json:"checks"`
+}
+
 type HealthRegistry struct {
-	healthCheckers []HealthChecker
+	mu      sync.RWMutex
+	checks  []*HealthCheck
+	results map[string]*HealthCheckResult
+	ready   atomic.Bool
 }
 
 func NewHealthRegistry() *HealthRegistry {
 	return &HealthRegistry{
-		healthCheckers: []HealthChecker{},
+		checks:  []*HealthCheck{},
+		results: map[string]*HealthCheckResult{},
 	}
 }
 
+// AddHealthCheckers adds critical checks without a name.
 func (r *HealthRegistry) AddHealthCheckers(checkers ...HealthChecker) {
-	r.healthCheckers = append(r.healthCheckers, checkers...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, checker := range checkers {
+		checker := checker
+		r.checks = append(r.checks, &HealthCheck{
+			Name:     fmt.Sprintf("checker-%d", len(r.checks)),
+			Critical: true,
+			Check: func(ctx context.Context) error {
+				return checker()
+			},
+		})
+	}
 }
 
+// AddHealthChecks adds named checks.
+func (r *HealthRegistry) AddHealthChecks(checks ...*HealthCheck) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checks = append(r.checks, checks...)
+}
+
+// SetReady marks the service as ready to receive traffic, or not.
+func (r *HealthRegistry) SetReady(ready bool) {
+	r.ready.Store(ready)
+}
+
+// IsReady reports if SetReady was called with true.
+func (r *HealthRegistry) IsReady() bool {
+	return r.ready.Load()
+}
+
+// Check returns the error of the first failing critical check.
 func (r *HealthRegistry) Check() error {
-	for _, checker := range r.healthCheckers {
-		if err := checker(); err != nil {
-			return err
+	for _, result := range r.Report(context.Background()).Checks {
+		if result.Critical && result.Status == HealthStatusDown {
+			return fmt.Errorf("%s: %s", result.Name, result.Error)
 		}
 	}
+
 	return nil
 }
+
+// Report runs the checks whose cached result is expired, in parallel, and returns every result.
+func (r *HealthRegistry) Report(ctx context.Context) *HealthReport {
+	r.mu.RLock()
+	checks := append([]*HealthCheck(nil), r.checks...)
+	r.mu.RUnlock()
+
+	results := make([]*HealthCheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check *HealthCheck) {
+			defer wg.Done()
+			results[i] = r.result(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := &HealthReport{
+		Status: HealthStatusUp,
+		Ready:  r.IsReady(),
+		Checks: results,
+	}
+
+	for _, result := range results {
+		if result.Status != HealthStatusDown {
+			continue
+		}
+
+		if result.Critical {
+			report.Status = HealthStatusDown
+		} else if report.Status == HealthStatusUp {
+			report.Status = HealthStatusDegraded
+		}
+	}
+
+	return report
+}
+
+func (r *HealthRegistry) result(ctx context.Context, check *HealthCheck) *HealthCheckResult {
+	cacheTTL := check.CacheTTL
+	if cacheTTL == 0 {
+		cacheTTL = defaultHealthCheckCacheTTL
+	}
+
+	r.mu.RLock()
+	cached, ok := r.results[check.Name]
+	r.mu.RUnlock()
+	if ok && time.Since(cached.CheckedAt) < cacheTTL {
+		return cached
+	}
+
+	timeout := check.Timeout
+	if timeout == 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	startedAt := time.Now()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- check.Check(checkCtx)
+	}()
+
+	var err error
+	select {
+	case err = <-errChan:
+	case <-checkCtx.Done():
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	result := &HealthCheckResult{
+		Name:      check.Name,
+		Status:    HealthStatusUp,
+		Critical:  check.Critical,
+		LatencyMs: time.Since(startedAt).Milliseconds(),
+		CheckedAt: time.Now(),
+	}
+	if err != nil {
+		result.Status = HealthStatusDown
+		result.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	r.results[check.Name] = result
+	r.mu.Unlock()
+
+	return result
+}
+
+// Handler answers GET /healthz, 503 when a critical check is down, and GET /ready,
+// 503 until SetReady(true) too. The other requests go to the next handler.
+func (r *HealthRegistry) Handler(c *fiber.Ctx) error {
+	if c.Method() != fiber.MethodGet {
+		return c.Next()
+	}
+
+	switch c.Path() {
+	case "/healthz":
+		report := r.Report(c.UserContext())
+		status := fiber.StatusOK
+		if report.Status == HealthStatusDown {
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(report)
+	case "/ready":
+		// ready once the features are initialized and the message router is running
+		report := r.Report(c.UserContext())
+		status := fiber.StatusOK
+		if !report.Ready || report.Status == HealthStatusDown {
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(report)
+	}
+
+	return c.Next()
+}
@@ -0,0 +1,204 @@
+package fiberapp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+func TestHealthRegistryTimesOutASlowCheck(t *testing.T) {
+	registry := NewHealthRegistry()
+	release := make(chan struct{})
+	defer close(release)
+	registry.AddHealthChecks(&HealthCheck{
+		Name:    "slow",
+		Timeout: 20 * time.Millisecond,
+		Check: func(ctx context.Context) error {
+			// ignores ctx, like a client without a deadline
+			<-release
+			return nil
+		},
+	})
+
+	startedAt := time.Now()
+	report := registry.Report(context.Background())
+	if elapsed := time.Since(startedAt); elapsed > time.Second {
+		t.Fatalf("the report waited %s for the slow check", elapsed)
+	}
+
+	result := report.Checks[0]
+	if result.Status != HealthStatusDown || !strings.Contains(result.Error, "timed out") {
+		t.Fatalf("result is %+v, want a timeout", result)
+	}
+}
+
+func TestHealthRegistryReusesTheResultWithinItsTTL(t *testing.T) {
+	registry := NewHealthRegistry()
+	var calls atomic.Int32
+	registry.AddHealthChecks(&HealthCheck{
+		Name:     "cached",
+		CacheTTL: 50 * time.Millisecond,
+		Check: func(ctx context.Context) error {
+			calls.Add(1)
+			return nil
+		},
+	})
+
+	registry.Report(context.Background())
+	registry.Report(context.Background())
+	if calls.Load() != 1 {
+		t.Fatalf("the check ran %d times within its TTL, want 1", calls.Load())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	registry.Report(context.Background())
+	if calls.Load() != 2 {
+		t.Fatalf("the check ran %d times after its TTL, want 2", calls.Load())
+	}
+}
+
+func TestHealthRegistryStatus(t *testing.T) {
+	failing := func(ctx context.Context) error { return errors.New("unavailable") }
+	passing := func(ctx context.Context) error { return nil }
+
+	tests := []struct {
+		name   string
+		checks []*HealthCheck
+		want   HealthStatus
+		code   int
+	}{
+		{
+			name:   "every check passes",
+			checks: []*HealthCheck{{Name: "db", Critical: true, Check: passing}, {Name: "mail", Check: passing}},
+			want:   HealthStatusUp,
+			code:   fiber.StatusOK,
+		},
+		{
+			name:   "a non critical check fails",
+			checks: []*HealthCheck{{Name: "db", Critical: true, Check: passing}, {Name: "mail", Check: failing}},
+			want:   HealthStatusDegraded,
+			code:   fiber.StatusOK,
+		},
+		{
+			name:   "a critical check fails",
+			checks: []*HealthCheck{{Name: "db", Critical: true, Check: failing}, {Name: "mail", Check: failing}},
+			want:   HealthStatusDown,
+			code:   fiber.StatusServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := NewHealthRegistry()
+			registry.AddHealthChecks(test.checks...)
+			registry.SetReady(true)
+
+			app := fiber.New()
+			app.Use(registry.Handler)
+
+			for _, path := range []string{"/healthz", "/ready"} {
+				code, report := getHealth(t, app, path)
+				if code != test.code || report.Status != test.want {
+					t.Fatalf("%s is %d %s, want %d %s", path, code, report.Status, test.code, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthRegistryIsNotReadyUntilSetReady(t *testing.T) {
+	registry := NewHealthRegistry()
+	app := fiber.New()
+	app.Use(registry.Handler)
+
+	if code, report := getHealth(t, app, "/ready"); code != fiber.StatusServiceUnavailable || report.Ready {
+		t.Fatalf("/ready is %d, ready %v before SetReady(true)", code, report.Ready)
+	}
+	// alive, though not ready
+	if code, _ := getHealth(t, app, "/healthz"); code != fiber.StatusOK {
+		t.Fatalf("/healthz is %d before SetReady(true)", code)
+	}
+
+	registry.SetReady(true)
+	if code, report := getHealth(t, app, "/ready"); code != fiber.StatusOK || !report.Ready {
+		t.Fatalf("/ready is %d, ready %v after SetReady(true)", code, report.Ready)
+	}
+
+	registry.SetReady(false)
+	if code, _ := getHealth(t, app, "/ready"); code != fiber.StatusServiceUnavailable {
+		t.Fatalf("/ready is %d during the shutdown", code)
+	}
+}
+
+func TestHealthEndpointsAreNotRateLimited(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("CONFIG_SECRETS_DIR", t.TempDir())
+	t.Setenv("RATE_LIMIT_MAX", "1")
+	t.Setenv("RATE_LIMIT_WINDOW", "1m")
+
+	storage, cleanupStorage, err := NewMemoryStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupStorage()
+
+	limiter, err := NewLimiter(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewHealthRegistry()
+	registry.SetReady(true)
+	app, _, err := NewFiberApp(zap.NewNop(), &configsvc.ConfigService{ServiceName: "test"}, registry, storage, limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Get("/orders", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/orders", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	response, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/orders", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("the route is %d, want the limit to be reached", response.StatusCode)
+	}
+
+	for _, path := range []string{"/healthz", "/ready"} {
+		if code, _ := getHealth(t, app, path); code != fiber.StatusOK {
+			t.Fatalf("%s is %d once the limit of the client is reached", path, code)
+		}
+	}
+}
+
+func getHealth(t *testing.T, app *fiber.App, path string) (int, *HealthReport) {
+	t.Helper()
+
+	response, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	report := &HealthReport{}
+	if err := json.NewDecoder(response.Body).Decode(report); err != nil {
+		t.Fatal(err)
+	}
+
+	return response.StatusCode, report
+}
@@ -0,0 +1,225 @@
+package fiberapp
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aiocean/wireset/apperror"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// OpenAPIPath serves the OpenAPI document of the registered handlers.
+	OpenAPIPath = "/openapi.json"
+	// DocsPath serves a docs UI of the OpenAPI document, when it is enabled.
+	DocsPath = "/docs"
+)
+
+// OpenAPIDocument is an OpenAPI 3.1 document.
+type OpenAPIDocument struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       OpenAPIInfo                      `json:"info"`
+	Servers    []OpenAPIServer                  `json:"servers,omitempty"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components OpenAPIComponents                `json:"components"`
+	Tags       []OpenAPITag                     `json:"tags,omitempty"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+type OpenAPITag struct {
+	Name string `json:"name"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes how a client authenticates, it is named after the AuthKind of its verifier.
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+var pathParamPattern = regexp.MustCompile(`:([A-Za-z0-9_]+)\??|\*`)
+
+// OpenAPI returns the OpenAPI document of the registered handlers.
+func (r *Registry) OpenAPI() *OpenAPIDocument {
+	schemas := newSchemaBuilder()
+	problem := schemas.Of(apperror.Problem{})
+
+	doc := &OpenAPIDocument{
+		OpenAPI: "3.1.0",
+		Info:    r.OpenAPIInfo,
+		Paths:   map[string]map[string]*Operation{},
+		Components: OpenAPIComponents{
+			Schemas:         schemas.schemas,
+			SecuritySchemes: r.securitySchemes(),
+		},
+	}
+
+	if r.ServerURL != "" {
+		doc.Servers = []OpenAPIServer{{URL: r.ServerURL}}
+	}
+
+	tags := map[string]bool{}
+	for _, handler := range r.HttpHandlers {
+		if handler.Hidden {
+			continue
+		}
+
+		path, params := openAPIPath(handler.Path)
+		operation := &Operation{
+			OperationID: operationID(handler.Method, handler.Path),
+			Summary:     handler.Summary,
+			Description: handler.Description,
+			Tags:        handler.Tags,
+			Parameters:  params,
+			Responses: map[string]*Response{
+				"default": {
+					Description: "The error, as RFC 7807 problem details",
+					Content: map[string]*MediaType{
+						apperror.ContentTypeProblem: {Schema: problem},
+					},
+				},
+			},
+		}
+
+		if handler.Request != nil {
+			operation.RequestBody = &RequestBody{
+				Required: true,
+				Content: map[string]*MediaType{
+					fiber.MIMEApplicationJSON: {Schema: schemas.Of(handler.Request)},
+				},
+			}
+		}
+
+		status := handler.ResponseStatus
+		if status == 0 {
+			status = http.StatusOK
+		}
+		response := &Response{Description: http.StatusText(status)}
+		if handler.Response != nil {
+			response.Content = map[string]*MediaType{
+				fiber.MIMEApplicationJSON: {Schema: schemas.Of(handler.Response)},
+			}
+		}
+		operation.Responses[strconv.Itoa(status)] = response
+
+		if scheme := string(handler.Auth.Kind); doc.Components.SecuritySchemes[scheme] != nil {
+			operation.Security = []map[string][]string{{scheme: {}}}
+		}
+
+		for _, tag := range handler.Tags {
+			tags[tag] = true
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*Operation{}
+		}
+		doc.Paths[path][strings.ToLower(handler.Method)] = operation
+	}
+
+	for tag := range tags {
+		doc.Tags = append(doc.Tags, OpenAPITag{Name: tag})
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool {
+		return doc.Tags[i].Name < doc.Tags[j].Name
+	})
+
+	return doc
+}
+
+func (r *Registry) securitySchemes() map[string]*SecurityScheme {
+	schemes := map[string]*SecurityScheme{}
+	for kind, verifier := range r.AuthVerifiers {
+		if provider, ok := verifier.(SecuritySchemeProvider); ok {
+			schemes[string(kind)] = provider.SecurityScheme()
+		}
+	}
+
+	return schemes
+}
+
+// openAPIPath converts the fiber parameters of path, such as :id, to the OpenAPI form.
+func openAPIPath(path string) (string, []*Parameter) {
+	var params []*Parameter
+	converted := pathParamPattern.ReplaceAllStringFunc(path, func(match string) string {
+		name := strings.TrimSuffix(strings.TrimPrefix(match, ":"), "?")
+		if match == "*" {
+			name = "wildcard"
+		}
+
+		params = append(params, &Parameter{
+			Name: name,
+			In:   "path",
+			// OpenAPI has no optional path parameters
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+
+		return "{" + name + "}"
+	})
+
+	return converted, params
+}
+
+func operationID(method, path string) string {
+	var builder strings.Builder
+	builder.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		builder.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+
+	return builder.String()
+}
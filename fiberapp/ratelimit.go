@@ -0,0 +1,114 @@
+package fiberapp
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/aiocean/wireset/apperror"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/pkg/errors"
+)
+
+// RateLimit limits the requests of a route, in addition to the global limit of the app.
+type RateLimit struct {
+	Max    int
+	Window time.Duration
+	// PerShop counts the requests of each shop, resolved by the auth policy of the route.
+	// The requests are counted per client ip otherwise.
+	PerShop bool
+}
+
+// rateLimiter returns the limiter of the route, its counters are in the storage of the registry.
+func (r *Registry) rateLimiter(handler *HttpHandler) fiber.Handler {
+	limit := handler.RateLimit
+	routeKey := "ratelimit:" + createHandlerID(handler.Method, handler.Path) + ":"
+
+	return limiter.New(limiter.Config{
+		Max:               limit.Max,
+		Expiration:        limit.Window,
+		Storage:           r.Storage,
+		LimiterMiddleware: limiter.SlidingWindow{},
+		KeyGenerator: func(c *fiber.Ctx) string {
+			if limit.PerShop {
+				if shopID := rateLimitShop(c); shopID != "" {
+					return routeKey + "shop:" + shopID
+				}
+			}
+
+			return routeKey + "ip:" + c.IP()
+		},
+		LimitReached: rateLimitReached,
+	})
+}
+
+// rateLimitShop returns the shop id which the shopify session verifier stored, or the subject of the other verifiers.
+func rateLimitShop(c *fiber.Ctx) string {
+	if shopID, ok := c.Locals("shopID").(string); ok && shopID != "" {
+		return shopID
+	}
+
+	return AuthSubject(c)
+}
+
+func rateLimitReached(c *fiber.Ctx) error {
+	return apperror.New(apperror.CodeRateLimited, fiber.StatusTooManyRequests, "too many requests")
+}
+
+// LimiterConfig is the global limit of the app, counted per client ip.
+type LimiterConfig struct {
+	Max    int           `env:"RATE_LIMIT_MAX" default:"500"`
+	Window time.Duration `env:"RATE_LIMIT_WINDOW" default:"30s"`
+}
+
+func (c *LimiterConfig) Validate() error {
+	if c.Max <= 0 {
+		return errors.New("RATE_LIMIT_MAX must be positive")
+	}
+
+	if c.Window < time.Second {
+		return errors.New("RATE_LIMIT_WINDOW must be at least 1s")
+	}
+
+	return nil
+}
+
+// Limiter is the global limiter of the app, its limit can be changed at runtime, see Watch.
+type Limiter struct {
+	storage fiber.Storage
+	handler atomic.Pointer[fiber.Handler]
+}
+
+func NewLimiter(storage fiber.Storage) (*Limiter, error) {
+	config := &LimiterConfig{}
+	if err := configsvc.Load(config); err != nil {
+		return nil, err
+	}
+
+	l := &Limiter{storage: storage}
+	l.Update(config)
+
+	return l, nil
+}
+
+// Update replaces the limit, the requests already counted in the storage are kept.
+func (l *Limiter) Update(config *LimiterConfig) {
+	handler := limiter.New(limiter.Config{
+		Max:               config.Max,
+		Expiration:        config.Window,
+		Storage:           l.storage,
+		LimiterMiddleware: limiter.SlidingWindow{},
+		LimitReached:      rateLimitReached,
+	})
+	l.handler.Store(&handler)
+}
+
+// Watch updates the limit when RATE_LIMIT_MAX or RATE_LIMIT_WINDOW change.
+func (l *Limiter) Watch(watcher *configsvc.Watcher) error {
+	return configsvc.Subscribe(watcher, l.Update)
+}
+
+func (l *Limiter) Handle(c *fiber.Ctx) error {
+	return (*l.handler.Load())(c)
+}
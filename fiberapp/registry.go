@@ -1,23 +1,73 @@
 package fiberapp
 
-import "github.com/gofiber/fiber/v2"
-import "strings"
+import (
+	"os"
+	"strings"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/gofiber/fiber/v2"
+)
 
 type HttpHandler struct {
 	Method   string
 	Path     string
 	Handlers []fiber.Handler
+
+	// The optional metadata below describes the handler in the OpenAPI document.
+	Summary     string
+	Description string
+	Tags        []string
+	// Request is a value of the type of the json body, such as model.Foo{}.
+	Request any
+	// Response is a value of the type of the json response.
+	Response any
+	// ResponseStatus of a success, 200 when 0.
+	ResponseStatus int
+	// Auth is the policy which authenticates the requests, every handler must declare it.
+	Auth AuthPolicy
+	// Hidden handlers are not in the OpenAPI document.
+	Hidden bool
+	// RateLimit of the route, checked after the auth policy so it can count per shop.
+	RateLimit *RateLimit
 }
 
 type Registry struct {
 	HttpHandlers    map[string]*HttpHandler
 	HttpMiddlewares map[string]interface{}
+	AuthVerifiers   map[AuthKind]AuthVerifier
+	AuthPolicies    map[string]AuthPolicy
+	// Storage keeps the counters of the rate limits.
+	Storage     fiber.Storage
+	OpenAPIInfo OpenAPIInfo
+	ServerURL   string
+	// DocsEnabled serves a docs UI at DocsPath.
+	DocsEnabled bool
 }
 
-func NewRegistry() *Registry {
+func NewRegistry(cfg *configsvc.ConfigService, storage fiber.Storage) *Registry {
+	version := os.Getenv("API_VERSION")
+	if version == "" {
+		version = "1.0.0"
+	}
+
+	// the docs are enabled outside production, unless DOCS_ENABLED says otherwise
+	docsEnabled := !cfg.IsProduction()
+	if value, ok := os.LookupEnv("DOCS_ENABLED"); ok {
+		docsEnabled = value == "true"
+	}
+
 	return &Registry{
 		HttpHandlers:    map[string]*HttpHandler{},
 		HttpMiddlewares: map[string]interface{}{},
+		AuthVerifiers:   map[AuthKind]AuthVerifier{},
+		AuthPolicies:    map[string]AuthPolicy{},
+		OpenAPIInfo: OpenAPIInfo{
+			Title:   cfg.ServiceName,
+			Version: version,
+		},
+		ServerURL:   cfg.ServiceUrl,
+		DocsEnabled: docsEnabled,
+		Storage:     storage,
 	}
 }
 
@@ -42,12 +92,54 @@ func createHandlerID(method, path string) string {
 
 func (r *Registry) RegisterHandlers(app *fiber.App) {
 	for _, handler := range r.HttpHandlers {
-		app.Add(handler.Method, handler.Path, handler.Handlers...)
+		handlers := []fiber.Handler{r.authenticate(handler.Auth)}
+		if handler.RateLimit != nil {
+			handlers = append(handlers, r.rateLimiter(handler))
+		}
+		handlers = append(handlers, handler.Handlers...)
+		app.Add(handler.Method, handler.Path, handlers...)
+	}
+
+	// the document is built once, every handler is registered by now
+	document := r.OpenAPI()
+	app.Get(OpenAPIPath, func(c *fiber.Ctx) error {
+		return c.JSON(document)
+	})
+
+	if r.DocsEnabled {
+		app.Get(DocsPath, func(c *fiber.Ctx) error {
+			c.Type("html")
+			return c.SendString(docsPage)
+		})
 	}
 }
 
+// RegisterMiddlewares registers the auth policies of the paths, then the middlewares.
 func (r *Registry) RegisterMiddlewares(app *fiber.App) {
+	for path, policy := range r.AuthPolicies {
+		app.Use(path, r.authenticate(policy))
+	}
+
 	for path, middleware := range r.HttpMiddlewares {
 		app.Use(path, middleware)
 	}
 }
+
+// docsPage renders the OpenAPI document with Swagger UI.
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>API docs</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "` + OpenAPIPath + `", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>`
@@ -0,0 +1,122 @@
+package fiberapp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/wire"
+)
+
+// MemoryStorageWireset keeps the rate limits and the idempotency keys in memory, they are per pod.
+// Use redissvc.FiberStorageWireset when the service runs several replicas.
+var MemoryStorageWireset = wire.NewSet(
+	NewMemoryStorage,
+	wire.Bind(new(fiber.Storage), new(*MemoryStorage)),
+)
+
+const memoryStorageGCInterval = time.Minute
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryStorage is a fiber.Storage in memory, the expired entries are removed every minute.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	entries map[string]memoryEntry
+	done    chan struct{}
+	once    sync.Once
+}
+
+func NewMemoryStorage() (*MemoryStorage, func(), error) {
+	storage := &MemoryStorage{
+		entries: map[string]memoryEntry{},
+		done:    make(chan struct{}),
+	}
+
+	go storage.gc()
+
+	cleanup := func() {
+		_ = storage.Close()
+	}
+
+	return storage, cleanup, nil
+}
+
+func (s *MemoryStorage) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	entry, ok := s.entries[key]
+	s.mu.RUnlock()
+
+	if !ok || (!entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt)) {
+		return nil, nil
+	}
+
+	return entry.value, nil
+}
+
+func (s *MemoryStorage) Set(key string, value []byte, exp time.Duration) error {
+	if key == "" || len(value) == 0 {
+		return nil
+	}
+
+	entry := memoryEntry{
+		// the caller may reuse the buffer
+		value: append([]byte(nil), value...),
+	}
+	if exp > 0 {
+		entry.expiresAt = time.Now().Add(exp)
+	}
+
+	s.mu.Lock()
+	s.entries[key] = entry
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *MemoryStorage) Delete(key string) error {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *MemoryStorage) Reset() error {
+	s.mu.Lock()
+	s.entries = map[string]memoryEntry{}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *MemoryStorage) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	return nil
+}
+
+func (s *MemoryStorage) gc() {
+	ticker := time.NewTicker(memoryStorageGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, entry := range s.entries {
+				if !entry.expiresAt.IsZero() && now.After(entry.expiresAt) {
+					delete(s.entries, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -3,23 +3,26 @@ package firebasesvc
 import (
 	"encoding/base64"
 	"fmt"
-	"os"
+
+	"github.com/aiocean/wireset/configsvc"
 )
 
 type FirebaseCfg struct {
 	Credentials []byte
 }
 
+// firebaseEnv is bound by configsvc.Loader, the credential is base64 encoded.
+type firebaseEnv struct {
+	Credential string `env:"FIREBASE_CREDENTIAL" required:"true" secret:"true"`
+}
+
 func NewFirebaseCfg() (*FirebaseCfg, error) {
-	credential := os.Getenv("FIREBASE_CREDENTIAL")
-	if credential == "" {
-		return nil, fmt.Errorf("FIREBASE_CREDENTIAL is empty")
+	env := &firebaseEnv{}
+	if err := configsvc.Load(env); err != nil {
+		return nil, err
 	}
 
-	//credential is base64 encoded
-
-	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(credential)))
-	_, err := base64.StdEncoding.Decode(decoded, []byte(credential))
+	decoded, err := base64.StdEncoding.DecodeString(env.Credential)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode FIREBASE_CREDENTIAL: %w", err)
 	}
@@ -2,8 +2,11 @@ package firestoresvc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/aiocean/wireset/fiberapp"
 	"github.com/aiocean/wireset/firebasesvc"
+	"google.golang.org/api/iterator"
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go"
@@ -19,6 +22,7 @@ var DefaultWireset = wire.NewSet(
 func NewFirestoreSvc(
 	app *firebase.App,
 	logger *zap.Logger,
+	healthRegistry *fiberapp.HealthRegistry,
 ) (*firestore.Client, func(), error) {
 	ctx := context.Background()
 
@@ -27,6 +31,17 @@ func NewFirestoreSvc(
 		return nil, nil, fmt.Errorf("error initializing app: %v", err)
 	}
 
+	healthRegistry.AddHealthChecks(&fiberapp.HealthCheck{
+		Name:     "firestore",
+		Critical: true,
+		Check: func(ctx context.Context) error {
+			if _, err := client.Collections(ctx).Next(); err != nil && !errors.Is(err, iterator.Done) {
+				return err
+			}
+			return nil
+		},
+	})
+
 	localLogger := logger.With(zap.Strings("tags", []string{"FirestoreSvc"}))
 
 	cleanup := func() {
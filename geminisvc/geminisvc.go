@@ -4,11 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/aiocean/wireset/cachesvc"
+	"github.com/aiocean/wireset/configsvc"
 	"github.com/google/generative-ai-go/genai"
 	"github.com/pkg/errors"
 	"google.golang.org/api/option"
-	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,14 +17,35 @@ type GeminiSvc struct {
 	Client   *genai.Client
 	Model    *genai.GenerativeModel
 	Cachesvc *cachesvc.CacheService
+
+	mu          sync.RWMutex
+	modelConfig *ModelConfig
+}
+
+// Config is bound by configsvc.Loader.
+type Config struct {
+	APIKey string `env:"VERTEX_API_KEY" required:"true" secret:"true"`
+}
+
+// ModelConfig names the models, it can be changed at runtime, see Watch.
+type ModelConfig struct {
+	Model          string `env:"GEMINI_MODEL" default:"gemini-1.0-pro-latest"`
+	AnswerModel    string `env:"GEMINI_ANSWER_MODEL" default:"gemini-pro"`
+	EmbeddingModel string `env:"GEMINI_EMBEDDING_MODEL" default:"embedding-001"`
 }
 
 func NewGeminiSvcFromEnv(ctx context.Context, cacheSvc *cachesvc.CacheService) (*GeminiSvc, func(), error) {
-	apiKey := os.Getenv("VERTEX_API_KEY")
-	if apiKey == "" {
-		return nil, nil, errors.New("missing VERTEX_API_KEY")
+	config := &Config{}
+	if err := configsvc.Load(config); err != nil {
+		return nil, nil, err
 	}
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+
+	modelConfig := &ModelConfig{}
+	if err := configsvc.Load(modelConfig); err != nil {
+		return nil, nil, err
+	}
+
+	client, err := genai.NewClient(ctx, option.WithAPIKey(config.APIKey))
 	if err != nil {
 		return nil, nil, errors.WithMessage(err, "failed to create genai client")
 	}
@@ -34,7 +56,18 @@ func NewGeminiSvcFromEnv(ctx context.Context, cacheSvc *cachesvc.CacheService) (
 		}
 	}
 
-	model := client.GenerativeModel("gemini-1.0-pro-latest")
+	svc := &GeminiSvc{
+		Client:   client,
+		Cachesvc: cacheSvc,
+	}
+	svc.UpdateModels(modelConfig)
+
+	return svc, cleanup, nil
+}
+
+// UpdateModels replaces the models, the chat sessions already started keep their model.
+func (f *GeminiSvc) UpdateModels(config *ModelConfig) {
+	model := f.Client.GenerativeModel(config.Model)
 	model.SafetySettings = []*genai.SafetySetting{
 		{
 			Category:  genai.HarmCategoryDangerousContent,
@@ -45,11 +78,23 @@ func NewGeminiSvcFromEnv(ctx context.Context, cacheSvc *cachesvc.CacheService) (
 	candidateCount := int32(1)
 	model.CandidateCount = &candidateCount
 
-	return &GeminiSvc{
-		Client:   client,
-		Cachesvc: cacheSvc,
-		Model:    model,
-	}, cleanup, nil
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Model = model
+	f.modelConfig = config
+}
+
+// Watch updates the models when GEMINI_MODEL, GEMINI_ANSWER_MODEL or GEMINI_EMBEDDING_MODEL change.
+func (f *GeminiSvc) Watch(watcher *configsvc.Watcher) error {
+	return configsvc.Subscribe(watcher, f.UpdateModels)
+}
+
+func (f *GeminiSvc) models() (*genai.GenerativeModel, *ModelConfig) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.Model, f.modelConfig
 }
 
 // GetSession returns a chat session
@@ -60,7 +105,8 @@ func (f *GeminiSvc) GetSession(sessionID string) (*genai.ChatSession, error) {
 		return session, nil
 	}
 
-	session := f.Model.StartChat()
+	model, _ := f.models()
+	session := model.StartChat()
 	f.Cachesvc.SetWithTTL(sessionID, session, time.Minute*5)
 
 	return session, nil
@@ -99,7 +145,8 @@ func (f *GeminiSvc) SendMessage(ctx context.Context, message string, sessionID s
 
 // EmbedDoc generates an embedding for retrieval
 func (f *GeminiSvc) EmbedDoc(ctx context.Context, title, content string) ([]float32, error) {
-	model := f.Client.EmbeddingModel("embedding-001")
+	_, config := f.models()
+	model := f.Client.EmbeddingModel(config.EmbeddingModel)
 
 	resp, err := model.EmbedContentWithTitle(ctx, title, genai.Text(content))
 	if err != nil {
@@ -111,7 +158,8 @@ func (f *GeminiSvc) EmbedDoc(ctx context.Context, title, content string) ([]floa
 
 // EmbedQuery generates an embedding for a query
 func (f *GeminiSvc) EmbedQuery(ctx context.Context, query string) ([]float32, error) {
-	model := f.Client.EmbeddingModel("embedding-001")
+	_, config := f.models()
+	model := f.Client.EmbeddingModel(config.EmbeddingModel)
 	model.TaskType = genai.TaskTypeRetrievalQuery
 
 	resp, err := model.EmbedContent(ctx, genai.Text(query))
@@ -124,7 +172,8 @@ func (f *GeminiSvc) EmbedQuery(ctx context.Context, query string) ([]float32, er
 
 // GenerateAnswer generates an answer for a query
 func (f *GeminiSvc) GenerateAnswer(ctx context.Context, dataContext, query string) (*genai.GenerateContentResponse, error) {
-	model := f.Client.GenerativeModel("gemini-pro")
+	_, config := f.models()
+	model := f.Client.GenerativeModel(config.AnswerModel)
 	candidateCount := int32(1)
 	model.CandidateCount = &candidateCount
 	chatSession := model.StartChat()
@@ -168,7 +217,8 @@ func (f *GeminiSvc) ParseStructuredData(ctx context.Context, examples []ParseStr
 	parts = append(parts, genai.Text("Input: "+data))
 	parts = append(parts, genai.Text("Output: "))
 
-	resp, err := f.Model.GenerateContent(ctx, parts...)
+	model, _ := f.models()
+	resp, err := model.GenerateContent(ctx, parts...)
 	if err != nil {
 		return errors.WithMessage(err, "failed to generate answer")
 	}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/aiocean/wireset/configsvc"
 	"github.com/google/wire"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -17,6 +18,8 @@ type Config struct {
 	Environment string
 	TimeZone    *time.Location
 	LogLevel    zapcore.Level
+	// Level of the logger, it can be changed at runtime, see WatchLevel.
+	Level zap.AtomicLevel
 }
 
 // DefaultConfig returns a default configuration for the logging service
@@ -37,11 +40,17 @@ func DefaultConfig() (*Config, error) {
 		Environment: environment,
 		TimeZone:    loc,
 		LogLevel:    logLevel,
+		Level:       zap.NewAtomicLevelAt(logLevel),
 	}, nil
 }
 
-// NewLogger creates a new zap logger based on the provided configuration
+// NewLogger creates a new zap logger based on the provided configuration.
+// A config without Level starts at LogLevel.
 func NewLogger(config *Config) (*zap.Logger, error) {
+	if config.Level == (zap.AtomicLevel{}) {
+		config.Level = zap.NewAtomicLevelAt(config.LogLevel)
+	}
+
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "ts",
 		LevelKey:       "level",
@@ -58,7 +67,7 @@ func NewLogger(config *Config) (*zap.Logger, error) {
 	}
 
 	zapConfig := zap.Config{
-		Level:             zap.NewAtomicLevelAt(config.LogLevel),
+		Level:             config.Level,
 		Development:       config.Environment == "development",
 		EncoderConfig:     encoderConfig,
 		DisableStacktrace: config.Environment != "development",
@@ -72,7 +81,7 @@ func NewLogger(config *Config) (*zap.Logger, error) {
 		return zapcore.NewCore(
 			zapcore.NewJSONEncoder(encoderConfig),
 			zapcore.AddSync(os.Stderr),
-			config.LogLevel,
+			config.Level,
 		)
 	}))
 
@@ -82,3 +91,29 @@ func NewLogger(config *Config) (*zap.Logger, error) {
 
 	return logger.WithOptions(zap.WithCaller(config.Environment == "development")), nil
 }
+
+// LevelConfig is the runtime level of the logger, the default of the environment when it is empty.
+type LevelConfig struct {
+	Level string `env:"LOG_LEVEL"`
+}
+
+func (c *LevelConfig) Validate() error {
+	if c.Level == "" {
+		return nil
+	}
+
+	_, err := zapcore.ParseLevel(c.Level)
+	return err
+}
+
+// WatchLevel changes the level of the logger when LOG_LEVEL changes.
+func WatchLevel(watcher *configsvc.Watcher, config *Config) error {
+	return configsvc.Subscribe(watcher, func(levelConfig *LevelConfig) {
+		level := config.LogLevel
+		if levelConfig.Level != "" {
+			level, _ = zapcore.ParseLevel(levelConfig.Level)
+		}
+
+		config.Level.SetLevel(level)
+	})
+}
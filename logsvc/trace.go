@@ -0,0 +1,32 @@
+package logsvc
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// TraceFields returns the trace and span ids of the span in ctx, none when ctx has no span.
+func TraceFields(ctx context.Context) []zap.Field {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil
+	}
+
+	return []zap.Field{
+		zap.String("trace_id", spanContext.TraceID().String()),
+		zap.String("span_id", spanContext.SpanID().String()),
+	}
+}
+
+// WithTrace returns the logger with the trace and span ids of the span in ctx,
+// so the logs can be found from the trace.
+func WithTrace(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	fields := TraceFields(ctx)
+	if len(fields) == 0 {
+		return logger
+	}
+
+	return logger.With(fields...)
+}
@@ -1,8 +1,9 @@
 package model
 
+// InstallWebhookCmd subscribes the shop to the registered webhooks with its stored access token.
 type InstallWebhookCmd struct {
+	ShopID          string
 	MyshopifyDomain string
-	AccessToken     string
 }
 
 type CreateInsuranceProductCmd struct {
@@ -16,3 +17,14 @@ type SetShopStateCmd struct {
 	ShopID string
 	State  map[string]interface{}
 }
+
+// DeleteShopUserCmd deletes the firebase user of the shop, it undoes the user creation of the onboarding.
+type DeleteShopUserCmd struct {
+	ShopID string
+}
+
+// DeleteShopCmd deletes the shop and its token, it undoes the install of the onboarding.
+// The shop is installed again at its next check in.
+type DeleteShopCmd struct {
+	ShopID string
+}
@@ -1,15 +1,39 @@
 package model
 
+// ShopInstalledEvt is published when a shop installs the app for the first time.
+// The events do not carry the access token, the handlers read it from the TokenRepository.
 type ShopInstalledEvt struct {
 	MyshopifyDomain string
-	AccessToken     string
 	ShopID          string
 }
 
+// ShopUserCreatedEvt is published when the firebase user of a new shop is created.
+type ShopUserCreatedEvt struct {
+	ShopID          string
+	MyshopifyDomain string
+}
+
+// WebhooksInstalledEvt is published when the shop is subscribed to the registered webhooks.
+type WebhooksInstalledEvt struct {
+	ShopID          string
+	MyshopifyDomain string
+}
+
+// ShopReinstalledEvt is published when an uninstalled or redacted shop installs the app again.
+type ShopReinstalledEvt struct {
+	ShopID          string
+	MyshopifyDomain string
+}
+
 type ShopUninstalledEvt struct {
 	MyshopifyDomain string
 }
 
+// ShopSubscriptionUpdatedEvt is published when a subscription of the shop changed, such as when it is approved or cancelled.
+type ShopSubscriptionUpdatedEvt struct {
+	MyshopifyDomain string
+}
+
 type ShopCheckedInEvt struct {
 	MyshopifyDomain string
 	SessionToken    string
@@ -17,3 +41,34 @@ type ShopCheckedInEvt struct {
 
 type ServerStartedEvt struct {
 }
+
+// GdprCustomer is the customer referenced by the GDPR mandatory webhooks.
+type GdprCustomer struct {
+	ID    int64  `json:"id"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+// CustomerDataRequestedEvt is published when a customer requests their data from a shop.
+type CustomerDataRequestedEvt struct {
+	ShopID          string
+	MyshopifyDomain string
+	Customer        GdprCustomer
+	OrdersRequested []int64
+	DataRequestID   int64
+}
+
+// CustomerRedactRequestedEvt is published when a shop requests to delete the data of a customer.
+type CustomerRedactRequestedEvt struct {
+	ShopID          string
+	MyshopifyDomain string
+	Customer        GdprCustomer
+	OrdersToRedact  []int64
+}
+
+// ShopRedactRequestedEvt is published 48 hours after a shop uninstalled the app,
+// every data of the shop must be deleted.
+type ShopRedactRequestedEvt struct {
+	ShopID          string
+	MyshopifyDomain string
+}
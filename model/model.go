@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type AuthResponse struct {
 	Message           string `json:"message,omitempty"`
 	AuthenticationUrl string `json:"authenticationUrl,omitempty"`
@@ -10,11 +12,63 @@ type ShopifyToken struct {
 	AccessToken string `json:"accessToken" firestore:"accessToken"`
 }
 
+// ShopStatus is the step of the shop in its lifecycle.
+type ShopStatus string
+
+const (
+	ShopStatusInstalled   ShopStatus = "installed"
+	ShopStatusUninstalled ShopStatus = "uninstalled"
+	// ShopStatusRedacted is a soft deleted shop, Shopify asked to erase its data after the uninstall.
+	ShopStatusRedacted ShopStatus = "redacted"
+)
+
+// ShopLifecycle is stored with the shop, the timestamps are the latest of each step.
+// A reinstalled shop has the installed status again.
+type ShopLifecycle struct {
+	Status        ShopStatus `json:"status,omitempty" firestore:"status,omitempty"`
+	InstalledAt   *time.Time `json:"installedAt,omitempty" firestore:"installedAt,omitempty"`
+	UninstalledAt *time.Time `json:"uninstalledAt,omitempty" firestore:"uninstalledAt,omitempty"`
+	ReinstalledAt *time.Time `json:"reinstalledAt,omitempty" firestore:"reinstalledAt,omitempty"`
+	RedactedAt    *time.Time `json:"redactedAt,omitempty" firestore:"redactedAt,omitempty"`
+}
+
+// IsInstalled is also true for the shops stored before their lifecycle was, they have no status.
+func (l *ShopLifecycle) IsInstalled() bool {
+	return l.Status == "" || l.Status == ShopStatusInstalled
+}
+
+// Plan is a pricing plan. Its ID is the name of the Shopify subscription which pays it.
 type Plan struct {
-	PlanID   string    `json:"planId" firestore:"planId"`
-	Features []Feature `json:"features" firestore:"features"`
+	ID          string     `json:"id" firestore:"id"`
+	Name        string     `json:"name" firestore:"name"`
+	Description string     `json:"description" firestore:"description"`
+	Price       float64    `json:"price" firestore:"price"`
+	Features    []*Feature `json:"features" firestore:"features"`
+}
+
+// HasFeature reports if the plan includes the feature.
+func (p *Plan) HasFeature(featureID string) bool {
+	for _, feature := range p.Features {
+		if feature.ID == featureID {
+			return true
+		}
+	}
+
+	return false
 }
 
+// Feature is what a plan unlocks.
 type Feature struct {
-	FeatureID string `json:"featureId" firestore:"featureId"`
+	ID          string `json:"id" firestore:"id"`
+	Name        string `json:"name" firestore:"name"`
+	Description string `json:"description" firestore:"description"`
+}
+
+// PlanAssignment is a plan of a shop, from its assignment until it is unassigned.
+type PlanAssignment struct {
+	PlanID string `json:"planId" firestore:"planId"`
+	// SubscriptionID is the Shopify subscription which pays the plan, empty for a plan assigned by the app.
+	SubscriptionID string     `json:"subscriptionId,omitempty" firestore:"subscriptionId,omitempty"`
+	AssignedAt     time.Time  `json:"assignedAt" firestore:"assignedAt"`
+	UnassignedAt   *time.Time `json:"unassignedAt,omitempty" firestore:"unassignedAt,omitempty"`
 }
@@ -0,0 +1,54 @@
+package otelsvc
+
+import (
+	"net/http"
+
+	"github.com/aiocean/wireset/apperror"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/aiocean/wireset/otelsvc"
+
+// FiberMiddleware starts a server span for each request, the child of the span of the caller.
+// The handlers receive the span in c.UserContext().
+func FiberMiddleware(c *fiber.Ctx) error {
+	headers := http.Header{}
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		headers.Add(string(key), string(value))
+	})
+
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), propagation.HeaderCarrier(headers))
+	ctx, span := otel.Tracer(tracerName).Start(ctx, c.Method(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", c.Method()),
+			attribute.String("url.path", c.Path()),
+		),
+	)
+	defer span.End()
+
+	c.SetUserContext(ctx)
+	err := c.Next()
+
+	// the route is known once the request is handled
+	route := c.Route().Path
+	span.SetName(c.Method() + " " + route)
+	span.SetAttributes(attribute.String("http.route", route))
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		status = apperror.From(err).Status
+		span.RecordError(err)
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+
+	return err
+}
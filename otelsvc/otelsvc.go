@@ -0,0 +1,135 @@
+// Package otelsvc exports OpenTelemetry traces to an OTLP collector.
+// The instrumentation of fiberapp, pubsub and shopifysvc uses the global tracer provider,
+// it is a no-op until NewTracerProvider sets it.
+package otelsvc
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.uber.org/zap"
+)
+
+// DefaultWireset exports to the OTLP gRPC collector of OTEL_EXPORTER_OTLP_ENDPOINT.
+var DefaultWireset = wire.NewSet(
+	ConfigFromEnv,
+	NewOTLPExporter,
+	wire.Bind(new(sdktrace.SpanExporter), new(*otlptrace.Exporter)),
+	NewTracerProvider,
+)
+
+// ProviderWireset requires a Config and a sdktrace.SpanExporter, such as tracetest.InMemoryExporter in the tests.
+var ProviderWireset = wire.NewSet(
+	NewTracerProvider,
+)
+
+// shutdownTimeout bounds the export of the remaining spans on shutdown.
+const shutdownTimeout = 5 * time.Second
+
+// Config is bound by configsvc.Loader.
+type Config struct {
+	// Endpoint of the collector, host:port, or a url whose http scheme means insecure.
+	Endpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT" required:"true"`
+	Insecure bool   `env:"OTEL_EXPORTER_OTLP_INSECURE"`
+	// SampleRatio of the traces started here, the others follow the decision of their parent.
+	SampleRatio float64 `env:"OTEL_TRACES_SAMPLER_ARG" default:"1"`
+}
+
+func (c *Config) Validate() error {
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return errors.Errorf("OTEL_TRACES_SAMPLER_ARG must be between 0 and 1, got %v", c.SampleRatio)
+	}
+
+	return nil
+}
+
+// ConfigFromEnv loads the config from the layered sources of configsvc.Loader.
+func ConfigFromEnv() (*Config, error) {
+	config := &Config{}
+	if err := configsvc.Load(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func NewOTLPExporter(config *Config) (*otlptrace.Exporter, error) {
+	endpoint := config.Endpoint
+	insecure := config.Insecure
+	if strings.Contains(endpoint, "://") {
+		endpointUrl, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse otlp endpoint")
+		}
+		endpoint = endpointUrl.Host
+		insecure = insecure || endpointUrl.Scheme == "http"
+	}
+
+	options := []otlptracegrpc.Option{
+		otlptracegrpc.WithEndpoint(endpoint),
+	}
+	if insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+
+	// the connection is established in the background, the exporter does not wait for the collector
+	exporter, err := otlptracegrpc.New(context.Background(), options...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create otlp exporter")
+	}
+
+	return exporter, nil
+}
+
+// NewTracerProvider sets the global tracer provider and the W3C propagator.
+// The cleanup flushes the spans which are not exported yet.
+func NewTracerProvider(
+	config *Config,
+	configSvc *configsvc.ConfigService,
+	exporter sdktrace.SpanExporter,
+	logger *zap.Logger,
+) (*sdktrace.TracerProvider, func(), error) {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(configSvc.ServiceName),
+		semconv.DeploymentEnvironment(configSvc.Environment),
+	))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create otel resource")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	cleanup := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := provider.Shutdown(ctx); err != nil {
+			logger.Error("failed to shut down tracer provider", zap.Error(err))
+		}
+	}
+
+	return provider, cleanup, nil
+}
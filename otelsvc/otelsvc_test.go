@@ -0,0 +1,239 @@
+package otelsvc_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/aiocean/wireset/otelsvc"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/garsue/watermillzap"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// the trace context of the caller, in the W3C traceparent format
+const (
+	traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+)
+
+type orderCreatedEvt struct {
+	ID string `json:"id"`
+}
+
+func TestSpansOfARequestAndItsMessageAreExportedWithTheCallerTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider, cleanup, err := otelsvc.NewTracerProvider(
+		&otelsvc.Config{SampleRatio: 1},
+		&configsvc.ConfigService{ServiceName: "otelsvc-test"},
+		exporter,
+		zap.NewNop(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	channel := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+	defer channel.Close()
+
+	eventBus, err := pubsub.NewEventBus(channel, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := message.NewRouter(message.RouterConfig{}, watermill.NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.AddMiddleware(pubsub.Tracing)
+
+	handled := make(chan struct{}, 1)
+	router.AddNoPublisherHandler("OrderCreatedHandler", cqrs.JSONMarshaler{}.Name(&orderCreatedEvt{}), channel, func(msg *message.Message) error {
+		handled <- struct{}{}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = router.Run(ctx) }()
+	<-router.Running()
+
+	app := fiber.New()
+	app.Use(otelsvc.FiberMiddleware)
+	app.Post("/orders", func(c *fiber.Ctx) error {
+		return eventBus.Publish(c.UserContext(), &orderCreatedEvt{ID: "1"})
+	})
+
+	req := httptest.NewRequest(fiber.MethodPost, "/orders", nil)
+	req.Header.Set("traceparent", traceparent)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status is %d", resp.StatusCode)
+	}
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message was not handled")
+	}
+
+	// the spans are exported by batches, the shutdown would reset the exporter
+	_ = router.Close()
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[trace.SpanKind]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.SpanKind] = span
+	}
+
+	server, ok := spans[trace.SpanKindServer]
+	if !ok {
+		t.Fatal("the span of the request is not exported")
+	}
+	if server.Name != "POST /orders" {
+		t.Fatalf("the span of the request is named %q", server.Name)
+	}
+
+	consumer, ok := spans[trace.SpanKindConsumer]
+	if !ok {
+		t.Fatal("the span of the message is not exported")
+	}
+	if consumer.Name != "handle OrderCreatedHandler" {
+		t.Fatalf("the span of the message is named %q", consumer.Name)
+	}
+
+	for _, span := range []tracetest.SpanStub{server, consumer} {
+		if got := span.SpanContext.TraceID().String(); got != traceID {
+			t.Fatalf("the span %q has the trace %s, want the trace of the caller %s", span.Name, got, traceID)
+		}
+	}
+	if consumer.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Fatalf("the span of the message is not a child of the span of the request")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("CONFIG_SECRETS_DIR", t.TempDir())
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4317")
+	t.Setenv("OTEL_EXPORTER_OTLP_INSECURE", "true")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0.25")
+
+	config, err := otelsvc.ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Endpoint != "http://collector:4317" || !config.Insecure || config.SampleRatio != 0.25 {
+		t.Fatalf("config is %+v", config)
+	}
+
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "2")
+	if _, err := otelsvc.ConfigFromEnv(); err == nil {
+		t.Fatal("a sample ratio above 1 is accepted")
+	}
+}
+
+func TestLogsOfAMessageHaveTheCallerTrace(t *testing.T) {
+	_, cleanup, err := otelsvc.NewTracerProvider(
+		&otelsvc.Config{SampleRatio: 1},
+		&configsvc.ConfigService{ServiceName: "otelsvc-test"},
+		tracetest.NewInMemoryExporter(),
+		zap.NewNop(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core)
+
+	channel := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+	defer channel.Close()
+
+	eventBus, err := pubsub.NewEventBus(channel, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := message.NewRouter(message.RouterConfig{}, watermill.NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.AddMiddleware(
+		pubsub.Tracing,
+		pubsub.Retry{
+			Policy: pubsub.RetryPolicy{MaxAttempts: 3, InitialInterval: time.Millisecond, Multiplier: 1},
+			Logger: watermillzap.NewLogger(logger),
+		}.Middleware,
+	)
+
+	handled := make(chan struct{}, 1)
+	attempts := 0
+	router.AddNoPublisherHandler("OrderCreatedHandler", cqrs.JSONMarshaler{}.Name(&orderCreatedEvt{}), channel, func(msg *message.Message) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("order is locked")
+		}
+
+		logsvc.WithTrace(msg.Context(), logger).Info("order handled")
+		handled <- struct{}{}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = router.Run(ctx) }()
+	<-router.Running()
+	defer router.Close()
+
+	app := fiber.New()
+	app.Use(otelsvc.FiberMiddleware)
+	app.Post("/orders", func(c *fiber.Ctx) error {
+		return eventBus.Publish(c.UserContext(), &orderCreatedEvt{ID: "1"})
+	})
+
+	req := httptest.NewRequest(fiber.MethodPost, "/orders", nil)
+	req.Header.Set("traceparent", traceparent)
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message was not handled")
+	}
+
+	messages := map[string]int{}
+	for _, entry := range logs.All() {
+		messages[entry.Message]++
+		if got := entry.ContextMap()["trace_id"]; got != traceID {
+			t.Fatalf("the log %q has the trace %v, want the trace of the caller %s", entry.Message, got, traceID)
+		}
+		if entry.ContextMap()["span_id"] == "" {
+			t.Fatalf("the log %q has no span", entry.Message)
+		}
+	}
+	if messages["order handled"] != 1 || messages["Error occurred, retrying"] != 1 {
+		t.Fatalf("logs are %v, want the log of the handler and the log of its failed retry", messages)
+	}
+}
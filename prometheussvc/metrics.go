@@ -0,0 +1,95 @@
+package prometheussvc
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The collectors of the built-in instrumentation, the packages observe them directly.
+// They are exported by the metrics feature, observing them costs little when it is disabled.
+var (
+	HttpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of the http requests, by route template.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	MessagesProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "messages_processed_total",
+		Help: "Messages processed by the handlers, result is success, error or dead_lettered once the retries are done.",
+	}, []string{"handler", "result"})
+
+	MessagesRetried = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "messages_retried_total",
+		Help: "Retries of the handlers.",
+	}, []string{"handler"})
+
+	MessagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "messages_failed_total",
+		Help: "Messages which still failed after the last retry, they are moved to the dead letter queue.",
+	}, []string{"handler"})
+
+	MessageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "message_handling_duration_seconds",
+		Help:    "Duration of the handlers, retries included.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler"})
+
+	WebsocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "websocket_connections",
+		Help: "Websocket connections held by this pod.",
+	})
+
+	WebsocketRooms = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "websocket_rooms",
+		Help: "Rooms with a member connected to this pod.",
+	})
+
+	ShopifyAPIDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shopify_api_request_duration_seconds",
+		Help:    "Duration of the Shopify admin api calls, api is rest or graphql.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"api", "status"})
+
+	ShopifyAPIThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shopify_api_throttled_total",
+		Help: "Shopify admin api calls rejected by the rate limit.",
+	}, []string{"api"})
+
+	ShopifyAPIAvailable = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shopify_api_available_ratio",
+		Help:    "Available part of the rate limit bucket of the shop, reported by each call.",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, []string{"api"})
+)
+
+// Collectors returns the collectors of the built-in instrumentation.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		HttpRequestDuration,
+		MessagesProcessed,
+		MessagesRetried,
+		MessagesFailed,
+		MessageDuration,
+		WebsocketConnections,
+		WebsocketRooms,
+		ShopifyAPIDuration,
+		ShopifyAPIThrottled,
+		ShopifyAPIAvailable,
+	}
+}
+
+// Register registers the collectors, the ones already registered are skipped.
+func Register(registerer prometheus.Registerer, collectors ...prometheus.Collector) error {
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			var alreadyRegistered prometheus.AlreadyRegisteredError
+			if errors.As(err, &alreadyRegistered) {
+				continue
+			}
+			return errors.Wrap(err, "failed to register collector")
+		}
+	}
+
+	return nil
+}
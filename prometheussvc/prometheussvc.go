@@ -1,7 +1,20 @@
 package prometheussvc
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"github.com/google/wire"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var DefaultWireset = wire.NewSet(
+	NewPrometheusSvc,
+	NewGatherer,
+)
 
 func NewPrometheusSvc() prometheus.Registerer {
 	return prometheus.DefaultRegisterer
 }
+
+// NewGatherer returns the registry which NewPrometheusSvc registers to.
+func NewGatherer() prometheus.Gatherer {
+	return prometheus.DefaultGatherer
+}
@@ -17,6 +17,7 @@ func NewCommandBus(publisher message.Publisher, logger *zap.Logger) (*cqrs.Comma
 		},
 		OnSend: func(params cqrs.CommandBusOnSendParams) error {
 			params.Message.Metadata.Set("sent_at", time.Now().String())
+			injectTraceContext(params.Message)
 			return nil
 		},
 		Marshaler: cqrs.JSONMarshaler{},
@@ -34,6 +35,10 @@ func NewEventBus(publisher message.Publisher, logger *zap.Logger) (*cqrs.EventBu
 		},
 		OnPublish: func(params cqrs.OnEventSendParams) error {
 			params.Message.Metadata.Set("published_at", time.Now().String())
+			injectTraceContext(params.Message)
+			if uuid, ok := messageUUIDFromCtx(params.Message.Context()); ok {
+				params.Message.UUID = uuid
+			}
 			return nil
 		},
 		Marshaler: cqrs.JSONMarshaler{},
@@ -43,7 +48,7 @@ func NewEventBus(publisher message.Publisher, logger *zap.Logger) (*cqrs.EventBu
 	return eventBus, err
 }
 
-func NewEventProcessor(router *message.Router, subscriber message.Subscriber, logger *zap.Logger) (*cqrs.EventProcessor, error) {
+func NewEventProcessor(router *message.Router, subscriber message.Subscriber, handlers *HandlerRegistry, logger *zap.Logger) (*cqrs.EventProcessor, error) {
 	return cqrs.NewEventProcessorWithConfig(
 		router,
 		cqrs.EventProcessorConfig{
@@ -51,6 +56,7 @@ func NewEventProcessor(router *message.Router, subscriber message.Subscriber, lo
 				return params.EventName, nil
 			},
 			SubscriberConstructor: func(params cqrs.EventProcessorSubscriberConstructorParams) (message.Subscriber, error) {
+				handlers.Add(params.HandlerName, params.EventHandler)
 				return subscriber, nil
 			},
 
@@ -66,7 +72,7 @@ func NewEventProcessor(router *message.Router, subscriber message.Subscriber, lo
 }
 
 // NewCommandProcessor creates a new command processor.
-func NewCommandProcessor(router *message.Router, subscriber message.Subscriber, logger *zap.Logger) (*cqrs.CommandProcessor, error) {
+func NewCommandProcessor(router *message.Router, subscriber message.Subscriber, handlers *HandlerRegistry, logger *zap.Logger) (*cqrs.CommandProcessor, error) {
 	return cqrs.NewCommandProcessorWithConfig(
 		router,
 		cqrs.CommandProcessorConfig{
@@ -74,6 +80,7 @@ func NewCommandProcessor(router *message.Router, subscriber message.Subscriber,
 				return params.CommandName, nil
 			},
 			SubscriberConstructor: func(params cqrs.CommandProcessorSubscriberConstructorParams) (message.Subscriber, error) {
+				handlers.Add(params.HandlerName, params.Handler)
 				return subscriber, nil
 			},
 
@@ -0,0 +1,261 @@
+package pubsub
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// metadataReplayHandler targets a replayed message to the handler which failed it,
+// other handlers subscribed to the same topic skip it.
+const metadataReplayHandler = "replay_handler"
+
+// metadataReplayedFrom keeps the uuid of the original message.
+const metadataReplayedFrom = "replayed_from"
+
+// metadataDeadLettered marks the consumed message once it is saved in the dead letter queue,
+// the handler metrics count it apart from the successes.
+const metadataDeadLettered = "dead_lettered"
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a message which failed every retry.
+type DeadLetter struct {
+	ID          string            `json:"id" firestore:"id"`
+	MessageUUID string            `json:"messageUuid" firestore:"messageUuid"`
+	Topic       string            `json:"topic" firestore:"topic"`
+	HandlerName string            `json:"handlerName" firestore:"handlerName"`
+	Payload     []byte            `json:"payload" firestore:"payload"`
+	Metadata    map[string]string `json:"metadata" firestore:"metadata"`
+	Error       string            `json:"error" firestore:"error"`
+	Attempts    int               `json:"attempts" firestore:"attempts"`
+	FailedAt    time.Time         `json:"failedAt" firestore:"failedAt"`
+}
+
+// DeadLetterStore persists the dead letters.
+type DeadLetterStore interface {
+	// Save stores the dead letter, the store assigns the ID when it is empty.
+	Save(ctx context.Context, deadLetter *DeadLetter) error
+	// List returns the most recent dead letters first.
+	List(ctx context.Context, limit int) ([]*DeadLetter, error)
+	// Get returns the dead letter or ErrDeadLetterNotFound.
+	Get(ctx context.Context, id string) (*DeadLetter, error)
+	Delete(ctx context.Context, id string) error
+	Purge(ctx context.Context) error
+}
+
+// DeadLetterQueue records the messages which the router gave up on, and replays them on demand.
+type DeadLetterQueue struct {
+	Store     DeadLetterStore
+	Publisher message.Publisher
+	Logger    *zap.Logger
+}
+
+func NewDeadLetterQueue(
+	store DeadLetterStore,
+	publisher message.Publisher,
+	logger *zap.Logger,
+) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		Store:     store,
+		Publisher: publisher,
+		Logger:    logger.Named("deadLetterQueue"),
+	}
+}
+
+// Add records the failed message, and marks it as dead lettered.
+func (q *DeadLetterQueue) Add(msg *message.Message, handlerErr error, attempts int) error {
+	metadata := make(map[string]string, len(msg.Metadata))
+	for key, value := range msg.Metadata {
+		metadata[key] = value
+	}
+
+	deadLetter := &DeadLetter{
+		MessageUUID: msg.UUID,
+		Topic:       message.SubscribeTopicFromCtx(msg.Context()),
+		HandlerName: message.HandlerNameFromCtx(msg.Context()),
+		Payload:     append([]byte(nil), msg.Payload...),
+		Metadata:    metadata,
+		Attempts:    attempts,
+		FailedAt:    time.Now(),
+	}
+
+	if handlerErr != nil {
+		deadLetter.Error = handlerErr.Error()
+	}
+
+	// the message context is canceled once the handler returns, the record must outlive it
+	if err := q.Save(context.Background(), deadLetter); err != nil {
+		return err
+	}
+
+	msg.Metadata.Set(metadataDeadLettered, "true")
+	return nil
+}
+
+// IsDeadLettered is true for a message which the handler gave up on, and which is saved in the dead letter queue.
+func IsDeadLettered(msg *message.Message) bool {
+	return msg.Metadata.Get(metadataDeadLettered) != ""
+}
+
+// Save records a dead letter which did not come from a handler, such as an outbox record which could not be published.
+func (q *DeadLetterQueue) Save(ctx context.Context, deadLetter *DeadLetter) error {
+	if err := q.Store.Save(ctx, deadLetter); err != nil {
+		return errors.WithMessage(err, "save dead letter")
+	}
+
+	q.Logger.Error("message moved to dead letter queue",
+		zap.String("id", deadLetter.ID),
+		zap.String("topic", deadLetter.Topic),
+		zap.String("handler", deadLetter.HandlerName),
+		zap.Int("attempts", deadLetter.Attempts),
+		zap.String("err", deadLetter.Error),
+	)
+
+	return nil
+}
+
+// List returns the most recent dead letters first.
+func (q *DeadLetterQueue) List(ctx context.Context, limit int) ([]*DeadLetter, error) {
+	return q.Store.List(ctx, limit)
+}
+
+// Get returns a dead letter by its id.
+func (q *DeadLetterQueue) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	return q.Store.Get(ctx, id)
+}
+
+// Replay publishes the message again to its topic, only the handler which failed it will handle it.
+// The dead letter is removed once it is published.
+func (q *DeadLetterQueue) Replay(ctx context.Context, id string) error {
+	deadLetter, err := q.Store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	msg := message.NewMessage(watermill.NewUUID(), deadLetter.Payload)
+	for key, value := range deadLetter.Metadata {
+		msg.Metadata.Set(key, value)
+	}
+	msg.Metadata.Set(metadataReplayedFrom, deadLetter.MessageUUID)
+	if deadLetter.HandlerName != "" {
+		msg.Metadata.Set(metadataReplayHandler, deadLetter.HandlerName)
+	}
+
+	if err := q.Publisher.Publish(deadLetter.Topic, msg); err != nil {
+		return errors.WithMessage(err, "publish dead letter")
+	}
+
+	if err := q.Store.Delete(ctx, id); err != nil {
+		return errors.WithMessage(err, "delete replayed dead letter")
+	}
+
+	q.Logger.Info("dead letter replayed", zap.String("id", id), zap.String("topic", deadLetter.Topic))
+	return nil
+}
+
+// Delete removes a single dead letter without replaying it.
+func (q *DeadLetterQueue) Delete(ctx context.Context, id string) error {
+	return q.Store.Delete(ctx, id)
+}
+
+// Purge removes every dead letter.
+func (q *DeadLetterQueue) Purge(ctx context.Context) error {
+	return q.Store.Purge(ctx)
+}
+
+// ReplayFilter skips the replayed messages which target another handler.
+func ReplayFilter(h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) ([]*message.Message, error) {
+		target := msg.Metadata.Get(metadataReplayHandler)
+		if target != "" && target != message.HandlerNameFromCtx(msg.Context()) {
+			return nil, nil
+		}
+
+		return h(msg)
+	}
+}
+
+var MemoryDeadLetterWireset = wire.NewSet(
+	NewMemoryDeadLetterStore,
+	wire.Bind(new(DeadLetterStore), new(*MemoryDeadLetterStore)),
+)
+
+// MemoryDeadLetterStore keeps the dead letters in memory, they are lost on restart.
+type MemoryDeadLetterStore struct {
+	mu          sync.RWMutex
+	deadLetters map[string]*DeadLetter
+}
+
+func NewMemoryDeadLetterStore() *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{
+		deadLetters: map[string]*DeadLetter{},
+	}
+}
+
+func (s *MemoryDeadLetterStore) Save(ctx context.Context, deadLetter *DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if deadLetter.ID == "" {
+		deadLetter.ID = watermill.NewULID()
+	}
+
+	s.deadLetters[deadLetter.ID] = deadLetter
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) List(ctx context.Context, limit int) ([]*DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deadLetters := make([]*DeadLetter, 0, len(s.deadLetters))
+	for _, deadLetter := range s.deadLetters {
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	// ULIDs are sortable by time
+	sort.Slice(deadLetters, func(i, j int) bool {
+		return deadLetters[i].ID > deadLetters[j].ID
+	})
+
+	if limit > 0 && len(deadLetters) > limit {
+		deadLetters = deadLetters[:limit]
+	}
+
+	return deadLetters, nil
+}
+
+func (s *MemoryDeadLetterStore) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if deadLetter, ok := s.deadLetters[id]; ok {
+		return deadLetter, nil
+	}
+
+	return nil, ErrDeadLetterNotFound
+}
+
+func (s *MemoryDeadLetterStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.deadLetters, id)
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) Purge(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetters = map[string]*DeadLetter{}
+	return nil
+}
@@ -0,0 +1,124 @@
+package pubsub
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var FirestoreDeadLetterWireset = wire.NewSet(
+	NewFirestoreDeadLetterStore,
+	wire.Bind(new(DeadLetterStore), new(*FirestoreDeadLetterStore)),
+)
+
+const deadLetterCollection = "dead_letters"
+
+// purgeBatchSize is the maximum number of writes firestore accepts in one batch.
+const purgeBatchSize = 500
+
+// FirestoreDeadLetterStore keeps the dead letters in the dead_letters collection.
+type FirestoreDeadLetterStore struct {
+	firestoreClient *firestore.Client
+}
+
+func NewFirestoreDeadLetterStore(firestoreClient *firestore.Client) *FirestoreDeadLetterStore {
+	return &FirestoreDeadLetterStore{
+		firestoreClient: firestoreClient,
+	}
+}
+
+func (s *FirestoreDeadLetterStore) Save(ctx context.Context, deadLetter *DeadLetter) error {
+	if deadLetter.ID == "" {
+		deadLetter.ID = watermill.NewULID()
+	}
+
+	if _, err := s.firestoreClient.Collection(deadLetterCollection).Doc(deadLetter.ID).Set(ctx, deadLetter); err != nil {
+		return errors.WithMessage(err, "save dead letter")
+	}
+
+	return nil
+}
+
+func (s *FirestoreDeadLetterStore) List(ctx context.Context, limit int) ([]*DeadLetter, error) {
+	query := s.firestoreClient.Collection(deadLetterCollection).OrderBy("failedAt", firestore.Desc)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	snapshots, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, errors.WithMessage(err, "list dead letters")
+	}
+
+	deadLetters := make([]*DeadLetter, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		var deadLetter DeadLetter
+		if err := snapshot.DataTo(&deadLetter); err != nil {
+			return nil, errors.WithMessage(err, "data to dead letter")
+		}
+		deadLetters = append(deadLetters, &deadLetter)
+	}
+
+	return deadLetters, nil
+}
+
+func (s *FirestoreDeadLetterStore) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	snapshot, err := s.firestoreClient.Collection(deadLetterCollection).Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrDeadLetterNotFound
+		}
+		return nil, errors.WithMessage(err, "get dead letter")
+	}
+
+	var deadLetter DeadLetter
+	if err := snapshot.DataTo(&deadLetter); err != nil {
+		return nil, errors.WithMessage(err, "data to dead letter")
+	}
+
+	return &deadLetter, nil
+}
+
+func (s *FirestoreDeadLetterStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.firestoreClient.Collection(deadLetterCollection).Doc(id).Delete(ctx); err != nil {
+		return errors.WithMessage(err, "delete dead letter")
+	}
+
+	return nil
+}
+
+func (s *FirestoreDeadLetterStore) Purge(ctx context.Context) error {
+	for {
+		iter := s.firestoreClient.Collection(deadLetterCollection).Limit(purgeBatchSize).Documents(ctx)
+		batch := s.firestoreClient.Batch()
+		count := 0
+		for {
+			snapshot, err := iter.Next()
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return errors.WithMessage(err, "list dead letters")
+			}
+
+			batch.Delete(snapshot.Ref)
+			count++
+		}
+		iter.Stop()
+
+		if count == 0 {
+			return nil
+		}
+
+		if _, err := batch.Commit(ctx); err != nil {
+			return errors.WithMessage(err, "purge dead letters")
+		}
+	}
+}
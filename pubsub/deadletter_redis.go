@@ -0,0 +1,115 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+var RedisDeadLetterWireset = wire.NewSet(
+	NewRedisDeadLetterStore,
+	wire.Bind(new(DeadLetterStore), new(*RedisDeadLetterStore)),
+)
+
+// RedisDeadLetterStore keeps the dead letters in a redis stream, the stream entry id is the dead letter id.
+type RedisDeadLetterStore struct {
+	client *redis.Client
+	key    string
+}
+
+func NewRedisDeadLetterStore(client *redis.Client, cfg *configsvc.ConfigService) *RedisDeadLetterStore {
+	return &RedisDeadLetterStore{
+		client: client,
+		key:    "dead_letters:" + cfg.ServiceName,
+	}
+}
+
+func (s *RedisDeadLetterStore) Save(ctx context.Context, deadLetter *DeadLetter) error {
+	data, err := json.Marshal(deadLetter)
+	if err != nil {
+		return errors.WithMessage(err, "marshal dead letter")
+	}
+
+	id, err := s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.key,
+		Values: map[string]interface{}{"data": data},
+	}).Result()
+	if err != nil {
+		return errors.WithMessage(err, "add dead letter to stream")
+	}
+
+	deadLetter.ID = id
+	return nil
+}
+
+func (s *RedisDeadLetterStore) List(ctx context.Context, limit int) ([]*DeadLetter, error) {
+	var entries []redis.XMessage
+	var err error
+	if limit > 0 {
+		entries, err = s.client.XRevRangeN(ctx, s.key, "+", "-", int64(limit)).Result()
+	} else {
+		entries, err = s.client.XRevRange(ctx, s.key, "+", "-").Result()
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "read dead letter stream")
+	}
+
+	deadLetters := make([]*DeadLetter, 0, len(entries))
+	for _, entry := range entries {
+		deadLetter, err := decodeDeadLetter(entry)
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, nil
+}
+
+func (s *RedisDeadLetterStore) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	entries, err := s.client.XRange(ctx, s.key, id, id).Result()
+	if err != nil {
+		return nil, errors.WithMessage(err, "read dead letter stream")
+	}
+
+	if len(entries) == 0 {
+		return nil, ErrDeadLetterNotFound
+	}
+
+	return decodeDeadLetter(entries[0])
+}
+
+func (s *RedisDeadLetterStore) Delete(ctx context.Context, id string) error {
+	if err := s.client.XDel(ctx, s.key, id).Err(); err != nil {
+		return errors.WithMessage(err, "delete dead letter")
+	}
+
+	return nil
+}
+
+func (s *RedisDeadLetterStore) Purge(ctx context.Context) error {
+	if err := s.client.Del(ctx, s.key).Err(); err != nil {
+		return errors.WithMessage(err, "purge dead letters")
+	}
+
+	return nil
+}
+
+func decodeDeadLetter(entry redis.XMessage) (*DeadLetter, error) {
+	data, ok := entry.Values["data"].(string)
+	if !ok {
+		return nil, errors.Errorf("dead letter %s has no data", entry.ID)
+	}
+
+	var deadLetter DeadLetter
+	if err := json.Unmarshal([]byte(data), &deadLetter); err != nil {
+		return nil, errors.WithMessage(err, "unmarshal dead letter")
+	}
+
+	deadLetter.ID = entry.ID
+	return &deadLetter, nil
+}
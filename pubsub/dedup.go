@@ -0,0 +1,257 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// DeduplicationProvider is implemented by the handlers which decide themselves if the duplicated messages are skipped.
+// Handlers which are naturally idempotent, or must see every delivery, return false to opt out.
+type DeduplicationProvider interface {
+	Deduplicate() bool
+}
+
+// DedupStatus is the state of a message for a handler.
+type DedupStatus int
+
+const (
+	// DedupClaimed means the caller leased the message and processes it.
+	DedupClaimed DedupStatus = iota
+	// DedupInProgress means another delivery holds the lease of the message.
+	DedupInProgress
+	// DedupProcessed means the handler processed the message already.
+	DedupProcessed
+)
+
+// DedupStore remembers the messages which a handler processes or processed.
+type DedupStore interface {
+	// Claim leases the message to the handler for lease, unless it is leased or processed already.
+	// An expired lease is claimed again, the delivery which held it is considered lost.
+	Claim(ctx context.Context, handlerName, messageID string, lease time.Duration) (DedupStatus, error)
+	// MarkProcessed replaces the lease, the message is skipped for ttl.
+	MarkProcessed(ctx context.Context, handlerName, messageID string, ttl time.Duration) error
+	// Release forgets the message, so a redelivery is processed again.
+	Release(ctx context.Context, handlerName, messageID string) error
+}
+
+// DefaultDedupTTL covers the redeliveries of the redis stream transport and the restarts of the replicas.
+const DefaultDedupTTL = 24 * time.Hour
+
+// DefaultDedupLease is longer than a handler call. The lease of a message adds the retry window of its handler,
+// so it does not expire while the message is retried and the message is not processed twice at once.
+const DefaultDedupLease = 5 * time.Minute
+
+// defaultInProgressDelay slows down the redeliveries of a message which is in progress.
+const defaultInProgressDelay = time.Second
+
+var ErrMessageInProgress = errors.New("message is processed by another delivery")
+
+// DedupStats are the counters of the Deduplicator.
+type DedupStats struct {
+	// Hits is the number of duplicated messages which were skipped.
+	Hits int64
+	// Misses is the number of messages which were processed for the first time.
+	Misses int64
+}
+
+// Deduplicator provides a middleware which processes a message only once per handler.
+// The message is leased before the handler runs, marked processed when the handler succeeds
+// and released when it fails, so the retries and the redeliveries of a failed message still reach the handler.
+// A delivery which crashed holds the lease until it expires, then a redelivery processes the message.
+type Deduplicator struct {
+	Store    DedupStore
+	Handlers *HandlerRegistry
+	// TTL is how long a processed message is remembered, Lease how long a delivery may process it,
+	// besides the retry window of the handler.
+	TTL   time.Duration
+	Lease time.Duration
+	// RetryPolicy is the policy of the handlers which declare none, the Retry.Policy of the router.
+	RetryPolicy RetryPolicy
+	Logger      *zap.Logger
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func NewDeduplicator(store DedupStore, handlers *HandlerRegistry, logger *zap.Logger) *Deduplicator {
+	return &Deduplicator{
+		Store:       store,
+		Handlers:    handlers,
+		TTL:         DefaultDedupTTL,
+		Lease:       DefaultDedupLease,
+		RetryPolicy: DefaultRetryPolicy(),
+		Logger:      logger.Named("dedup"),
+	}
+}
+
+// Stats returns the counters since the service started.
+func (d *Deduplicator) Stats() DedupStats {
+	return DedupStats{
+		Hits:   d.hits.Load(),
+		Misses: d.misses.Load(),
+	}
+}
+
+func (d *Deduplicator) enabledFor(handlerName string) bool {
+	if d.Handlers == nil {
+		return true
+	}
+
+	handler, ok := d.Handlers.Get(handlerName)
+	if !ok {
+		return true
+	}
+
+	provider, ok := handler.(DeduplicationProvider)
+	if !ok {
+		return true
+	}
+
+	return provider.Deduplicate()
+}
+
+// leaseFor returns the lease of the messages of the handler, the Retry middleware retries them within it.
+func (d *Deduplicator) leaseFor(handlerName string) time.Duration {
+	policy := d.RetryPolicy
+	if d.Handlers != nil {
+		if handlerPolicy, ok := d.Handlers.RetryPolicy(handlerName); ok {
+			policy = handlerPolicy
+		}
+	}
+
+	return d.Lease + policy.Window()
+}
+
+func (d *Deduplicator) Middleware(h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) ([]*message.Message, error) {
+		handlerName := message.HandlerNameFromCtx(msg.Context())
+		if !d.enabledFor(handlerName) {
+			return h(msg)
+		}
+
+		status, err := d.Store.Claim(msg.Context(), handlerName, msg.UUID, d.leaseFor(handlerName))
+		if err != nil {
+			// process the message anyway, a duplicate is better than a lost message
+			logsvc.WithTrace(msg.Context(), d.Logger).Error("failed to claim message", zap.String("handler", handlerName), zap.String("uuid", msg.UUID), zap.Error(err))
+			return h(msg)
+		}
+
+		switch status {
+		case DedupProcessed:
+			d.hits.Add(1)
+			logsvc.WithTrace(msg.Context(), d.Logger).Debug("skip duplicated message", zap.String("handler", handlerName), zap.String("uuid", msg.UUID))
+			return nil, nil
+		case DedupInProgress:
+			// nack the message, it is processed again if the other delivery fails or its lease expires
+			select {
+			case <-msg.Context().Done():
+			case <-time.After(defaultInProgressDelay):
+			}
+			return nil, ErrMessageInProgress
+		}
+		d.misses.Add(1)
+
+		producedMessages, err := h(msg)
+		if err != nil {
+			if releaseErr := d.Store.Release(context.Background(), handlerName, msg.UUID); releaseErr != nil {
+				logsvc.WithTrace(msg.Context(), d.Logger).Error("failed to release message", zap.String("handler", handlerName), zap.String("uuid", msg.UUID), zap.Error(releaseErr))
+			}
+			return producedMessages, err
+		}
+
+		if err := d.Store.MarkProcessed(context.Background(), handlerName, msg.UUID, d.TTL); err != nil {
+			// the lease expires, a redelivery processes the message again
+			logsvc.WithTrace(msg.Context(), d.Logger).Error("failed to mark message as processed", zap.String("handler", handlerName), zap.String("uuid", msg.UUID), zap.Error(err))
+		}
+
+		return producedMessages, nil
+	}
+}
+
+var MemoryDedupWireset = wire.NewSet(
+	NewMemoryDedupStore,
+	wire.Bind(new(DedupStore), new(*MemoryDedupStore)),
+)
+
+// MemoryDedupStore keeps the claims in memory, it only deduplicates inside one replica.
+// The expired claims are removed once per sweepInterval, a claim is checked when it is read.
+type MemoryDedupStore struct {
+	mu        sync.Mutex
+	claims    map[string]memoryDedupClaim
+	lastSweep time.Time
+}
+
+type memoryDedupClaim struct {
+	processed bool
+	expiresAt time.Time
+}
+
+const sweepInterval = time.Minute
+
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{
+		claims:    map[string]memoryDedupClaim{},
+		lastSweep: time.Now(),
+	}
+}
+
+func dedupKey(handlerName, messageID string) string {
+	return handlerName + ":" + messageID
+}
+
+func (s *MemoryDedupStore) Claim(ctx context.Context, handlerName, messageID string, lease time.Duration) (DedupStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	key := dedupKey(handlerName, messageID)
+	if claim, ok := s.claims[key]; ok && now.Before(claim.expiresAt) {
+		if claim.processed {
+			return DedupProcessed, nil
+		}
+		return DedupInProgress, nil
+	}
+
+	s.claims[key] = memoryDedupClaim{expiresAt: now.Add(lease)}
+	return DedupClaimed, nil
+}
+
+func (s *MemoryDedupStore) MarkProcessed(ctx context.Context, handlerName, messageID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.claims[dedupKey(handlerName, messageID)] = memoryDedupClaim{processed: true, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryDedupStore) Release(ctx context.Context, handlerName, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.claims, dedupKey(handlerName, messageID))
+	return nil
+}
+
+// sweep removes the expired claims, at most once per sweepInterval.
+func (s *MemoryDedupStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, claim := range s.claims {
+		if !now.Before(claim.expiresAt) {
+			delete(s.claims, key)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var FirestoreDedupWireset = wire.NewSet(
+	NewFirestoreDedupStore,
+	wire.Bind(new(DedupStore), new(*FirestoreDedupStore)),
+)
+
+// processedMessageCollection keeps a document per claimed message.
+// A firestore TTL policy on expiresAt removes the expired documents.
+const processedMessageCollection = "processed_messages"
+
+type processedMessage struct {
+	HandlerName string    `firestore:"handlerName"`
+	MessageID   string    `firestore:"messageId"`
+	Processed   bool      `firestore:"processed"`
+	ExpiresAt   time.Time `firestore:"expiresAt"`
+}
+
+// FirestoreDedupStore claims the messages in a transaction, the replicas share the claims.
+type FirestoreDedupStore struct {
+	firestoreClient *firestore.Client
+}
+
+func NewFirestoreDedupStore(firestoreClient *firestore.Client) *FirestoreDedupStore {
+	return &FirestoreDedupStore{
+		firestoreClient: firestoreClient,
+	}
+}
+
+func (s *FirestoreDedupStore) doc(handlerName, messageID string) *firestore.DocumentRef {
+	// handler names can contain slashes, which are not allowed in document ids
+	id := base64.RawURLEncoding.EncodeToString([]byte(dedupKey(handlerName, messageID)))
+	return s.firestoreClient.Collection(processedMessageCollection).Doc(id)
+}
+
+func (s *FirestoreDedupStore) Claim(ctx context.Context, handlerName, messageID string, lease time.Duration) (DedupStatus, error) {
+	ref := s.doc(handlerName, messageID)
+
+	result := DedupClaimed
+	err := s.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		result = DedupClaimed
+
+		snapshot, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return errors.WithMessage(err, "get processed message")
+		}
+
+		if err == nil {
+			var existing processedMessage
+			if err := snapshot.DataTo(&existing); err != nil {
+				return errors.WithMessage(err, "data to processed message")
+			}
+
+			// the TTL policy deletes the documents lazily
+			if time.Now().Before(existing.ExpiresAt) {
+				result = DedupInProgress
+				if existing.Processed {
+					result = DedupProcessed
+				}
+				return nil
+			}
+		}
+
+		return tx.Set(ref, &processedMessage{
+			HandlerName: handlerName,
+			MessageID:   messageID,
+			ExpiresAt:   time.Now().Add(lease),
+		})
+	})
+	if err != nil {
+		return DedupClaimed, errors.WithMessage(err, "claim message")
+	}
+
+	return result, nil
+}
+
+func (s *FirestoreDedupStore) MarkProcessed(ctx context.Context, handlerName, messageID string, ttl time.Duration) error {
+	_, err := s.doc(handlerName, messageID).Set(ctx, &processedMessage{
+		HandlerName: handlerName,
+		MessageID:   messageID,
+		Processed:   true,
+		ExpiresAt:   time.Now().Add(ttl),
+	})
+	if err != nil {
+		return errors.WithMessage(err, "mark message as processed")
+	}
+
+	return nil
+}
+
+func (s *FirestoreDedupStore) Release(ctx context.Context, handlerName, messageID string) error {
+	if _, err := s.doc(handlerName, messageID).Delete(ctx); err != nil {
+		return errors.WithMessage(err, "release message")
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+var RedisDedupWireset = wire.NewSet(
+	NewRedisDedupStore,
+	wire.Bind(new(DedupStore), new(*RedisDedupStore)),
+)
+
+// RedisDedupStore keeps a key per claimed message, the replicas share the claims.
+type RedisDedupStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisDedupStore(client *redis.Client, cfg *configsvc.ConfigService) *RedisDedupStore {
+	return &RedisDedupStore{
+		client: client,
+		prefix: "dedup:" + cfg.ServiceName + ":",
+	}
+}
+
+// the value of a claim key
+const (
+	redisDedupInProgress = "in_progress"
+	redisDedupProcessed  = "processed"
+)
+
+func (s *RedisDedupStore) Claim(ctx context.Context, handlerName, messageID string, lease time.Duration) (DedupStatus, error) {
+	key := s.prefix + dedupKey(handlerName, messageID)
+	claimed, err := s.client.SetNX(ctx, key, redisDedupInProgress, lease).Result()
+	if err != nil {
+		return DedupClaimed, errors.WithMessage(err, "claim message")
+	}
+	if claimed {
+		return DedupClaimed, nil
+	}
+
+	value, err := s.client.Get(ctx, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return DedupClaimed, errors.WithMessage(err, "get message claim")
+	}
+
+	// a key which expired meanwhile reads as in progress, the next redelivery claims it
+	if value == redisDedupProcessed {
+		return DedupProcessed, nil
+	}
+	return DedupInProgress, nil
+}
+
+func (s *RedisDedupStore) MarkProcessed(ctx context.Context, handlerName, messageID string, ttl time.Duration) error {
+	if err := s.client.Set(ctx, s.prefix+dedupKey(handlerName, messageID), redisDedupProcessed, ttl).Err(); err != nil {
+		return errors.WithMessage(err, "mark message as processed")
+	}
+
+	return nil
+}
+
+func (s *RedisDedupStore) Release(ctx context.Context, handlerName, messageID string) error {
+	if err := s.client.Del(ctx, s.prefix+dedupKey(handlerName, messageID)).Err(); err != nil {
+		return errors.WithMessage(err, "release message")
+	}
+
+	return nil
+}
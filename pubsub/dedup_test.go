@@ -0,0 +1,151 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// newDedupMessage returns a message outside of the router, its handler name is empty.
+func newDedupMessage(uuid string) *message.Message {
+	return message.NewMessage(uuid, nil)
+}
+
+func TestDeduplicatorProcessesAMessageOnce(t *testing.T) {
+	deduplicator := NewDeduplicator(NewMemoryDedupStore(), nil, zap.NewNop())
+
+	calls := 0
+	handler := deduplicator.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("failed")
+		}
+		return nil, nil
+	})
+
+	if _, err := handler(newDedupMessage("uuid")); err == nil {
+		t.Fatal("the error of the handler is not returned")
+	}
+	if _, err := handler(newDedupMessage("uuid")); err != nil {
+		t.Fatalf("the redelivery of a failed message: %v", err)
+	}
+	if _, err := handler(newDedupMessage("uuid")); err != nil {
+		t.Fatalf("the duplicate of a processed message: %v", err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("the handler ran %d times, want 2", calls)
+	}
+}
+
+func TestDeduplicatorProcessesTheMessageOfACrashedDeliveryOnceItsLeaseExpires(t *testing.T) {
+	store := NewMemoryDedupStore()
+	deduplicator := NewDeduplicator(store, nil, zap.NewNop())
+	deduplicator.Lease = 50 * time.Millisecond
+
+	// the delivery which held the lease crashed before the handler returned
+	if status, err := store.Claim(context.Background(), "", "uuid", deduplicator.Lease); err != nil || status != DedupClaimed {
+		t.Fatalf("claim: %v, %v", status, err)
+	}
+
+	calls := 0
+	handler := deduplicator.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		calls++
+		return nil, nil
+	})
+
+	if _, err := handler(newDedupMessage("uuid")); !errors.Is(err, ErrMessageInProgress) {
+		t.Fatalf("a redelivery during the lease: want ErrMessageInProgress, got %v", err)
+	}
+
+	time.Sleep(deduplicator.Lease)
+	if _, err := handler(newDedupMessage("uuid")); err != nil {
+		t.Fatalf("a redelivery after the lease: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("the handler ran %d times, want 1", calls)
+	}
+}
+
+// leaseRecordingStore records the lease of the claims.
+type leaseRecordingStore struct {
+	*MemoryDedupStore
+	lease time.Duration
+}
+
+func (s *leaseRecordingStore) Claim(ctx context.Context, handlerName, messageID string, lease time.Duration) (DedupStatus, error) {
+	s.lease = lease
+	return s.MemoryDedupStore.Claim(ctx, handlerName, messageID, lease)
+}
+
+type slowRetryHandler struct{}
+
+func (slowRetryHandler) RetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:         5,
+		InitialInterval:     2 * time.Second,
+		MaxInterval:         time.Minute,
+		Multiplier:          2,
+		MaxElapsedTime:      DefaultDedupLease,
+		RandomizationFactor: 0.5,
+	}
+}
+
+func TestDeduplicatorLeasesAMessageForTheRetriesOfItsHandler(t *testing.T) {
+	store := &leaseRecordingStore{MemoryDedupStore: NewMemoryDedupStore()}
+	handlers := NewHandlerRegistry()
+	handlers.Add("", slowRetryHandler{})
+	deduplicator := NewDeduplicator(store, handlers, zap.NewNop())
+
+	handler := deduplicator.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		return nil, nil
+	})
+	if _, err := handler(newDedupMessage("uuid")); err != nil {
+		t.Fatal(err)
+	}
+
+	window := slowRetryHandler{}.RetryPolicy().Window()
+	if window <= 0 {
+		t.Fatal("the retries of the handler have no window")
+	}
+	if want := deduplicator.Lease + window; store.lease != want {
+		t.Fatalf("the message is leased for %s, want the lease and the retry window %s", store.lease, want)
+	}
+}
+
+func TestRetryPolicyWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		want   time.Duration
+	}{
+		{name: "no retry", policy: NoRetry(), want: 0},
+		{
+			name:   "exponential intervals",
+			policy: RetryPolicy{MaxAttempts: 4, InitialInterval: time.Second, Multiplier: 2},
+			want:   7 * time.Second,
+		},
+		{
+			name:   "intervals capped before the randomization",
+			policy: RetryPolicy{MaxAttempts: 4, InitialInterval: time.Second, MaxInterval: 2 * time.Second, Multiplier: 2, RandomizationFactor: 0.5},
+			want:   7500 * time.Millisecond,
+		},
+		{
+			name:   "bounded by the max elapsed time",
+			policy: RetryPolicy{MaxAttempts: 10, InitialInterval: time.Minute, Multiplier: 2, MaxElapsedTime: 5 * time.Minute},
+			want:   5 * time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.Window(); got != test.want {
+				t.Fatalf("window is %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package pubsub
+
+import (
+	"sync"
+)
+
+// HandlerRegistry remembers the command and event handlers by their router handler name,
+// so router middlewares can look up the optional interfaces a handler implements.
+type HandlerRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]any
+}
+
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{
+		handlers: map[string]any{},
+	}
+}
+
+// Add records the handler, the processors call it when a handler is added to the router.
+func (r *HandlerRegistry) Add(handlerName string, handler any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers[handlerName] = handler
+}
+
+// Get returns the handler which is registered with the router handler name.
+func (r *HandlerRegistry) Get(handlerName string) (any, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	handler, ok := r.handlers[handlerName]
+	return handler, ok
+}
+
+// RetryPolicy returns the policy declared by the handler, if any.
+func (r *HandlerRegistry) RetryPolicy(handlerName string) (RetryPolicy, bool) {
+	handler, ok := r.Get(handlerName)
+	if !ok {
+		return RetryPolicy{}, false
+	}
+
+	provider, ok := handler.(RetryPolicyProvider)
+	if !ok {
+		return RetryPolicy{}, false
+	}
+
+	return provider.RetryPolicy(), true
+}
@@ -0,0 +1,30 @@
+package pubsub
+
+import (
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/prometheussvc"
+)
+
+// HandlerMetrics records the duration and the result of the handlers, it wraps the Retry middleware
+// so the duration includes the retries. The messages which Retry moved to the dead letter queue are dead_lettered.
+func HandlerMetrics(h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) ([]*message.Message, error) {
+		handlerName := message.HandlerNameFromCtx(msg.Context())
+		startedAt := time.Now()
+
+		producedMessages, err := h(msg)
+
+		result := "success"
+		if err != nil {
+			result = "error"
+		} else if IsDeadLettered(msg) {
+			result = "dead_lettered"
+		}
+		prometheussvc.MessagesProcessed.WithLabelValues(handlerName, result).Inc()
+		prometheussvc.MessageDuration.WithLabelValues(handlerName).Observe(time.Since(startedAt).Seconds())
+
+		return producedMessages, err
+	}
+}
@@ -0,0 +1,43 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/prometheussvc"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestHandlerMetricsCountTheDeadLetteredMessages(t *testing.T) {
+	deadLetters := NewDeadLetterQueue(NewMemoryDeadLetterStore(), nil, zap.NewNop())
+	retry := Retry{
+		Policy: NoRetry(),
+		Logger: watermill.NopLogger{},
+		OnFailed: func(msg *message.Message, err error, attempts int) ([]*message.Message, error) {
+			return nil, deadLetters.Add(msg, err, attempts)
+		},
+	}
+
+	handler := HandlerMetrics(retry.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		return nil, errors.New("failed")
+	}))
+
+	// the handler name is empty outside of the router
+	deadLettered := prometheussvc.MessagesProcessed.WithLabelValues("", "dead_lettered")
+	success := prometheussvc.MessagesProcessed.WithLabelValues("", "success")
+	before, beforeSuccess := testutil.ToFloat64(deadLettered), testutil.ToFloat64(success)
+
+	if _, err := handler(message.NewMessage(watermill.NewUUID(), nil)); err != nil {
+		t.Fatalf("the dead lettered message is not acked: %v", err)
+	}
+
+	if got := testutil.ToFloat64(deadLettered) - before; got != 1 {
+		t.Fatalf("%v dead lettered messages counted, want 1", got)
+	}
+	if got := testutil.ToFloat64(success) - beforeSuccess; got != 0 {
+		t.Fatalf("%v dead lettered messages counted as a success", got)
+	}
+}
@@ -0,0 +1,401 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// OutboxRecord is an event waiting to be published.
+// It is written together with the repository change which produced the event.
+type OutboxRecord struct {
+	ID        string    `json:"id" firestore:"id"`
+	EventName string    `json:"eventName" firestore:"eventName"`
+	Payload   []byte    `json:"payload" firestore:"payload"`
+	CreatedAt time.Time `json:"createdAt" firestore:"createdAt"`
+	Attempts  int       `json:"attempts" firestore:"attempts"`
+	LastError string    `json:"lastError" firestore:"lastError"`
+	// LeasedUntil is when the relay which claimed the record gives it up, zero when no relay claimed it.
+	LeasedUntil time.Time `json:"leasedUntil" firestore:"leasedUntil"`
+}
+
+// OutboxStore persists the outbox records.
+type OutboxStore interface {
+	// Add stores the records outside of any transaction.
+	Add(ctx context.Context, records ...*OutboxRecord) error
+	// Pending returns up to limit records which are not published yet, the oldest first.
+	Pending(ctx context.Context, limit int) ([]*OutboxRecord, error)
+	// Claim leases up to limit pending records until now plus lease, the oldest first.
+	// The records leased by another relay are skipped until their lease ends.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxRecord, error)
+	// Delete removes a published record.
+	Delete(ctx context.Context, id string) error
+	// MarkFailed records a failed publish attempt and ends the lease, the record stays pending.
+	MarkFailed(ctx context.Context, id string, err error) error
+}
+
+const (
+	defaultOutboxPollInterval = 5 * time.Second
+	defaultOutboxBatchSize    = 50
+	defaultOutboxLease        = time.Minute
+	defaultOutboxMaxAttempts  = 5
+)
+
+// Outbox publishes the stored events through the EventBus, at least once.
+// The relay starts with the router and polls the store until the router is closed.
+// Every replica runs a relay, a record is leased to one of them while it is published.
+type Outbox struct {
+	Store           OutboxStore
+	EventBus        *cqrs.EventBus
+	DeadLetterQueue *DeadLetterQueue
+	Logger          *zap.Logger
+	PollInterval    time.Duration
+	BatchSize       int
+	// Lease is how long a relay has to publish the records it claimed, another relay claims them afterward.
+	Lease time.Duration
+	// MaxAttempts is how many times a record is published before it moves to the dead letter queue.
+	MaxAttempts int
+
+	marshaler cqrs.JSONMarshaler
+	mu        sync.RWMutex
+	types     map[string]reflect.Type
+	notify    chan struct{}
+	stop      chan struct{}
+}
+
+func NewOutbox(
+	store OutboxStore,
+	eventBus *cqrs.EventBus,
+	deadLetterQueue *DeadLetterQueue,
+	router *message.Router,
+	logger *zap.Logger,
+) (*Outbox, func(), error) {
+	outbox := &Outbox{
+		Store:           store,
+		EventBus:        eventBus,
+		DeadLetterQueue: deadLetterQueue,
+		Logger:          logger.Named("outbox"),
+		PollInterval:    defaultOutboxPollInterval,
+		BatchSize:       defaultOutboxBatchSize,
+		Lease:           defaultOutboxLease,
+		MaxAttempts:     defaultOutboxMaxAttempts,
+		types:           map[string]reflect.Type{},
+		notify:          make(chan struct{}, 1),
+		stop:            make(chan struct{}),
+	}
+
+	router.AddPlugin(func(r *message.Router) error {
+		go func() {
+			<-r.Running()
+			outbox.run()
+		}()
+		return nil
+	})
+
+	cleanup := func() {
+		close(outbox.stop)
+	}
+
+	return outbox, cleanup, nil
+}
+
+// RegisterEvents lets the relay decode the records of these event types.
+// Every event type which can be pending when the service starts must be registered.
+func (o *Outbox) RegisterEvents(events ...any) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, event := range events {
+		eventType := reflect.TypeOf(event)
+		if eventType.Kind() == reflect.Ptr {
+			eventType = eventType.Elem()
+		}
+		o.types[o.marshaler.Name(event)] = eventType
+	}
+}
+
+// NewRecord builds the record of the event, to be written in the same transaction as the repository change.
+func (o *Outbox) NewRecord(event any) (*OutboxRecord, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, errors.WithMessage(err, "marshal event")
+	}
+
+	o.RegisterEvents(event)
+
+	return &OutboxRecord{
+		ID:        watermill.NewULID(),
+		EventName: o.marshaler.Name(event),
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// Publish stores the events, they are published by the relay.
+func (o *Outbox) Publish(ctx context.Context, events ...any) error {
+	records := make([]*OutboxRecord, 0, len(events))
+	for _, event := range events {
+		record, err := o.NewRecord(event)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+
+	if err := o.Store.Add(ctx, records...); err != nil {
+		return errors.WithMessage(err, "add outbox records")
+	}
+
+	o.Notify()
+	return nil
+}
+
+// Notify wakes up the relay, so the new records do not wait for the next poll.
+func (o *Outbox) Notify() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Flush claims the pending records and publishes them once, it returns the number of published records.
+// A record which failed MaxAttempts times moves to the dead letter queue, so it does not hold back the newer ones.
+// The publishes stop when the lease ends, another relay may claim the records then.
+func (o *Outbox) Flush(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, o.Lease)
+	defer cancel()
+
+	records, err := o.Store.Claim(ctx, o.BatchSize, o.Lease)
+	if err != nil {
+		return 0, errors.WithMessage(err, "claim pending outbox records")
+	}
+
+	published := 0
+	for _, record := range records {
+		if err := o.publish(ctx, record); err != nil {
+			if ctx.Err() != nil {
+				// the relay stops or the lease ended, the attempt does not count
+				return published, errors.WithMessage(ctx.Err(), "publish outbox record")
+			}
+
+			o.Logger.Error("failed to publish outbox record", zap.String("id", record.ID), zap.String("event", record.EventName), zap.Error(err))
+			if err := o.fail(ctx, record, err); err != nil {
+				o.Logger.Error("failed to mark outbox record as failed", zap.String("id", record.ID), zap.Error(err))
+			}
+			continue
+		}
+
+		// a crash before the delete publishes the record again, consumers must be idempotent
+		if err := o.Store.Delete(ctx, record.ID); err != nil {
+			return published, errors.WithMessage(err, "delete published outbox record")
+		}
+		published++
+	}
+
+	return published, nil
+}
+
+// fail records the failed attempt, or moves the record to the dead letter queue after the last attempt.
+func (o *Outbox) fail(ctx context.Context, record *OutboxRecord, publishErr error) error {
+	if record.Attempts+1 < o.MaxAttempts {
+		return o.Store.MarkFailed(ctx, record.ID, publishErr)
+	}
+
+	// the event name is the topic and the marshaler metadata, a replay reaches every handler of the event
+	deadLetter := &DeadLetter{
+		MessageUUID: record.ID,
+		Topic:       record.EventName,
+		Payload:     record.Payload,
+		Metadata:    map[string]string{"name": record.EventName},
+		Error:       publishErr.Error(),
+		Attempts:    record.Attempts + 1,
+		FailedAt:    time.Now(),
+	}
+	if err := o.DeadLetterQueue.Save(ctx, deadLetter); err != nil {
+		return err
+	}
+
+	if err := o.Store.Delete(ctx, record.ID); err != nil {
+		return errors.WithMessage(err, "delete dead outbox record")
+	}
+
+	return nil
+}
+
+func (o *Outbox) publish(ctx context.Context, record *OutboxRecord) error {
+	o.mu.RLock()
+	eventType, ok := o.types[record.EventName]
+	o.mu.RUnlock()
+	if !ok {
+		return errors.Errorf("event %s is not registered in the outbox", record.EventName)
+	}
+
+	event := reflect.New(eventType).Interface()
+	if err := json.Unmarshal(record.Payload, event); err != nil {
+		return errors.WithMessage(err, "unmarshal event")
+	}
+
+	// the record id is used as message uuid, so a duplicate can be recognized by the consumers
+	return o.EventBus.Publish(WithMessageUUID(ctx, record.ID), event)
+}
+
+func (o *Outbox) run() {
+	ticker := time.NewTicker(o.PollInterval)
+	defer ticker.Stop()
+
+	// the cleanup cancels the flush in progress
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-o.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		for {
+			published, err := o.Flush(ctx)
+			if err != nil && ctx.Err() == nil {
+				o.Logger.Error("failed to flush outbox", zap.Error(err))
+			}
+
+			// keep flushing while full batches are published
+			if err != nil || published < o.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+		case <-o.notify:
+		}
+	}
+}
+
+type messageUUIDKey struct{}
+
+// WithMessageUUID sets the uuid of the message which the buses publish with this context.
+func WithMessageUUID(ctx context.Context, uuid string) context.Context {
+	return context.WithValue(ctx, messageUUIDKey{}, uuid)
+}
+
+func messageUUIDFromCtx(ctx context.Context) (string, bool) {
+	uuid, ok := ctx.Value(messageUUIDKey{}).(string)
+	return uuid, ok && uuid != ""
+}
+
+var MemoryOutboxWireset = wire.NewSet(
+	NewMemoryOutboxStore,
+	wire.Bind(new(OutboxStore), new(*MemoryOutboxStore)),
+)
+
+// MemoryOutboxStore keeps the records in memory, they are lost on restart.
+type MemoryOutboxStore struct {
+	mu      sync.Mutex
+	records map[string]*OutboxRecord
+}
+
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{
+		records: map[string]*OutboxRecord{},
+	}
+}
+
+func (s *MemoryOutboxStore) Add(ctx context.Context, records ...*OutboxRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range records {
+		stored := *record
+		s.records[record.ID] = &stored
+	}
+
+	return nil
+}
+
+func (s *MemoryOutboxStore) Pending(ctx context.Context, limit int) ([]*OutboxRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]*OutboxRecord, 0, len(s.records))
+	for _, record := range s.records {
+		copied := *record
+		records = append(records, &copied)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+
+	return records, nil
+}
+
+func (s *MemoryOutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	records := make([]*OutboxRecord, 0, len(s.records))
+	for _, record := range s.records {
+		if record.LeasedUntil.After(now) {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+
+	claimed := make([]*OutboxRecord, 0, len(records))
+	for _, record := range records {
+		record.LeasedUntil = now.Add(lease)
+		copied := *record
+		claimed = append(claimed, &copied)
+	}
+
+	return claimed, nil
+}
+
+func (s *MemoryOutboxStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, id)
+	return nil
+}
+
+func (s *MemoryOutboxStore) MarkFailed(ctx context.Context, id string, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[id]; ok {
+		record.Attempts++
+		record.LastError = err.Error()
+		record.LeasedUntil = time.Time{}
+	}
+
+	return nil
+}
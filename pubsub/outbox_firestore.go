@@ -0,0 +1,146 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var FirestoreOutboxWireset = wire.NewSet(
+	NewFirestoreOutboxStore,
+	wire.Bind(new(OutboxStore), new(*FirestoreOutboxStore)),
+)
+
+// OutboxCollection is the firestore collection of the outbox records.
+// Repositories write into it inside their transaction, see CreateOutboxRecords.
+const OutboxCollection = "outbox"
+
+// CreateOutboxRecords adds the records to a firestore transaction.
+func CreateOutboxRecords(client *firestore.Client, tx *firestore.Transaction, records ...*OutboxRecord) error {
+	for _, record := range records {
+		if err := tx.Create(client.Collection(OutboxCollection).Doc(record.ID), record); err != nil {
+			return errors.WithMessage(err, "create outbox record")
+		}
+	}
+
+	return nil
+}
+
+// FirestoreOutboxStore reads the records which the repositories wrote in the outbox collection.
+type FirestoreOutboxStore struct {
+	firestoreClient *firestore.Client
+}
+
+func NewFirestoreOutboxStore(firestoreClient *firestore.Client) *FirestoreOutboxStore {
+	return &FirestoreOutboxStore{
+		firestoreClient: firestoreClient,
+	}
+}
+
+func (s *FirestoreOutboxStore) Add(ctx context.Context, records ...*OutboxRecord) error {
+	batch := s.firestoreClient.Batch()
+	for _, record := range records {
+		batch.Create(s.firestoreClient.Collection(OutboxCollection).Doc(record.ID), record)
+	}
+
+	if _, err := batch.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "add outbox records")
+	}
+
+	return nil
+}
+
+func (s *FirestoreOutboxStore) Pending(ctx context.Context, limit int) ([]*OutboxRecord, error) {
+	query := s.firestoreClient.Collection(OutboxCollection).OrderBy("createdAt", firestore.Asc)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	snapshots, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, errors.WithMessage(err, "list outbox records")
+	}
+
+	records := make([]*OutboxRecord, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		var record OutboxRecord
+		if err := snapshot.DataTo(&record); err != nil {
+			return nil, errors.WithMessage(err, "data to outbox record")
+		}
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
+
+// Claim leases the records in a transaction, so two relays do not claim the same record.
+// It needs a composite index of the outbox collection on leasedUntil and createdAt.
+func (s *FirestoreOutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxRecord, error) {
+	var claimed []*OutboxRecord
+	err := s.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		now := time.Now()
+		query := s.firestoreClient.Collection(OutboxCollection).
+			Where("leasedUntil", "<=", now).
+			OrderBy("leasedUntil", firestore.Asc).
+			OrderBy("createdAt", firestore.Asc)
+		if limit > 0 {
+			query = query.Limit(limit)
+		}
+
+		snapshots, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return errors.WithMessage(err, "list outbox records")
+		}
+
+		claimed = make([]*OutboxRecord, 0, len(snapshots))
+		for _, snapshot := range snapshots {
+			var record OutboxRecord
+			if err := snapshot.DataTo(&record); err != nil {
+				return errors.WithMessage(err, "data to outbox record")
+			}
+
+			record.LeasedUntil = now.Add(lease)
+			if err := tx.Update(snapshot.Ref, []firestore.Update{{Path: "leasedUntil", Value: record.LeasedUntil}}); err != nil {
+				return errors.WithMessage(err, "lease outbox record")
+			}
+			claimed = append(claimed, &record)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "claim outbox records")
+	}
+
+	return claimed, nil
+}
+
+func (s *FirestoreOutboxStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.firestoreClient.Collection(OutboxCollection).Doc(id).Delete(ctx); err != nil {
+		return errors.WithMessage(err, "delete outbox record")
+	}
+
+	return nil
+}
+
+func (s *FirestoreOutboxStore) MarkFailed(ctx context.Context, id string, err error) error {
+	updates := []firestore.Update{
+		{Path: "attempts", Value: firestore.Increment(1)},
+		{Path: "lastError", Value: err.Error()},
+		{Path: "leasedUntil", Value: time.Time{}},
+	}
+
+	if _, updateErr := s.firestoreClient.Collection(OutboxCollection).Doc(id).Update(ctx, updates); updateErr != nil {
+		if status.Code(updateErr) == codes.NotFound {
+			return nil
+		}
+		return errors.WithMessage(updateErr, "mark outbox record as failed")
+	}
+
+	return nil
+}
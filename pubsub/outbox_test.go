@@ -0,0 +1,359 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type outboxTestEvt struct {
+	Name string `json:"name"`
+}
+
+// failingPublisher fails the first publishes, then publishes through the channel.
+type failingPublisher struct {
+	message.Publisher
+	failures int
+}
+
+func (p *failingPublisher) Publish(topic string, messages ...*message.Message) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker is unavailable")
+	}
+
+	return p.Publisher.Publish(topic, messages...)
+}
+
+type outboxFixture struct {
+	store     *MemoryOutboxStore
+	deadStore *MemoryDeadLetterStore
+	publisher *failingPublisher
+	messages  <-chan *message.Message
+}
+
+func newOutboxFixture(t *testing.T) *outboxFixture {
+	t.Helper()
+
+	channel := gochannel.NewGoChannel(gochannel.Config{OutputChannelBuffer: 10}, watermill.NopLogger{})
+	t.Cleanup(func() { _ = channel.Close() })
+
+	messages, err := channel.Subscribe(context.Background(), cqrs.JSONMarshaler{}.Name(&outboxTestEvt{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &outboxFixture{
+		store:     NewMemoryOutboxStore(),
+		deadStore: NewMemoryDeadLetterStore(),
+		publisher: &failingPublisher{Publisher: channel},
+		messages:  messages,
+	}
+}
+
+// newOutbox starts a relay on the store, like a replica which (re)starts.
+func (f *outboxFixture) newOutbox(t *testing.T) *Outbox {
+	t.Helper()
+
+	router, err := message.NewRouter(message.RouterConfig{}, watermill.NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox, _ := f.newRelay(t, f.publisher, router)
+	return outbox
+}
+
+// newRelay returns a relay which publishes through the publisher, it starts with the router.
+func (f *outboxFixture) newRelay(t *testing.T, publisher message.Publisher, router *message.Router) (*Outbox, func()) {
+	t.Helper()
+
+	eventBus, err := NewEventBus(publisher, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadLetterQueue := NewDeadLetterQueue(f.deadStore, f.publisher, zap.NewNop())
+	outbox, cleanup, err := NewOutbox(f.store, eventBus, deadLetterQueue, router, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var once sync.Once
+	stop := func() { once.Do(cleanup) }
+	t.Cleanup(stop)
+
+	outbox.RegisterEvents(&outboxTestEvt{})
+	return outbox, stop
+}
+
+func (f *outboxFixture) receive(t *testing.T) *message.Message {
+	t.Helper()
+
+	select {
+	case msg := <-f.messages:
+		msg.Ack()
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message was published")
+		return nil
+	}
+}
+
+func (f *outboxFixture) assertNothingReceived(t *testing.T) {
+	t.Helper()
+
+	select {
+	case msg := <-f.messages:
+		t.Fatalf("unexpected message %s", msg.UUID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (f *outboxFixture) assertPending(t *testing.T, want int) []*OutboxRecord {
+	t.Helper()
+
+	pending, err := f.store.Pending(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != want {
+		t.Fatalf("%d pending records, want %d", len(pending), want)
+	}
+
+	return pending
+}
+
+func flush(t *testing.T, outbox *Outbox, want int) {
+	t.Helper()
+
+	published, err := outbox.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published != want {
+		t.Fatalf("published %d records, want %d", published, want)
+	}
+}
+
+func TestOutboxRelaysThePendingRecordsAfterARestart(t *testing.T) {
+	f := newOutboxFixture(t)
+
+	// the records were stored by a replica which stopped before relaying them
+	previous := f.newOutbox(t)
+	record, err := previous.NewRecord(&outboxTestEvt{Name: "installed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.store.Add(context.Background(), record); err != nil {
+		t.Fatal(err)
+	}
+
+	flush(t, f.newOutbox(t), 1)
+
+	msg := f.receive(t)
+	if msg.UUID != record.ID {
+		t.Fatalf("message uuid is %s, want the record id %s", msg.UUID, record.ID)
+	}
+	f.assertPending(t, 0)
+}
+
+func TestOutboxRetriesAFailedPublish(t *testing.T) {
+	f := newOutboxFixture(t)
+	outbox := f.newOutbox(t)
+	f.publisher.failures = 1
+
+	if err := outbox.Publish(context.Background(), &outboxTestEvt{Name: "installed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	flush(t, outbox, 0)
+	pending := f.assertPending(t, 1)
+	if pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Fatalf("the failed attempt is not recorded: %+v", pending[0])
+	}
+	if !pending[0].LeasedUntil.IsZero() {
+		t.Fatalf("the lease of the failed record is not ended")
+	}
+
+	flush(t, outbox, 1)
+	f.receive(t)
+	f.assertPending(t, 0)
+}
+
+func TestOutboxDoesNotRepublishTheSentRecords(t *testing.T) {
+	f := newOutboxFixture(t)
+	outbox := f.newOutbox(t)
+
+	if err := outbox.Publish(context.Background(), &outboxTestEvt{Name: "installed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	flush(t, outbox, 1)
+	f.receive(t)
+
+	flush(t, outbox, 0)
+	flush(t, f.newOutbox(t), 0)
+	f.assertNothingReceived(t)
+}
+
+func TestOutboxSkipsTheRecordsLeasedByAnotherRelay(t *testing.T) {
+	f := newOutboxFixture(t)
+	outbox := f.newOutbox(t)
+
+	if err := outbox.Publish(context.Background(), &outboxTestEvt{Name: "installed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := f.store.Claim(context.Background(), 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 {
+		t.Fatalf("claimed %d records, want 1", len(claimed))
+	}
+
+	flush(t, outbox, 0)
+	f.assertNothingReceived(t)
+	f.assertPending(t, 1)
+}
+
+func TestOutboxMovesTheExhaustedRecordsToTheDeadLetterQueue(t *testing.T) {
+	f := newOutboxFixture(t)
+	outbox := f.newOutbox(t)
+	outbox.MaxAttempts = 2
+
+	// the event of a poison record is not registered, its publish fails every time
+	poison := &OutboxRecord{ID: watermill.NewULID(), EventName: "unknown", Payload: []byte(`{}`), CreatedAt: time.Now().Add(-time.Minute)}
+	if err := f.store.Add(context.Background(), poison); err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Publish(context.Background(), &outboxTestEvt{Name: "installed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	flush(t, outbox, 1)
+	f.receive(t)
+	flush(t, outbox, 0)
+	f.assertPending(t, 0)
+
+	deadLetters, err := f.deadStore.List(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].MessageUUID != poison.ID || deadLetters[0].Attempts != 2 {
+		t.Fatalf("the poison record is not dead lettered: %+v", deadLetters)
+	}
+}
+
+// blockingPublisher holds the publishes until their context is done.
+type blockingPublisher struct {
+	entered chan struct{}
+	done    chan error
+}
+
+func newBlockingPublisher() *blockingPublisher {
+	return &blockingPublisher{
+		entered: make(chan struct{}, 10),
+		done:    make(chan error, 10),
+	}
+}
+
+func (p *blockingPublisher) Publish(topic string, messages ...*message.Message) error {
+	p.entered <- struct{}{}
+	<-messages[0].Context().Done()
+	err := messages[0].Context().Err()
+	p.done <- err
+	return err
+}
+
+func (p *blockingPublisher) Close() error {
+	return nil
+}
+
+func waitFor[T any](t *testing.T, channel <-chan T, what string) T {
+	t.Helper()
+
+	select {
+	case value := <-channel:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+func TestOutboxLeasesARecordToOneRelayUntilTheLeaseEnds(t *testing.T) {
+	f := newOutboxFixture(t)
+	router, err := message.NewRouter(message.RouterConfig{}, watermill.NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// relay A claims the record, then its publish hangs
+	stuck := newBlockingPublisher()
+	relayA, _ := f.newRelay(t, stuck, router)
+	relayA.Lease = 200 * time.Millisecond
+	if err := relayA.Publish(context.Background(), &outboxTestEvt{Name: "installed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	flushedA := make(chan error, 1)
+	go func() {
+		_, err := relayA.Flush(context.Background())
+		flushedA <- err
+	}()
+	waitFor(t, stuck.entered, "the publish of relay A")
+
+	relayB := f.newOutbox(t)
+	flush(t, relayB, 0)
+	f.assertNothingReceived(t)
+
+	// the lease ends, relay A gives the record up without counting an attempt
+	if err := waitFor(t, flushedA, "the flush of relay A"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("the flush of relay A returned %v, want the end of its lease", err)
+	}
+	if pending := f.assertPending(t, 1); pending[0].Attempts != 0 {
+		t.Fatalf("the record has %d attempts, the end of a lease is not a failure", pending[0].Attempts)
+	}
+
+	flush(t, relayB, 1)
+	f.receive(t)
+	f.assertPending(t, 0)
+}
+
+func TestOutboxCleanupCancelsTheFlushInProgress(t *testing.T) {
+	f := newOutboxFixture(t)
+	router, err := message.NewRouter(message.RouterConfig{}, watermill.NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stuck := newBlockingPublisher()
+	outbox, cleanup := f.newRelay(t, stuck, router)
+	if err := outbox.Publish(context.Background(), &outboxTestEvt{Name: "installed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = router.Run(context.Background()) }()
+	<-router.Running()
+	defer router.Close()
+
+	waitFor(t, stuck.entered, "the publish of the relay")
+	cleanup()
+
+	if err := waitFor(t, stuck.done, "the publish to be cancelled"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("the publish ended with %v, want it cancelled by the cleanup", err)
+	}
+	if pending := f.assertPending(t, 1); pending[0].Attempts != 0 {
+		t.Fatalf("the record has %d attempts, the shutdown is not a failure", pending[0].Attempts)
+	}
+}
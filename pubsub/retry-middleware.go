@@ -14,37 +14,46 @@ import (
 
 // Retry provides a middleware that retries the api if errors are returned.
 // The retry behaviour is configurable, with exponential backoff and maximum elapsed time.
+// Handlers can override the default policy by implementing RetryPolicyProvider.
 type Retry struct {
-	// MaxRetries is maximum number of times a retry will be attempted.
-	MaxRetries int
-
-	// InitalInterval is the first interval between retries. Subsequent intervals will be scaled by Multiplier.
-	InitialInterval time.Duration
-	// MaxInterval sets the limit for the exponential backoff of retries. The interval will not be increased beyond MaxInterval.
-	MaxInterval time.Duration
-	// Multiplier is the factor by which the waiting interval will be multiplied between retries.
-	Multiplier float64
-	// MaxElapsedTime sets the time limit of how long retries will be attempted. Disabled if 0.
-	MaxElapsedTime time.Duration
-	// RandomizationFactor randomizes the spread of the backoff times within the interval of:
-	// [currentInterval * (1 - randomization_factor), currentInterval * (1 + randomization_factor)].
-	RandomizationFactor float64
-
-	OnFailed func(msg *message.Message, err error) ([]*message.Message, error)
+	// Policy is used for the handlers which do not declare their own policy.
+	Policy RetryPolicy
+
+	// Handlers is used to find the policy declared by the handler of the message. Optional.
+	Handlers *HandlerRegistry
+
+	// OnFailed is called when the message still fails after the last retry, or when the handler panics.
+	// attempts is the number of times the handler was called.
+	OnFailed func(msg *message.Message, err error, attempts int) ([]*message.Message, error)
 
 	// OnRetryHook is an optional function that will be executed on each retry attempt.
 	// The number of the current retry is passed as retryNum,
 	OnRetryHook func(retryNum int, delay time.Duration)
 
+	// OnRetry is like OnRetryHook, with the message being retried. Optional.
+	OnRetry func(msg *message.Message, retryNum int, delay time.Duration)
+
 	Logger watermill.LoggerAdapter
 }
 
+// policyFor returns the policy of the handler which processes the message.
+func (r Retry) policyFor(msg *message.Message) RetryPolicy {
+	if r.Handlers != nil {
+		if policy, ok := r.Handlers.RetryPolicy(message.HandlerNameFromCtx(msg.Context())); ok {
+			return policy
+		}
+	}
+
+	return r.Policy
+}
+
 func (r Retry) Middleware(h message.HandlerFunc) message.HandlerFunc {
 	return func(msg *message.Message) (events []*message.Message, err error) {
+		attempts := 1
 		defer func() {
 			if reason := recover(); reason != nil {
 				if r.OnFailed != nil {
-					_, err = r.OnFailed(msg, errors.WithStack(fmt.Errorf("recover: %v: ; Stack: %s", reason, debug.Stack())))
+					_, err = r.OnFailed(msg, errors.WithStack(fmt.Errorf("recover: %v: ; Stack: %s", reason, debug.Stack())), attempts)
 				} else {
 					err = errors.WithStack(fmt.Errorf("recover: %v: ; Stack: %s", reason, debug.Stack()))
 				}
@@ -56,32 +65,44 @@ func (r Retry) Middleware(h message.HandlerFunc) message.HandlerFunc {
 			return producedMessages, nil
 		}
 
+		policy := r.policyFor(msg)
+
 		expBackoff := backoff.NewExponentialBackOff()
-		expBackoff.InitialInterval = r.InitialInterval
-		expBackoff.MaxInterval = r.MaxInterval
-		expBackoff.Multiplier = r.Multiplier
-		expBackoff.MaxElapsedTime = r.MaxElapsedTime
-		expBackoff.RandomizationFactor = r.RandomizationFactor
+		expBackoff.InitialInterval = policy.InitialInterval
+		expBackoff.MaxInterval = policy.MaxInterval
+		expBackoff.Multiplier = policy.Multiplier
+		expBackoff.MaxElapsedTime = policy.MaxElapsedTime
+		expBackoff.RandomizationFactor = policy.RandomizationFactor
 
 		ctx := msg.Context()
-		if r.MaxElapsedTime > 0 {
+		if policy.MaxElapsedTime > 0 {
 			var cancel func()
-			ctx, cancel = context.WithTimeout(ctx, r.MaxElapsedTime)
+			ctx, cancel = context.WithTimeout(ctx, policy.MaxElapsedTime)
 			defer cancel()
 		}
 
-		retryNum := 1
 		expBackoff.Reset()
 	retryLoop:
-		for {
+		for attempts < policy.MaxAttempts && policy.shouldRetry(err) {
 			waitTime := expBackoff.NextBackOff()
+			if waitTime == backoff.Stop {
+				break retryLoop
+			}
+
 			select {
 			case <-ctx.Done():
-				return producedMessages, err
+				if msg.Context().Err() != nil {
+					// the router is closing, nack the message so it is delivered again
+					return producedMessages, err
+				}
+				// MaxElapsedTime is reached
+				break retryLoop
 			case <-time.After(waitTime):
 				// go on
 			}
 
+			retryNum := attempts
+			attempts++
 			producedMessages, err = h(msg)
 			if err == nil {
 				return producedMessages, nil
@@ -90,23 +111,21 @@ func (r Retry) Middleware(h message.HandlerFunc) message.HandlerFunc {
 			if r.Logger != nil {
 				r.Logger.Error("Error occurred, retrying", err, watermill.LogFields{
 					"retry_no":     retryNum,
-					"max_retries":  r.MaxRetries,
+					"max_attempts": policy.MaxAttempts,
 					"wait_time":    waitTime,
 					"elapsed_time": expBackoff.GetElapsedTime(),
-				})
+				}.Add(traceLogFields(msg.Context())))
 			}
 			if r.OnRetryHook != nil {
 				r.OnRetryHook(retryNum, waitTime)
 			}
-
-			retryNum++
-			if retryNum > r.MaxRetries {
-				break retryLoop
+			if r.OnRetry != nil {
+				r.OnRetry(msg, retryNum, waitTime)
 			}
 		}
 
 		if r.OnFailed != nil {
-			return r.OnFailed(msg, err)
+			return r.OnFailed(msg, err, attempts)
 		}
 
 		return nil, err
@@ -0,0 +1,193 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pkg/errors"
+)
+
+// fastRetryPolicy retries without waiting long.
+func fastRetryPolicy(maxAttempts int) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     maxAttempts,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		Multiplier:      1,
+	}
+}
+
+type retryPolicyHandler struct {
+	policy RetryPolicy
+}
+
+func (h retryPolicyHandler) RetryPolicy() RetryPolicy {
+	return h.policy
+}
+
+// failedDelivery is what the OnFailed hook of a Retry middleware received.
+type failedDelivery struct {
+	calls    int
+	err      error
+	attempts int
+}
+
+// runRetry runs a failing handler through the middleware, outside of the router the handler name is empty.
+func runRetry(t *testing.T, retry Retry, handlerErr func(call int) error) (*failedDelivery, error) {
+	t.Helper()
+
+	delivery := &failedDelivery{}
+	retry.OnFailed = func(msg *message.Message, err error, attempts int) ([]*message.Message, error) {
+		delivery.err = err
+		delivery.attempts = attempts
+		return nil, nil
+	}
+
+	handler := retry.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		delivery.calls++
+		return nil, handlerErr(delivery.calls)
+	})
+	_, err := handler(message.NewMessage("uuid", nil))
+
+	return delivery, err
+}
+
+func TestRetryUsesThePolicyOfTheHandler(t *testing.T) {
+	handlers := NewHandlerRegistry()
+	handlers.Add("", retryPolicyHandler{policy: fastRetryPolicy(4)})
+
+	delivery, err := runRetry(t, Retry{Policy: fastRetryPolicy(2), Handlers: handlers}, func(int) error {
+		return errors.New("failed")
+	})
+	if err != nil {
+		t.Fatalf("the dead lettered message is not acked: %v", err)
+	}
+
+	if delivery.calls != 4 || delivery.attempts != 4 {
+		t.Fatalf("the handler ran %d times and OnFailed got %d attempts, want the 4 of the handler policy", delivery.calls, delivery.attempts)
+	}
+}
+
+func TestRetryUsesTheDefaultPolicyOfTheHandlersWhichDeclareNone(t *testing.T) {
+	handlers := NewHandlerRegistry()
+	handlers.Add("", struct{}{})
+
+	delivery, _ := runRetry(t, Retry{Policy: fastRetryPolicy(3), Handlers: handlers}, func(int) error {
+		return errors.New("failed")
+	})
+
+	if delivery.calls != 3 || delivery.attempts != 3 {
+		t.Fatalf("the handler ran %d times and OnFailed got %d attempts, want 3", delivery.calls, delivery.attempts)
+	}
+}
+
+func TestRetryDeadLettersAnErrorWhichIsNotRetryable(t *testing.T) {
+	permanent := errors.New("permanent")
+	policy := fastRetryPolicy(5)
+	policy.Retryable = func(err error) bool {
+		return !errors.Is(err, permanent)
+	}
+
+	tests := []struct {
+		name     string
+		failures []error
+		want     int
+	}{
+		{name: "at the first attempt", failures: []error{permanent}, want: 1},
+		{name: "after a temporary error", failures: []error{errors.New("temporary"), permanent}, want: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delivery, _ := runRetry(t, Retry{Policy: policy}, func(call int) error {
+				return test.failures[call-1]
+			})
+
+			if delivery.calls != test.want || delivery.attempts != test.want {
+				t.Fatalf("the handler ran %d times and OnFailed got %d attempts, want %d", delivery.calls, delivery.attempts, test.want)
+			}
+			if !errors.Is(delivery.err, permanent) {
+				t.Fatalf("OnFailed got %v, want the last error", delivery.err)
+			}
+		})
+	}
+}
+
+func TestRetryWithNoRetry(t *testing.T) {
+	delivery, _ := runRetry(t, Retry{Policy: NoRetry()}, func(int) error {
+		return errors.New("failed")
+	})
+
+	if delivery.calls != 1 || delivery.attempts != 1 {
+		t.Fatalf("the handler ran %d times and OnFailed got %d attempts, want 1", delivery.calls, delivery.attempts)
+	}
+}
+
+func TestRetryStopsAtTheFirstSuccess(t *testing.T) {
+	var retries []int
+	retry := Retry{
+		Policy: fastRetryPolicy(5),
+		OnRetry: func(msg *message.Message, retryNum int, delay time.Duration) {
+			retries = append(retries, retryNum)
+		},
+	}
+
+	delivery, err := runRetry(t, retry, func(call int) error {
+		if call < 3 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if delivery.calls != 3 {
+		t.Fatalf("the handler ran %d times, want 3", delivery.calls)
+	}
+	if delivery.attempts != 0 {
+		t.Fatal("OnFailed is called for a message which succeeded")
+	}
+	// OnRetry reports the retries which failed
+	if len(retries) != 1 || retries[0] != 1 {
+		t.Fatalf("OnRetry got the retries %v, want [1]", retries)
+	}
+}
+
+func TestRetryCountsTheAttemptWhichPanics(t *testing.T) {
+	delivery := &failedDelivery{}
+	retry := Retry{
+		Policy: fastRetryPolicy(3),
+		OnFailed: func(msg *message.Message, err error, attempts int) ([]*message.Message, error) {
+			delivery.err = err
+			delivery.attempts = attempts
+			return nil, nil
+		},
+	}
+
+	handler := retry.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		delivery.calls++
+		if delivery.calls == 2 {
+			panic("boom")
+		}
+		return nil, errors.New("failed")
+	})
+	if _, err := handler(message.NewMessage("uuid", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if delivery.attempts != 2 {
+		t.Fatalf("OnFailed got %d attempts, want the 2 calls of the handler", delivery.attempts)
+	}
+}
+
+func TestRetryReturnsTheErrorWithoutOnFailed(t *testing.T) {
+	handler := Retry{Policy: fastRetryPolicy(2)}.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		return nil, errors.New("failed")
+	})
+
+	if _, err := handler(message.NewMessage("uuid", nil)); err == nil {
+		t.Fatal("the error is not returned, the message would be acked")
+	}
+}
@@ -0,0 +1,90 @@
+package pubsub
+
+import (
+	"time"
+)
+
+// RetryPolicyProvider is implemented by the command and event handlers which need their own retry policy,
+// next to HandlerName and NewCommand/NewEvent. Handlers without it use the router default policy.
+type RetryPolicyProvider interface {
+	RetryPolicy() RetryPolicy
+}
+
+// RetryPolicy describes how a failed message is retried before it is moved to the dead letter queue.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times the handler is called, including the first call.
+	// A value of 1 or less disables retries.
+	MaxAttempts int
+
+	// InitialInterval is the first interval between retries. Subsequent intervals will be scaled by Multiplier.
+	InitialInterval time.Duration
+	// MaxInterval sets the limit for the exponential backoff of retries. The interval will not be increased beyond MaxInterval.
+	MaxInterval time.Duration
+	// Multiplier is the factor by which the waiting interval will be multiplied between retries.
+	Multiplier float64
+	// MaxElapsedTime sets the time limit of how long retries will be attempted. Disabled if 0.
+	MaxElapsedTime time.Duration
+	// RandomizationFactor randomizes the spread of the backoff times within the interval of:
+	// [currentInterval * (1 - randomization_factor), currentInterval * (1 + randomization_factor)].
+	RandomizationFactor float64
+
+	// Retryable decides if the error is worth another attempt. Every error is retried when it is nil.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy is used by the handlers which do not declare their own policy.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:         3,
+		InitialInterval:     time.Second,
+		MaxInterval:         10 * time.Second,
+		Multiplier:          2,
+		MaxElapsedTime:      time.Minute,
+		RandomizationFactor: 0.5,
+	}
+}
+
+// NoRetry is a policy which sends the message to the dead letter queue at the first failure.
+func NoRetry() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 1,
+	}
+}
+
+// Window is the longest time the retries of a message wait between the attempts, the attempts themselves aside.
+func (p RetryPolicy) Window() time.Duration {
+	if p.MaxAttempts <= 1 {
+		return 0
+	}
+
+	var window time.Duration
+	interval := float64(p.InitialInterval)
+	for i := 1; i < p.MaxAttempts; i++ {
+		wait := interval
+		if p.MaxInterval > 0 && wait > float64(p.MaxInterval) {
+			wait = float64(p.MaxInterval)
+		}
+		// the backoff randomizes the interval after capping it
+		window += time.Duration(wait * (1 + p.RandomizationFactor))
+		interval *= p.Multiplier
+	}
+
+	// the Retry middleware stops waiting at MaxElapsedTime
+	if p.MaxElapsedTime > 0 && window > p.MaxElapsedTime {
+		window = p.MaxElapsedTime
+	}
+
+	return window
+}
+
+func (p RetryPolicy) shouldRetry(err error) bool {
+	if p.MaxAttempts <= 1 {
+		return false
+	}
+
+	if p.Retryable == nil {
+		return true
+	}
+
+	return p.Retryable(err)
+}
@@ -1,9 +1,15 @@
 package pubsub
 
 import (
-	"github.com/aiocean/wireset/configsvc"
+	"context"
 	"time"
 
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/aiocean/wireset/prometheussvc"
+	"github.com/pkg/errors"
+
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
 	"github.com/garsue/watermillzap"
@@ -13,6 +19,10 @@ import (
 func NewRouter(
 	logSvc *zap.Logger,
 	cfg *configsvc.ConfigService,
+	deadLetters *DeadLetterQueue,
+	handlers *HandlerRegistry,
+	deduplicator *Deduplicator,
+	healthRegistry *fiberapp.HealthRegistry,
 ) (*message.Router, func(), error) {
 	logger := logSvc.With(zap.Strings("tags", []string{"Router"}))
 	waterLogger := watermillzap.NewLogger(logger)
@@ -25,18 +35,40 @@ func NewRouter(
 	router.AddMiddleware(
 		//middleware.Recoverer,
 		middleware.CorrelationID,
+		Tracing,
+		ReplayFilter,
+		deduplicator.Middleware,
+		HandlerMetrics,
 		Retry{
-			MaxRetries:      2,
-			InitialInterval: time.Second * 1,
-			Logger:          waterLogger,
-			OnFailed: func(msg *message.Message, err error) ([]*message.Message, error) {
-				// save event to collection
-				logger.Error("Router: error handling message", zap.String("err", err.Error()))
+			Policy:   deduplicator.RetryPolicy,
+			Handlers: handlers,
+			Logger:   waterLogger,
+			OnRetry: func(msg *message.Message, retryNum int, delay time.Duration) {
+				prometheussvc.MessagesRetried.WithLabelValues(message.HandlerNameFromCtx(msg.Context())).Inc()
+			},
+			OnFailed: func(msg *message.Message, err error, attempts int) ([]*message.Message, error) {
+				prometheussvc.MessagesFailed.WithLabelValues(message.HandlerNameFromCtx(msg.Context())).Inc()
+				if saveErr := deadLetters.Add(msg, err, attempts); saveErr != nil {
+					// nack the message, so it is not lost while the dead letter store is unavailable
+					logsvc.WithTrace(msg.Context(), logger).Error("Router: error saving dead letter", zap.String("err", err.Error()), zap.Error(saveErr))
+					return nil, saveErr
+				}
 				return nil, nil
 			},
 		}.Middleware,
 	)
 
+	healthRegistry.AddHealthChecks(&fiberapp.HealthCheck{
+		Name:     "router",
+		Critical: true,
+		Check: func(ctx context.Context) error {
+			if router.IsClosed() {
+				return errors.New("router is closed")
+			}
+			return nil
+		},
+	})
+
 	cleanup := func() {
 		logger.Info("Router: Cleaning up")
 		if err := router.Close(); err != nil {
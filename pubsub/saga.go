@@ -0,0 +1,517 @@
+package pubsub
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/aiocean/wireset/logsvc"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Saga is a workflow which spans several handlers.
+// Each step is completed by an event, the events of one saga instance are correlated by a key such as the shop id.
+// The first handled step starts the saga, the saga is completed when every step is completed.
+type Saga struct {
+	Name string
+	// Timeout compensates the saga when it is not completed in time. Disabled if 0.
+	Timeout time.Duration
+	Steps   []*SagaStep
+	// Failures are the events which abort the saga and compensate the completed steps.
+	Failures []*SagaTrigger
+}
+
+// SagaTrigger correlates an event with a saga instance.
+type SagaTrigger struct {
+	// Event is a pointer to a zero value of the event, like the NewEvent of the event handlers.
+	Event any
+	// Key returns the correlation key of the event. Events with an empty key are ignored.
+	Key func(event any) string
+}
+
+// SagaStep is completed when its event is handled.
+type SagaStep struct {
+	Name string
+	SagaTrigger
+	// Then returns the commands to send when the step is completed. Optional.
+	Then func(ctx context.Context, state *SagaState, event any) ([]any, error)
+	// Compensate returns the commands which undo the step when the saga fails or times out. Optional.
+	Compensate func(ctx context.Context, state *SagaState) ([]any, error)
+}
+
+type SagaStatus string
+
+const (
+	SagaRunning   SagaStatus = "running"
+	SagaCompleted SagaStatus = "completed"
+	SagaFailed    SagaStatus = "failed"
+	SagaTimedOut  SagaStatus = "timed_out"
+)
+
+// SagaState is the persisted state of a saga instance.
+type SagaState struct {
+	Saga       string     `json:"saga" firestore:"saga"`
+	Key        string     `json:"key" firestore:"key"`
+	InstanceID string     `json:"instanceId" firestore:"instanceId"`
+	Status     SagaStatus `json:"status" firestore:"status"`
+	// CompletedSteps are the names of the completed steps, in the order they completed.
+	CompletedSteps []string `json:"completedSteps" firestore:"completedSteps"`
+	// Data is shared by the steps of the instance. Do not store secrets in it.
+	Data  map[string]string `json:"data" firestore:"data"`
+	Error string            `json:"error" firestore:"error"`
+	// Version is incremented by every save, it detects the concurrent updates.
+	Version    int64     `json:"version" firestore:"version"`
+	StartedAt  time.Time `json:"startedAt" firestore:"startedAt"`
+	UpdatedAt  time.Time `json:"updatedAt" firestore:"updatedAt"`
+	DeadlineAt time.Time `json:"deadlineAt" firestore:"deadlineAt"`
+}
+
+// Done reports if the instance is not running anymore.
+func (s *SagaState) Done() bool {
+	return s.Status != SagaRunning
+}
+
+// IsStepCompleted reports if the step is completed.
+func (s *SagaState) IsStepCompleted(step string) bool {
+	for _, completed := range s.CompletedSteps {
+		if completed == step {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SagaStore persists the saga states, see repository.SagaRepository.
+type SagaStore interface {
+	// Get returns ErrSagaNotFound when the saga has no instance for the key.
+	Get(ctx context.Context, saga, key string) (*SagaState, error)
+	// Save stores the state and increments its version.
+	// It returns ErrSagaConflict when the stored version is not the version of the state.
+	Save(ctx context.Context, state *SagaState) error
+	// List returns up to limit instances of the saga, the latest updated first.
+	List(ctx context.Context, saga string, limit int) ([]*SagaState, error)
+}
+
+var (
+	ErrSagaNotFound = errors.New("saga not found")
+	ErrSagaConflict = errors.New("saga was updated concurrently")
+)
+
+// SagaTimeoutCmd is scheduled when a saga instance starts.
+type SagaTimeoutCmd struct {
+	Saga       string
+	Key        string
+	InstanceID string
+}
+
+// ProcessManager runs the sagas on top of the cqrs buses.
+type ProcessManager struct {
+	Store            SagaStore
+	CommandBus       *cqrs.CommandBus
+	EventProcessor   *cqrs.EventProcessor
+	CommandProcessor *cqrs.CommandProcessor
+	Scheduler        *Scheduler
+	Logger           *zap.Logger
+
+	mu    sync.RWMutex
+	sagas map[string]*Saga
+}
+
+func NewProcessManager(
+	store SagaStore,
+	commandBus *cqrs.CommandBus,
+	eventProcessor *cqrs.EventProcessor,
+	commandProcessor *cqrs.CommandProcessor,
+	scheduler *Scheduler,
+	logger *zap.Logger,
+) (*ProcessManager, error) {
+	pm := &ProcessManager{
+		Store:            store,
+		CommandBus:       commandBus,
+		EventProcessor:   eventProcessor,
+		CommandProcessor: commandProcessor,
+		Scheduler:        scheduler,
+		Logger:           logger.Named("saga"),
+		sagas:            map[string]*Saga{},
+	}
+
+	if err := commandProcessor.AddHandlers(&sagaTimeoutHandler{pm: pm}); err != nil {
+		return nil, errors.WithMessage(err, "add saga timeout handler")
+	}
+
+	return pm, nil
+}
+
+// Register adds the event handlers of the sagas, it must be called before the router runs.
+func (pm *ProcessManager) Register(sagas ...*Saga) error {
+	for _, saga := range sagas {
+		if saga.Name == "" || len(saga.Steps) == 0 {
+			return errors.New("saga must have a name and at least one step")
+		}
+
+		pm.mu.Lock()
+		if _, ok := pm.sagas[saga.Name]; ok {
+			pm.mu.Unlock()
+			return errors.Errorf("saga %s is already registered", saga.Name)
+		}
+		pm.sagas[saga.Name] = saga
+		pm.mu.Unlock()
+
+		handlers := make([]cqrs.EventHandler, 0, len(saga.Steps)+len(saga.Failures))
+		for i, step := range saga.Steps {
+			saga, index := saga, i
+			handlers = append(handlers, &sagaEventHandler{
+				name:    "saga." + saga.Name + "." + step.Name,
+				trigger: &step.SagaTrigger,
+				handle: func(ctx context.Context, key string, event any) error {
+					return pm.handleStep(ctx, saga, index, key, event)
+				},
+			})
+		}
+
+		for _, failure := range saga.Failures {
+			saga := saga
+			handlers = append(handlers, &sagaEventHandler{
+				name:    "saga." + saga.Name + ".fail." + cqrs.FullyQualifiedStructName(failure.Event),
+				trigger: failure,
+				handle: func(ctx context.Context, key string, event any) error {
+					return pm.handleFailure(ctx, saga, key, event)
+				},
+			})
+		}
+
+		if err := pm.EventProcessor.AddHandlers(handlers...); err != nil {
+			return errors.WithMessagef(err, "add handlers of saga %s", saga.Name)
+		}
+	}
+
+	return nil
+}
+
+// State returns the state of the saga instance.
+func (pm *ProcessManager) State(ctx context.Context, saga, key string) (*SagaState, error) {
+	return pm.Store.Get(ctx, saga, key)
+}
+
+// List returns the latest instances of the saga.
+func (pm *ProcessManager) List(ctx context.Context, saga string, limit int) ([]*SagaState, error) {
+	return pm.Store.List(ctx, saga, limit)
+}
+
+func (pm *ProcessManager) handleStep(ctx context.Context, saga *Saga, index int, key string, event any) error {
+	step := saga.Steps[index]
+
+	state, err := pm.Store.Get(ctx, saga.Name, key)
+	if err != nil && !errors.Is(err, ErrSagaNotFound) {
+		return errors.WithMessage(err, "get saga state")
+	}
+
+	// the events of the steps can be handled in any order, the first one starts the instance.
+	// Only the first step starts a new instance over a finished one.
+	started := false
+	switch {
+	case state == nil, index == 0 && state.Done():
+		// the new instance replaces the finished one, it keeps its version
+		var version int64
+		if state != nil {
+			version = state.Version
+		}
+
+		now := time.Now()
+		state = &SagaState{
+			Saga:       saga.Name,
+			Key:        key,
+			InstanceID: watermill.NewULID(),
+			Status:     SagaRunning,
+			Data:       map[string]string{},
+			Version:    version,
+			StartedAt:  now,
+		}
+		started = true
+		if saga.Timeout > 0 {
+			state.DeadlineAt = now.Add(saga.Timeout)
+		}
+	case state.Done():
+		logsvc.WithTrace(ctx, pm.Logger).Debug("no running saga for the event", zap.String("saga", saga.Name), zap.String("step", step.Name), zap.String("key", key))
+		return nil
+	case state.IsStepCompleted(step.Name):
+		return nil
+	}
+
+	var commands []any
+	if step.Then != nil {
+		commands, err = step.Then(ctx, state, event)
+		if err != nil {
+			return errors.WithMessagef(err, "run step %s of saga %s", step.Name, saga.Name)
+		}
+	}
+
+	state.CompletedSteps = append(state.CompletedSteps, step.Name)
+	state.UpdatedAt = time.Now()
+	if len(state.CompletedSteps) == len(saga.Steps) {
+		state.Status = SagaCompleted
+	}
+
+	// a conflict fails the message, the retry reads the state again
+	if err := pm.Store.Save(ctx, state); err != nil {
+		return errors.WithMessage(err, "save saga state")
+	}
+
+	if err := pm.send(ctx, commands); err != nil {
+		pm.revertStep(ctx, state, step.Name)
+		return err
+	}
+
+	logsvc.WithTrace(ctx, pm.Logger).Info("saga step completed",
+		zap.String("saga", saga.Name),
+		zap.String("step", step.Name),
+		zap.String("key", key),
+		zap.String("status", string(state.Status)),
+	)
+
+	if started && saga.Timeout > 0 && state.Status == SagaRunning {
+		timeout := &SagaTimeoutCmd{Saga: saga.Name, Key: key, InstanceID: state.InstanceID}
+		if _, err := pm.Scheduler.SendAt(ctx, timeout, state.DeadlineAt); err != nil {
+			return errors.WithMessage(err, "schedule saga timeout")
+		}
+	}
+
+	return nil
+}
+
+// revertStep marks the step as not completed when its commands could not be sent,
+// so the retry of the event runs the step again.
+func (pm *ProcessManager) revertStep(ctx context.Context, state *SagaState, step string) {
+	completed := make([]string, 0, len(state.CompletedSteps))
+	for _, name := range state.CompletedSteps {
+		if name != step {
+			completed = append(completed, name)
+		}
+	}
+	state.CompletedSteps = completed
+	state.Status = SagaRunning
+	state.UpdatedAt = time.Now()
+
+	if err := pm.Store.Save(ctx, state); err != nil {
+		logsvc.WithTrace(ctx, pm.Logger).Error("failed to revert saga step", zap.String("saga", state.Saga), zap.String("step", step), zap.String("key", state.Key), zap.Error(err))
+	}
+}
+
+func (pm *ProcessManager) handleFailure(ctx context.Context, saga *Saga, key string, event any) error {
+	state, err := pm.Store.Get(ctx, saga.Name, key)
+	if err != nil {
+		if errors.Is(err, ErrSagaNotFound) {
+			return nil
+		}
+		return errors.WithMessage(err, "get saga state")
+	}
+
+	if state.Done() {
+		return nil
+	}
+
+	return pm.compensate(ctx, saga, state, SagaFailed, cqrs.FullyQualifiedStructName(event))
+}
+
+func (pm *ProcessManager) handleTimeout(ctx context.Context, cmd *SagaTimeoutCmd) error {
+	pm.mu.RLock()
+	saga, ok := pm.sagas[cmd.Saga]
+	pm.mu.RUnlock()
+	if !ok {
+		logsvc.WithTrace(ctx, pm.Logger).Warn("timeout of an unknown saga", zap.String("saga", cmd.Saga))
+		return nil
+	}
+
+	state, err := pm.Store.Get(ctx, cmd.Saga, cmd.Key)
+	if err != nil {
+		if errors.Is(err, ErrSagaNotFound) {
+			return nil
+		}
+		return errors.WithMessage(err, "get saga state")
+	}
+
+	// the instance completed, or a newer instance started with the same key
+	if state.Done() || state.InstanceID != cmd.InstanceID {
+		return nil
+	}
+
+	return pm.compensate(ctx, saga, state, SagaTimedOut, "timed out")
+}
+
+// compensate undoes the completed steps, the latest completed first.
+// The compensating commands are sent again when the state can not be saved, they must be idempotent.
+func (pm *ProcessManager) compensate(ctx context.Context, saga *Saga, state *SagaState, status SagaStatus, reason string) error {
+	steps := map[string]*SagaStep{}
+	for _, step := range saga.Steps {
+		steps[step.Name] = step
+	}
+
+	for i := len(state.CompletedSteps) - 1; i >= 0; i-- {
+		step, ok := steps[state.CompletedSteps[i]]
+		if !ok || step.Compensate == nil {
+			continue
+		}
+
+		commands, err := step.Compensate(ctx, state)
+		if err != nil {
+			return errors.WithMessagef(err, "compensate step %s of saga %s", step.Name, saga.Name)
+		}
+
+		if err := pm.send(ctx, commands); err != nil {
+			return err
+		}
+	}
+
+	state.Status = status
+	state.Error = reason
+	state.UpdatedAt = time.Now()
+	if err := pm.Store.Save(ctx, state); err != nil {
+		return errors.WithMessage(err, "save saga state")
+	}
+
+	logsvc.WithTrace(ctx, pm.Logger).Warn("saga compensated",
+		zap.String("saga", saga.Name),
+		zap.String("key", state.Key),
+		zap.String("status", string(status)),
+		zap.String("reason", reason),
+	)
+
+	return nil
+}
+
+func (pm *ProcessManager) send(ctx context.Context, commands []any) error {
+	for _, cmd := range commands {
+		if err := pm.CommandBus.Send(ctx, cmd); err != nil {
+			return errors.WithMessagef(err, "send command %s", cqrs.FullyQualifiedStructName(cmd))
+		}
+	}
+
+	return nil
+}
+
+// sagaEventHandler routes the event of a step, or a failure, to the process manager.
+type sagaEventHandler struct {
+	name    string
+	trigger *SagaTrigger
+	handle  func(ctx context.Context, key string, event any) error
+}
+
+func (h *sagaEventHandler) HandlerName() string {
+	return h.name
+}
+
+func (h *sagaEventHandler) NewEvent() any {
+	return reflect.New(reflect.TypeOf(h.trigger.Event).Elem()).Interface()
+}
+
+func (h *sagaEventHandler) Handle(ctx context.Context, event any) error {
+	key := h.trigger.Key(event)
+	if key == "" {
+		return nil
+	}
+
+	return h.handle(ctx, key, event)
+}
+
+type sagaTimeoutHandler struct {
+	pm *ProcessManager
+}
+
+func (h *sagaTimeoutHandler) HandlerName() string {
+	return "saga.timeout"
+}
+
+func (h *sagaTimeoutHandler) NewCommand() any {
+	return &SagaTimeoutCmd{}
+}
+
+func (h *sagaTimeoutHandler) Handle(ctx context.Context, cmd any) error {
+	return h.pm.handleTimeout(ctx, cmd.(*SagaTimeoutCmd))
+}
+
+var MemorySagaWireset = wire.NewSet(
+	NewMemorySagaStore,
+	wire.Bind(new(SagaStore), new(*MemorySagaStore)),
+)
+
+// MemorySagaStore keeps the saga states in memory, they are lost on restart.
+type MemorySagaStore struct {
+	mu     sync.RWMutex
+	states map[string]*SagaState
+}
+
+func NewMemorySagaStore() *MemorySagaStore {
+	return &MemorySagaStore{
+		states: map[string]*SagaState{},
+	}
+}
+
+func (s *MemorySagaStore) Get(ctx context.Context, saga, key string) (*SagaState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, ok := s.states[saga+":"+key]
+	if !ok {
+		return nil, ErrSagaNotFound
+	}
+
+	return copySagaState(state), nil
+}
+
+func (s *MemorySagaStore) Save(ctx context.Context, state *SagaState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := state.Saga + ":" + state.Key
+	var version int64
+	if stored, ok := s.states[key]; ok {
+		version = stored.Version
+	}
+	if version != state.Version {
+		return ErrSagaConflict
+	}
+
+	state.Version++
+	s.states[key] = copySagaState(state)
+	return nil
+}
+
+func (s *MemorySagaStore) List(ctx context.Context, saga string, limit int) ([]*SagaState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	states := make([]*SagaState, 0)
+	for _, state := range s.states {
+		if state.Saga == saga {
+			states = append(states, copySagaState(state))
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].UpdatedAt.After(states[j].UpdatedAt)
+	})
+
+	if limit > 0 && len(states) > limit {
+		states = states[:limit]
+	}
+
+	return states, nil
+}
+
+func copySagaState(state *SagaState) *SagaState {
+	copied := *state
+	copied.CompletedSteps = append([]string(nil), state.CompletedSteps...)
+	copied.Data = make(map[string]string, len(state.Data))
+	for key, value := range state.Data {
+		copied.Data[key] = value
+	}
+
+	return &copied
+}
@@ -0,0 +1,343 @@
+package pubsub
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"go.uber.org/zap"
+)
+
+const sagaTestName = "test-onboarding"
+
+type sagaStartedEvt struct{ Key string }
+type sagaUserCreatedEvt struct{ Key string }
+type sagaWebhooksInstalledEvt struct{ Key string }
+type sagaFailedEvt struct{ Key string }
+
+// sagaTestCmd records the commands sent by the steps and the compensations.
+type sagaTestCmd struct {
+	Name string
+	Key  string
+}
+
+// sagaRecorder records names, such as the handled commands.
+type sagaRecorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (r *sagaRecorder) record(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.names = append(r.names, name)
+}
+
+func (r *sagaRecorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.names...)
+}
+
+type sagaTestCmdHandler struct {
+	sagaRecorder
+}
+
+func (h *sagaTestCmdHandler) HandlerName() string {
+	return "sagaTestCmdHandler"
+}
+
+func (h *sagaTestCmdHandler) NewCommand() any {
+	return &sagaTestCmd{}
+}
+
+func (h *sagaTestCmdHandler) Handle(ctx context.Context, cmd any) error {
+	h.record(cmd.(*sagaTestCmd).Name)
+	return nil
+}
+
+// conflictingSagaStore runs beforeSave once, before the first save, like a concurrent update of the instance.
+type conflictingSagaStore struct {
+	*MemorySagaStore
+	saved      atomic.Bool
+	beforeSave func()
+}
+
+func (s *conflictingSagaStore) Save(ctx context.Context, state *SagaState) error {
+	if s.saved.CompareAndSwap(false, true) {
+		s.beforeSave()
+	}
+	return s.MemorySagaStore.Save(ctx, state)
+}
+
+type sagaFixture struct {
+	pm       *ProcessManager
+	saga     *Saga
+	store    SagaStore
+	eventBus *cqrs.EventBus
+	commands *sagaTestCmdHandler
+	// compensated are the compensated steps, in the order the process manager compensated them
+	compensated *sagaRecorder
+}
+
+// newSagaTestSaga has three steps, each sends a command and is compensated by another one.
+func newSagaTestSaga(compensated *sagaRecorder) *Saga {
+	step := func(name string, event any, key func(event any) string) *SagaStep {
+		return &SagaStep{
+			Name:        name,
+			SagaTrigger: SagaTrigger{Event: event, Key: key},
+			Then: func(ctx context.Context, state *SagaState, event any) ([]any, error) {
+				return []any{&sagaTestCmd{Name: "then " + name, Key: state.Key}}, nil
+			},
+			Compensate: func(ctx context.Context, state *SagaState) ([]any, error) {
+				compensated.record(name)
+				return []any{&sagaTestCmd{Name: "undo " + name, Key: state.Key}}, nil
+			},
+		}
+	}
+
+	return &Saga{
+		Name: sagaTestName,
+		Steps: []*SagaStep{
+			step("started", &sagaStartedEvt{}, func(event any) string { return event.(*sagaStartedEvt).Key }),
+			step("user-created", &sagaUserCreatedEvt{}, func(event any) string { return event.(*sagaUserCreatedEvt).Key }),
+			step("webhooks-installed", &sagaWebhooksInstalledEvt{}, func(event any) string { return event.(*sagaWebhooksInstalledEvt).Key }),
+		},
+		Failures: []*SagaTrigger{
+			{Event: &sagaFailedEvt{}, Key: func(event any) string { return event.(*sagaFailedEvt).Key }},
+		},
+	}
+}
+
+func newSagaFixture(t *testing.T, store SagaStore) *sagaFixture {
+	t.Helper()
+
+	logger := watermill.NopLogger{}
+	channel := gochannel.NewGoChannel(gochannel.Config{}, logger)
+	t.Cleanup(func() { _ = channel.Close() })
+
+	router, err := message.NewRouter(message.RouterConfig{}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := NewHandlerRegistry()
+	// a failed message is retried quickly, like the router retries it in production
+	router.AddMiddleware(Retry{Policy: RetryPolicy{MaxAttempts: 5, InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond, Multiplier: 2}, Handlers: handlers}.Middleware)
+
+	commandBus, err := NewCommandBus(channel, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventBus, err := NewEventBus(channel, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventProcessor, err := NewEventProcessor(router, channel, handlers, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	commandProcessor, err := NewCommandProcessor(router, channel, handlers, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler := NewScheduler(NewMemoryScheduleStore(), channel, zap.NewNop())
+	pm, err := NewProcessManager(store, commandBus, eventProcessor, commandProcessor, scheduler, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compensated := &sagaRecorder{}
+	saga := newSagaTestSaga(compensated)
+	if err := pm.Register(saga); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := &sagaTestCmdHandler{}
+	if err := commandProcessor.AddHandlers(commands); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { _ = router.Run(ctx) }()
+	<-router.Running()
+	t.Cleanup(func() {
+		cancel()
+		_ = router.Close()
+	})
+
+	return &sagaFixture{pm: pm, saga: saga, store: store, eventBus: eventBus, commands: commands, compensated: compensated}
+}
+
+func (f *sagaFixture) publish(t *testing.T, event any) {
+	t.Helper()
+
+	if err := f.eventBus.Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor returns the state of the instance once the condition holds.
+func (f *sagaFixture) waitFor(t *testing.T, key string, condition func(state *SagaState) bool) *SagaState {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state, err := f.store.Get(context.Background(), sagaTestName, key)
+		if err == nil && condition(state) {
+			return state
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the saga state %+v did not reach the condition, last error %v", state, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForCommands waits until the commands are handled, in any order: they are sent on their own topics.
+func (f *sagaFixture) waitForCommands(t *testing.T, want ...string) {
+	t.Helper()
+
+	sort.Strings(want)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := f.commands.recorded()
+		sort.Strings(got)
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sent commands %v, want %v", got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func stepCompleted(step string) func(state *SagaState) bool {
+	return func(state *SagaState) bool { return state.IsStepCompleted(step) }
+}
+
+func TestSagaCompletesTheStepsArrivingOutOfOrder(t *testing.T) {
+	f := newSagaFixture(t, NewMemorySagaStore())
+
+	f.publish(t, &sagaWebhooksInstalledEvt{Key: "shop"})
+	f.waitFor(t, "shop", stepCompleted("webhooks-installed"))
+	f.publish(t, &sagaStartedEvt{Key: "shop"})
+	f.waitFor(t, "shop", stepCompleted("started"))
+	f.publish(t, &sagaUserCreatedEvt{Key: "shop"})
+
+	state := f.waitFor(t, "shop", func(state *SagaState) bool { return state.Done() })
+	if state.Status != SagaCompleted {
+		t.Fatalf("status is %s, want %s", state.Status, SagaCompleted)
+	}
+	if want := []string{"webhooks-installed", "started", "user-created"}; !reflect.DeepEqual(state.CompletedSteps, want) {
+		t.Fatalf("completed steps are %v, want %v", state.CompletedSteps, want)
+	}
+	f.waitForCommands(t, "then webhooks-installed", "then started", "then user-created")
+}
+
+func TestSagaIgnoresADuplicateStepEvent(t *testing.T) {
+	f := newSagaFixture(t, NewMemorySagaStore())
+
+	f.publish(t, &sagaStartedEvt{Key: "shop"})
+	f.waitFor(t, "shop", stepCompleted("started"))
+	f.publish(t, &sagaStartedEvt{Key: "shop"})
+	f.publish(t, &sagaUserCreatedEvt{Key: "shop"})
+
+	state := f.waitFor(t, "shop", stepCompleted("user-created"))
+	if want := []string{"started", "user-created"}; !reflect.DeepEqual(state.CompletedSteps, want) {
+		t.Fatalf("completed steps are %v, want %v", state.CompletedSteps, want)
+	}
+	f.waitForCommands(t, "then started", "then user-created")
+
+	// the duplicate may be handled last, it does not run the step again either
+	time.Sleep(50 * time.Millisecond)
+	f.waitForCommands(t, "then started", "then user-created")
+}
+
+func TestSagaFailureCompensatesTheCompletedStepsInReverseOrder(t *testing.T) {
+	f := newSagaFixture(t, NewMemorySagaStore())
+
+	f.publish(t, &sagaStartedEvt{Key: "shop"})
+	f.waitFor(t, "shop", stepCompleted("started"))
+	f.publish(t, &sagaUserCreatedEvt{Key: "shop"})
+	f.waitFor(t, "shop", stepCompleted("user-created"))
+	f.publish(t, &sagaFailedEvt{Key: "shop"})
+
+	state := f.waitFor(t, "shop", func(state *SagaState) bool { return state.Done() })
+	if state.Status != SagaFailed {
+		t.Fatalf("status is %s, want %s", state.Status, SagaFailed)
+	}
+	if state.Error != cqrs.FullyQualifiedStructName(&sagaFailedEvt{}) {
+		t.Fatalf("error is %q, want the name of the failure event", state.Error)
+	}
+	if want := []string{"user-created", "started"}; !reflect.DeepEqual(f.compensated.recorded(), want) {
+		t.Fatalf("compensated steps are %v, want %v", f.compensated.recorded(), want)
+	}
+	f.waitForCommands(t, "then started", "then user-created", "undo user-created", "undo started")
+
+	// the steps of a failed instance are not run anymore
+	f.publish(t, &sagaWebhooksInstalledEvt{Key: "shop"})
+	time.Sleep(50 * time.Millisecond)
+	if state := f.waitFor(t, "shop", func(*SagaState) bool { return true }); state.IsStepCompleted("webhooks-installed") {
+		t.Fatal("a step of the failed instance was completed")
+	}
+}
+
+func TestSagaIgnoresTheTimeoutOfAStaleInstance(t *testing.T) {
+	f := newSagaFixture(t, NewMemorySagaStore())
+	ctx := context.Background()
+
+	f.publish(t, &sagaStartedEvt{Key: "shop"})
+	state := f.waitFor(t, "shop", stepCompleted("started"))
+
+	// the timeout was scheduled for an instance which was replaced since
+	if err := f.pm.handleTimeout(ctx, &SagaTimeoutCmd{Saga: sagaTestName, Key: "shop", InstanceID: "stale"}); err != nil {
+		t.Fatal(err)
+	}
+	if state := f.waitFor(t, "shop", func(*SagaState) bool { return true }); state.Status != SagaRunning {
+		t.Fatalf("status is %s after a stale timeout, want %s", state.Status, SagaRunning)
+	}
+
+	if err := f.pm.handleTimeout(ctx, &SagaTimeoutCmd{Saga: sagaTestName, Key: "shop", InstanceID: state.InstanceID}); err != nil {
+		t.Fatal(err)
+	}
+	if state := f.waitFor(t, "shop", func(*SagaState) bool { return true }); state.Status != SagaTimedOut {
+		t.Fatalf("status is %s after the timeout, want %s", state.Status, SagaTimedOut)
+	}
+	f.waitForCommands(t, "then started", "undo started")
+}
+
+func TestSagaRetriesAStepAfterAConcurrentUpdate(t *testing.T) {
+	store := &conflictingSagaStore{MemorySagaStore: NewMemorySagaStore()}
+	f := newSagaFixture(t, store)
+
+	// another step completes between the read and the save of the first one
+	store.beforeSave = func() {
+		if err := f.pm.handleStep(context.Background(), f.saga, 1, "shop", &sagaUserCreatedEvt{Key: "shop"}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	f.publish(t, &sagaStartedEvt{Key: "shop"})
+
+	state := f.waitFor(t, "shop", stepCompleted("started"))
+	if want := []string{"user-created", "started"}; !reflect.DeepEqual(state.CompletedSteps, want) {
+		t.Fatalf("completed steps are %v, want %v", state.CompletedSteps, want)
+	}
+	if state.Version != 2 {
+		t.Fatalf("version is %d, want 2 saves", state.Version)
+	}
+	// the commands of the conflicting attempt are not sent
+	f.waitForCommands(t, "then user-created", "then started")
+}
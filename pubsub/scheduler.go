@@ -0,0 +1,238 @@
+package pubsub
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ScheduledCommand is a marshaled command waiting for its delivery time.
+type ScheduledCommand struct {
+	ID          string            `json:"id"`
+	CommandName string            `json:"commandName"`
+	Payload     []byte            `json:"payload"`
+	Metadata    map[string]string `json:"metadata"`
+	DueAt       time.Time         `json:"dueAt"`
+}
+
+// ScheduleStore keeps the scheduled commands until they are sent.
+type ScheduleStore interface {
+	Add(ctx context.Context, cmd *ScheduledCommand) error
+	// Due returns up to limit commands which are due at now, and hides them from the other dispatchers for lease.
+	// A command which is not acknowledged before the lease ends is returned again.
+	Due(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*ScheduledCommand, error)
+	// Ack removes a sent command.
+	Ack(ctx context.Context, id string) error
+	// Cancel removes a command which is not sent yet, it returns ErrScheduledCommandNotFound when there is none.
+	Cancel(ctx context.Context, id string) error
+}
+
+var ErrScheduledCommandNotFound = errors.New("scheduled command not found")
+
+const (
+	defaultSchedulePollInterval = time.Second
+	defaultScheduleBatchSize    = 100
+	defaultScheduleLease        = time.Minute
+)
+
+// Scheduler sends commands later, beside the cqrs.CommandBus.
+// The commands are published on the same topic as the CommandBus does, so the command handlers do not change.
+type Scheduler struct {
+	Store        ScheduleStore
+	Publisher    message.Publisher
+	Logger       *zap.Logger
+	PollInterval time.Duration
+	BatchSize    int
+	Lease        time.Duration
+
+	marshaler cqrs.JSONMarshaler
+}
+
+func NewScheduler(store ScheduleStore, publisher message.Publisher, logger *zap.Logger) *Scheduler {
+	return &Scheduler{
+		Store:        store,
+		Publisher:    publisher,
+		Logger:       logger.Named("scheduler"),
+		PollInterval: defaultSchedulePollInterval,
+		BatchSize:    defaultScheduleBatchSize,
+		Lease:        defaultScheduleLease,
+	}
+}
+
+// SendAt schedules the command for the time at, it returns the id which cancels it.
+func (s *Scheduler) SendAt(ctx context.Context, cmd any, at time.Time) (string, error) {
+	msg, err := s.marshaler.Marshal(cmd)
+	if err != nil {
+		return "", errors.WithMessage(err, "marshal command")
+	}
+
+	// the trace of the caller goes on with the dispatched command, like a command sent on the CommandBus
+	msg.SetContext(ctx)
+	injectTraceContext(msg)
+
+	scheduled := &ScheduledCommand{
+		ID:          msg.UUID,
+		CommandName: s.marshaler.Name(cmd),
+		Payload:     msg.Payload,
+		Metadata:    msg.Metadata,
+		DueAt:       at,
+	}
+
+	if err := s.Store.Add(ctx, scheduled); err != nil {
+		return "", errors.WithMessage(err, "add scheduled command")
+	}
+
+	return scheduled.ID, nil
+}
+
+// SendAfter schedules the command after the delay.
+func (s *Scheduler) SendAfter(ctx context.Context, cmd any, delay time.Duration) (string, error) {
+	return s.SendAt(ctx, cmd, time.Now().Add(delay))
+}
+
+// Cancel removes the scheduled command.
+func (s *Scheduler) Cancel(ctx context.Context, id string) error {
+	return s.Store.Cancel(ctx, id)
+}
+
+// Dispatch sends the due commands once, it returns the number of sent commands.
+func (s *Scheduler) Dispatch(ctx context.Context) (int, error) {
+	commands, err := s.Store.Due(ctx, time.Now(), s.BatchSize, s.Lease)
+	if err != nil {
+		return 0, errors.WithMessage(err, "list due commands")
+	}
+
+	sent := 0
+	for _, cmd := range commands {
+		msg := message.NewMessage(cmd.ID, cmd.Payload)
+		for key, value := range cmd.Metadata {
+			msg.Metadata.Set(key, value)
+		}
+		msg.Metadata.Set("sent_at", time.Now().String())
+		msg.Metadata.Set("scheduled_at", cmd.DueAt.String())
+
+		// the topic is the command name, see NewCommandBus
+		if err := s.Publisher.Publish(cmd.CommandName, msg); err != nil {
+			// the command is dispatched again when the lease ends
+			s.Logger.Error("failed to send scheduled command", zap.String("id", cmd.ID), zap.String("command", cmd.CommandName), zap.Error(err))
+			continue
+		}
+
+		if err := s.Store.Ack(ctx, cmd.ID); err != nil {
+			return sent, errors.WithMessage(err, "ack scheduled command")
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// Run dispatches the due commands until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			sent, err := s.Dispatch(ctx)
+			if err != nil {
+				s.Logger.Error("failed to dispatch scheduled commands", zap.Error(err))
+			}
+
+			// keep dispatching while full batches are sent
+			if err != nil || sent < s.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+var MemoryScheduleWireset = wire.NewSet(
+	NewMemoryScheduleStore,
+	wire.Bind(new(ScheduleStore), new(*MemoryScheduleStore)),
+)
+
+// MemoryScheduleStore keeps the scheduled commands in memory, they are lost on restart.
+type MemoryScheduleStore struct {
+	mu       sync.Mutex
+	commands map[string]*ScheduledCommand
+	// visibleAt is the due time, or the end of the lease of a dispatched command
+	visibleAt map[string]time.Time
+}
+
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return &MemoryScheduleStore{
+		commands:  map[string]*ScheduledCommand{},
+		visibleAt: map[string]time.Time{},
+	}
+}
+
+func (s *MemoryScheduleStore) Add(ctx context.Context, cmd *ScheduledCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commands[cmd.ID] = cmd
+	s.visibleAt[cmd.ID] = cmd.DueAt
+	return nil
+}
+
+func (s *MemoryScheduleStore) Due(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*ScheduledCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := make([]*ScheduledCommand, 0)
+	for id, visibleAt := range s.visibleAt {
+		if !visibleAt.After(now) {
+			due = append(due, s.commands[id])
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].DueAt.Before(due[j].DueAt)
+	})
+
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	for _, cmd := range due {
+		s.visibleAt[cmd.ID] = now.Add(lease)
+	}
+
+	return due, nil
+}
+
+func (s *MemoryScheduleStore) Ack(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.commands, id)
+	delete(s.visibleAt, id)
+	return nil
+}
+
+func (s *MemoryScheduleStore) Cancel(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.commands[id]; !ok {
+		return ErrScheduledCommandNotFound
+	}
+
+	delete(s.commands, id)
+	delete(s.visibleAt, id)
+	return nil
+}
@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+var RedisScheduleWireset = wire.NewSet(
+	NewRedisScheduleStore,
+	wire.Bind(new(ScheduleStore), new(*RedisScheduleStore)),
+)
+
+// dueScript moves the due commands to the end of their lease, so only one replica dispatches them.
+var dueScript = redis.NewScript(`
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call("ZADD", KEYS[1], ARGV[3], id)
+end
+if #ids == 0 then
+	return {}
+end
+return redis.call("HMGET", KEYS[2], unpack(ids))
+`)
+
+// RedisScheduleStore keeps the command ids in a sorted set scored by the due time,
+// and the commands in a hash. The commands survive the restarts.
+type RedisScheduleStore struct {
+	client   *redis.Client
+	queueKey string
+	dataKey  string
+}
+
+func NewRedisScheduleStore(client *redis.Client, cfg *configsvc.ConfigService) *RedisScheduleStore {
+	return &RedisScheduleStore{
+		client:   client,
+		queueKey: "scheduled_commands:" + cfg.ServiceName,
+		dataKey:  "scheduled_commands_data:" + cfg.ServiceName,
+	}
+}
+
+func (s *RedisScheduleStore) Add(ctx context.Context, cmd *ScheduledCommand) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return errors.WithMessage(err, "marshal scheduled command")
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, s.dataKey, cmd.ID, data)
+	pipe.ZAdd(ctx, s.queueKey, redis.Z{Score: float64(cmd.DueAt.UnixMilli()), Member: cmd.ID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.WithMessage(err, "add scheduled command")
+	}
+
+	return nil
+}
+
+func (s *RedisScheduleStore) Due(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*ScheduledCommand, error) {
+	if limit <= 0 {
+		limit = defaultScheduleBatchSize
+	}
+
+	result, err := dueScript.Run(ctx, s.client,
+		[]string{s.queueKey, s.dataKey},
+		now.UnixMilli(), limit, now.Add(lease).UnixMilli(),
+	).Slice()
+	if err != nil {
+		return nil, errors.WithMessage(err, "get due commands")
+	}
+
+	commands := make([]*ScheduledCommand, 0, len(result))
+	for _, item := range result {
+		data, ok := item.(string)
+		if !ok {
+			// the data is gone, the command was cancelled meanwhile
+			continue
+		}
+
+		var cmd ScheduledCommand
+		if err := json.Unmarshal([]byte(data), &cmd); err != nil {
+			return nil, errors.WithMessage(err, "unmarshal scheduled command")
+		}
+		commands = append(commands, &cmd)
+	}
+
+	return commands, nil
+}
+
+func (s *RedisScheduleStore) Ack(ctx context.Context, id string) error {
+	pipe := s.client.TxPipeline()
+	pipe.ZRem(ctx, s.queueKey, id)
+	pipe.HDel(ctx, s.dataKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.WithMessage(err, "ack scheduled command")
+	}
+
+	return nil
+}
+
+func (s *RedisScheduleStore) Cancel(ctx context.Context, id string) error {
+	pipe := s.client.TxPipeline()
+	removed := pipe.ZRem(ctx, s.queueKey, id)
+	pipe.HDel(ctx, s.dataKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.WithMessage(err, "cancel scheduled command")
+	}
+
+	if removed.Val() == 0 {
+		return ErrScheduledCommandNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+type scheduledTestCmd struct {
+	Name string `json:"name"`
+}
+
+type schedulerFixture struct {
+	client   *redis.Client
+	channel  *gochannel.GoChannel
+	messages <-chan *message.Message
+}
+
+func newSchedulerFixture(t *testing.T) *schedulerFixture {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	channel := gochannel.NewGoChannel(gochannel.Config{OutputChannelBuffer: 10}, watermill.NopLogger{})
+	t.Cleanup(func() { _ = channel.Close() })
+
+	// the topic of a command is its name, see NewCommandBus
+	messages, err := channel.Subscribe(context.Background(), cqrs.JSONMarshaler{}.Name(&scheduledTestCmd{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &schedulerFixture{client: client, channel: channel, messages: messages}
+}
+
+// newScheduler starts a scheduler on the redis, like a replica which (re)starts.
+func (f *schedulerFixture) newScheduler() *Scheduler {
+	store := NewRedisScheduleStore(f.client, &configsvc.ConfigService{ServiceName: "scheduler-test"})
+	return NewScheduler(store, f.channel, zap.NewNop())
+}
+
+func (f *schedulerFixture) receive(t *testing.T) *message.Message {
+	t.Helper()
+
+	select {
+	case msg := <-f.messages:
+		msg.Ack()
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no command was published")
+		return nil
+	}
+}
+
+func dispatch(t *testing.T, scheduler *Scheduler, want int) {
+	t.Helper()
+
+	sent, err := scheduler.Dispatch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != want {
+		t.Fatalf("dispatched %d commands, want %d", sent, want)
+	}
+}
+
+func TestSchedulerPublishesTheDueCommandOnItsTopic(t *testing.T) {
+	f := newSchedulerFixture(t)
+	scheduler := f.newScheduler()
+
+	id, err := scheduler.SendAfter(context.Background(), &scheduledTestCmd{Name: "later"}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatch(t, scheduler, 0)
+	time.Sleep(60 * time.Millisecond)
+	dispatch(t, scheduler, 1)
+
+	msg := f.receive(t)
+	if msg.UUID != id {
+		t.Fatalf("message uuid is %s, want the scheduled id %s", msg.UUID, id)
+	}
+	var cmd scheduledTestCmd
+	if err := json.Unmarshal(msg.Payload, &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Name != "later" {
+		t.Fatalf("command is %+v", cmd)
+	}
+
+	// the sent command is acknowledged
+	dispatch(t, scheduler, 0)
+}
+
+func TestSchedulerCancelsACommand(t *testing.T) {
+	f := newSchedulerFixture(t)
+	scheduler := f.newScheduler()
+
+	id, err := scheduler.SendAfter(context.Background(), &scheduledTestCmd{Name: "cancelled"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Cancel(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	dispatch(t, scheduler, 0)
+
+	if err := scheduler.Cancel(context.Background(), id); !errors.Is(err, ErrScheduledCommandNotFound) {
+		t.Fatalf("cancel twice: want ErrScheduledCommandNotFound, got %v", err)
+	}
+	if err := scheduler.Cancel(context.Background(), "unknown"); !errors.Is(err, ErrScheduledCommandNotFound) {
+		t.Fatalf("cancel an unknown command: want ErrScheduledCommandNotFound, got %v", err)
+	}
+}
+
+func TestSchedulerDispatchesAgainACommandWhichIsNotAcknowledged(t *testing.T) {
+	f := newSchedulerFixture(t)
+	scheduler := f.newScheduler()
+	scheduler.Lease = 50 * time.Millisecond
+
+	if _, err := scheduler.SendAfter(context.Background(), &scheduledTestCmd{Name: "leased"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// a replica took the command and crashed before it acknowledged it
+	leased, err := scheduler.Store.Due(context.Background(), time.Now(), 10, scheduler.Lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leased) != 1 {
+		t.Fatalf("leased %d commands, want 1", len(leased))
+	}
+
+	dispatch(t, scheduler, 0)
+	time.Sleep(60 * time.Millisecond)
+	dispatch(t, scheduler, 1)
+	f.receive(t)
+}
+
+func TestSchedulerDispatchesTheCommandsScheduledBeforeARestart(t *testing.T) {
+	f := newSchedulerFixture(t)
+
+	previous := f.newScheduler()
+	if _, err := previous.SendAfter(context.Background(), &scheduledTestCmd{Name: "first"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := previous.SendAfter(context.Background(), &scheduledTestCmd{Name: "second"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatch(t, f.newScheduler(), 2)
+	f.receive(t)
+	f.receive(t)
+}
+
+func TestSchedulerKeepsTheTraceOfTheCaller(t *testing.T) {
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	f := newSchedulerFixture(t)
+	scheduler := f.newScheduler()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	if _, err := scheduler.SendAfter(ctx, &scheduledTestCmd{Name: "traced"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	dispatch(t, scheduler, 1)
+
+	msg := f.receive(t)
+	extracted := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), metadataCarrier(msg.Metadata)))
+	if extracted.TraceID() != traceID || extracted.SpanID() != spanID {
+		t.Fatalf("the dispatched command has the trace %s/%s, want the trace of the caller", extracted.TraceID(), extracted.SpanID())
+	}
+}
@@ -0,0 +1,84 @@
+package pubsub
+
+import (
+	"context"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/aiocean/wireset/pubsub"
+
+// metadataCarrier lets the otel propagator read and write the trace context in the message metadata.
+type metadataCarrier message.Metadata
+
+var _ propagation.TextMapCarrier = metadataCarrier{}
+
+func (c metadataCarrier) Get(key string) string {
+	return message.Metadata(c).Get(key)
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	message.Metadata(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// injectTraceContext writes the trace context of the message context in its metadata,
+// it does nothing when no tracer is configured.
+func injectTraceContext(msg *message.Message) {
+	otel.GetTextMapPropagator().Inject(msg.Context(), metadataCarrier(msg.Metadata))
+}
+
+// traceLogFields returns the trace and span ids of the span in ctx for the watermill logger, like logsvc.TraceFields.
+func traceLogFields(ctx context.Context) watermill.LogFields {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil
+	}
+
+	return watermill.LogFields{
+		"trace_id": spanContext.TraceID().String(),
+		"span_id":  spanContext.SpanID().String(),
+	}
+}
+
+// Tracing starts a consumer span for each handled message, the child of the span which published it.
+// The handlers receive the span in the message context, logsvc.WithTrace adds it to their logs.
+func Tracing(h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) ([]*message.Message, error) {
+		handlerName := message.HandlerNameFromCtx(msg.Context())
+
+		// keep the values of the router in the context, only the span comes from the metadata
+		ctx := otel.GetTextMapPropagator().Extract(msg.Context(), metadataCarrier(msg.Metadata))
+		ctx, span := otel.Tracer(tracerName).Start(ctx, "handle "+handlerName,
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.String("messaging.system", "watermill"),
+				attribute.String("messaging.message.id", msg.UUID),
+				attribute.String("messaging.destination.name", message.SubscribeTopicFromCtx(msg.Context())),
+			),
+		)
+		defer span.End()
+
+		msg.SetContext(ctx)
+		producedMessages, err := h(msg)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		return producedMessages, err
+	}
+}
@@ -4,10 +4,40 @@ import (
 	"github.com/google/wire"
 )
 
+// DefaultWireset requires a transport (GoroutineWireset or RedisWireset),
+// a dead letter store (MemoryDeadLetterWireset, RedisDeadLetterWireset or FirestoreDeadLetterWireset),
+// a deduplication store (MemoryDedupWireset, RedisDedupWireset or FirestoreDedupWireset),
+// an outbox store (MemoryOutboxWireset or FirestoreOutboxWireset),
+// a schedule store (MemoryScheduleWireset or RedisScheduleWireset)
+// and a saga store (MemorySagaWireset or repository.SagaRepoWireset).
+// MemoryStoresWireset and RedisStoresWireset provide the stores together.
 var DefaultWireset = wire.NewSet(
 	NewCommandProcessor,
 	NewEventProcessor,
 	NewCommandBus,
 	NewEventBus,
 	NewRouter,
+	NewDeadLetterQueue,
+	NewHandlerRegistry,
+	NewOutbox,
+	NewDeduplicator,
+	NewScheduler,
+	NewProcessManager,
+)
+
+// MemoryStoresWireset keeps every store of DefaultWireset in memory, for a single replica or the tests.
+var MemoryStoresWireset = wire.NewSet(
+	MemoryDeadLetterWireset,
+	MemoryDedupWireset,
+	MemoryOutboxWireset,
+	MemoryScheduleWireset,
+	MemorySagaWireset,
+)
+
+// RedisStoresWireset shares the dead letters, the claims and the schedules between the replicas.
+// It requires redis, and an outbox store and a saga store, which redis does not keep.
+var RedisStoresWireset = wire.NewSet(
+	RedisDeadLetterWireset,
+	RedisDedupWireset,
+	RedisScheduleWireset,
 )
@@ -0,0 +1,41 @@
+package redissvc
+
+import (
+	"context"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// ConfigSourceWireset lets configsvc.Watcher reload the config from a redis hash.
+var ConfigSourceWireset = wire.NewSet(
+	NewConfigSource,
+	wire.Bind(new(configsvc.Source), new(*ConfigSource)),
+	configsvc.WatcherWireset,
+)
+
+// ConfigSource reads the runtime config from the hash "config:<service>", the fields are the env names:
+//
+//	HSET config:my-app LOG_LEVEL debug
+type ConfigSource struct {
+	client *redis.Client
+	key    string
+}
+
+func NewConfigSource(client *redis.Client, cfg *configsvc.ConfigService) *ConfigSource {
+	return &ConfigSource{
+		client: client,
+		key:    "config:" + cfg.ServiceName,
+	}
+}
+
+func (s *ConfigSource) Values(ctx context.Context) (map[string]string, error) {
+	values, err := s.client.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the config hash")
+	}
+
+	return values, nil
+}
@@ -2,11 +2,12 @@ package redissvc
 
 import (
 	"context"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp"
 	"github.com/google/wire"
 	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
-	"os"
 	"time"
 )
 
@@ -19,21 +20,18 @@ var EnvWireset = wire.NewSet(
 	RedisConfigFromEnv,
 )
 
-func getEnvVar(key string) (string, error) {
-	value, ok := os.LookupEnv(key)
-	if !ok {
-		return "", errors.Errorf("%s is not set", key)
-	}
-	return value, nil
+// RedisConfig is bound by configsvc.Loader.
+type RedisConfig struct {
+	URI string `env:"REDIS_URI" required:"true" secret:"true"`
 }
 
 func RedisConfigFromEnv() (*redis.Options, error) {
-	uri, err := getEnvVar("REDIS_URI")
-	if err != nil {
+	config := &RedisConfig{}
+	if err := configsvc.Load(config); err != nil {
 		return nil, err
 	}
 
-	opt, err := redis.ParseURL(uri)
+	opt, err := redis.ParseURL(config.URI)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse REDIS_URI")
 	}
@@ -45,6 +43,7 @@ func NewRedisClient(
 	ctx context.Context,
 	logSvc *zap.Logger,
 	config *redis.Options,
+	healthRegistry *fiberapp.HealthRegistry,
 ) (*redis.Client, func(), error) {
 	logger := logSvc.With(zap.Strings("tags", []string{"redis-client"}))
 	redisClient := redis.NewClient(config).WithTimeout(20 * time.Second)
@@ -54,6 +53,14 @@ func NewRedisClient(
 		return nil, nil, errors.Wrap(err, "failed to connect to Redis")
 	}
 
+	healthRegistry.AddHealthChecks(&fiberapp.HealthCheck{
+		Name:     "redis",
+		Critical: true,
+		Check: func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		},
+	})
+
 	cleanup := func() {
 		logger.Info("Router: Cleaning up")
 		if err := redisClient.Close(); err != nil {
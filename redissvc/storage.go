@@ -0,0 +1,102 @@
+package redissvc
+
+import (
+	"context"
+	"time"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// FiberStorageWireset shares the rate limits and the idempotency keys of fiberapp between the replicas.
+var FiberStorageWireset = wire.NewSet(
+	NewFiberStorage,
+	wire.Bind(new(fiber.Storage), new(*FiberStorage)),
+)
+
+// storageTimeout bounds the calls of the middlewares, fiber.Storage has no context.
+const storageTimeout = 2 * time.Second
+
+// FiberStorage is a fiber.Storage in redis, the keys are prefixed by the service name.
+type FiberStorage struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewFiberStorage(client *redis.Client, cfg *configsvc.ConfigService) *FiberStorage {
+	return &FiberStorage{
+		client: client,
+		prefix: "fiber:" + cfg.ServiceName + ":",
+	}
+}
+
+func (s *FiberStorage) Get(key string) ([]byte, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	value, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get fiber storage key")
+	}
+
+	return value, nil
+}
+
+func (s *FiberStorage) Set(key string, value []byte, exp time.Duration) error {
+	if key == "" || len(value) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	if err := s.client.Set(ctx, s.prefix+key, value, exp).Err(); err != nil {
+		return errors.Wrap(err, "failed to set fiber storage key")
+	}
+
+	return nil
+}
+
+func (s *FiberStorage) Delete(key string) error {
+	if key == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, s.prefix+key).Err(); err != nil {
+		return errors.Wrap(err, "failed to delete fiber storage key")
+	}
+
+	return nil
+}
+
+// Reset deletes the keys of the service only, the redis database may be shared.
+func (s *FiberStorage) Reset() error {
+	ctx := context.Background()
+
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := s.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return errors.Wrap(err, "failed to reset fiber storage")
+		}
+	}
+
+	return errors.Wrap(iter.Err(), "failed to scan fiber storage")
+}
+
+// Close does nothing, the client is closed by its own cleanup.
+func (s *FiberStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,57 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aiocean/wireset/repository/repositorytest"
+)
+
+const firestoreProjectID = "repositorytest"
+
+// TestFirestoreRepositories runs against the emulator, such as `gcloud emulators firestore start`.
+func TestFirestoreRepositories(t *testing.T) {
+	host := os.Getenv("FIRESTORE_EMULATOR_HOST")
+	if host == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+
+	client, err := firestore.NewClient(context.Background(), firestoreProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	backend := repositorytest.FirestoreBackend(client)
+	repositorytest.Run(t, func(t *testing.T) repositorytest.Repositories {
+		clearEmulator(t, host)
+		t.Cleanup(func() { clearEmulator(t, host) })
+
+		return backend(t)
+	})
+}
+
+// clearEmulator deletes every document of the project, the suite expects empty collections.
+func clearEmulator(t *testing.T, host string) {
+	t.Helper()
+
+	url := fmt.Sprintf("http://%s/emulator/v1/projects/%s/databases/(default)/documents", host, firestoreProjectID)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("clearing the emulator returned %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aiocean/wireset/apperror"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/shopifysvc"
+)
+
+var ErrShopInstalled = apperror.New(apperror.CodeConflict, http.StatusConflict, "shop is installed")
+
+// uninstalledStatuses are the statuses listed by ListUninstalledSince.
+var uninstalledStatuses = []model.ShopStatus{model.ShopStatusUninstalled, model.ShopStatusRedacted}
+
+func installedLifecycle(at time.Time) model.ShopLifecycle {
+	return model.ShopLifecycle{
+		Status:      model.ShopStatusInstalled,
+		InstalledAt: &at,
+	}
+}
+
+func uninstalledLifecycle(lifecycle model.ShopLifecycle, at time.Time) model.ShopLifecycle {
+	lifecycle.Status = model.ShopStatusUninstalled
+	lifecycle.UninstalledAt = &at
+	return lifecycle
+}
+
+func reinstalledLifecycle(lifecycle model.ShopLifecycle, at time.Time) model.ShopLifecycle {
+	lifecycle.Status = model.ShopStatusInstalled
+	lifecycle.ReinstalledAt = &at
+	return lifecycle
+}
+
+func redactedLifecycle(lifecycle model.ShopLifecycle, at time.Time) model.ShopLifecycle {
+	lifecycle.Status = model.ShopStatusRedacted
+	lifecycle.RedactedAt = &at
+	return lifecycle
+}
+
+// isUninstalledSince is true for the shops listed by ListUninstalledSince.
+func isUninstalledSince(lifecycle model.ShopLifecycle, since time.Time) bool {
+	return !lifecycle.IsInstalled() && lifecycle.UninstalledAt != nil && !lifecycle.UninstalledAt.Before(since)
+}
+
+// redactedShop keeps what identifies the shop, the other details are erased.
+func redactedShop(shop *shopifysvc.Shop) *shopifysvc.Shop {
+	return &shopifysvc.Shop{
+		ID:              shop.ID,
+		MyshopifyDomain: shop.MyshopifyDomain,
+	}
+}
@@ -0,0 +1,831 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aiocean/wireset/cryptosvc"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/aiocean/wireset/shopifysvc"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+)
+
+// MemoryRepoWireset keeps the shops, the tokens, the states and the plans in memory, for the tests and the local development.
+// It requires a pubsub.OutboxStore, such as pubsub.MemoryOutboxWireset.
+var MemoryRepoWireset = wire.NewSet(
+	NewMemoryStore,
+	wire.Struct(new(MemoryShopRepository), "*"),
+	wire.Struct(new(MemoryTokenRepository), "*"),
+	wire.Struct(new(MemoryStateRepository), "*"),
+	newEmptyMemoryPlanRepository,
+	wire.Bind(new(ShopRepository), new(*MemoryShopRepository)),
+	wire.Bind(new(TokenRepository), new(*MemoryTokenRepository)),
+	wire.Bind(new(StateRepository), new(*MemoryStateRepository)),
+	wire.Bind(new(PlanRepository), new(*MemoryPlanRepository)),
+)
+
+// MemoryStore is shared by the memory repositories, the token lives with its shop like in firestore.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	shops         map[string]*memoryShop
+	states        map[string]map[string]interface{}
+	stateWatchers map[string][]chan map[string]interface{}
+}
+
+type memoryShop struct {
+	shop      shopifysvc.Shop
+	lifecycle model.ShopLifecycle
+	token     string
+	lastLogin *time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		shops:         map[string]*memoryShop{},
+		states:        map[string]map[string]interface{}{},
+		stateWatchers: map[string][]chan map[string]interface{}{},
+	}
+}
+
+// MemoryShopRepository is a ShopRepository in a MemoryStore.
+type MemoryShopRepository struct {
+	Store  *MemoryStore
+	Outbox pubsub.OutboxStore
+}
+
+func (r *MemoryShopRepository) IsShopExists(ctx context.Context, shopID string) (bool, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return false, errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.RLock()
+	defer r.Store.mu.RUnlock()
+
+	_, ok := r.Store.shops[normalizedID]
+	return ok, nil
+}
+
+func (r *MemoryShopRepository) IsDomainExists(ctx context.Context, domain string) (bool, error) {
+	r.Store.mu.RLock()
+	defer r.Store.mu.RUnlock()
+
+	return r.Store.findByDomain(domain) != nil, nil
+}
+
+func (r *MemoryShopRepository) Create(ctx context.Context, shop *shopifysvc.Shop) error {
+	normalizedID, err := NormalizeShopID(shop.ID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	// like a firestore set, the document is replaced, the token with it
+	r.Store.shops[normalizedID] = &memoryShop{shop: *shop, lifecycle: installedLifecycle(time.Now())}
+	return nil
+}
+
+func (r *MemoryShopRepository) CreateWithEvents(ctx context.Context, shop *shopifysvc.Shop, records ...*pubsub.OutboxRecord) error {
+	normalizedID, err := NormalizeShopID(shop.ID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	// the lock is held while the records are added, nothing sees the shop without its events
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	if _, ok := r.Store.shops[normalizedID]; ok {
+		return ErrShopExists
+	}
+
+	if len(records) > 0 {
+		if err := r.Outbox.Add(ctx, records...); err != nil {
+			return errors.WithMessage(err, "add outbox records")
+		}
+	}
+
+	r.Store.shops[normalizedID] = &memoryShop{shop: *shop, lifecycle: installedLifecycle(time.Now())}
+	return nil
+}
+
+func (r *MemoryShopRepository) Update(ctx context.Context, shop *shopifysvc.Shop) error {
+	normalizedID, err := NormalizeShopID(shop.ID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	stored, ok := r.Store.shops[normalizedID]
+	if !ok {
+		return ErrShopNotFound
+	}
+
+	stored.shop = *shop
+	return nil
+}
+
+func (r *MemoryShopRepository) Get(ctx context.Context, shopID string) (*shopifysvc.Shop, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.RLock()
+	defer r.Store.mu.RUnlock()
+
+	stored, ok := r.Store.shops[normalizedID]
+	if !ok {
+		return nil, ErrShopNotFound
+	}
+
+	shop := stored.shop
+	return &shop, nil
+}
+
+func (r *MemoryShopRepository) GetByDomain(ctx context.Context, domain string) (*shopifysvc.Shop, error) {
+	r.Store.mu.RLock()
+	defer r.Store.mu.RUnlock()
+
+	stored := r.Store.findByDomain(domain)
+	if stored == nil {
+		return nil, ErrShopNotFound
+	}
+
+	shop := stored.shop
+	return &shop, nil
+}
+
+func (r *MemoryShopRepository) CountShops(ctx context.Context) (int64, error) {
+	r.Store.mu.RLock()
+	defer r.Store.mu.RUnlock()
+
+	return int64(len(r.Store.shops)), nil
+}
+
+func (r *MemoryShopRepository) UpdateLastLogin(ctx context.Context, shopID string, at *time.Time) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	stored, ok := r.Store.shops[normalizedID]
+	if !ok {
+		return ErrShopNotFound
+	}
+
+	stored.lastLogin = at
+	return nil
+}
+
+func (r *MemoryShopRepository) UpdateStoreState(ctx context.Context, shopID string, key string, value interface{}) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	r.Store.mergeState(normalizedID, map[string]interface{}{key: value})
+	return nil
+}
+
+func (r *MemoryShopRepository) Delete(ctx context.Context, shopID string) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	delete(r.Store.shops, normalizedID)
+	return nil
+}
+
+func (r *MemoryShopRepository) GetLifecycle(ctx context.Context, shopID string) (*model.ShopLifecycle, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.RLock()
+	defer r.Store.mu.RUnlock()
+
+	stored, ok := r.Store.shops[normalizedID]
+	if !ok {
+		return nil, ErrShopNotFound
+	}
+
+	lifecycle := stored.lifecycle
+	return &lifecycle, nil
+}
+
+func (r *MemoryShopRepository) MarkUninstalled(ctx context.Context, shopID string, at time.Time) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	stored, ok := r.Store.shops[normalizedID]
+	if !ok {
+		return ErrShopNotFound
+	}
+
+	stored.lifecycle = uninstalledLifecycle(stored.lifecycle, at)
+	return nil
+}
+
+func (r *MemoryShopRepository) ReinstallWithEvents(ctx context.Context, shop *shopifysvc.Shop, at time.Time, records ...*pubsub.OutboxRecord) error {
+	normalizedID, err := NormalizeShopID(shop.ID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	stored, ok := r.Store.shops[normalizedID]
+	if !ok {
+		return ErrShopNotFound
+	}
+	if stored.lifecycle.IsInstalled() {
+		return ErrShopInstalled
+	}
+
+	if len(records) > 0 {
+		if err := r.Outbox.Add(ctx, records...); err != nil {
+			return errors.WithMessage(err, "add outbox records")
+		}
+	}
+
+	stored.shop = *shop
+	stored.lifecycle = reinstalledLifecycle(stored.lifecycle, at)
+	return nil
+}
+
+func (r *MemoryShopRepository) MarkRedacted(ctx context.Context, shopID string, at time.Time) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	stored, ok := r.Store.shops[normalizedID]
+	if !ok {
+		return ErrShopNotFound
+	}
+
+	stored.shop = *redactedShop(&stored.shop)
+	stored.token = ""
+	stored.lastLogin = nil
+	stored.lifecycle = redactedLifecycle(stored.lifecycle, at)
+	return nil
+}
+
+func (r *MemoryShopRepository) ListUninstalledSince(ctx context.Context, since time.Time) ([]*shopifysvc.Shop, error) {
+	r.Store.mu.RLock()
+	defer r.Store.mu.RUnlock()
+
+	var found []*memoryShop
+	for _, stored := range r.Store.shops {
+		if isUninstalledSince(stored.lifecycle, since) {
+			found = append(found, stored)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].lifecycle.UninstalledAt.Before(*found[j].lifecycle.UninstalledAt)
+	})
+
+	shops := make([]*shopifysvc.Shop, len(found))
+	for i, stored := range found {
+		shop := stored.shop
+		shops[i] = &shop
+	}
+
+	return shops, nil
+}
+
+func (s *MemoryStore) findByDomain(domain string) *memoryShop {
+	for _, stored := range s.shops {
+		if stored.shop.MyshopifyDomain == domain {
+			return stored
+		}
+	}
+
+	return nil
+}
+
+// MemoryTokenRepository is a TokenRepository in a MemoryStore, the tokens are sealed like in the other stores.
+type MemoryTokenRepository struct {
+	Store    *MemoryStore
+	Envelope *cryptosvc.Envelope
+}
+
+func (r *MemoryTokenRepository) GetToken(ctx context.Context, shopID string) (*model.ShopifyToken, error) {
+	if shopID == "" {
+		return nil, errors.New("shop id is empty")
+	}
+
+	normalizedShopID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to normalize shop id")
+	}
+
+	r.Store.mu.RLock()
+	defer r.Store.mu.RUnlock()
+
+	stored, ok := r.Store.shops[normalizedShopID]
+	if !ok || stored.token == "" {
+		return nil, ErrTokenNotFound
+	}
+
+	accessToken, err := r.Envelope.Open(stored.token, normalizedShopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to open shopify token")
+	}
+
+	return &model.ShopifyToken{
+		ShopID:      shopID,
+		AccessToken: accessToken,
+	}, nil
+}
+
+func (r *MemoryTokenRepository) SaveAccessToken(ctx context.Context, token *model.ShopifyToken) error {
+	if token == nil {
+		return errors.New("token is nil")
+	}
+
+	normalizedShopID, err := NormalizeShopID(token.ShopID)
+	if err != nil {
+		return errors.WithMessage(err, "failed to normalize shop id")
+	}
+
+	sealed, err := r.Envelope.Seal(token.AccessToken, normalizedShopID)
+	if err != nil {
+		return errors.WithMessage(err, "failed to seal shopify token")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	stored, ok := r.Store.shops[normalizedShopID]
+	if !ok {
+		return errors.WithMessage(ErrTokenNotFound, "failed to update shop: "+normalizedShopID)
+	}
+
+	stored.token = sealed
+	return nil
+}
+
+func (r *MemoryTokenRepository) DeleteToken(ctx context.Context, shopID string) error {
+	normalizedShopID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "failed to normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	if stored, ok := r.Store.shops[normalizedShopID]; ok {
+		stored.token = ""
+	}
+
+	return nil
+}
+
+func (r *MemoryTokenRepository) ReencryptTokens(ctx context.Context) (int, error) {
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	count := 0
+	for normalizedShopID, stored := range r.Store.shops {
+		if stored.token == "" || !r.Envelope.NeedsRotation(stored.token) {
+			continue
+		}
+
+		resealed, err := reseal(r.Envelope, stored.token, normalizedShopID)
+		if err != nil {
+			return count, err
+		}
+
+		stored.token = resealed
+		count++
+	}
+
+	return count, nil
+}
+
+// MemoryStateRepository is a StateRepository in a MemoryStore, the top level keys are merged.
+type MemoryStateRepository struct {
+	Store *MemoryStore
+}
+
+func (r *MemoryStateRepository) GetShopState(ctx context.Context, shopID string) (map[string]interface{}, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.RLock()
+	defer r.Store.mu.RUnlock()
+
+	return copyState(r.Store.states[normalizedID]), nil
+}
+
+func (r *MemoryStateRepository) GetShopStateKey(ctx context.Context, shopID string, key string) (interface{}, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.RLock()
+	defer r.Store.mu.RUnlock()
+
+	value, ok := r.Store.states[normalizedID][key]
+	if !ok {
+		return nil, ErrStateKeyNotFound
+	}
+
+	return value, nil
+}
+
+func (r *MemoryStateRepository) SetShopState(ctx context.Context, shopID string, state map[string]interface{}) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	r.Store.mergeState(normalizedID, state)
+	return nil
+}
+
+func (r *MemoryStateRepository) UpdateShopState(ctx context.Context, shopID string, update func(state map[string]interface{}) error) (map[string]interface{}, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	// the lock is held while update runs, nothing changes the state meanwhile
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	state := copyState(r.Store.states[normalizedID])
+	if err := update(state); err != nil {
+		return nil, err
+	}
+
+	r.Store.states[normalizedID] = state
+	r.Store.notifyState(normalizedID)
+
+	return copyState(state), nil
+}
+
+func (r *MemoryStateRepository) DeleteShopStateKey(ctx context.Context, shopID string, key string) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	if _, ok := r.Store.states[normalizedID][key]; !ok {
+		return nil
+	}
+
+	delete(r.Store.states[normalizedID], key)
+	r.Store.notifyState(normalizedID)
+
+	return nil
+}
+
+func (r *MemoryStateRepository) DeleteShopState(ctx context.Context, shopID string) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.Store.mu.Lock()
+	defer r.Store.mu.Unlock()
+
+	delete(r.Store.states, normalizedID)
+	r.Store.notifyState(normalizedID)
+
+	return nil
+}
+
+func (r *MemoryStateRepository) WatchShopState(ctx context.Context, shopID string) (<-chan map[string]interface{}, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	// the buffer keeps the latest state only, a slow reader skips the states in between
+	watcher := make(chan map[string]interface{}, 1)
+
+	r.Store.mu.Lock()
+	r.Store.stateWatchers[normalizedID] = append(r.Store.stateWatchers[normalizedID], watcher)
+	watcher <- copyState(r.Store.states[normalizedID])
+	r.Store.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		r.Store.mu.Lock()
+		defer r.Store.mu.Unlock()
+
+		watchers := r.Store.stateWatchers[normalizedID]
+		for i, w := range watchers {
+			if w == watcher {
+				r.Store.stateWatchers[normalizedID] = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+		if len(r.Store.stateWatchers[normalizedID]) == 0 {
+			delete(r.Store.stateWatchers, normalizedID)
+		}
+		close(watcher)
+	}()
+
+	return watcher, nil
+}
+
+// mergeState merges the state into the state of the shop, the lock must be held.
+func (s *MemoryStore) mergeState(normalizedID string, state map[string]interface{}) {
+	stored, ok := s.states[normalizedID]
+	if !ok {
+		stored = map[string]interface{}{}
+		s.states[normalizedID] = stored
+	}
+
+	for key, value := range state {
+		stored[key] = value
+	}
+
+	s.notifyState(normalizedID)
+}
+
+// notifyState sends the state to its watchers, the lock must be held.
+func (s *MemoryStore) notifyState(normalizedID string) {
+	for _, watcher := range s.stateWatchers[normalizedID] {
+		// replace the state which is not read yet
+		select {
+		case <-watcher:
+		default:
+		}
+		watcher <- copyState(s.states[normalizedID])
+	}
+}
+
+func copyState(state map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(state))
+	for key, value := range state {
+		copied[key] = value
+	}
+
+	return copied
+}
+
+// MemoryPlanRepository is an in-memory implementation of PlanRepository.
+type MemoryPlanRepository struct {
+	mu       sync.RWMutex
+	plans    []*model.Plan
+	shopPlan map[string]*model.PlanAssignment
+	history  map[string][]*model.PlanAssignment
+}
+
+// NewMemoryPlanRepository creates a new instance of MemoryPlanRepository.
+func NewMemoryPlanRepository(plans []*model.Plan) *MemoryPlanRepository {
+	return &MemoryPlanRepository{
+		plans:    plans,
+		shopPlan: map[string]*model.PlanAssignment{},
+		history:  map[string][]*model.PlanAssignment{},
+	}
+}
+
+// newEmptyMemoryPlanRepository provides a MemoryPlanRepository to MemoryRepoWireset, the plans are created by the app.
+func newEmptyMemoryPlanRepository() *MemoryPlanRepository {
+	return NewMemoryPlanRepository(nil)
+}
+
+// GetPlan returns the pricing plan with the given ID.
+func (r *MemoryPlanRepository) GetPlan(ctx context.Context, ID string) (*model.Plan, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.getPlan(ID)
+}
+
+func (r *MemoryPlanRepository) getPlan(ID string) (*model.Plan, error) {
+	for _, plan := range r.plans {
+		if plan.ID == ID {
+			return plan, nil
+		}
+	}
+	return nil, ErrPlanNotFound
+}
+
+// ListPlans returns a list of all pricing plans.
+func (r *MemoryPlanRepository) ListPlans(ctx context.Context) ([]*model.Plan, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]*model.Plan(nil), r.plans...), nil
+}
+
+// CreatePlan creates a new pricing plan.
+func (r *MemoryPlanRepository) CreatePlan(ctx context.Context, plan *model.Plan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.getPlan(plan.ID); err == nil {
+		return ErrPlanExists
+	}
+
+	r.plans = append(r.plans, plan)
+	return nil
+}
+
+// UpdatePlan updates an existing pricing plan.
+func (r *MemoryPlanRepository) UpdatePlan(ctx context.Context, plan *model.Plan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, p := range r.plans {
+		if p.ID == plan.ID {
+			r.plans[i] = plan
+			return nil
+		}
+	}
+	return ErrPlanNotFound
+}
+
+// DeletePlan deletes a pricing plan.
+func (r *MemoryPlanRepository) DeletePlan(ctx context.Context, ID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, plan := range r.plans {
+		if plan.ID == ID {
+			r.plans = append(r.plans[:i:i], r.plans[i+1:]...)
+			return nil
+		}
+	}
+	return ErrPlanNotFound
+}
+
+// GetFeaturesForPlan returns a list of features that are included in the given pricing plan.
+func (r *MemoryPlanRepository) GetFeaturesForPlan(ctx context.Context, ID string) ([]*model.Feature, error) {
+	plan, err := r.GetPlan(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+	return plan.Features, nil
+}
+
+// CanPlanFeature checks if the given plan ID has the given feature ID.
+func (r *MemoryPlanRepository) CanPlanFeature(ctx context.Context, planID, featureID string) (bool, error) {
+	plan, err := r.GetPlan(ctx, planID)
+	if err != nil {
+		return false, err
+	}
+	return plan.HasFeature(featureID), nil
+}
+
+func (r *MemoryPlanRepository) AssignPlan(ctx context.Context, shopID string, assignment model.PlanAssignment) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.getPlan(assignment.PlanID); err != nil {
+		return err
+	}
+
+	current := r.shopPlan[normalizedID]
+	if current != nil {
+		if current.PlanID == assignment.PlanID && current.SubscriptionID == assignment.SubscriptionID {
+			return nil
+		}
+		unassignedAt := assignment.AssignedAt
+		current.UnassignedAt = &unassignedAt
+	}
+
+	assignment.UnassignedAt = nil
+	r.shopPlan[normalizedID] = &assignment
+	r.history[normalizedID] = append(r.history[normalizedID], &assignment)
+	return nil
+}
+
+func (r *MemoryPlanRepository) UnassignPlan(ctx context.Context, shopID string, at time.Time) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if current := r.shopPlan[normalizedID]; current != nil {
+		current.UnassignedAt = &at
+		delete(r.shopPlan, normalizedID)
+	}
+
+	return nil
+}
+
+func (r *MemoryPlanRepository) DeleteShopPlans(ctx context.Context, shopID string) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.shopPlan, normalizedID)
+	delete(r.history, normalizedID)
+	return nil
+}
+
+// GetPlansOfShop returns a list of pricing plans for the given shop ID.
+func (r *MemoryPlanRepository) GetPlansOfShop(ctx context.Context, shopID string) ([]*model.Plan, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	current, ok := r.shopPlan[normalizedID]
+	if !ok {
+		return nil, ErrNoPlanFound
+	}
+
+	plan, err := r.getPlan(current.PlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*model.Plan{plan}, nil
+}
+
+// CanShopFeature checks if the given shop ID has the given feature ID.
+func (r *MemoryPlanRepository) CanShopFeature(ctx context.Context, shopID, featureID string) (bool, error) {
+	plans, err := r.GetPlansOfShop(ctx, shopID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, plan := range plans {
+		if plan.HasFeature(featureID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *MemoryPlanRepository) GetPlanHistory(ctx context.Context, shopID string) ([]*model.PlanAssignment, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	assignments := r.history[normalizedID]
+	history := make([]*model.PlanAssignment, 0, len(assignments))
+	for i := len(assignments) - 1; i >= 0; i-- {
+		assignment := *assignments[i]
+		history = append(history, &assignment)
+	}
+
+	return history, nil
+}
@@ -0,0 +1,11 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/aiocean/wireset/repository/repositorytest"
+)
+
+func TestMemoryRepositories(t *testing.T) {
+	repositorytest.Run(t, repositorytest.MemoryBackend)
+}
@@ -1,143 +1,384 @@
 package repository
 
 import (
-	"errors"
+	"context"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aiocean/wireset/apperror"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/shopifysvc"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
-	ErrPlanNotFound = errors.New("plan not found")
-	ErrNoPlanFound  = errors.New("no plan found for shop")
+	ErrPlanNotFound = apperror.New(apperror.CodePlanNotFound, http.StatusNotFound, "plan not found")
+	ErrNoPlanFound  = apperror.New(apperror.CodePlanNotFound, http.StatusNotFound, "no plan found for shop")
+	ErrPlanExists   = apperror.New(apperror.CodeConflict, http.StatusConflict, "plan already exists")
 )
 
 // Plan is a model for a pricing plan.
-type Plan struct {
-	ID          string     `json:"id"`
-	Name        string     `json:"name"`
-	Description string     `json:"description"`
-	Price       float64    `json:"price"`
-	Features    []*Feature `json:"features"`
-}
+//
+// Deprecated: use model.Plan.
+type Plan = model.Plan
 
 // Feature is a model for a feature.
-type Feature struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+//
+// Deprecated: use model.Feature.
+type Feature = model.Feature
+
+var PlanRepoWireset = wire.NewSet(
+	wire.Struct(new(FirestorePlanRepository), "*"),
+	wire.Bind(new(PlanRepository), new(*FirestorePlanRepository)),
+)
+
+// SyncShopPlan assigns the plan named by the active subscription of the shop, see shopifysvc.ShopifyClient.GetSubscription.
+// The plan of the shop is unassigned when the subscription is nil or not active.
+func SyncShopPlan(ctx context.Context, plans PlanRepository, shopID string, subscription *shopifysvc.Subscription, at time.Time) error {
+	if subscription == nil || subscription.Status != shopifysvc.SubscriptionStatusActive {
+		return plans.UnassignPlan(ctx, shopID, at)
+	}
+
+	return plans.AssignPlan(ctx, shopID, model.PlanAssignment{
+		PlanID:         subscription.Name,
+		SubscriptionID: subscription.ID,
+		AssignedAt:     at,
+	})
 }
 
-// PlanRepository is an interface for a pricing plan repository.
-type PlanRepository interface {
-	GetPlan(ID string) (*Plan, error)
-	ListPlans() ([]*Plan, error)
-	CreatePlan(plan *Plan) error
-	UpdatePlan(plan *Plan) error
-	DeletePlan(ID string) error
-	GetFeaturesForPlan(ID string) ([]*Feature, error)
-	CanPlanFeature(planID, featureID string) (bool, error)
-	GetPlansOfShop(shopID string) ([]*Plan, error)
-	CanShopFeature(shopID, featureID string) (bool, error)
+// FirestorePlanRepository keeps the plans in the plans collection, and the plan of each shop in the shopPlans collection.
+// The assignments of a shop are in the history collection of its document.
+type FirestorePlanRepository struct {
+	FirestoreClient *firestore.Client
 }
 
-// MemoryPlanRepository is an in-memory implementation of PlanRepository.
-type MemoryPlanRepository struct {
-	plans    []*Plan
-	shopPlan map[string]string
+// firestoreShopPlan is the current assignment of a shop, HistoryID is its document in the history.
+type firestoreShopPlan struct {
+	model.PlanAssignment
+	HistoryID string `firestore:"historyId"`
 }
 
-// NewMemoryPlanRepository creates a new instance of MemoryPlanRepository.
-func NewMemoryPlanRepository(plans []*Plan) *MemoryPlanRepository {
-	return &MemoryPlanRepository{
-		plans: plans,
+func (r *FirestorePlanRepository) plan(ID string) *firestore.DocumentRef {
+	return r.FirestoreClient.Collection("plans").Doc(ID)
+}
+
+func (r *FirestorePlanRepository) shopPlan(shopID string) (*firestore.DocumentRef, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
 	}
+
+	return r.FirestoreClient.Collection("shopPlans").Doc(normalizedID), nil
 }
 
 // GetPlan returns the pricing plan with the given ID.
-func (r *MemoryPlanRepository) GetPlan(ID string) (*Plan, error) {
-	for _, plan := range r.plans {
-		if plan.ID == ID {
-			return plan, nil
+func (r *FirestorePlanRepository) GetPlan(ctx context.Context, ID string) (*model.Plan, error) {
+	snapshot, err := r.plan(ID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrPlanNotFound
 		}
+		return nil, errors.WithMessage(err, "get plan")
 	}
-	return nil, ErrPlanNotFound
+
+	plan := model.Plan{}
+	if err := snapshot.DataTo(&plan); err != nil {
+		return nil, errors.WithMessage(err, "data to plan")
+	}
+
+	return &plan, nil
 }
 
-// ListPlans returns a list of all pricing plans.
-func (r *MemoryPlanRepository) ListPlans() ([]*Plan, error) {
-	return r.plans, nil
+// ListPlans returns the plans, the cheapest first.
+func (r *FirestorePlanRepository) ListPlans(ctx context.Context) ([]*model.Plan, error) {
+	cur := r.FirestoreClient.Collection("plans").OrderBy("price", firestore.Asc).Documents(ctx)
+	defer cur.Stop()
+
+	var plans []*model.Plan
+	for {
+		doc, err := cur.Next()
+		if err == iterator.Done {
+			return plans, nil
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, "list plans")
+		}
+
+		plan := model.Plan{}
+		if err := doc.DataTo(&plan); err != nil {
+			return nil, errors.WithMessage(err, "data to plan")
+		}
+		plans = append(plans, &plan)
+	}
 }
 
-// CreatePlan creates a new pricing plan.
-func (r *MemoryPlanRepository) CreatePlan(plan *Plan) error {
-	r.plans = append(r.plans, plan)
+func (r *FirestorePlanRepository) CreatePlan(ctx context.Context, plan *model.Plan) error {
+	if _, err := r.plan(plan.ID).Create(ctx, plan); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return ErrPlanExists
+		}
+		return errors.WithMessage(err, "create plan")
+	}
+
 	return nil
 }
 
-// UpdatePlan updates an existing pricing plan.
-func (r *MemoryPlanRepository) UpdatePlan(plan *Plan) error {
-	for i, p := range r.plans {
-		if p.ID == plan.ID {
-			r.plans[i] = plan
-			return nil
+func (r *FirestorePlanRepository) UpdatePlan(ctx context.Context, plan *model.Plan) error {
+	updates := []firestore.Update{
+		{Path: "name", Value: plan.Name},
+		{Path: "description", Value: plan.Description},
+		{Path: "price", Value: plan.Price},
+		{Path: "features", Value: plan.Features},
+	}
+
+	if _, err := r.plan(plan.ID).Update(ctx, updates); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return ErrPlanNotFound
 		}
+		return errors.WithMessage(err, "update plan")
 	}
-	return ErrPlanNotFound
+
+	return nil
 }
 
-// DeletePlan deletes a pricing plan.
-func (r *MemoryPlanRepository) DeletePlan(ID string) error {
-	for i, plan := range r.plans {
-		if plan.ID == ID {
-			r.plans = append(r.plans[:i], r.plans[i+1:]...)
-			return nil
+func (r *FirestorePlanRepository) DeletePlan(ctx context.Context, ID string) error {
+	if _, err := r.plan(ID).Delete(ctx, firestore.Exists); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return ErrPlanNotFound
 		}
+		return errors.WithMessage(err, "delete plan")
 	}
-	return ErrPlanNotFound
+
+	return nil
 }
 
 // GetFeaturesForPlan returns a list of features that are included in the given pricing plan.
-func (r *MemoryPlanRepository) GetFeaturesForPlan(ID string) ([]*Feature, error) {
-	for _, plan := range r.plans {
-		if plan.ID == ID {
-			return plan.Features, nil
-		}
+func (r *FirestorePlanRepository) GetFeaturesForPlan(ctx context.Context, ID string) ([]*model.Feature, error) {
+	plan, err := r.GetPlan(ctx, ID)
+	if err != nil {
+		return nil, err
 	}
-	return nil, ErrPlanNotFound
+
+	return plan.Features, nil
 }
 
 // CanPlanFeature checks if the given plan ID has the given feature ID.
-func (r *MemoryPlanRepository) CanPlanFeature(planID, featureID string) (bool, error) {
-	for _, plan := range r.plans {
-		if plan.ID == planID {
-			for _, feature := range plan.Features {
-				if feature.ID == featureID {
-					return true, nil
-				}
+func (r *FirestorePlanRepository) CanPlanFeature(ctx context.Context, planID, featureID string) (bool, error) {
+	plan, err := r.GetPlan(ctx, planID)
+	if err != nil {
+		return false, err
+	}
+
+	return plan.HasFeature(featureID), nil
+}
+
+// AssignPlan ends the current assignment and starts the new one in a transaction.
+func (r *FirestorePlanRepository) AssignPlan(ctx context.Context, shopID string, assignment model.PlanAssignment) error {
+	shopPlanRef, err := r.shopPlan(shopID)
+	if err != nil {
+		return err
+	}
+
+	assignment.UnassignedAt = nil
+	err = r.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(r.plan(assignment.PlanID)); err != nil {
+			if status.Code(err) == codes.NotFound {
+				return ErrPlanNotFound
 			}
-			return false, nil
+			return errors.WithMessage(err, "get plan")
+		}
+
+		current, err := r.getShopPlan(tx, shopPlanRef)
+		if err != nil {
+			return err
 		}
+
+		if current != nil {
+			if current.PlanID == assignment.PlanID && current.SubscriptionID == assignment.SubscriptionID {
+				return nil
+			}
+			if err := r.endAssignment(tx, shopPlanRef, current, assignment.AssignedAt); err != nil {
+				return err
+			}
+		}
+
+		historyRef := shopPlanRef.Collection("history").NewDoc()
+		if err := tx.Create(historyRef, assignment); err != nil {
+			return errors.WithMessage(err, "create plan history")
+		}
+
+		return tx.Set(shopPlanRef, firestoreShopPlan{PlanAssignment: assignment, HistoryID: historyRef.ID})
+	})
+	if errors.Is(err, ErrPlanNotFound) {
+		return ErrPlanNotFound
 	}
-	return false, ErrPlanNotFound
+	if err != nil {
+		return errors.WithMessage(err, "assign plan")
+	}
+
+	return nil
 }
 
-// GetPlansOfShop returns a list of pricing plans for the given shop ID.
-func (r *MemoryPlanRepository) GetPlansOfShop(shopID string) ([]*Plan, error) {
-	var plans []*Plan
-	for _, plan := range r.plans {
-		if r.shopPlan[shopID] == plan.ID {
-			plans = append(plans, plan)
+func (r *FirestorePlanRepository) UnassignPlan(ctx context.Context, shopID string, at time.Time) error {
+	shopPlanRef, err := r.shopPlan(shopID)
+	if err != nil {
+		return err
+	}
+
+	err = r.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current, err := r.getShopPlan(tx, shopPlanRef)
+		if err != nil || current == nil {
+			return err
+		}
+
+		if err := r.endAssignment(tx, shopPlanRef, current, at); err != nil {
+			return err
 		}
+
+		return tx.Delete(shopPlanRef)
+	})
+	if err != nil {
+		return errors.WithMessage(err, "unassign plan")
 	}
-	if len(plans) == 0 {
-		return nil, ErrNoPlanFound
+
+	return nil
+}
+
+// GetPlansOfShop returns the plan of the shop, a shop has one plan at a time.
+func (r *FirestorePlanRepository) GetPlansOfShop(ctx context.Context, shopID string) ([]*model.Plan, error) {
+	shopPlanRef, err := r.shopPlan(shopID)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := shopPlanRef.Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNoPlanFound
+		}
+		return nil, errors.WithMessage(err, "get shop plan")
+	}
+
+	current := firestoreShopPlan{}
+	if err := snapshot.DataTo(&current); err != nil {
+		return nil, errors.WithMessage(err, "data to shop plan")
+	}
+
+	plan, err := r.GetPlan(ctx, current.PlanID)
+	if err != nil {
+		return nil, err
 	}
-	return plans, nil
+
+	return []*model.Plan{plan}, nil
 }
 
 // CanShopFeature checks if the given shop ID has the given feature ID.
-func (r *MemoryPlanRepository) CanShopFeature(shopID, featureID string) (bool, error) {
-	planID, ok := r.shopPlan[shopID]
-	if !ok {
-		return false, ErrNoPlanFound
+func (r *FirestorePlanRepository) CanShopFeature(ctx context.Context, shopID, featureID string) (bool, error) {
+	plans, err := r.GetPlansOfShop(ctx, shopID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, plan := range plans {
+		if plan.HasFeature(featureID) {
+			return true, nil
+		}
 	}
-	return r.CanPlanFeature(planID, featureID)
+
+	return false, nil
+}
+
+func (r *FirestorePlanRepository) GetPlanHistory(ctx context.Context, shopID string) ([]*model.PlanAssignment, error) {
+	shopPlanRef, err := r.shopPlan(shopID)
+	if err != nil {
+		return nil, err
+	}
+
+	cur := shopPlanRef.Collection("history").OrderBy("assignedAt", firestore.Desc).Documents(ctx)
+	defer cur.Stop()
+
+	var history []*model.PlanAssignment
+	for {
+		doc, err := cur.Next()
+		if err == iterator.Done {
+			return history, nil
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, "list plan history")
+		}
+
+		assignment := model.PlanAssignment{}
+		if err := doc.DataTo(&assignment); err != nil {
+			return nil, errors.WithMessage(err, "data to plan assignment")
+		}
+		history = append(history, &assignment)
+	}
+}
+
+// DeleteShopPlans deletes the history documents, then the shopPlans document.
+func (r *FirestorePlanRepository) DeleteShopPlans(ctx context.Context, shopID string) error {
+	shopPlanRef, err := r.shopPlan(shopID)
+	if err != nil {
+		return err
+	}
+
+	cur := shopPlanRef.Collection("history").DocumentRefs(ctx)
+	for {
+		historyRef, err := cur.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return errors.WithMessage(err, "list plan history")
+		}
+
+		if _, err := historyRef.Delete(ctx); err != nil {
+			return errors.WithMessage(err, "delete plan history")
+		}
+	}
+
+	if _, err := shopPlanRef.Delete(ctx); err != nil {
+		return errors.WithMessage(err, "delete shop plan")
+	}
+
+	return nil
+}
+
+// getShopPlan returns the current assignment of the shop, nil when it has none.
+func (r *FirestorePlanRepository) getShopPlan(tx *firestore.Transaction, shopPlanRef *firestore.DocumentRef) (*firestoreShopPlan, error) {
+	snapshot, err := tx.Get(shopPlanRef)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, errors.WithMessage(err, "get shop plan")
+	}
+
+	current := firestoreShopPlan{}
+	if err := snapshot.DataTo(&current); err != nil {
+		return nil, errors.WithMessage(err, "data to shop plan")
+	}
+
+	return &current, nil
+}
+
+// endAssignment sets when the current assignment ended, in the history.
+func (r *FirestorePlanRepository) endAssignment(tx *firestore.Transaction, shopPlanRef *firestore.DocumentRef, current *firestoreShopPlan, at time.Time) error {
+	if current.HistoryID == "" {
+		return nil
+	}
+
+	updates := []firestore.Update{
+		{Path: "unassignedAt", Value: at},
+	}
+	if err := tx.Update(shopPlanRef.Collection("history").Doc(current.HistoryID), updates); err != nil {
+		return errors.WithMessage(err, "update plan history")
+	}
+
+	return nil
 }
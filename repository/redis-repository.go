@@ -0,0 +1,977 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/cryptosvc"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/aiocean/wireset/shopifysvc"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// RedisRepoWireset keeps the shops, the tokens and the states in redis.
+// It requires a pubsub.OutboxStore, the outbox records are not written in the same transaction as the shop.
+// It does not keep the plans, add PlanRepoWireset for them.
+var RedisRepoWireset = wire.NewSet(
+	NewRedisShopRepository,
+	NewRedisTokenRepository,
+	NewRedisStateRepository,
+	wire.Bind(new(ShopRepository), new(*RedisShopRepository)),
+	wire.Bind(new(TokenRepository), new(*RedisTokenRepository)),
+	wire.Bind(new(StateRepository), new(*RedisStateRepository)),
+)
+
+// redisTxRetries bounds the optimistic transactions which fail because a watched key changed,
+// a retry waits up to redisTxBackoff times the attempt so the concurrent writers do not collide again.
+const (
+	redisTxRetries = 10
+	redisTxBackoff = 5 * time.Millisecond
+)
+
+// redisKeyspace names the keys of the repositories, prefixed by the service name.
+// A shop is a hash with the shop json, its lifecycle json, the token and the last login, like the firestore document.
+type redisKeyspace struct {
+	prefix string
+}
+
+func newRedisKeyspace(cfg *configsvc.ConfigService) redisKeyspace {
+	return redisKeyspace{prefix: "repo:" + cfg.ServiceName + ":"}
+}
+
+func (k redisKeyspace) shop(normalizedID string) string {
+	return k.prefix + "shops:" + normalizedID
+}
+
+// shopIDs is the set of the shop ids, it is counted by CountShops.
+func (k redisKeyspace) shopIDs() string {
+	return k.prefix + "shops"
+}
+
+// domains maps the myshopify domains to the shop ids.
+func (k redisKeyspace) domains() string {
+	return k.prefix + "domains"
+}
+
+// uninstalled scores the ids of the shops which are not installed by their uninstall time, in milliseconds.
+func (k redisKeyspace) uninstalled() string {
+	return k.prefix + "uninstalled"
+}
+
+func (k redisKeyspace) state(normalizedID string) string {
+	return k.prefix + "states:" + normalizedID
+}
+
+// stateChannel is where the changes of the state are published.
+func (k redisKeyspace) stateChannel(normalizedID string) string {
+	return k.prefix + "state-changes:" + normalizedID
+}
+
+const (
+	redisShopField      = "shop"
+	redisLifecycleField = "lifecycle"
+	redisTokenField     = "shopifyToken"
+	redisLastLoginField = "lastLoginTime"
+)
+
+// watch runs fn in an optimistic transaction, again when a watched key changed meanwhile.
+func watch(ctx context.Context, client *redis.Client, fn func(tx *redis.Tx) error, keys ...string) error {
+	var err error
+	for i := 0; i < redisTxRetries; i++ {
+		err = client.Watch(ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(rand.Int63n(int64(redisTxBackoff) * int64(i+1)))):
+		}
+	}
+
+	return err
+}
+
+// RedisShopRepository is a ShopRepository in redis.
+type RedisShopRepository struct {
+	client *redis.Client
+	keys   redisKeyspace
+	outbox pubsub.OutboxStore
+}
+
+func NewRedisShopRepository(client *redis.Client, cfg *configsvc.ConfigService, outbox pubsub.OutboxStore) *RedisShopRepository {
+	return &RedisShopRepository{
+		client: client,
+		keys:   newRedisKeyspace(cfg),
+		outbox: outbox,
+	}
+}
+
+func (r *RedisShopRepository) IsShopExists(ctx context.Context, shopID string) (bool, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return false, errors.WithMessage(err, "normalize shop id")
+	}
+
+	exists, err := r.client.HExists(ctx, r.keys.shop(normalizedID), redisShopField).Result()
+	if err != nil {
+		return false, errors.WithMessage(err, "get shop")
+	}
+
+	return exists, nil
+}
+
+func (r *RedisShopRepository) IsDomainExists(ctx context.Context, domain string) (bool, error) {
+	_, err := r.GetByDomain(ctx, domain)
+	if errors.Is(err, ErrShopNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *RedisShopRepository) Create(ctx context.Context, shop *shopifysvc.Shop) error {
+	normalizedID, err := NormalizeShopID(shop.ID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	key := r.keys.shop(normalizedID)
+	err = watch(ctx, r.client, func(tx *redis.Tx) error {
+		previous, err := r.getShop(ctx, tx, key)
+		if err != nil && !errors.Is(err, ErrShopNotFound) {
+			return err
+		}
+
+		// like a firestore set, the document is replaced, the token with it
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			if err := r.writeShop(ctx, pipe, normalizedID, previous, shop); err != nil {
+				return err
+			}
+			return r.writeLifecycle(ctx, pipe, normalizedID, installedLifecycle(time.Now()))
+		})
+		return err
+	}, key)
+	if err != nil {
+		return errors.WithMessage(err, "create shop")
+	}
+
+	return nil
+}
+
+func (r *RedisShopRepository) CreateWithEvents(ctx context.Context, shop *shopifysvc.Shop, records ...*pubsub.OutboxRecord) error {
+	normalizedID, err := NormalizeShopID(shop.ID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	key := r.keys.shop(normalizedID)
+	err = watch(ctx, r.client, func(tx *redis.Tx) error {
+		exists, err := tx.HExists(ctx, key, redisShopField).Result()
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrShopExists
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := r.writeShop(ctx, pipe, normalizedID, nil, shop); err != nil {
+				return err
+			}
+			return r.writeLifecycle(ctx, pipe, normalizedID, installedLifecycle(time.Now()))
+		})
+		return err
+	}, key)
+	if errors.Is(err, ErrShopExists) {
+		return ErrShopExists
+	}
+	if err != nil {
+		return errors.WithMessage(err, "create shop")
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	// the records are added after the shop, the shop is deleted when they cannot be added
+	if err := r.outbox.Add(ctx, records...); err != nil {
+		if deleteErr := r.Delete(ctx, shop.ID); deleteErr != nil {
+			return errors.WithMessagef(err, "add outbox records, then delete shop: %v", deleteErr)
+		}
+		return errors.WithMessage(err, "add outbox records")
+	}
+
+	return nil
+}
+
+func (r *RedisShopRepository) Update(ctx context.Context, shop *shopifysvc.Shop) error {
+	normalizedID, err := NormalizeShopID(shop.ID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	key := r.keys.shop(normalizedID)
+	err = watch(ctx, r.client, func(tx *redis.Tx) error {
+		previous, err := r.getShop(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return r.writeShop(ctx, pipe, normalizedID, previous, shop)
+		})
+		return err
+	}, key)
+	if errors.Is(err, ErrShopNotFound) {
+		return ErrShopNotFound
+	}
+	if err != nil {
+		return errors.WithMessage(err, "update shop")
+	}
+
+	return nil
+}
+
+func (r *RedisShopRepository) Get(ctx context.Context, shopID string) (*shopifysvc.Shop, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	return r.getShop(ctx, r.client, r.keys.shop(normalizedID))
+}
+
+func (r *RedisShopRepository) GetByDomain(ctx context.Context, domain string) (*shopifysvc.Shop, error) {
+	normalizedID, err := r.client.HGet(ctx, r.keys.domains(), domain).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrShopNotFound
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "get shop")
+	}
+
+	shop, err := r.getShop(ctx, r.client, r.keys.shop(normalizedID))
+	if err != nil {
+		return nil, err
+	}
+
+	// the index is updated with the shop, a mismatch is a shop being replaced
+	if shop.MyshopifyDomain != domain {
+		return nil, ErrShopNotFound
+	}
+
+	return shop, nil
+}
+
+func (r *RedisShopRepository) CountShops(ctx context.Context) (int64, error) {
+	count, err := r.client.SCard(ctx, r.keys.shopIDs()).Result()
+	if err != nil {
+		return 0, errors.WithMessage(err, "count shops")
+	}
+
+	return count, nil
+}
+
+func (r *RedisShopRepository) UpdateLastLogin(ctx context.Context, shopID string, at *time.Time) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	key := r.keys.shop(normalizedID)
+	err = watch(ctx, r.client, func(tx *redis.Tx) error {
+		exists, err := tx.HExists(ctx, key, redisShopField).Result()
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrShopNotFound
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if at == nil {
+				pipe.HDel(ctx, key, redisLastLoginField)
+				return nil
+			}
+			pipe.HSet(ctx, key, redisLastLoginField, at.Format(time.RFC3339Nano))
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, ErrShopNotFound) {
+		return ErrShopNotFound
+	}
+	if err != nil {
+		return errors.WithMessage(err, "update shop")
+	}
+
+	return nil
+}
+
+func (r *RedisShopRepository) UpdateStoreState(ctx context.Context, shopID string, key string, value interface{}) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	return setRedisState(ctx, r.client, r.keys, normalizedID, map[string]interface{}{key: value})
+}
+
+func (r *RedisShopRepository) Delete(ctx context.Context, shopID string) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	key := r.keys.shop(normalizedID)
+	err = watch(ctx, r.client, func(tx *redis.Tx) error {
+		previous, err := r.getShop(ctx, tx, key)
+		if errors.Is(err, ErrShopNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.SRem(ctx, r.keys.shopIDs(), normalizedID)
+			pipe.ZRem(ctx, r.keys.uninstalled(), normalizedID)
+			if previous.MyshopifyDomain != "" {
+				pipe.HDel(ctx, r.keys.domains(), previous.MyshopifyDomain)
+			}
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		return errors.WithMessage(err, "delete shop")
+	}
+
+	return nil
+}
+
+func (r *RedisShopRepository) GetLifecycle(ctx context.Context, shopID string) (*model.ShopLifecycle, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	return r.getLifecycle(ctx, r.client, r.keys.shop(normalizedID))
+}
+
+func (r *RedisShopRepository) MarkUninstalled(ctx context.Context, shopID string, at time.Time) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	key := r.keys.shop(normalizedID)
+	err = watch(ctx, r.client, func(tx *redis.Tx) error {
+		lifecycle, err := r.getLifecycle(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return r.writeLifecycle(ctx, pipe, normalizedID, uninstalledLifecycle(*lifecycle, at))
+		})
+		return err
+	}, key)
+	if errors.Is(err, ErrShopNotFound) {
+		return ErrShopNotFound
+	}
+	if err != nil {
+		return errors.WithMessage(err, "update shop")
+	}
+
+	return nil
+}
+
+func (r *RedisShopRepository) ReinstallWithEvents(ctx context.Context, shop *shopifysvc.Shop, at time.Time, records ...*pubsub.OutboxRecord) error {
+	normalizedID, err := NormalizeShopID(shop.ID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	key := r.keys.shop(normalizedID)
+	var previousLifecycle *model.ShopLifecycle
+	err = watch(ctx, r.client, func(tx *redis.Tx) error {
+		previous, err := r.getShop(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		previousLifecycle, err = r.getLifecycle(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if previousLifecycle.IsInstalled() {
+			return ErrShopInstalled
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := r.writeShop(ctx, pipe, normalizedID, previous, shop); err != nil {
+				return err
+			}
+			return r.writeLifecycle(ctx, pipe, normalizedID, reinstalledLifecycle(*previousLifecycle, at))
+		})
+		return err
+	}, key)
+	if errors.Is(err, ErrShopNotFound) {
+		return ErrShopNotFound
+	}
+	if errors.Is(err, ErrShopInstalled) {
+		return ErrShopInstalled
+	}
+	if err != nil {
+		return errors.WithMessage(err, "reinstall shop")
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	// the records are added after the shop, the shop is uninstalled again when they cannot be added
+	if err := r.outbox.Add(ctx, records...); err != nil {
+		_, restoreErr := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return r.writeLifecycle(ctx, pipe, normalizedID, *previousLifecycle)
+		})
+		if restoreErr != nil {
+			return errors.WithMessagef(err, "add outbox records, then restore lifecycle: %v", restoreErr)
+		}
+		return errors.WithMessage(err, "add outbox records")
+	}
+
+	return nil
+}
+
+func (r *RedisShopRepository) MarkRedacted(ctx context.Context, shopID string, at time.Time) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	key := r.keys.shop(normalizedID)
+	err = watch(ctx, r.client, func(tx *redis.Tx) error {
+		previous, err := r.getShop(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		lifecycle, err := r.getLifecycle(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := r.writeShop(ctx, pipe, normalizedID, previous, redactedShop(previous)); err != nil {
+				return err
+			}
+			pipe.HDel(ctx, key, redisLastLoginField, redisTokenField)
+			return r.writeLifecycle(ctx, pipe, normalizedID, redactedLifecycle(*lifecycle, at))
+		})
+		return err
+	}, key)
+	if errors.Is(err, ErrShopNotFound) {
+		return ErrShopNotFound
+	}
+	if err != nil {
+		return errors.WithMessage(err, "redact shop")
+	}
+
+	return nil
+}
+
+func (r *RedisShopRepository) ListUninstalledSince(ctx context.Context, since time.Time) ([]*shopifysvc.Shop, error) {
+	normalizedIDs, err := r.client.ZRangeByScore(ctx, r.keys.uninstalled(), &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, errors.WithMessage(err, "list shops")
+	}
+
+	shops := make([]*shopifysvc.Shop, 0, len(normalizedIDs))
+	for _, normalizedID := range normalizedIDs {
+		shop, err := r.getShop(ctx, r.client, r.keys.shop(normalizedID))
+		// the shop was deleted since the range was read
+		if errors.Is(err, ErrShopNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		shops = append(shops, shop)
+	}
+
+	return shops, nil
+}
+
+// getShop reads the shop with the client, or with the transaction which watches its key.
+func (r *RedisShopRepository) getShop(ctx context.Context, client redis.Cmdable, key string) (*shopifysvc.Shop, error) {
+	data, err := client.HGet(ctx, key, redisShopField).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrShopNotFound
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "get shop")
+	}
+
+	shop := shopifysvc.Shop{}
+	if err := json.Unmarshal(data, &shop); err != nil {
+		return nil, errors.WithMessage(err, "data to shop")
+	}
+
+	return &shop, nil
+}
+
+// writeShop writes the shop and moves its domain in the index.
+func (r *RedisShopRepository) writeShop(ctx context.Context, pipe redis.Pipeliner, normalizedID string, previous, shop *shopifysvc.Shop) error {
+	data, err := json.Marshal(shop)
+	if err != nil {
+		return errors.WithMessage(err, "marshal shop")
+	}
+
+	pipe.HSet(ctx, r.keys.shop(normalizedID), redisShopField, data)
+	pipe.SAdd(ctx, r.keys.shopIDs(), normalizedID)
+	if previous != nil && previous.MyshopifyDomain != "" && previous.MyshopifyDomain != shop.MyshopifyDomain {
+		pipe.HDel(ctx, r.keys.domains(), previous.MyshopifyDomain)
+	}
+	if shop.MyshopifyDomain != "" {
+		pipe.HSet(ctx, r.keys.domains(), shop.MyshopifyDomain, normalizedID)
+	}
+
+	return nil
+}
+
+// getLifecycle reads the lifecycle of the shop, it is empty for a shop stored before its lifecycle was.
+func (r *RedisShopRepository) getLifecycle(ctx context.Context, client redis.Cmdable, key string) (*model.ShopLifecycle, error) {
+	values, err := client.HMGet(ctx, key, redisShopField, redisLifecycleField).Result()
+	if err != nil {
+		return nil, errors.WithMessage(err, "get shop")
+	}
+	if values[0] == nil {
+		return nil, ErrShopNotFound
+	}
+
+	lifecycle := model.ShopLifecycle{}
+	if data, ok := values[1].(string); ok {
+		if err := json.Unmarshal([]byte(data), &lifecycle); err != nil {
+			return nil, errors.WithMessage(err, "data to lifecycle")
+		}
+	}
+
+	return &lifecycle, nil
+}
+
+// writeLifecycle writes the lifecycle and moves the shop in or out of the uninstalled shops.
+func (r *RedisShopRepository) writeLifecycle(ctx context.Context, pipe redis.Pipeliner, normalizedID string, lifecycle model.ShopLifecycle) error {
+	data, err := json.Marshal(lifecycle)
+	if err != nil {
+		return errors.WithMessage(err, "marshal lifecycle")
+	}
+
+	pipe.HSet(ctx, r.keys.shop(normalizedID), redisLifecycleField, data)
+	if lifecycle.IsInstalled() || lifecycle.UninstalledAt == nil {
+		pipe.ZRem(ctx, r.keys.uninstalled(), normalizedID)
+		return nil
+	}
+
+	pipe.ZAdd(ctx, r.keys.uninstalled(), redis.Z{
+		Score:  float64(lifecycle.UninstalledAt.UnixMilli()),
+		Member: normalizedID,
+	})
+	return nil
+}
+
+// RedisTokenRepository is a TokenRepository in redis, the sealed token is a field of the shop hash.
+type RedisTokenRepository struct {
+	client   *redis.Client
+	keys     redisKeyspace
+	envelope *cryptosvc.Envelope
+}
+
+func NewRedisTokenRepository(client *redis.Client, cfg *configsvc.ConfigService, envelope *cryptosvc.Envelope) *RedisTokenRepository {
+	return &RedisTokenRepository{
+		client:   client,
+		keys:     newRedisKeyspace(cfg),
+		envelope: envelope,
+	}
+}
+
+func (r *RedisTokenRepository) GetToken(ctx context.Context, shopID string) (*model.ShopifyToken, error) {
+	if shopID == "" {
+		return nil, errors.New("shop id is empty")
+	}
+
+	normalizedShopID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to normalize shop id")
+	}
+
+	sealed, err := r.client.HGet(ctx, r.keys.shop(normalizedShopID), redisTokenField).Result()
+	if errors.Is(err, redis.Nil) || (err == nil && sealed == "") {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get shopify token")
+	}
+
+	accessToken, err := r.envelope.Open(sealed, normalizedShopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to open shopify token")
+	}
+
+	return &model.ShopifyToken{
+		ShopID:      shopID,
+		AccessToken: accessToken,
+	}, nil
+}
+
+func (r *RedisTokenRepository) SaveAccessToken(ctx context.Context, token *model.ShopifyToken) error {
+	if token == nil {
+		return errors.New("token is nil")
+	}
+
+	normalizedShopID, err := NormalizeShopID(token.ShopID)
+	if err != nil {
+		return errors.WithMessage(err, "failed to normalize shop id")
+	}
+
+	sealed, err := r.envelope.Seal(token.AccessToken, normalizedShopID)
+	if err != nil {
+		return errors.WithMessage(err, "failed to seal shopify token")
+	}
+
+	key := r.keys.shop(normalizedShopID)
+	err = watch(ctx, r.client, func(tx *redis.Tx) error {
+		exists, err := tx.HExists(ctx, key, redisShopField).Result()
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errors.WithMessage(ErrTokenNotFound, "failed to update shop: "+normalizedShopID)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, redisTokenField, sealed)
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, ErrTokenNotFound) {
+		return err
+	}
+	if err != nil {
+		return errors.WithMessage(err, "failed to update shop")
+	}
+
+	return nil
+}
+
+func (r *RedisTokenRepository) DeleteToken(ctx context.Context, shopID string) error {
+	normalizedShopID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "failed to normalize shop id")
+	}
+
+	if err := r.client.HDel(ctx, r.keys.shop(normalizedShopID), redisTokenField).Err(); err != nil {
+		return errors.WithMessage(err, "failed to delete token")
+	}
+
+	return nil
+}
+
+func (r *RedisTokenRepository) ReencryptTokens(ctx context.Context) (int, error) {
+	normalizedShopIDs, err := r.client.SMembers(ctx, r.keys.shopIDs()).Result()
+	if err != nil {
+		return 0, errors.WithMessage(err, "failed to list shops")
+	}
+
+	count := 0
+	for _, normalizedShopID := range normalizedShopIDs {
+		key := r.keys.shop(normalizedShopID)
+		resealed := false
+		err := watch(ctx, r.client, func(tx *redis.Tx) error {
+			resealed = false
+			sealed, err := tx.HGet(ctx, key, redisTokenField).Result()
+			if errors.Is(err, redis.Nil) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if sealed == "" || !r.envelope.NeedsRotation(sealed) {
+				return nil
+			}
+
+			value, err := reseal(r.envelope, sealed, normalizedShopID)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, key, redisTokenField, value)
+				return nil
+			})
+			resealed = err == nil
+			return err
+		}, key)
+		if err != nil {
+			return count, errors.WithMessage(err, "failed to update shop: "+normalizedShopID)
+		}
+		if resealed {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// RedisStateRepository is a StateRepository in redis, the state is a hash of json values.
+// The changes are published on a channel of the shop, which WatchShopState subscribes to.
+type RedisStateRepository struct {
+	client *redis.Client
+	keys   redisKeyspace
+	logger *zap.Logger
+}
+
+func NewRedisStateRepository(client *redis.Client, cfg *configsvc.ConfigService, logger *zap.Logger) *RedisStateRepository {
+	return &RedisStateRepository{
+		client: client,
+		keys:   newRedisKeyspace(cfg),
+		logger: logger.Named("redisStateRepository"),
+	}
+}
+
+func (r *RedisStateRepository) GetShopState(ctx context.Context, shopID string) (map[string]interface{}, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	return getRedisState(ctx, r.client, r.keys.state(normalizedID))
+}
+
+func (r *RedisStateRepository) GetShopStateKey(ctx context.Context, shopID string, key string) (interface{}, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	data, err := r.client.HGet(ctx, r.keys.state(normalizedID), key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrStateKeyNotFound
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "get state from redis")
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, errors.WithMessagef(err, "unmarshal state %s", key)
+	}
+
+	return value, nil
+}
+
+func (r *RedisStateRepository) SetShopState(ctx context.Context, shopID string, state map[string]interface{}) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	return setRedisState(ctx, r.client, r.keys, normalizedID, state)
+}
+
+func (r *RedisStateRepository) UpdateShopState(ctx context.Context, shopID string, update func(state map[string]interface{}) error) (map[string]interface{}, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	key := r.keys.state(normalizedID)
+	var state map[string]interface{}
+	err = watch(ctx, r.client, func(tx *redis.Tx) error {
+		state, err = getRedisState(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		if err := update(state); err != nil {
+			return err
+		}
+
+		values, err := marshalRedisState(state)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			if len(values) > 0 {
+				pipe.HSet(ctx, key, values)
+			}
+			pipe.Publish(ctx, r.keys.stateChannel(normalizedID), "")
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		return nil, errors.WithMessage(err, "update state in redis")
+	}
+
+	return state, nil
+}
+
+func (r *RedisStateRepository) DeleteShopStateKey(ctx context.Context, shopID string, key string) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, r.keys.state(normalizedID), key)
+		pipe.Publish(ctx, r.keys.stateChannel(normalizedID), "")
+		return nil
+	})
+	if err != nil {
+		return errors.WithMessage(err, "delete state key from redis")
+	}
+
+	return nil
+}
+
+func (r *RedisStateRepository) DeleteShopState(ctx context.Context, shopID string) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.keys.state(normalizedID))
+		pipe.Publish(ctx, r.keys.stateChannel(normalizedID), "")
+		return nil
+	})
+	if err != nil {
+		return errors.WithMessage(err, "delete state from redis")
+	}
+
+	return nil
+}
+
+// WatchShopState subscribes to the changes of the state, the state is read again on each of them.
+func (r *RedisStateRepository) WatchShopState(ctx context.Context, shopID string) (<-chan map[string]interface{}, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	subscription := r.client.Subscribe(ctx, r.keys.stateChannel(normalizedID))
+	// wait for the subscription, so no change is missed between the first read and the first message
+	if _, err := subscription.Receive(ctx); err != nil {
+		_ = subscription.Close()
+		return nil, errors.WithMessage(err, "subscribe to the state changes")
+	}
+
+	states := make(chan map[string]interface{})
+	go func() {
+		defer close(states)
+		defer subscription.Close()
+
+		changes := subscription.Channel()
+		for {
+			state, err := r.GetShopState(ctx, shopID)
+			if err != nil {
+				if ctx.Err() == nil {
+					r.logger.Error("failed to get the watched state", zap.String("shopID", shopID), zap.Error(err))
+				}
+				return
+			}
+
+			select {
+			case states <- state:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case <-changes:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return states, nil
+}
+
+// getRedisState reads the state with the client, or with the transaction which watches its key.
+func getRedisState(ctx context.Context, client redis.Cmdable, key string) (map[string]interface{}, error) {
+	values, err := client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, errors.WithMessage(err, "get state from redis")
+	}
+
+	state := make(map[string]interface{}, len(values))
+	for field, data := range values {
+		var value interface{}
+		if err := json.Unmarshal([]byte(data), &value); err != nil {
+			return nil, errors.WithMessagef(err, "unmarshal state %s", field)
+		}
+		state[field] = value
+	}
+
+	return state, nil
+}
+
+func marshalRedisState(state map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(state))
+	for key, value := range state {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "marshal state %s", key)
+		}
+		values[key] = data
+	}
+
+	return values, nil
+}
+
+// setRedisState merges the state into the hash and notifies the watchers.
+func setRedisState(ctx context.Context, client *redis.Client, keys redisKeyspace, normalizedID string, state map[string]interface{}) error {
+	if len(state) == 0 {
+		return nil
+	}
+
+	values, err := marshalRedisState(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, keys.state(normalizedID), values)
+		pipe.Publish(ctx, keys.stateChannel(normalizedID), "")
+		return nil
+	})
+	if err != nil {
+		return errors.WithMessage(err, "set state to redis")
+	}
+
+	return nil
+}
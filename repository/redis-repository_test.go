@@ -0,0 +1,17 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/aiocean/wireset/repository/repositorytest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisRepositories(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	repositorytest.Run(t, repositorytest.RedisBackend(client))
+}
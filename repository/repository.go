@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/aiocean/wireset/shopifysvc"
+	"github.com/google/wire"
+)
+
+// FirestoreRepoWireset keeps the shops, the tokens, the states and the plans in firestore.
+// The repository wiresets require a *cryptosvc.Envelope, such as cryptosvc.DefaultWireset.
+var FirestoreRepoWireset = wire.NewSet(
+	ShopRepoWireset,
+	TokenRepoWireset,
+	StateRepoWireset,
+	PlanRepoWireset,
+)
+
+// ShopRepository stores the installed shops. The shop ids are normalized, see NormalizeShopID.
+type ShopRepository interface {
+	IsShopExists(ctx context.Context, shopID string) (bool, error)
+	IsDomainExists(ctx context.Context, domain string) (bool, error)
+	// Create creates or replaces the shop. Create and CreateWithEvents store it as installed, Update keeps its lifecycle.
+	Create(ctx context.Context, shop *shopifysvc.Shop) error
+	// CreateWithEvents creates the shop and stores the events in the outbox.
+	// It fails with ErrShopExists when the shop is already created.
+	CreateWithEvents(ctx context.Context, shop *shopifysvc.Shop, records ...*pubsub.OutboxRecord) error
+	// Update fails with ErrShopNotFound when the shop does not exist.
+	Update(ctx context.Context, shop *shopifysvc.Shop) error
+	// Get fails with ErrShopNotFound when the shop does not exist.
+	Get(ctx context.Context, shopID string) (*shopifysvc.Shop, error)
+	// GetByDomain fails with ErrShopNotFound when no shop has the myshopify domain.
+	GetByDomain(ctx context.Context, domain string) (*shopifysvc.Shop, error)
+	CountShops(ctx context.Context) (int64, error)
+	UpdateLastLogin(ctx context.Context, shopID string, at *time.Time) error
+	// UpdateStoreState sets a key of the state of the shop, see StateRepository.
+	UpdateStoreState(ctx context.Context, shopID string, key string, value interface{}) error
+	// Delete deletes the shop and its token, it does nothing if the shop does not exist.
+	Delete(ctx context.Context, shopID string) error
+
+	// GetLifecycle fails with ErrShopNotFound when the shop does not exist.
+	GetLifecycle(ctx context.Context, shopID string) (*model.ShopLifecycle, error)
+	// MarkUninstalled fails with ErrShopNotFound when the shop does not exist. The token is not deleted.
+	MarkUninstalled(ctx context.Context, shopID string, at time.Time) error
+	// ReinstallWithEvents updates an uninstalled or redacted shop, marks it reinstalled and stores the events in the outbox.
+	// It fails with ErrShopNotFound when the shop does not exist, and with ErrShopInstalled when it is installed.
+	ReinstallWithEvents(ctx context.Context, shop *shopifysvc.Shop, at time.Time, records ...*pubsub.OutboxRecord) error
+	// MarkRedacted replaces the shop by a tombstone: the id, the myshopify domain and the lifecycle, so that
+	// a reinstall is known as such. Every other field is erased, the token and the last login too.
+	// It fails with ErrShopNotFound when the shop does not exist.
+	MarkRedacted(ctx context.Context, shopID string, at time.Time) error
+	// ListUninstalledSince returns the shops uninstalled since the time, redacted or not, which are not installed again.
+	// They are ordered by their uninstall time.
+	ListUninstalledSince(ctx context.Context, since time.Time) ([]*shopifysvc.Shop, error)
+}
+
+// TokenRepository stores the access token of the shops, a token belongs to a created shop.
+// The tokens are sealed by a cryptosvc.Envelope, bound to their shop, and opened when they are read.
+type TokenRepository interface {
+	// GetToken fails with ErrTokenNotFound when the shop has no token.
+	GetToken(ctx context.Context, shopID string) (*model.ShopifyToken, error)
+	// SaveAccessToken fails with ErrTokenNotFound when the shop does not exist.
+	SaveAccessToken(ctx context.Context, token *model.ShopifyToken) error
+	// DeleteToken does nothing if the shop does not exist.
+	DeleteToken(ctx context.Context, shopID string) error
+	// ReencryptTokens seals again the tokens in plain text or sealed by a rotated key, it returns how many.
+	// A token saved meanwhile is skipped, it is sealed by the active key already.
+	ReencryptTokens(ctx context.Context) (int, error)
+}
+
+// StateRepository stores the state of the shops, a map which the app merges into.
+// The values go through json in redis, so a number may come back as a float64.
+type StateRepository interface {
+	// GetShopState returns the state of the shop, empty when it has none.
+	GetShopState(ctx context.Context, shopID string) (map[string]interface{}, error)
+	// GetShopStateKey fails with ErrStateKeyNotFound when the state has no such key.
+	GetShopStateKey(ctx context.Context, shopID string, key string) (interface{}, error)
+	// SetShopState merges the state into the state of the shop.
+	SetShopState(ctx context.Context, shopID string, state map[string]interface{}) error
+	// UpdateShopState reads the state, lets update change it, and replaces it in a transaction.
+	// update may be called again when the state changed meanwhile. It returns the new state.
+	UpdateShopState(ctx context.Context, shopID string, update func(state map[string]interface{}) error) (map[string]interface{}, error)
+	// DeleteShopStateKey does nothing if the state has no such key.
+	DeleteShopStateKey(ctx context.Context, shopID string, key string) error
+	// DeleteShopState deletes the whole state of the shop.
+	DeleteShopState(ctx context.Context, shopID string) error
+	// WatchShopState sends the state of the shop, then again after every change, until ctx is done.
+	WatchShopState(ctx context.Context, shopID string) (<-chan map[string]interface{}, error)
+}
+
+// PlanRepository stores the pricing plans and which plan each shop has.
+// A shop has one plan at a time, the previous ones are kept in its history.
+type PlanRepository interface {
+	// GetPlan fails with ErrPlanNotFound when the plan does not exist.
+	GetPlan(ctx context.Context, ID string) (*model.Plan, error)
+	ListPlans(ctx context.Context) ([]*model.Plan, error)
+	// CreatePlan fails with ErrPlanExists when the plan is already created.
+	CreatePlan(ctx context.Context, plan *model.Plan) error
+	// UpdatePlan fails with ErrPlanNotFound when the plan does not exist.
+	UpdatePlan(ctx context.Context, plan *model.Plan) error
+	// DeletePlan fails with ErrPlanNotFound when the plan does not exist. The shops keep it in their history.
+	DeletePlan(ctx context.Context, ID string) error
+	// GetFeaturesForPlan fails with ErrPlanNotFound when the plan does not exist.
+	GetFeaturesForPlan(ctx context.Context, ID string) ([]*model.Feature, error)
+	// CanPlanFeature fails with ErrPlanNotFound when the plan does not exist.
+	CanPlanFeature(ctx context.Context, planID, featureID string) (bool, error)
+
+	// AssignPlan replaces the plan of the shop, the previous one is unassigned at assignment.AssignedAt.
+	// Assigning the current plan with the same subscription does nothing. It fails with ErrPlanNotFound when the plan does not exist.
+	AssignPlan(ctx context.Context, shopID string, assignment model.PlanAssignment) error
+	// UnassignPlan does nothing if the shop has no plan.
+	UnassignPlan(ctx context.Context, shopID string, at time.Time) error
+	// GetPlansOfShop fails with ErrNoPlanFound when the shop has no plan.
+	GetPlansOfShop(ctx context.Context, shopID string) ([]*model.Plan, error)
+	// CanShopFeature fails with ErrNoPlanFound when the shop has no plan.
+	CanShopFeature(ctx context.Context, shopID, featureID string) (bool, error)
+	// GetPlanHistory returns the assignments of the shop, the latest first.
+	GetPlanHistory(ctx context.Context, shopID string) ([]*model.PlanAssignment, error)
+	// DeleteShopPlans deletes the plan of the shop and its history, it does nothing if the shop has none.
+	DeleteShopPlans(ctx context.Context, shopID string) error
+}
@@ -0,0 +1,720 @@
+// Package repositorytest checks that a backend of the repository package behaves like the others.
+// Run it from the tests of a backend:
+//
+//	func TestMemoryRepositories(t *testing.T) {
+//		repositorytest.Run(t, repositorytest.MemoryBackend)
+//	}
+package repositorytest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/cryptosvc"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/aiocean/wireset/repository"
+	"github.com/aiocean/wireset/shopifysvc"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Repositories are the repositories of a backend, they share their store.
+type Repositories struct {
+	Shops  repository.ShopRepository
+	Tokens repository.TokenRepository
+	States repository.StateRepository
+	// Outbox receives the records of CreateWithEvents, it is not checked when nil.
+	Outbox pubsub.OutboxStore
+	// Rotated reads the tokens of Tokens, its envelope seals with a new key and keeps the key of Tokens.
+	Rotated repository.TokenRepository
+	// Plans is not checked when nil, the redis backend does not keep the plans.
+	Plans repository.PlanRepository
+}
+
+// envelopes returns the envelope of a backend, and the envelope after its key rotated.
+func envelopes(t *testing.T) (*cryptosvc.Envelope, *cryptosvc.Envelope) {
+	t.Helper()
+
+	oldKey, err := cryptosvc.GenerateKey("old")
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	newKey, err := cryptosvc.GenerateKey("new")
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	envelope, err := cryptosvc.NewEnvelope(&cryptosvc.Config{Keys: []string{oldKey}})
+	if err != nil {
+		t.Fatalf("new envelope: %v", err)
+	}
+	rotated, err := cryptosvc.NewEnvelope(&cryptosvc.Config{Keys: []string{newKey, oldKey}})
+	if err != nil {
+		t.Fatalf("new rotated envelope: %v", err)
+	}
+
+	return envelope, rotated
+}
+
+// Backend returns repositories which are isolated from the other tests.
+type Backend func(t *testing.T) Repositories
+
+// MemoryBackend returns repositories in a new MemoryStore.
+func MemoryBackend(t *testing.T) Repositories {
+	store := repository.NewMemoryStore()
+	outbox := pubsub.NewMemoryOutboxStore()
+	envelope, rotated := envelopes(t)
+
+	return Repositories{
+		Shops:   &repository.MemoryShopRepository{Store: store, Outbox: outbox},
+		Tokens:  &repository.MemoryTokenRepository{Store: store, Envelope: envelope},
+		States:  &repository.MemoryStateRepository{Store: store},
+		Outbox:  outbox,
+		Rotated: &repository.MemoryTokenRepository{Store: store, Envelope: rotated},
+		Plans:   repository.NewMemoryPlanRepository(nil),
+	}
+}
+
+// RedisBackend returns repositories in the redis of the client, under a service name of their own.
+// The keys are deleted when the test ends.
+func RedisBackend(client *redis.Client) Backend {
+	return func(t *testing.T) Repositories {
+		cfg := &configsvc.ConfigService{ServiceName: fmt.Sprintf("repositorytest-%d", time.Now().UnixNano())}
+		outbox := pubsub.NewMemoryOutboxStore()
+
+		t.Cleanup(func() {
+			ctx := context.Background()
+			iter := client.Scan(ctx, 0, "repo:"+cfg.ServiceName+":*", 100).Iterator()
+			for iter.Next(ctx) {
+				client.Del(ctx, iter.Val())
+			}
+		})
+
+		envelope, rotated := envelopes(t)
+
+		return Repositories{
+			Shops:   repository.NewRedisShopRepository(client, cfg, outbox),
+			Tokens:  repository.NewRedisTokenRepository(client, cfg, envelope),
+			States:  repository.NewRedisStateRepository(client, cfg, zap.NewNop()),
+			Outbox:  outbox,
+			Rotated: repository.NewRedisTokenRepository(client, cfg, rotated),
+		}
+	}
+}
+
+// FirestoreBackend returns repositories in the firestore of the client, such as the emulator.
+// The suite expects the collections to be empty when a test starts, and counts every token of the shops.
+func FirestoreBackend(client *firestore.Client) Backend {
+	return func(t *testing.T) Repositories {
+		envelope, rotated := envelopes(t)
+
+		return Repositories{
+			Shops:   repository.NewFirestoreShopRepository(client),
+			Tokens:  repository.NewFirestoreTokenRepository(client, envelope),
+			States:  &repository.FirestoreStateRepository{FirestoreClient: client, Logger: zap.NewNop()},
+			Outbox:  pubsub.NewFirestoreOutboxStore(client),
+			Rotated: repository.NewFirestoreTokenRepository(client, rotated),
+			Plans:   &repository.FirestorePlanRepository{FirestoreClient: client},
+		}
+	}
+}
+
+// Run checks the repositories of the backend.
+func Run(t *testing.T, backend Backend) {
+	t.Run("shops", func(t *testing.T) { testShops(t, backend(t)) })
+	t.Run("lifecycle", func(t *testing.T) { testLifecycle(t, backend(t)) })
+	t.Run("tokens", func(t *testing.T) { testTokens(t, backend(t)) })
+	t.Run("states", func(t *testing.T) { testStates(t, backend(t)) })
+	t.Run("plans", func(t *testing.T) {
+		repos := backend(t)
+		if repos.Plans == nil {
+			t.Skip("the backend does not keep the plans")
+		}
+		testPlans(t, repos.Plans)
+	})
+}
+
+// newShop returns a shop with a gid, the slashes must be normalized by every backend.
+func newShop() *shopifysvc.Shop {
+	id := time.Now().UnixNano()
+	shop := &shopifysvc.Shop{
+		ID:              fmt.Sprintf("gid://shopify/Shop/%d", id),
+		Name:            "Test shop",
+		MyshopifyDomain: fmt.Sprintf("test-%d.myshopify.com", id),
+		CountryCode:     "VN",
+	}
+
+	return shop
+}
+
+func testShops(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	shops := repos.Shops
+	shop := newShop()
+
+	if _, err := shops.Get(ctx, shop.ID); !errors.Is(err, repository.ErrShopNotFound) {
+		t.Fatalf("get unknown shop: want ErrShopNotFound, got %v", err)
+	}
+	if _, err := shops.GetByDomain(ctx, shop.MyshopifyDomain); !errors.Is(err, repository.ErrShopNotFound) {
+		t.Fatalf("get unknown domain: want ErrShopNotFound, got %v", err)
+	}
+	if exists, err := shops.IsShopExists(ctx, shop.ID); err != nil || exists {
+		t.Fatalf("unknown shop exists: %v, %v", exists, err)
+	}
+	if exists, err := shops.IsDomainExists(ctx, shop.MyshopifyDomain); err != nil || exists {
+		t.Fatalf("unknown domain exists: %v, %v", exists, err)
+	}
+
+	countBefore, err := shops.CountShops(ctx)
+	if err != nil {
+		t.Fatalf("count shops: %v", err)
+	}
+
+	record := &pubsub.OutboxRecord{ID: shop.MyshopifyDomain, EventName: "ShopInstalledEvt", CreatedAt: time.Now()}
+	if err := shops.CreateWithEvents(ctx, shop, record); err != nil {
+		t.Fatalf("create shop: %v", err)
+	}
+	t.Cleanup(func() { _ = shops.Delete(context.Background(), shop.ID) })
+
+	if err := shops.CreateWithEvents(ctx, shop); !errors.Is(err, repository.ErrShopExists) {
+		t.Fatalf("create shop twice: want ErrShopExists, got %v", err)
+	}
+
+	if repos.Outbox != nil {
+		pending, err := repos.Outbox.Pending(ctx, 0)
+		if err != nil {
+			t.Fatalf("pending outbox records: %v", err)
+		}
+		if !containsRecord(pending, record.ID) {
+			t.Fatalf("the outbox record of the created shop is missing")
+		}
+	}
+
+	assertShop(t, shops, shop)
+
+	if exists, err := shops.IsShopExists(ctx, shop.ID); err != nil || !exists {
+		t.Fatalf("created shop does not exist: %v, %v", exists, err)
+	}
+	if exists, err := shops.IsDomainExists(ctx, shop.MyshopifyDomain); err != nil || !exists {
+		t.Fatalf("created domain does not exist: %v, %v", exists, err)
+	}
+
+	if count, err := shops.CountShops(ctx); err != nil || count != countBefore+1 {
+		t.Fatalf("count shops: want %d, got %d, %v", countBefore+1, count, err)
+	}
+
+	previousDomain := shop.MyshopifyDomain
+	updated := *shop
+	updated.Name = "Renamed shop"
+	updated.MyshopifyDomain = "renamed-" + shop.MyshopifyDomain
+	if err := shops.Update(ctx, &updated); err != nil {
+		t.Fatalf("update shop: %v", err)
+	}
+	assertShop(t, shops, &updated)
+
+	if _, err := shops.GetByDomain(ctx, previousDomain); !errors.Is(err, repository.ErrShopNotFound) {
+		t.Fatalf("get previous domain: want ErrShopNotFound, got %v", err)
+	}
+
+	unknown := newShop()
+	if err := shops.Update(ctx, unknown); !errors.Is(err, repository.ErrShopNotFound) {
+		t.Fatalf("update unknown shop: want ErrShopNotFound, got %v", err)
+	}
+
+	now := time.Now()
+	if err := shops.UpdateLastLogin(ctx, shop.ID, &now); err != nil {
+		t.Fatalf("update last login: %v", err)
+	}
+	if err := shops.UpdateLastLogin(ctx, unknown.ID, &now); !errors.Is(err, repository.ErrShopNotFound) {
+		t.Fatalf("update last login of unknown shop: want ErrShopNotFound, got %v", err)
+	}
+
+	if err := shops.Create(ctx, &updated); err != nil {
+		t.Fatalf("replace shop: %v", err)
+	}
+	if count, err := shops.CountShops(ctx); err != nil || count != countBefore+1 {
+		t.Fatalf("count shops after replace: want %d, got %d, %v", countBefore+1, count, err)
+	}
+
+	if err := shops.Delete(ctx, shop.ID); err != nil {
+		t.Fatalf("delete shop: %v", err)
+	}
+	if _, err := shops.Get(ctx, shop.ID); !errors.Is(err, repository.ErrShopNotFound) {
+		t.Fatalf("get deleted shop: want ErrShopNotFound, got %v", err)
+	}
+	if exists, err := shops.IsDomainExists(ctx, updated.MyshopifyDomain); err != nil || exists {
+		t.Fatalf("deleted domain exists: %v, %v", exists, err)
+	}
+	if err := shops.Delete(ctx, shop.ID); err != nil {
+		t.Fatalf("delete unknown shop: %v", err)
+	}
+	if count, err := shops.CountShops(ctx); err != nil || count != countBefore {
+		t.Fatalf("count shops after delete: want %d, got %d, %v", countBefore, count, err)
+	}
+}
+
+func assertShop(t *testing.T, shops repository.ShopRepository, want *shopifysvc.Shop) {
+	t.Helper()
+	ctx := context.Background()
+
+	got, err := shops.Get(ctx, want.ID)
+	if err != nil {
+		t.Fatalf("get shop: %v", err)
+	}
+	if *got != *want {
+		t.Fatalf("get shop: want %+v, got %+v", want, got)
+	}
+
+	got, err = shops.GetByDomain(ctx, want.MyshopifyDomain)
+	if err != nil {
+		t.Fatalf("get shop by domain: %v", err)
+	}
+	if *got != *want {
+		t.Fatalf("get shop by domain: want %+v, got %+v", want, got)
+	}
+}
+
+func testLifecycle(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	shops := repos.Shops
+	shop := newShop()
+	unknown := newShop()
+
+	if _, err := shops.GetLifecycle(ctx, unknown.ID); !errors.Is(err, repository.ErrShopNotFound) {
+		t.Fatalf("lifecycle of unknown shop: want ErrShopNotFound, got %v", err)
+	}
+	if err := shops.MarkUninstalled(ctx, unknown.ID, time.Now()); !errors.Is(err, repository.ErrShopNotFound) {
+		t.Fatalf("uninstall unknown shop: want ErrShopNotFound, got %v", err)
+	}
+	if err := shops.MarkRedacted(ctx, unknown.ID, time.Now()); !errors.Is(err, repository.ErrShopNotFound) {
+		t.Fatalf("redact unknown shop: want ErrShopNotFound, got %v", err)
+	}
+	if err := shops.ReinstallWithEvents(ctx, unknown, time.Now()); !errors.Is(err, repository.ErrShopNotFound) {
+		t.Fatalf("reinstall unknown shop: want ErrShopNotFound, got %v", err)
+	}
+
+	if err := shops.CreateWithEvents(ctx, shop); err != nil {
+		t.Fatalf("create shop: %v", err)
+	}
+	t.Cleanup(func() { _ = shops.Delete(context.Background(), shop.ID) })
+
+	lifecycle := assertLifecycle(t, shops, shop.ID, model.ShopStatusInstalled)
+	if lifecycle.InstalledAt == nil {
+		t.Fatalf("installed shop has no install time")
+	}
+	if err := shops.ReinstallWithEvents(ctx, shop, time.Now()); !errors.Is(err, repository.ErrShopInstalled) {
+		t.Fatalf("reinstall installed shop: want ErrShopInstalled, got %v", err)
+	}
+
+	since := time.Now().Add(-time.Minute)
+	uninstalledAt := time.Now()
+	if err := shops.MarkUninstalled(ctx, shop.ID, uninstalledAt); err != nil {
+		t.Fatalf("uninstall shop: %v", err)
+	}
+	lifecycle = assertLifecycle(t, shops, shop.ID, model.ShopStatusUninstalled)
+	if lifecycle.UninstalledAt == nil || !lifecycle.UninstalledAt.Equal(uninstalledAt) {
+		t.Fatalf("uninstall time: want %v, got %v", uninstalledAt, lifecycle.UninstalledAt)
+	}
+	assertUninstalledSince(t, shops, since, shop.ID, true)
+	assertUninstalledSince(t, shops, uninstalledAt.Add(time.Second), shop.ID, false)
+
+	record := &pubsub.OutboxRecord{ID: "reinstalled-" + shop.MyshopifyDomain, EventName: "ShopReinstalledEvt", CreatedAt: time.Now()}
+	reinstalled := *shop
+	reinstalled.Name = "Reinstalled shop"
+	if err := shops.ReinstallWithEvents(ctx, &reinstalled, time.Now(), record); err != nil {
+		t.Fatalf("reinstall shop: %v", err)
+	}
+	lifecycle = assertLifecycle(t, shops, shop.ID, model.ShopStatusInstalled)
+	if lifecycle.ReinstalledAt == nil || lifecycle.UninstalledAt == nil {
+		t.Fatalf("reinstalled shop lost its timestamps: %+v", lifecycle)
+	}
+	assertShop(t, shops, &reinstalled)
+	assertUninstalledSince(t, shops, since, shop.ID, false)
+
+	if repos.Outbox != nil {
+		pending, err := repos.Outbox.Pending(ctx, 0)
+		if err != nil {
+			t.Fatalf("pending outbox records: %v", err)
+		}
+		if !containsRecord(pending, record.ID) {
+			t.Fatalf("the outbox record of the reinstalled shop is missing")
+		}
+	}
+
+	// updating the details keeps the lifecycle
+	if err := shops.Update(ctx, shop); err != nil {
+		t.Fatalf("update shop: %v", err)
+	}
+	assertLifecycle(t, shops, shop.ID, model.ShopStatusInstalled)
+
+	if err := shops.MarkUninstalled(ctx, shop.ID, time.Now()); err != nil {
+		t.Fatalf("uninstall shop again: %v", err)
+	}
+	if err := repos.Tokens.SaveAccessToken(ctx, &model.ShopifyToken{ShopID: shop.ID, AccessToken: "shpat_redacted"}); err != nil {
+		t.Fatalf("save token: %v", err)
+	}
+	if err := shops.MarkRedacted(ctx, shop.ID, time.Now()); err != nil {
+		t.Fatalf("redact shop: %v", err)
+	}
+	lifecycle = assertLifecycle(t, shops, shop.ID, model.ShopStatusRedacted)
+	if lifecycle.RedactedAt == nil {
+		t.Fatalf("redacted shop has no redact time")
+	}
+	assertShop(t, shops, &shopifysvc.Shop{ID: shop.ID, MyshopifyDomain: shop.MyshopifyDomain})
+	assertUninstalledSince(t, shops, since, shop.ID, true)
+	if _, err := repos.Tokens.GetToken(ctx, shop.ID); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Fatalf("token of redacted shop: want ErrTokenNotFound, got %v", err)
+	}
+
+	if err := shops.ReinstallWithEvents(ctx, shop, time.Now()); err != nil {
+		t.Fatalf("reinstall redacted shop: %v", err)
+	}
+	assertLifecycle(t, shops, shop.ID, model.ShopStatusInstalled)
+	assertShop(t, shops, shop)
+
+	if err := shops.Delete(ctx, shop.ID); err != nil {
+		t.Fatalf("delete shop: %v", err)
+	}
+	assertUninstalledSince(t, shops, since, shop.ID, false)
+}
+
+func assertLifecycle(t *testing.T, shops repository.ShopRepository, shopID string, want model.ShopStatus) *model.ShopLifecycle {
+	t.Helper()
+
+	lifecycle, err := shops.GetLifecycle(context.Background(), shopID)
+	if err != nil {
+		t.Fatalf("get lifecycle: %v", err)
+	}
+	if lifecycle.Status != want {
+		t.Fatalf("status: want %s, got %s", want, lifecycle.Status)
+	}
+
+	return lifecycle
+}
+
+func assertUninstalledSince(t *testing.T, shops repository.ShopRepository, since time.Time, shopID string, want bool) {
+	t.Helper()
+
+	uninstalled, err := shops.ListUninstalledSince(context.Background(), since)
+	if err != nil {
+		t.Fatalf("list uninstalled shops: %v", err)
+	}
+
+	found := false
+	for _, shop := range uninstalled {
+		found = found || shop.ID == shopID
+	}
+	if found != want {
+		t.Fatalf("shop uninstalled since %v: want %v, got %v", since, want, found)
+	}
+}
+
+func containsRecord(records []*pubsub.OutboxRecord, id string) bool {
+	for _, record := range records {
+		if record.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func testTokens(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	shop := newShop()
+	token := &model.ShopifyToken{ShopID: shop.ID, AccessToken: "shpat_test"}
+
+	if err := repos.Tokens.SaveAccessToken(ctx, token); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Fatalf("save token of unknown shop: want ErrTokenNotFound, got %v", err)
+	}
+	if err := repos.Tokens.DeleteToken(ctx, shop.ID); err != nil {
+		t.Fatalf("delete token of unknown shop: %v", err)
+	}
+
+	if err := repos.Shops.Create(ctx, shop); err != nil {
+		t.Fatalf("create shop: %v", err)
+	}
+	t.Cleanup(func() { _ = repos.Shops.Delete(context.Background(), shop.ID) })
+
+	if _, err := repos.Tokens.GetToken(ctx, shop.ID); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Fatalf("get missing token: want ErrTokenNotFound, got %v", err)
+	}
+
+	if err := repos.Tokens.SaveAccessToken(ctx, token); err != nil {
+		t.Fatalf("save token: %v", err)
+	}
+	got, err := repos.Tokens.GetToken(ctx, shop.ID)
+	if err != nil {
+		t.Fatalf("get token: %v", err)
+	}
+	if *got != *token {
+		t.Fatalf("get token: want %+v, got %+v", token, got)
+	}
+
+	// the token sealed by the old key is read after the rotation, then sealed again by the new key
+	if got, err := repos.Rotated.GetToken(ctx, shop.ID); err != nil || *got != *token {
+		t.Fatalf("get token after rotation: want %+v, got %+v, %v", token, got, err)
+	}
+	if count, err := repos.Rotated.ReencryptTokens(ctx); err != nil || count != 1 {
+		t.Fatalf("reencrypt tokens: want 1, got %d, %v", count, err)
+	}
+	if count, err := repos.Rotated.ReencryptTokens(ctx); err != nil || count != 0 {
+		t.Fatalf("reencrypt tokens again: want 0, got %d, %v", count, err)
+	}
+	if got, err := repos.Rotated.GetToken(ctx, shop.ID); err != nil || *got != *token {
+		t.Fatalf("get reencrypted token: want %+v, got %+v, %v", token, got, err)
+	}
+	if _, err := repos.Tokens.GetToken(ctx, shop.ID); !errors.Is(err, cryptosvc.ErrUnknownKey) {
+		t.Fatalf("get reencrypted token without the new key: want ErrUnknownKey, got %v", err)
+	}
+
+	if err := repos.Tokens.DeleteToken(ctx, shop.ID); err != nil {
+		t.Fatalf("delete token: %v", err)
+	}
+	if _, err := repos.Tokens.GetToken(ctx, shop.ID); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Fatalf("get deleted token: want ErrTokenNotFound, got %v", err)
+	}
+
+	// the token is deleted with its shop
+	if err := repos.Tokens.SaveAccessToken(ctx, token); err != nil {
+		t.Fatalf("save token again: %v", err)
+	}
+	if err := repos.Shops.Delete(ctx, shop.ID); err != nil {
+		t.Fatalf("delete shop: %v", err)
+	}
+	if err := repos.Shops.Create(ctx, shop); err != nil {
+		t.Fatalf("create shop again: %v", err)
+	}
+	if _, err := repos.Tokens.GetToken(ctx, shop.ID); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Fatalf("get token of a recreated shop: want ErrTokenNotFound, got %v", err)
+	}
+}
+
+func testStates(t *testing.T, repos Repositories) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	states := repos.States
+	shop := newShop()
+	t.Cleanup(func() { _ = states.DeleteShopState(context.Background(), shop.ID) })
+
+	assertState(t, states, shop.ID, map[string]interface{}{})
+	if _, err := states.GetShopStateKey(ctx, shop.ID, "onboarded"); !errors.Is(err, repository.ErrStateKeyNotFound) {
+		t.Fatalf("get unknown key: want ErrStateKeyNotFound, got %v", err)
+	}
+
+	watched, err := states.WatchShopState(ctx, shop.ID)
+	if err != nil {
+		t.Fatalf("watch state: %v", err)
+	}
+	assertWatched(t, watched, map[string]interface{}{})
+
+	if err := states.SetShopState(ctx, shop.ID, map[string]interface{}{"onboarded": true}); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+	assertWatched(t, watched, map[string]interface{}{"onboarded": true})
+
+	if err := states.SetShopState(ctx, shop.ID, map[string]interface{}{"step": "billing"}); err != nil {
+		t.Fatalf("merge state: %v", err)
+	}
+	assertState(t, states, shop.ID, map[string]interface{}{"onboarded": true, "step": "billing"})
+
+	if err := repos.Shops.UpdateStoreState(ctx, shop.ID, "plan", "basic"); err != nil {
+		t.Fatalf("update store state: %v", err)
+	}
+	value, err := states.GetShopStateKey(ctx, shop.ID, "plan")
+	if err != nil || value != "basic" {
+		t.Fatalf("get key: want basic, got %v, %v", value, err)
+	}
+
+	// the updates run concurrently, none of the increments is lost
+	const increments = 10
+	errs := make(chan error, increments)
+	for i := 0; i < increments; i++ {
+		go func() {
+			_, err := states.UpdateShopState(ctx, shop.ID, func(state map[string]interface{}) error {
+				count, _ := state["count"].(float64)
+				state["count"] = count + 1
+				return nil
+			})
+			errs <- err
+		}()
+	}
+	for i := 0; i < increments; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("update state: %v", err)
+		}
+	}
+	value, err = states.GetShopStateKey(ctx, shop.ID, "count")
+	if err != nil || value != float64(increments) {
+		t.Fatalf("get count: want %d, got %v, %v", increments, value, err)
+	}
+
+	if err := states.DeleteShopStateKey(ctx, shop.ID, "count"); err != nil {
+		t.Fatalf("delete key: %v", err)
+	}
+	if err := states.DeleteShopStateKey(ctx, shop.ID, "count"); err != nil {
+		t.Fatalf("delete unknown key: %v", err)
+	}
+	assertState(t, states, shop.ID, map[string]interface{}{"onboarded": true, "step": "billing", "plan": "basic"})
+
+	if err := states.DeleteShopState(ctx, shop.ID); err != nil {
+		t.Fatalf("delete state: %v", err)
+	}
+	if err := states.DeleteShopState(ctx, shop.ID); err != nil {
+		t.Fatalf("delete unknown state: %v", err)
+	}
+	assertState(t, states, shop.ID, map[string]interface{}{})
+	assertWatched(t, watched, map[string]interface{}{})
+
+	cancel()
+	for range watched {
+		// the channel is closed once ctx is done
+	}
+}
+
+func assertState(t *testing.T, states repository.StateRepository, shopID string, want map[string]interface{}) {
+	t.Helper()
+
+	got, err := states.GetShopState(context.Background(), shopID)
+	if err != nil {
+		t.Fatalf("get state: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("get state: want %v, got %v", want, got)
+	}
+}
+
+// assertWatched waits until the watcher sends the state, the states in between may be skipped.
+func assertWatched(t *testing.T, watched <-chan map[string]interface{}, want map[string]interface{}) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got, ok := <-watched:
+			if !ok {
+				t.Fatalf("watch state: the channel is closed")
+			}
+			if reflect.DeepEqual(got, want) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("watch state: %v is not received", want)
+		}
+	}
+}
+
+func testPlans(t *testing.T, plans repository.PlanRepository) {
+	ctx := context.Background()
+	shop := newShop()
+	suffix := fmt.Sprintf("-%d", time.Now().UnixNano())
+	basic := &model.Plan{ID: "basic" + suffix, Name: "Basic", Price: 0, Features: []*model.Feature{{ID: "reports"}}}
+	premium := &model.Plan{ID: "premium" + suffix, Name: "Premium", Price: 10, Features: []*model.Feature{{ID: "reports"}, {ID: "export"}}}
+
+	for _, plan := range []*model.Plan{basic, premium} {
+		if err := plans.CreatePlan(ctx, plan); err != nil {
+			t.Fatalf("create plan: %v", err)
+		}
+		planID := plan.ID
+		t.Cleanup(func() { _ = plans.DeletePlan(context.Background(), planID) })
+	}
+	if err := plans.CreatePlan(ctx, basic); !errors.Is(err, repository.ErrPlanExists) {
+		t.Fatalf("create plan twice: want ErrPlanExists, got %v", err)
+	}
+	if _, err := plans.GetPlan(ctx, "unknown"+suffix); !errors.Is(err, repository.ErrPlanNotFound) {
+		t.Fatalf("get unknown plan: want ErrPlanNotFound, got %v", err)
+	}
+	if can, err := plans.CanPlanFeature(ctx, premium.ID, "export"); err != nil || !can {
+		t.Fatalf("premium can export: %v, %v", can, err)
+	}
+
+	if _, err := plans.GetPlansOfShop(ctx, shop.ID); !errors.Is(err, repository.ErrNoPlanFound) {
+		t.Fatalf("plans of a shop without plan: want ErrNoPlanFound, got %v", err)
+	}
+	if err := plans.AssignPlan(ctx, shop.ID, model.PlanAssignment{PlanID: "unknown" + suffix, AssignedAt: time.Now()}); !errors.Is(err, repository.ErrPlanNotFound) {
+		t.Fatalf("assign unknown plan: want ErrPlanNotFound, got %v", err)
+	}
+
+	start := time.Now()
+	if err := plans.AssignPlan(ctx, shop.ID, model.PlanAssignment{PlanID: basic.ID, AssignedAt: start}); err != nil {
+		t.Fatalf("assign plan: %v", err)
+	}
+	if can, err := plans.CanShopFeature(ctx, shop.ID, "export"); err != nil || can {
+		t.Fatalf("basic shop can export: %v, %v", can, err)
+	}
+
+	// a subscription synced twice is assigned once
+	subscription := &shopifysvc.Subscription{ID: "gid://shopify/AppSubscription/1", Name: premium.ID, Status: shopifysvc.SubscriptionStatusActive}
+	for i := 0; i < 2; i++ {
+		if err := repository.SyncShopPlan(ctx, plans, shop.ID, subscription, start.Add(time.Minute)); err != nil {
+			t.Fatalf("sync plan: %v", err)
+		}
+	}
+	if got, err := plans.GetPlansOfShop(ctx, shop.ID); err != nil || len(got) != 1 || got[0].ID != premium.ID {
+		t.Fatalf("plans of a premium shop: %v, %v", got, err)
+	}
+	if can, err := plans.CanShopFeature(ctx, shop.ID, "export"); err != nil || !can {
+		t.Fatalf("premium shop cannot export: %v, %v", can, err)
+	}
+
+	if err := repository.SyncShopPlan(ctx, plans, shop.ID, nil, start.Add(2*time.Minute)); err != nil {
+		t.Fatalf("sync cancelled plan: %v", err)
+	}
+	if _, err := plans.GetPlansOfShop(ctx, shop.ID); !errors.Is(err, repository.ErrNoPlanFound) {
+		t.Fatalf("plans of an unassigned shop: want ErrNoPlanFound, got %v", err)
+	}
+	if err := plans.UnassignPlan(ctx, shop.ID, time.Now()); err != nil {
+		t.Fatalf("unassign plan twice: %v", err)
+	}
+
+	history, err := plans.GetPlanHistory(ctx, shop.ID)
+	if err != nil {
+		t.Fatalf("plan history: %v", err)
+	}
+	if len(history) != 2 || history[0].PlanID != premium.ID || history[1].PlanID != basic.ID {
+		t.Fatalf("plan history: want premium then basic, got %+v", history)
+	}
+	if history[0].SubscriptionID != subscription.ID || history[0].UnassignedAt == nil || !history[1].UnassignedAt.Equal(start.Add(time.Minute)) {
+		t.Fatalf("plan history: %+v, %+v", history[0], history[1])
+	}
+
+	if err := plans.AssignPlan(ctx, shop.ID, model.PlanAssignment{PlanID: basic.ID, AssignedAt: time.Now()}); err != nil {
+		t.Fatalf("assign plan before delete: %v", err)
+	}
+	if err := plans.DeleteShopPlans(ctx, shop.ID); err != nil {
+		t.Fatalf("delete shop plans: %v", err)
+	}
+	if _, err := plans.GetPlansOfShop(ctx, shop.ID); !errors.Is(err, repository.ErrNoPlanFound) {
+		t.Fatalf("plans of shop after delete: want ErrNoPlanFound, got %v", err)
+	}
+	if history, err := plans.GetPlanHistory(ctx, shop.ID); err != nil || len(history) != 0 {
+		t.Fatalf("plan history after delete: %+v, %v", history, err)
+	}
+	if err := plans.DeleteShopPlans(ctx, shop.ID); err != nil {
+		t.Fatalf("delete shop plans twice: %v", err)
+	}
+
+	updated := *premium
+	updated.Features = []*model.Feature{{ID: "reports"}}
+	if err := plans.UpdatePlan(ctx, &updated); err != nil {
+		t.Fatalf("update plan: %v", err)
+	}
+	if features, err := plans.GetFeaturesForPlan(ctx, premium.ID); err != nil || len(features) != 1 {
+		t.Fatalf("features of the updated plan: %v, %v", features, err)
+	}
+
+	if err := plans.DeletePlan(ctx, basic.ID); err != nil {
+		t.Fatalf("delete plan: %v", err)
+	}
+	if err := plans.DeletePlan(ctx, basic.ID); !errors.Is(err, repository.ErrPlanNotFound) {
+		t.Fatalf("delete unknown plan: want ErrPlanNotFound, got %v", err)
+	}
+}
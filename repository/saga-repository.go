@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/google/wire"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var SagaRepoWireset = wire.NewSet(
+	NewSagaRepository,
+	wire.Bind(new(pubsub.SagaStore), new(*SagaRepository)),
+)
+
+// SagaRepository persists the saga states of the process manager in the sagas collection.
+type SagaRepository struct {
+	firestoreClient *firestore.Client
+}
+
+func NewSagaRepository(firestoreClient *firestore.Client) *SagaRepository {
+	return &SagaRepository{
+		firestoreClient: firestoreClient,
+	}
+}
+
+func (r *SagaRepository) doc(saga, key string) *firestore.DocumentRef {
+	// keys such as the shop gid contain slashes, which are not allowed in document ids
+	id := saga + "_" + base64.RawURLEncoding.EncodeToString([]byte(key))
+	return r.firestoreClient.Collection("sagas").Doc(id)
+}
+
+func (r *SagaRepository) Get(ctx context.Context, saga, key string) (*pubsub.SagaState, error) {
+	snapshot, err := r.doc(saga, key).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, pubsub.ErrSagaNotFound
+		}
+		return nil, errors.WithMessage(err, "get saga")
+	}
+
+	var state pubsub.SagaState
+	if err := snapshot.DataTo(&state); err != nil {
+		return nil, errors.WithMessage(err, "data to saga")
+	}
+
+	return &state, nil
+}
+
+func (r *SagaRepository) Save(ctx context.Context, state *pubsub.SagaState) error {
+	ref := r.doc(state.Saga, state.Key)
+	saved := *state
+
+	err := r.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var version int64
+		snapshot, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return errors.WithMessage(err, "get saga")
+		}
+		if err == nil {
+			var stored pubsub.SagaState
+			if err := snapshot.DataTo(&stored); err != nil {
+				return errors.WithMessage(err, "data to saga")
+			}
+			version = stored.Version
+		}
+
+		if version != state.Version {
+			return pubsub.ErrSagaConflict
+		}
+
+		saved.Version = state.Version + 1
+		return tx.Set(ref, &saved)
+	})
+	if err != nil {
+		if errors.Is(err, pubsub.ErrSagaConflict) {
+			return pubsub.ErrSagaConflict
+		}
+		return errors.WithMessage(err, "save saga")
+	}
+
+	state.Version = saved.Version
+	return nil
+}
+
+func (r *SagaRepository) List(ctx context.Context, saga string, limit int) ([]*pubsub.SagaState, error) {
+	query := r.firestoreClient.Collection("sagas").
+		Where("saga", "==", saga).
+		OrderBy("updatedAt", firestore.Desc)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	snapshots, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, errors.WithMessage(err, "list sagas")
+	}
+
+	states := make([]*pubsub.SagaState, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		var state pubsub.SagaState
+		if err := snapshot.DataTo(&state); err != nil {
+			return nil, errors.WithMessage(err, "data to saga")
+		}
+		states = append(states, &state)
+	}
+
+	return states, nil
+}
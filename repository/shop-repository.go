@@ -3,36 +3,50 @@ package repository
 import (
 	"cloud.google.com/go/firestore/apiv1/firestorepb"
 	"context"
+	"github.com/aiocean/wireset/apperror"
 	"github.com/pkg/errors"
+	"net/http"
 	"time"
 
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
 	"github.com/aiocean/wireset/shopifysvc"
 
 	"cloud.google.com/go/firestore"
 	"github.com/google/wire"
+	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
-type ShopRepository struct {
+// FirestoreShopRepository keeps the shops in the shops collection.
+type FirestoreShopRepository struct {
 	firestoreClient *firestore.Client
 }
 
-func NewShopRepository(
+func NewFirestoreShopRepository(
 	firestoreClient *firestore.Client,
-) *ShopRepository {
-	return &ShopRepository{
+) *FirestoreShopRepository {
+	return &FirestoreShopRepository{
 		firestoreClient: firestoreClient,
 	}
 }
 
-var ErrShopNotFound = errors.New("shop not found")
+var ErrShopNotFound = apperror.New(apperror.CodeShopNotFound, http.StatusNotFound, "shop not found")
+var ErrShopExists = apperror.New(apperror.CodeConflict, http.StatusConflict, "shop already exists")
 
 var ShopRepoWireset = wire.NewSet(
-	NewShopRepository,
+	NewFirestoreShopRepository,
+	wire.Bind(new(ShopRepository), new(*FirestoreShopRepository)),
 )
 
-func (r *ShopRepository) IsShopExists(ctx context.Context, shopID string) (bool, error) {
+// firestoreShop is the shop document, the lifecycle fields are next to the shop fields.
+type firestoreShop struct {
+	shopifysvc.Shop
+	model.ShopLifecycle
+}
+
+func (r *FirestoreShopRepository) IsShopExists(ctx context.Context, shopID string) (bool, error) {
 	normalizedID, err := NormalizeShopID(shopID)
 	if err != nil {
 		return false, errors.WithMessage(err, "normalize shop id")
@@ -50,13 +64,13 @@ func (r *ShopRepository) IsShopExists(ctx context.Context, shopID string) (bool,
 }
 
 // IsDomainExists checks if the shop domain exists
-func (r *ShopRepository) IsDomainExists(ctx context.Context, domain string) (bool, error) {
+func (r *FirestoreShopRepository) IsDomainExists(ctx context.Context, domain string) (bool, error) {
 	cur := r.firestoreClient.Collection("shops").Where("myshopifyDomain", "==", domain).Documents(ctx)
 	defer cur.Stop()
 
 	_, err := cur.Next()
 	if err != nil {
-		if status.Code(err) == codes.NotFound {
+		if err == iterator.Done {
 			return false, nil
 		}
 
@@ -66,22 +80,50 @@ func (r *ShopRepository) IsDomainExists(ctx context.Context, domain string) (boo
 	return true, nil
 }
 
-func (r *ShopRepository) Create(ctx context.Context, shop *shopifysvc.Shop) error {
+func (r *FirestoreShopRepository) Create(ctx context.Context, shop *shopifysvc.Shop) error {
 	normalizedID, err := NormalizeShopID(shop.ID)
 	if err != nil {
 		return errors.WithMessage(err, "normalize shop id")
 	}
 
-	if _, err := r.firestoreClient.Collection("shops").Doc(normalizedID).Set(ctx, shop); err != nil {
+	doc := firestoreShop{Shop: *shop, ShopLifecycle: installedLifecycle(time.Now())}
+	if _, err := r.firestoreClient.Collection("shops").Doc(normalizedID).Set(ctx, doc); err != nil {
 		return errors.WithMessage(err, "create shop")
 	}
 
 	return nil
 }
 
-func (r *ShopRepository) Update(ctx context.Context, shop *shopifysvc.Shop) error {
+// CreateWithEvents creates the shop and stores the events in the outbox in the same transaction.
+// It fails with ErrShopExists when the shop is already created.
+func (r *FirestoreShopRepository) CreateWithEvents(ctx context.Context, shop *shopifysvc.Shop, records ...*pubsub.OutboxRecord) error {
+	normalizedID, err := NormalizeShopID(shop.ID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
 
-	updates := []firestore.Update{
+	shopRef := r.firestoreClient.Collection("shops").Doc(normalizedID)
+	doc := firestoreShop{Shop: *shop, ShopLifecycle: installedLifecycle(time.Now())}
+	err = r.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := tx.Create(shopRef, doc); err != nil {
+			return errors.WithMessage(err, "create shop")
+		}
+
+		return pubsub.CreateOutboxRecords(r.firestoreClient, tx, records...)
+	})
+	if err != nil {
+		if status.Code(errors.Cause(err)) == codes.AlreadyExists {
+			return ErrShopExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+// shopUpdates replaces the shop fields of the document, the other fields are kept.
+func shopUpdates(shop *shopifysvc.Shop) []firestore.Update {
+	return []firestore.Update{
 		{Path: "id", Value: shop.ID},
 		{Path: "domain", Value: shop.Domain},
 		{Path: "myshopifyDomain", Value: shop.MyshopifyDomain},
@@ -92,6 +134,10 @@ func (r *ShopRepository) Update(ctx context.Context, shop *shopifysvc.Shop) erro
 		{Path: "ianaTimezone", Value: shop.IanaTimezone},
 		{Path: "currencyCode", Value: shop.CurrencyCode},
 	}
+}
+
+func (r *FirestoreShopRepository) Update(ctx context.Context, shop *shopifysvc.Shop) error {
+	updates := shopUpdates(shop)
 
 	normalizedID, err := NormalizeShopID(shop.ID)
 	if err != nil {
@@ -99,13 +145,16 @@ func (r *ShopRepository) Update(ctx context.Context, shop *shopifysvc.Shop) erro
 	}
 
 	if _, err := r.firestoreClient.Collection("shops").Doc(normalizedID).Update(ctx, updates); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return ErrShopNotFound
+		}
 		return errors.WithMessage(err, "update shop")
 	}
 
 	return nil
 }
 
-func (r *ShopRepository) Get(ctx context.Context, shopID string) (*shopifysvc.Shop, error) {
+func (r *FirestoreShopRepository) Get(ctx context.Context, shopID string) (*shopifysvc.Shop, error) {
 
 	normalizedID, err := NormalizeShopID(shopID)
 	if err != nil {
@@ -128,14 +177,14 @@ func (r *ShopRepository) Get(ctx context.Context, shopID string) (*shopifysvc.Sh
 	return &shop, nil
 }
 
-func (r *ShopRepository) GetByDomain(ctx context.Context, domain string) (*shopifysvc.Shop, error) {
+func (r *FirestoreShopRepository) GetByDomain(ctx context.Context, domain string) (*shopifysvc.Shop, error) {
 
 	cur := r.firestoreClient.Collection("shops").Where("myshopifyDomain", "==", domain).Documents(ctx)
 	defer cur.Stop()
 
 	doc, err := cur.Next()
 	if err != nil {
-		if status.Code(err) == codes.NotFound {
+		if err == iterator.Done {
 			return nil, ErrShopNotFound
 		}
 		return nil, errors.WithMessage(err, "get shop")
@@ -150,7 +199,7 @@ func (r *ShopRepository) GetByDomain(ctx context.Context, domain string) (*shopi
 }
 
 // CountShops returns the number of shops
-func (r *ShopRepository) CountShops(ctx context.Context) (int64, error) {
+func (r *FirestoreShopRepository) CountShops(ctx context.Context) (int64, error) {
 	// count the number of shops from the firestore
 	aggregationQuery := r.firestoreClient.Collection("shops").NewAggregationQuery().WithCount("all")
 	results, err := aggregationQuery.Get(ctx)
@@ -167,7 +216,7 @@ func (r *ShopRepository) CountShops(ctx context.Context) (int64, error) {
 	return countValue.GetIntegerValue(), nil
 }
 
-func (r *ShopRepository) UpdateLastLogin(ctx context.Context, shopID string, at *time.Time) error {
+func (r *FirestoreShopRepository) UpdateLastLogin(ctx context.Context, shopID string, at *time.Time) error {
 	updates := []firestore.Update{
 		{Path: "lastLoginTime", Value: at},
 	}
@@ -178,13 +227,198 @@ func (r *ShopRepository) UpdateLastLogin(ctx context.Context, shopID string, at
 	}
 
 	if _, err := r.firestoreClient.Collection("shops").Doc(normalizedID).Update(ctx, updates); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return ErrShopNotFound
+		}
 		return errors.WithMessage(err, "update shop")
 	}
 
 	return nil
 }
 
-// UpdateStoreState updates the store state
-func (r *ShopRepository) UpdateStoreState(ctx context.Context, shopID string, key string, value interface{}) error {
-	panic("implement me")
+// UpdateStoreState sets a key of the shop state, in the states collection
+func (r *FirestoreShopRepository) UpdateStoreState(ctx context.Context, shopID string, key string, value interface{}) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	state := map[string]interface{}{key: value}
+	if _, err := r.firestoreClient.Collection("states").Doc(normalizedID).Set(ctx, state, firestore.MergeAll); err != nil {
+		return errors.WithMessage(err, "set state to firestore")
+	}
+
+	return nil
+}
+
+// Delete deletes the shop document, it does nothing if the shop does not exist
+func (r *FirestoreShopRepository) Delete(ctx context.Context, shopID string) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	if _, err := r.firestoreClient.Collection("shops").Doc(normalizedID).Delete(ctx); err != nil {
+		return errors.WithMessage(err, "delete shop")
+	}
+
+	return nil
+}
+
+func (r *FirestoreShopRepository) GetLifecycle(ctx context.Context, shopID string) (*model.ShopLifecycle, error) {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	snapshot, err := r.firestoreClient.Collection("shops").Doc(normalizedID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrShopNotFound
+		}
+		return nil, errors.WithMessage(err, "get shop")
+	}
+
+	lifecycle := model.ShopLifecycle{}
+	if err = snapshot.DataTo(&lifecycle); err != nil {
+		return nil, errors.WithMessage(err, "data to lifecycle")
+	}
+
+	return &lifecycle, nil
+}
+
+func (r *FirestoreShopRepository) MarkUninstalled(ctx context.Context, shopID string, at time.Time) error {
+	updates := []firestore.Update{
+		{Path: "status", Value: model.ShopStatusUninstalled},
+		{Path: "uninstalledAt", Value: at},
+	}
+
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	if _, err := r.firestoreClient.Collection("shops").Doc(normalizedID).Update(ctx, updates); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return ErrShopNotFound
+		}
+		return errors.WithMessage(err, "update shop")
+	}
+
+	return nil
+}
+
+// ReinstallWithEvents updates the shop and stores the events in the outbox in the same transaction.
+func (r *FirestoreShopRepository) ReinstallWithEvents(ctx context.Context, shop *shopifysvc.Shop, at time.Time, records ...*pubsub.OutboxRecord) error {
+	normalizedID, err := NormalizeShopID(shop.ID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	shopRef := r.firestoreClient.Collection("shops").Doc(normalizedID)
+	err = r.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(shopRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return ErrShopNotFound
+			}
+			return errors.WithMessage(err, "get shop")
+		}
+
+		lifecycle := model.ShopLifecycle{}
+		if err := snapshot.DataTo(&lifecycle); err != nil {
+			return errors.WithMessage(err, "data to lifecycle")
+		}
+		if lifecycle.IsInstalled() {
+			return ErrShopInstalled
+		}
+
+		updates := append(shopUpdates(shop),
+			firestore.Update{Path: "status", Value: model.ShopStatusInstalled},
+			firestore.Update{Path: "reinstalledAt", Value: at},
+		)
+		if err := tx.Update(shopRef, updates); err != nil {
+			return errors.WithMessage(err, "update shop")
+		}
+
+		return pubsub.CreateOutboxRecords(r.firestoreClient, tx, records...)
+	})
+	if errors.Is(err, ErrShopNotFound) {
+		return ErrShopNotFound
+	}
+	if errors.Is(err, ErrShopInstalled) {
+		return ErrShopInstalled
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MarkRedacted replaces the shop document by its tombstone, the token and the last login are erased with it.
+func (r *FirestoreShopRepository) MarkRedacted(ctx context.Context, shopID string, at time.Time) error {
+	normalizedID, err := NormalizeShopID(shopID)
+	if err != nil {
+		return errors.WithMessage(err, "normalize shop id")
+	}
+
+	shopRef := r.firestoreClient.Collection("shops").Doc(normalizedID)
+	err = r.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(shopRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return ErrShopNotFound
+			}
+			return errors.WithMessage(err, "get shop")
+		}
+
+		doc := firestoreShop{}
+		if err := snapshot.DataTo(&doc); err != nil {
+			return errors.WithMessage(err, "data to shop")
+		}
+
+		tombstone := firestoreShop{
+			Shop:          *redactedShop(&doc.Shop),
+			ShopLifecycle: redactedLifecycle(doc.ShopLifecycle, at),
+		}
+		return tx.Set(shopRef, tombstone)
+	})
+	if errors.Is(err, ErrShopNotFound) {
+		return ErrShopNotFound
+	}
+	if err != nil {
+		return errors.WithMessage(err, "redact shop")
+	}
+
+	return nil
+}
+
+// ListUninstalledSince needs a composite index of the shops on status and uninstalledAt.
+func (r *FirestoreShopRepository) ListUninstalledSince(ctx context.Context, since time.Time) ([]*shopifysvc.Shop, error) {
+	cur := r.firestoreClient.Collection("shops").
+		Where("status", "in", uninstalledStatuses).
+		Where("uninstalledAt", ">=", since).
+		OrderBy("uninstalledAt", firestore.Asc).
+		Documents(ctx)
+	defer cur.Stop()
+
+	var shops []*shopifysvc.Shop
+	for {
+		doc, err := cur.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, "list shops")
+		}
+
+		shop := shopifysvc.Shop{}
+		if err = doc.DataTo(&shop); err != nil {
+			return nil, errors.WithMessage(err, "data to shop")
+		}
+		shops = append(shops, &shop)
+	}
+
+	return shops, nil
 }
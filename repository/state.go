@@ -2,31 +2,197 @@ package repository
 
 import (
 	"context"
+	"net/http"
 
 	"cloud.google.com/go/firestore"
+	"github.com/aiocean/wireset/apperror"
 	"github.com/google/wire"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-var StateRepoWireset = wire.NewSet(wire.Struct(new(StateRepository), "*"))
+var StateRepoWireset = wire.NewSet(
+	wire.Struct(new(FirestoreStateRepository), "*"),
+	wire.Bind(new(StateRepository), new(*FirestoreStateRepository)),
+)
+
+var ErrStateKeyNotFound = apperror.New(apperror.CodeNotFound, http.StatusNotFound, "state key not found")
 
-type StateRepository struct {
+// FirestoreStateRepository keeps the state of each shop in the states collection.
+type FirestoreStateRepository struct {
 	FirestoreClient *firestore.Client
 	Logger          *zap.Logger
 }
 
-// SetShopState set state to firestore
-func (r *StateRepository) SetShopState(ctx context.Context, shopID string, state map[string]interface{}) error {
-
+func (r *FirestoreStateRepository) doc(shopID string) (*firestore.DocumentRef, error) {
 	normalizedID, err := NormalizeShopID(shopID)
 	if err != nil {
-		return errors.WithMessage(err, "normalize shop id")
+		return nil, errors.WithMessage(err, "normalize shop id")
+	}
+
+	return r.FirestoreClient.Collection("states").Doc(normalizedID), nil
+}
+
+// GetShopState get state from firestore
+func (r *FirestoreStateRepository) GetShopState(ctx context.Context, shopID string) (map[string]interface{}, error) {
+	doc, err := r.doc(shopID)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := doc.Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return map[string]interface{}{}, nil
+		}
+		return nil, errors.WithMessage(err, "get state from firestore")
+	}
+
+	return snapshot.Data(), nil
+}
+
+// GetShopStateKey get a key of the state from firestore
+func (r *FirestoreStateRepository) GetShopStateKey(ctx context.Context, shopID string, key string) (interface{}, error) {
+	doc, err := r.doc(shopID)
+	if err != nil {
+		return nil, err
 	}
 
-	if _, err := r.FirestoreClient.Collection("states").Doc(normalizedID).Set(ctx, state, firestore.MergeAll); err != nil {
+	snapshot, err := doc.Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrStateKeyNotFound
+		}
+		return nil, errors.WithMessage(err, "get state from firestore")
+	}
+
+	// the key is a single field, even when it contains dots
+	value, err := snapshot.DataAtPath(firestore.FieldPath{key})
+	if err != nil {
+		return nil, ErrStateKeyNotFound
+	}
+
+	return value, nil
+}
+
+// SetShopState set state to firestore
+func (r *FirestoreStateRepository) SetShopState(ctx context.Context, shopID string, state map[string]interface{}) error {
+	doc, err := r.doc(shopID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := doc.Set(ctx, state, firestore.MergeAll); err != nil {
 		return errors.WithMessage(err, "set state to firestore")
 	}
 
 	return nil
 }
+
+// UpdateShopState replaces the state in a firestore transaction, which is retried on contention
+func (r *FirestoreStateRepository) UpdateShopState(ctx context.Context, shopID string, update func(state map[string]interface{}) error) (map[string]interface{}, error) {
+	doc, err := r.doc(shopID)
+	if err != nil {
+		return nil, err
+	}
+
+	var state map[string]interface{}
+	err = r.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		state = map[string]interface{}{}
+
+		snapshot, err := tx.Get(doc)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return errors.WithMessage(err, "get state from firestore")
+		}
+		if err == nil {
+			state = snapshot.Data()
+		}
+
+		if err := update(state); err != nil {
+			return err
+		}
+
+		return tx.Set(doc, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// DeleteShopStateKey deletes a key of the state
+func (r *FirestoreStateRepository) DeleteShopStateKey(ctx context.Context, shopID string, key string) error {
+	doc, err := r.doc(shopID)
+	if err != nil {
+		return err
+	}
+
+	updates := []firestore.Update{
+		{FieldPath: firestore.FieldPath{key}, Value: firestore.Delete},
+	}
+
+	if _, err := doc.Update(ctx, updates); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return errors.WithMessage(err, "delete state key from firestore")
+	}
+
+	return nil
+}
+
+// DeleteShopState deletes the whole state of the shop
+func (r *FirestoreStateRepository) DeleteShopState(ctx context.Context, shopID string) error {
+	doc, err := r.doc(shopID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := doc.Delete(ctx); err != nil {
+		return errors.WithMessage(err, "delete state from firestore")
+	}
+
+	return nil
+}
+
+// WatchShopState listens to the snapshots of the state document
+func (r *FirestoreStateRepository) WatchShopState(ctx context.Context, shopID string) (<-chan map[string]interface{}, error) {
+	doc, err := r.doc(shopID)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(chan map[string]interface{})
+	snapshots := doc.Snapshots(ctx)
+
+	go func() {
+		defer close(states)
+		defer snapshots.Stop()
+
+		for {
+			snapshot, err := snapshots.Next()
+			if err != nil {
+				if ctx.Err() == nil && status.Code(err) != codes.Canceled {
+					r.Logger.Error("failed to watch the shop state", zap.String("shopID", shopID), zap.Error(err))
+				}
+				return
+			}
+
+			state := map[string]interface{}{}
+			if snapshot.Exists() {
+				state = snapshot.Data()
+			}
+
+			select {
+			case states <- state:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return states, nil
+}
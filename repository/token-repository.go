@@ -2,7 +2,9 @@ package repository
 
 import (
 	"context"
+	"github.com/aiocean/wireset/cryptosvc"
 	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
@@ -12,22 +14,28 @@ import (
 	"github.com/google/wire"
 )
 
-// repository get shopify token from database
-type TokenRepository struct {
+// FirestoreTokenRepository keeps the sealed shopify token in the shop document.
+type FirestoreTokenRepository struct {
 	firestoreClient *firestore.Client
+	envelope        *cryptosvc.Envelope
 }
 
-func NewTokenRepository(
+func NewFirestoreTokenRepository(
 	firestoreClient *firestore.Client,
-) *TokenRepository {
-	return &TokenRepository{
+	envelope *cryptosvc.Envelope,
+) *FirestoreTokenRepository {
+	return &FirestoreTokenRepository{
 		firestoreClient: firestoreClient,
+		envelope:        envelope,
 	}
 }
 
-var TokenRepoWireset = wire.NewSet(NewTokenRepository)
+var TokenRepoWireset = wire.NewSet(
+	NewFirestoreTokenRepository,
+	wire.Bind(new(TokenRepository), new(*FirestoreTokenRepository)),
+)
 
-func (r *TokenRepository) GetToken(ctx context.Context, shopID string) (*model.ShopifyToken, error) {
+func (r *FirestoreTokenRepository) GetToken(ctx context.Context, shopID string) (*model.ShopifyToken, error) {
 	if shopID == "" {
 		return nil, errors.New("shop id is empty")
 	}
@@ -39,21 +47,30 @@ func (r *TokenRepository) GetToken(ctx context.Context, shopID string) (*model.S
 
 	snapshot, err := r.firestoreClient.Collection("shops").Doc(normalizedShopID).Get(ctx)
 	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrTokenNotFound
+		}
 		return nil, errors.WithMessage(err, "failed to get shop")
 	}
 
-	if !snapshot.Exists() {
-		return nil, errors.New("shop not found")
+	tokenString, err := snapshot.DataAtPath(firestore.FieldPath{"shopifyToken"})
+	if err != nil {
+		return nil, ErrTokenNotFound
+	}
+
+	sealed, ok := tokenString.(string)
+	if !ok || sealed == "" {
+		return nil, ErrTokenNotFound
 	}
 
-	tokenString, err := snapshot.DataAtPath(firestore.FieldPath{"shopifyToken"})
+	accessToken, err := r.envelope.Open(sealed, normalizedShopID)
 	if err != nil {
-		return nil, errors.WithMessage(err, "failed to get shopify token")
+		return nil, errors.WithMessage(err, "failed to open shopify token")
 	}
 
 	token := model.ShopifyToken{
 		ShopID:      shopID,
-		AccessToken: tokenString.(string),
+		AccessToken: accessToken,
 	}
 
 	return &token, nil
@@ -61,29 +78,119 @@ func (r *TokenRepository) GetToken(ctx context.Context, shopID string) (*model.S
 
 var ErrTokenNotFound = errors.New("token not found")
 
-func (r *TokenRepository) SaveAccessToken(ctx context.Context, token *model.ShopifyToken) error {
+func (r *FirestoreTokenRepository) SaveAccessToken(ctx context.Context, token *model.ShopifyToken) error {
 	if token == nil {
 		return errors.New("token is nil")
 	}
 
+	normalizedShopID, err := NormalizeShopID(token.ShopID)
+	if err != nil {
+		return errors.WithMessage(err, "failed to normalize shop id")
+	}
+
+	sealed, err := r.envelope.Seal(token.AccessToken, normalizedShopID)
+	if err != nil {
+		return errors.WithMessage(err, "failed to seal shopify token")
+	}
+
 	updates := []firestore.Update{
 		{
 			Path:  "shopifyToken",
-			Value: token.AccessToken,
+			Value: sealed,
 		},
 	}
 
-	normalizedShopID, err := NormalizeShopID(token.ShopID)
+	if _, err := r.firestoreClient.Collection("shops").Doc(normalizedShopID).Update(ctx, updates); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return errors.WithMessage(ErrTokenNotFound, "failed to update shop: "+normalizedShopID)
+		}
+		return errors.WithMessage(err, "failed to update shop")
+	}
+
+	return nil
+}
+
+// DeleteToken removes the access token of the shop, it does nothing if the shop does not exist
+func (r *FirestoreTokenRepository) DeleteToken(ctx context.Context, shopID string) error {
+	normalizedShopID, err := NormalizeShopID(shopID)
 	if err != nil {
 		return errors.WithMessage(err, "failed to normalize shop id")
 	}
 
+	updates := []firestore.Update{
+		{
+			Path:  "shopifyToken",
+			Value: firestore.Delete,
+		},
+	}
+
 	if _, err := r.firestoreClient.Collection("shops").Doc(normalizedShopID).Update(ctx, updates); err != nil {
 		if status.Code(err) == codes.NotFound {
-			return errors.WithMessage(ErrTokenNotFound, "failed to update shop: "+normalizedShopID)
+			return nil
 		}
-		return errors.WithMessage(err, "failed to update shop")
+		return errors.WithMessage(err, "failed to delete token")
 	}
 
 	return nil
 }
+
+// ReencryptTokens updates each token on the condition that its document is unchanged since it was read
+func (r *FirestoreTokenRepository) ReencryptTokens(ctx context.Context) (int, error) {
+	cur := r.firestoreClient.Collection("shops").Select("shopifyToken").Documents(ctx)
+	defer cur.Stop()
+
+	count := 0
+	for {
+		doc, err := cur.Next()
+		if err == iterator.Done {
+			return count, nil
+		}
+		if err != nil {
+			return count, errors.WithMessage(err, "failed to list shops")
+		}
+
+		value, err := doc.DataAtPath(firestore.FieldPath{"shopifyToken"})
+		if err != nil {
+			continue
+		}
+		sealed, ok := value.(string)
+		if !ok || sealed == "" || !r.envelope.NeedsRotation(sealed) {
+			continue
+		}
+
+		resealed, err := reseal(r.envelope, sealed, doc.Ref.ID)
+		if err != nil {
+			return count, err
+		}
+
+		updates := []firestore.Update{
+			{
+				Path:  "shopifyToken",
+				Value: resealed,
+			},
+		}
+
+		if _, err := doc.Ref.Update(ctx, updates, firestore.LastUpdateTime(doc.UpdateTime)); err != nil {
+			if status.Code(err) == codes.FailedPrecondition || status.Code(err) == codes.NotFound {
+				continue
+			}
+			return count, errors.WithMessage(err, "failed to update shop: "+doc.Ref.ID)
+		}
+		count++
+	}
+}
+
+// reseal opens the token and seals it with the active key.
+func reseal(envelope *cryptosvc.Envelope, sealed, normalizedShopID string) (string, error) {
+	accessToken, err := envelope.Open(sealed, normalizedShopID)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to open shopify token of shop: "+normalizedShopID)
+	}
+
+	resealed, err := envelope.Seal(accessToken, normalizedShopID)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to seal shopify token")
+	}
+
+	return resealed, nil
+}
@@ -2,23 +2,37 @@ package server
 
 import (
 	"context"
+	"net"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/aiocean/wireset/configsvc"
 	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/model"
+	"github.com/aiocean/wireset/pubsub"
 	fiber "github.com/gofiber/fiber/v2"
+	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
-	"os"
 
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/google/wire"
 	"go.uber.org/zap"
 )
 
+// ShutdownTimeout bounds the whole shutdown sequence.
+const ShutdownTimeout = 30 * time.Second
+
 type ApiServer struct {
 	MsgRouter           *message.Router
 	ConfigSvc           *configsvc.ConfigService
 	LogSvc              *zap.Logger
 	FiberSvc            *fiber.App
 	HttpHandlerRegistry *fiberapp.Registry
+	HealthRegistry      *fiberapp.HealthRegistry
+	Scheduler           *pubsub.Scheduler
+	EventBus            *cqrs.EventBus
 	Features            []Feature
 }
 
@@ -27,46 +41,187 @@ var DefaultWireset = wire.NewSet(
 	wire.Bind(new(Server), new(*ApiServer)),
 )
 
+// Start initializes the features, runs the message router, then the http server.
+// The server shuts down gracefully when ctx is done or on SIGINT/SIGTERM:
+// the http requests and websocket connections are drained, then the message handlers are finished.
+// The channel receives nil after a graceful shutdown, or the error which stopped the server.
+// Run the wire cleanup only after receiving from it.
 func (s *ApiServer) Start(ctx context.Context) chan error {
 	errChan := make(chan error, 1)
 
-	// in it features
-	for _, feature := range s.Features {
+	go func() {
+		errChan <- s.run(ctx)
+	}()
+
+	return errChan
+}
+
+func (s *ApiServer) run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	s.LogSvc.Debug("Loaded config", zap.Any("config", configsvc.Dump(s.ConfigSvc)))
+
+	features, err := s.enabledFeatures()
+	if err != nil {
+		return err
+	}
+
+	// init features
+	for _, feature := range features {
 		s.LogSvc.Info("Initializing feature", zap.String("feature", feature.Name()))
 		if err := feature.Init(); err != nil {
-			errChan <- errors.WithMessage(err, "failed to init feature")
-			return errChan
+			return errors.WithMessage(err, "failed to init feature")
 		}
 	}
 
-	// start message router
+	// every route declares its auth policy, checked before anything is started
+	if err := s.HttpHandlerRegistry.Validate(); err != nil {
+		return errors.WithMessage(err, "invalid http route")
+	}
+
+	// start message router, it is closed by the shutdown sequence instead of ctx,
+	// so the handlers keep running while the http server is drained
+	routerErr := make(chan error, 1)
 	go func() {
-		err := s.MsgRouter.Run(ctx)
-		if err != nil {
-			errChan <- errors.WithMessage(err, "failed to run message router")
-			return
+		routerErr <- s.MsgRouter.Run(context.Background())
+	}()
+
+	select {
+	case <-s.MsgRouter.Running():
+	case err := <-routerErr:
+		return errors.WithMessage(stoppedErr(err, "message router stopped"), "failed to run message router")
+	case <-ctx.Done():
+		return s.shutdown(nil)
+	}
+
+	// start features
+	started := make([]Feature, 0, len(features))
+	for _, feature := range features {
+		if starter, ok := feature.(FeatureStarter); ok {
+			s.LogSvc.Info("Starting feature", zap.String("feature", feature.Name()))
+			if err := starter.Start(ctx); err != nil {
+				startErr := errors.WithMessagef(err, "failed to start feature %s", feature.Name())
+				if shutdownErr := s.shutdown(started); shutdownErr != nil {
+					s.LogSvc.Error("failed to shut down", zap.Error(shutdownErr))
+				}
+				return startErr
+			}
 		}
+		started = append(started, feature)
+	}
 
-		errChan <- errors.New("message router stopped")
-	}()
+	// dispatch the scheduled commands now that the handlers subscribed
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	defer stopScheduler()
+	go s.Scheduler.Run(schedulerCtx)
 
 	// start fiber
+	s.HttpHandlerRegistry.RegisterMiddlewares(s.FiberSvc)
+	s.HttpHandlerRegistry.RegisterHandlers(s.FiberSvc)
+
+	httpErr := make(chan error, 1)
 	go func() {
-		port := os.Getenv("PORT")
-		if port == "" {
-			port = "8080"
+		httpErr <- s.FiberSvc.Listen(net.JoinHostPort(s.ConfigSvc.Address, s.ConfigSvc.Port))
+	}()
+
+	// every feature is initialized and the router is running
+	s.HealthRegistry.SetReady(true)
+
+	if err := s.EventBus.Publish(ctx, &model.ServerStartedEvt{}); err != nil {
+		s.LogSvc.Error("failed to publish server started event", zap.Error(err))
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		s.LogSvc.Info("Shutting down")
+	case err := <-routerErr:
+		runErr = errors.WithMessage(stoppedErr(err, "message router stopped"), "failed to run message router")
+	case err := <-httpErr:
+		runErr = errors.WithMessage(stoppedErr(err, "fiber stopped"), "failed to listen fiber")
+	}
+
+	stopScheduler()
+	shutdownErr := s.shutdown(started)
+	if runErr != nil {
+		if shutdownErr != nil {
+			s.LogSvc.Error("failed to shut down", zap.Error(shutdownErr))
 		}
+		return runErr
+	}
 
-		s.HttpHandlerRegistry.RegisterMiddlewares(s.FiberSvc)
-		s.HttpHandlerRegistry.RegisterHandlers(s.FiberSvc)
+	return shutdownErr
+}
 
-		if err := s.FiberSvc.Listen(":" + port); err != nil {
-			errChan <- errors.WithMessage(err, "failed to listen fiber")
-			return
+// enabledFeatures returns the features which are not disabled by the config, dependencies first.
+func (s *ApiServer) enabledFeatures() ([]Feature, error) {
+	enabled := make([]Feature, 0, len(s.Features))
+	for _, feature := range s.Features {
+		if !s.ConfigSvc.IsFeatureEnabled(feature.Name()) {
+			s.LogSvc.Info("Feature disabled", zap.String("feature", feature.Name()))
+			continue
 		}
+		enabled = append(enabled, feature)
+	}
+
+	sorted, err := SortFeatures(enabled)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to order features")
+	}
 
-		errChan <- errors.New("fiber stopped")
+	return sorted, nil
+}
+
+// shutdown drains the http server, stops the started features in reverse order, then closes the message router.
+func (s *ApiServer) shutdown(started []Feature) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	var result *multierror.Error
+
+	s.HealthRegistry.SetReady(false)
+
+	s.LogSvc.Info("Draining http server")
+	if err := s.FiberSvc.ShutdownWithContext(ctx); err != nil {
+		result = multierror.Append(result, errors.WithMessage(err, "shut down fiber"))
+	}
+
+	for i := len(started) - 1; i >= 0; i-- {
+		stopper, ok := started[i].(FeatureStopper)
+		if !ok {
+			continue
+		}
+
+		s.LogSvc.Info("Stopping feature", zap.String("feature", started[i].Name()))
+		if err := stopper.Stop(ctx); err != nil {
+			result = multierror.Append(result, errors.WithMessagef(err, "stop feature %s", started[i].Name()))
+		}
+	}
+
+	s.LogSvc.Info("Finishing message handlers")
+	closed := make(chan error, 1)
+	go func() {
+		closed <- s.MsgRouter.Close()
 	}()
 
-	return errChan
+	select {
+	case err := <-closed:
+		if err != nil {
+			result = multierror.Append(result, errors.WithMessage(err, "close message router"))
+		}
+	case <-ctx.Done():
+		result = multierror.Append(result, errors.WithMessage(ctx.Err(), "close message router"))
+	}
+
+	return result.ErrorOrNil()
+}
+
+// stoppedErr reports a component which stopped without error while the server was running.
+func stoppedErr(err error, message string) error {
+	if err != nil {
+		return err
+	}
+
+	return errors.New(message)
 }
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrFeatureCycle             = errors.New("feature dependency cycle")
+	ErrFeatureDependencyMissing = errors.New("feature dependency missing")
+)
+
+// SortFeatures orders the features so every feature comes after its dependencies.
+// Features without dependencies between them keep their registration order.
+func SortFeatures(features []Feature) ([]Feature, error) {
+	byName := make(map[string]Feature, len(features))
+	for _, feature := range features {
+		if _, ok := byName[feature.Name()]; ok {
+			return nil, errors.Errorf("feature %s is registered twice", feature.Name())
+		}
+		byName[feature.Name()] = feature
+	}
+
+	for _, feature := range features {
+		for _, dependency := range featureDependencies(feature) {
+			if _, ok := byName[dependency]; !ok {
+				return nil, errors.WithMessagef(ErrFeatureDependencyMissing, "feature %s depends on %s, which is not registered or is disabled", feature.Name(), dependency)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	states := make(map[string]int, len(features))
+	sorted := make([]Feature, 0, len(features))
+
+	var visit func(feature Feature, path []string) error
+	visit = func(feature Feature, path []string) error {
+		name := feature.Name()
+		switch states[name] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.WithMessage(ErrFeatureCycle, strings.Join(append(path, name), " -> "))
+		}
+
+		states[name] = visiting
+		for _, dependency := range featureDependencies(feature) {
+			if err := visit(byName[dependency], append(path, name)); err != nil {
+				return err
+			}
+		}
+		states[name] = visited
+
+		sorted = append(sorted, feature)
+		return nil
+	}
+
+	for _, feature := range features {
+		if err := visit(feature, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
+
+func featureDependencies(feature Feature) []string {
+	if dependent, ok := feature.(FeatureDependent); ok {
+		return dependent.Dependencies()
+	}
+
+	return nil
+}
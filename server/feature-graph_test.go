@@ -0,0 +1,174 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type testFeature struct {
+	name         string
+	dependencies []string
+}
+
+func (f *testFeature) Init() error {
+	return nil
+}
+
+func (f *testFeature) Name() string {
+	return f.name
+}
+
+func (f *testFeature) Dependencies() []string {
+	return f.dependencies
+}
+
+// independentFeature does not implement FeatureDependent.
+type independentFeature struct {
+	name string
+}
+
+func (f *independentFeature) Init() error {
+	return nil
+}
+
+func (f *independentFeature) Name() string {
+	return f.name
+}
+
+func feature(name string, dependencies ...string) Feature {
+	return &testFeature{name: name, dependencies: dependencies}
+}
+
+func featureNames(features []Feature) []string {
+	names := make([]string, 0, len(features))
+	for _, feature := range features {
+		names = append(names, feature.Name())
+	}
+
+	return names
+}
+
+func TestSortFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		features []Feature
+		want     []string
+	}{
+		{
+			name:     "independent features keep their order",
+			features: []Feature{feature("c"), &independentFeature{name: "a"}, feature("b")},
+			want:     []string{"c", "a", "b"},
+		},
+		{
+			name:     "dependencies first",
+			features: []Feature{feature("app", "auth", "db"), feature("auth", "db"), feature("db")},
+			want:     []string{"db", "auth", "app"},
+		},
+		{
+			name:     "the others keep their order around a dependency",
+			features: []Feature{feature("x"), feature("app", "db"), feature("y"), feature("db")},
+			want:     []string{"x", "db", "app", "y"},
+		},
+		{
+			name:     "shared dependency once",
+			features: []Feature{feature("a", "shared"), feature("b", "shared"), feature("shared")},
+			want:     []string{"shared", "a", "b"},
+		},
+		{name: "no feature", features: nil, want: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sorted, err := SortFeatures(test.features)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := featureNames(sorted); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("order is %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSortFeaturesRejectsAnInvalidGraph(t *testing.T) {
+	tests := []struct {
+		name     string
+		features []Feature
+		target   error
+		message  string
+	}{
+		{
+			name:     "cycle",
+			features: []Feature{feature("a", "b"), feature("b", "c"), feature("c", "a")},
+			target:   ErrFeatureCycle,
+			message:  "a -> b -> c -> a",
+		},
+		{
+			name:     "cycle behind a dependency",
+			features: []Feature{feature("app", "a"), feature("a", "b"), feature("b", "a")},
+			target:   ErrFeatureCycle,
+			message:  "app -> a -> b -> a",
+		},
+		{
+			name:     "self dependency",
+			features: []Feature{feature("a", "a")},
+			target:   ErrFeatureCycle,
+			message:  "a -> a",
+		},
+		{
+			name:     "missing dependency",
+			features: []Feature{feature("app", "db")},
+			target:   ErrFeatureDependencyMissing,
+			message:  "feature app depends on db",
+		},
+		{
+			name:     "duplicate name",
+			features: []Feature{feature("a"), &independentFeature{name: "a"}},
+			message:  "feature a is registered twice",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := SortFeatures(test.features)
+			if err == nil {
+				t.Fatal("the features are sorted")
+			}
+
+			if test.target != nil && !errors.Is(err, test.target) {
+				t.Fatalf("error is %v, want %v", err, test.target)
+			}
+			if !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("error is %q, want it to contain %q", err, test.message)
+			}
+		})
+	}
+}
+
+func TestEnabledFeaturesRejectsADisabledDependency(t *testing.T) {
+	features := []Feature{feature("app", "db"), feature("db"), feature("metrics")}
+
+	s := &ApiServer{
+		ConfigSvc: &configsvc.ConfigService{DisabledFeatures: []string{"metrics"}},
+		LogSvc:    zap.NewNop(),
+		Features:  features,
+	}
+	enabled, err := s.enabledFeatures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := featureNames(enabled), []string{"db", "app"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("enabled features are %v, want %v", got, want)
+	}
+
+	s.ConfigSvc.DisabledFeatures = []string{"db"}
+	if _, err := s.enabledFeatures(); !errors.Is(err, ErrFeatureDependencyMissing) {
+		t.Fatalf("error is %v, want %v", err, ErrFeatureDependencyMissing)
+	}
+}
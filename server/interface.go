@@ -7,6 +7,24 @@ type Feature interface {
 	Name() string
 }
 
+// FeatureDependent is implemented by the features which must be initialized after other features.
+// Dependencies returns the names of these features.
+type FeatureDependent interface {
+	Dependencies() []string
+}
+
+// FeatureStarter is implemented by the features which start something once the message router is running,
+// before the http server accepts requests.
+type FeatureStarter interface {
+	Start(ctx context.Context) error
+}
+
+// FeatureStopper is implemented by the features which stop something during the shutdown,
+// after the http server is drained and before the message handlers are finished.
+type FeatureStopper interface {
+	Stop(ctx context.Context) error
+}
+
 type Server interface {
 	Start(ctx context.Context) chan error
 }
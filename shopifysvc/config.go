@@ -1,53 +1,26 @@
 package shopifysvc
 
 import (
+	"github.com/aiocean/wireset/configsvc"
 	"github.com/google/wire"
-	"github.com/pkg/errors"
-	"os"
 )
 
 type Config struct {
-	ClientId      string
-	ClientSecret  string
+	ClientId      string `env:"SHOPIFY_CLIENT_ID" required:"true"`
+	ClientSecret  string `env:"SHOPIFY_CLIENT_SECRET" required:"true" secret:"true"`
 	RedirectUrl   string
-	ApiVersion    string
-	LoginNonce    string
-	AppListingUrl string
+	ApiVersion    string `env:"SHOPIFY_API_VERSION" required:"true"`
+	LoginNonce    string `env:"LOGIN_NONCE" required:"true" secret:"true"`
+	AppListingUrl string `env:"APP_LISTING_URL" required:"true"`
 }
 
 var EnvWireset = wire.NewSet(ConfigFromEnv)
 
+// ConfigFromEnv loads the config from the layered sources of configsvc.Loader.
 func ConfigFromEnv() (*Config, error) {
 	config := &Config{}
-
-	if value, ok := os.LookupEnv("SHOPIFY_CLIENT_SECRET"); ok {
-		config.ClientSecret = value
-	} else {
-		return nil, errors.New("SHOPIFY_CLIENT_SECRET is required")
-	}
-
-	if value, ok := os.LookupEnv("SHOPIFY_CLIENT_ID"); ok {
-		config.ClientId = value
-	} else {
-		return nil, errors.New("SHOPIFY_CLIENT_ID is required")
-	}
-
-	if value, ok := os.LookupEnv("SHOPIFY_API_VERSION"); ok {
-		config.ApiVersion = value
-	} else {
-		return nil, errors.New("SHOPIFY_API_VERSION is required")
-	}
-
-	if value, ok := os.LookupEnv("LOGIN_NONCE"); ok {
-		config.LoginNonce = value
-	} else {
-		return nil, errors.New("LOGIN_NONCE is required")
-	}
-
-	if value, ok := os.LookupEnv("APP_LISTING_URL"); ok {
-		config.AppListingUrl = value
-	} else {
-		return nil, errors.New("APP_LISTING_URL is required")
+	if err := configsvc.Load(config); err != nil {
+		return nil, err
 	}
 
 	return config, nil
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/aiocean/wireset/apperror"
 	"io/ioutil"
 	"net/http"
 )
@@ -23,6 +24,31 @@ type AccessTokenResponse struct {
 	Scope       string `json:"scope"`
 }
 
+// TokenExchangeError is returned when shopify refuses to exchange the session token.
+type TokenExchangeError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *TokenExchangeError) Error() string {
+	return fmt.Sprintf("failed to exchange access token, status code: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the same request may succeed later.
+// An invalid or expired session token never will.
+func (e *TokenExchangeError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// AppError is an unauthorized error when the session token is refused, a shopify error otherwise.
+func (e *TokenExchangeError) AppError() *apperror.Error {
+	if e.Temporary() {
+		return apperror.Wrap(e, apperror.CodeShopifyAPI, http.StatusBadGateway, "shopify api request failed")
+	}
+
+	return apperror.Wrap(e, apperror.CodeUnauthorized, http.StatusUnauthorized, "the session token is invalid")
+}
+
 func ExchangeAccessToken(shop, clientID, clientSecret, sessionToken string) (*AccessTokenResponse, error) {
 	url := fmt.Sprintf("https://%s/admin/oauth/access_token", shop)
 
@@ -50,6 +76,13 @@ func ExchangeAccessToken(shop, clientID, clientSecret, sessionToken string) (*Ac
 
 	body, _ := ioutil.ReadAll(resp.Body)
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, &TokenExchangeError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+		}
+	}
+
 	var accessTokenResponse AccessTokenResponse
 	err = json.Unmarshal(body, &accessTokenResponse)
 	if err != nil {
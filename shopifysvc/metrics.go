@@ -0,0 +1,68 @@
+package shopifysvc
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aiocean/wireset/prometheussvc"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	apiRest    = "rest"
+	apiGraphql = "graphql"
+)
+
+// headerCallLimit reports the usage of the rest rate limit bucket, such as 32/40.
+const headerCallLimit = "X-Shopify-Shop-Api-Call-Limit"
+
+// observeCall records the duration of a call, status is "error" when no response was received.
+func observeCall(api string, startedAt time.Time, resp *http.Response) {
+	status := "error"
+	if resp != nil {
+		status = strconv.Itoa(resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			prometheussvc.ShopifyAPIThrottled.WithLabelValues(api).Inc()
+		}
+	}
+
+	prometheussvc.ShopifyAPIDuration.WithLabelValues(api, status).Observe(time.Since(startedAt).Seconds())
+}
+
+// observeRestLimit records the available part of the rest bucket.
+func observeRestLimit(resp *http.Response) {
+	used, limit, ok := strings.Cut(resp.Header.Get(headerCallLimit), "/")
+	if !ok {
+		return
+	}
+
+	usedCalls, err := strconv.ParseFloat(used, 64)
+	if err != nil {
+		return
+	}
+
+	maxCalls, err := strconv.ParseFloat(limit, 64)
+	if err != nil || maxCalls == 0 {
+		return
+	}
+
+	prometheussvc.ShopifyAPIAvailable.WithLabelValues(apiRest).Observe(1 - usedCalls/maxCalls)
+}
+
+// observeGraphqlCost records the available part of the graphql bucket, and the throttled queries.
+func observeGraphqlCost(body []byte) {
+	throttleStatus := gjson.GetBytes(body, "extensions.cost.throttleStatus")
+	if maximum := throttleStatus.Get("maximumAvailable").Float(); maximum > 0 {
+		available := throttleStatus.Get("currentlyAvailable").Float()
+		prometheussvc.ShopifyAPIAvailable.WithLabelValues(apiGraphql).Observe(available / maximum)
+	}
+
+	for _, graphqlError := range gjson.GetBytes(body, "errors").Array() {
+		if graphqlError.Get("extensions.code").String() == "THROTTLED" {
+			prometheussvc.ShopifyAPIThrottled.WithLabelValues(apiGraphql).Inc()
+			return
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package shopifysvc
 
 import (
+	"github.com/aiocean/wireset/apperror"
 	"github.com/tidwall/gjson"
+	"net/http"
 	"strings"
 )
 
@@ -32,3 +34,8 @@ func (e *GraphQLError) Error() string {
 type GraphQLError struct {
 	Errors []gjson.Result
 }
+
+// AppError hides the shopify messages from the clients, they are logged with the cause.
+func (e *GraphQLError) AppError() *apperror.Error {
+	return apperror.Wrap(e, apperror.CodeShopifyAPI, http.StatusBadGateway, "shopify api request failed")
+}
@@ -2,6 +2,7 @@ package shopifysvc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/google/wire"
@@ -16,6 +17,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const graphQlEndpointTemplate = "https://%s.myshopify.com/admin/api/%s/graphql.json"
@@ -59,6 +61,8 @@ type ShopifyClient struct {
 	configSvc     *configsvc.ConfigService
 	httpClient    *http.Client
 	logger        *zap.Logger
+	// ctx carries the span of the caller, the requests are its children
+	ctx context.Context
 }
 
 func (s *ShopifyService) GetShopifyClient(shop, accessToken string) *ShopifyClient {
@@ -84,20 +88,45 @@ func (s *ShopifyService) GetShopifyClient(shop, accessToken string) *ShopifyClie
 
 	return &client
 }
+
+// WithContext returns a copy of the client whose requests belong to ctx, such as the span of a handler.
+func (c *ShopifyClient) WithContext(ctx context.Context) *ShopifyClient {
+	client := *c
+	client.ctx = ctx
+	return &client
+}
+
+func (c *ShopifyClient) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
 func (c *ShopifyClient) DoRestRequest(method, path string, body io.Reader) (*gjson.Result, error) {
 	endpoint := fmt.Sprintf(restEndpointTemplate, c.ShopifyDomain, c.ApiVersion) + path
-	req, err := http.NewRequest(method, endpoint, body)
+	ctx, span := startSpan(c.context(), apiRest, "rest "+method)
+	defer span.End()
+	span.SetAttributes(attribute.String("url.path", path))
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
 	if err != nil {
 		return nil, errors.Wrap(err, "DoRestRequest: failed to create request")
 	}
+	injectTraceContext(ctx, req)
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Shopify-Access-Token", c.AccessToken)
 
+	startedAt := time.Now()
 	resp, err := c.httpClient.Do(req)
+	observeCall(apiRest, startedAt, resp)
+	endSpan(span, resp, err)
 	if err != nil {
 		return nil, errors.Wrap(err, "DoRestRequest: failed to do request")
 	}
+	observeRestLimit(resp)
 
 	defer func() {
 		err := resp.Body.Close()
@@ -131,15 +160,22 @@ func (c *ShopifyClient) DoGraphqlRequest(request *GraphQlRequest) (*gjson.Result
 
 	endpoint := fmt.Sprintf(graphQlEndpointTemplate, c.ShopifyDomain, c.ApiVersion)
 
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(jsonPayload))
+	ctx, span := startSpan(c.context(), apiGraphql, "graphql "+request.OperationName)
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonPayload))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create request")
 	}
+	injectTraceContext(ctx, req)
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Shopify-Access-Token", c.AccessToken)
 
+	startedAt := time.Now()
 	resp, err := c.httpClient.Do(req)
+	observeCall(apiGraphql, startedAt, resp)
+	endSpan(span, resp, err)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to do request")
 	}
@@ -154,6 +190,7 @@ func (c *ShopifyClient) DoGraphqlRequest(request *GraphQlRequest) (*gjson.Result
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read response body")
 	}
+	observeGraphqlCost(respBody)
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.Errorf("failed to do request, status code: %d, body: %s", resp.StatusCode, string(respBody))
@@ -261,7 +298,16 @@ func (c *ShopifyClient) IsScriptInstalled(scriptUrl string) (bool, error) {
 }
 
 func (c *ShopifyClient) InstallAppUninstalledWebhook() error {
-	isInstalled, err := c.IsAppUninstalledWebhookInstalled()
+	return c.InstallWebhook("APP_UNINSTALLED", "/webhook/shopify/app-uninstalled")
+}
+
+func (c *ShopifyClient) IsAppUninstalledWebhookInstalled() (bool, error) {
+	return c.IsWebhookInstalled("APP_UNINSTALLED")
+}
+
+// InstallWebhook subscribes the app to the webhook topic, the callback path is relative to the service url.
+func (c *ShopifyClient) InstallWebhook(topic, callbackPath string) error {
+	isInstalled, err := c.IsWebhookInstalled(topic)
 	if err != nil {
 		return err
 	}
@@ -271,8 +317,8 @@ func (c *ShopifyClient) InstallAppUninstalledWebhook() error {
 	}
 
 	requestBody := &GraphQlRequest{
-		Query: `mutation webhookSubscriptionCreate($input: WebhookSubscriptionInput!) {
-			webhookSubscriptionCreate(input: $input) {
+		Query: `mutation webhookSubscriptionCreate($topic: WebhookSubscriptionTopic!, $input: WebhookSubscriptionInput!) {
+			webhookSubscriptionCreate(topic: $topic, webhookSubscription: $input) {
 				userErrors {
 					field
 					message
@@ -283,37 +329,46 @@ func (c *ShopifyClient) InstallAppUninstalledWebhook() error {
 			}
 		}`,
 		Variables: map[string]interface{}{
+			"topic": topic,
 			"input": map[string]interface{}{
-				"topic":   "APP_UNINSTALLED",
-				"format":  "JSON",
-				"address": c.configSvc.ServiceUrl + "/webhook/shopify/app-uninstalled",
+				"format":      "JSON",
+				"callbackUrl": c.configSvc.ServiceUrl + callbackPath,
 			},
 		},
 	}
 
-	if _, err := c.DoGraphqlRequest(requestBody); err != nil {
-		return errors.WithMessage(err, "failed to install app uninstalled webhook")
+	response, err := c.DoGraphqlRequest(requestBody)
+	if err != nil {
+		return errors.WithMessage(err, "failed to install webhook "+topic)
+	}
+
+	if userErrors := response.Get("webhookSubscriptionCreate.userErrors.#.message"); len(userErrors.Array()) > 0 {
+		return errors.Errorf("failed to install webhook %s: %s", topic, userErrors.String())
 	}
 
 	return nil
 }
 
-func (c *ShopifyClient) IsAppUninstalledWebhookInstalled() (bool, error) {
+// IsWebhookInstalled checks if the app is subscribed to the webhook topic.
+func (c *ShopifyClient) IsWebhookInstalled(topic string) (bool, error) {
 	requestBody := &GraphQlRequest{
-		Query: `{
-   webhookSubscriptions(first: 10, topic: APP_UNINSTALLED){
-    edges{
-     node {
-      id
-     }
-    }
-   }
-  }`,
+		Query: `query webhookSubscriptions($topics: [WebhookSubscriptionTopic!]) {
+			webhookSubscriptions(first: 10, topics: $topics){
+				edges{
+					node {
+						id
+					}
+				}
+			}
+		}`,
+		Variables: map[string]interface{}{
+			"topics": []string{topic},
+		},
 	}
 
 	response, err := c.DoGraphqlRequest(requestBody)
 	if err != nil {
-		return false, errors.WithMessage(err, "failed to check if app uninstalled webhook is installed")
+		return false, errors.WithMessage(err, "failed to check if webhook "+topic+" is installed")
 	}
 
 	total := response.Get("webhookSubscriptions.edges.#").Int()
@@ -506,6 +561,7 @@ func (c *ShopifyClient) SetAppDataMetaField(ownerId, key, value string) error {
 
 type Subscription struct {
 	ID                        string
+	Name                      string
 	TrialDays                 int
 	CurrentPeriodEnd          string
 	Status                    string
@@ -513,6 +569,9 @@ type Subscription struct {
 	CurrentPeriodEndFormatted string
 }
 
+// SubscriptionStatusActive is the status of a subscription which the merchant approved.
+const SubscriptionStatusActive = "ACTIVE"
+
 var ErrorSubscriptionNotFound = errors.New("subscription not found")
 
 func (c *ShopifyClient) GetSubscription() (*Subscription, error) {
@@ -543,9 +602,11 @@ func (c *ShopifyClient) GetSubscription() (*Subscription, error) {
 
 	subscription := &Subscription{
 		ID:               subscriptionData.Get("id").String(),
+		Name:             subscriptionData.Get("name").String(),
 		TrialDays:        int(subscriptionData.Get("trialDays").Int()),
 		CurrentPeriodEnd: subscriptionData.Get("currentPeriodEnd").String(),
 		Status:           subscriptionData.Get("status").String(),
+		Test:             subscriptionData.Get("test").Bool(),
 	}
 
 	return subscription, nil
@@ -0,0 +1,25 @@
+package shopifysvc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// VerifyWebhook checks the X-Shopify-Hmac-Sha256 signature of a webhook body.
+// The signature is the base64 encoded HMAC-SHA256 of the raw body, keyed by the app client secret.
+func VerifyWebhook(body []byte, signature, clientSecret string) bool {
+	if signature == "" || clientSecret == "" {
+		return false
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(clientSecret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -0,0 +1,44 @@
+package shopifysvc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+const testClientSecret = "client-secret"
+
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	body := []byte(`{"id":1,"domain":"shop.myshopify.com"}`)
+
+	tests := []struct {
+		name         string
+		body         []byte
+		signature    string
+		clientSecret string
+		want         bool
+	}{
+		{name: "correct signature", body: body, signature: sign(body, testClientSecret), clientSecret: testClientSecret, want: true},
+		{name: "signed with another secret", body: body, signature: sign(body, "another-secret"), clientSecret: testClientSecret},
+		{name: "tampered body", body: []byte(`{"id":2,"domain":"shop.myshopify.com"}`), signature: sign(body, testClientSecret), clientSecret: testClientSecret},
+		{name: "missing signature", body: body, signature: "", clientSecret: testClientSecret},
+		{name: "signature is not base64", body: body, signature: "not base64!", clientSecret: testClientSecret},
+		{name: "hex signature", body: body, signature: "8f434346648f6b96df89dda901c5176b10a6d83961dd3c1ac88b59b2dc327aa4", clientSecret: testClientSecret},
+		{name: "empty client secret", body: body, signature: sign(body, ""), clientSecret: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := VerifyWebhook(test.body, test.signature, test.clientSecret); got != test.want {
+				t.Fatalf("VerifyWebhook is %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/google/wire"
 
 	"github.com/aiocean/wireset/cachesvc"
+	"github.com/aiocean/wireset/cryptosvc"
 	"github.com/aiocean/wireset/feature/shopifyapp"
 	"github.com/aiocean/wireset/fiberapp"
 	"github.com/aiocean/wireset/fireauthsvc"
@@ -15,6 +16,8 @@ import (
 	"github.com/aiocean/wireset/shopifysvc"
 )
 
+// Common requires a transport, such as pubsub.GoroutineWireset, and the stores of pubsub.DefaultWireset,
+// such as pubsub.MemoryStoresWireset or FirestoreStores.
 var Common = wire.NewSet(
 	fiberapp.DefaultWireset,
 	server.DefaultWireset,
@@ -23,25 +26,42 @@ var Common = wire.NewSet(
 	cachesvc.DefaultWireset,
 )
 
+// FirestoreStores keeps the dead letters, the claims, the outbox and the sagas in firestore,
+// the outbox records are created in the transactions of the repositories. The schedules stay in memory.
+var FirestoreStores = wire.NewSet(
+	pubsub.FirestoreDeadLetterWireset,
+	pubsub.FirestoreDedupWireset,
+	pubsub.FirestoreOutboxWireset,
+	pubsub.MemoryScheduleWireset,
+	repository.SagaRepoWireset,
+)
+
+// ShopifyApp requires a transport, such as pubsub.GoroutineWireset.
 var ShopifyApp = wire.NewSet(
 	Common,
-	repository.ShopRepoWireset,
-	repository.TokenRepoWireset,
-	repository.StateRepoWireset,
+	FirestoreStores,
+	repository.FirestoreRepoWireset,
+	cryptosvc.DefaultWireset,
 	shopifysvc.DefaultWireset,
 	firestoresvc.DefaultWireset,
 	fireauthsvc.DefaultWireset,
 	shopifyapp.DefaultWireset,
 )
 
+// NormalApp requires a transport, such as pubsub.GoroutineWireset.
 var NormalApp = wire.NewSet(
 	Common,
+	pubsub.MemoryStoresWireset,
 	fireauthsvc.DefaultWireset,
 	firestoresvc.DefaultWireset,
 )
 
-// MinimalApp provides minimal dependencies for a basic app
-var MinimalApp = Common
+// MinimalApp provides minimal dependencies for a basic app, the pubsub stores are in memory.
+// It requires a transport, such as pubsub.GoroutineWireset.
+var MinimalApp = wire.NewSet(
+	Common,
+	pubsub.MemoryStoresWireset,
+)
 
 // CliApp provides dependencies for a CLI app
 var CliApp = wire.NewSet(